@@ -3,6 +3,7 @@ package ackhandler
 import (
 	"time"
 
+	"github.com/lucas-clemente/quic-go/congestion"
 	"github.com/lucas-clemente/quic-go/frames"
 	"github.com/lucas-clemente/quic-go/protocol"
 )
@@ -21,15 +22,62 @@ type SentPacketHandler interface {
 	GetLeastUnacked() protocol.PacketNumber
 
 	SendingAllowed() bool
+	// TimeUntilSend returns when the pacer allows sending the next packet. The zero time means now.
+	TimeUntilSend() time.Time
 	CheckForError() error
+	GetCongestionStats() CongestionStats
+	GetLossStats() LossStats
 
+	// TimeOfFirstRTO returns when the next retransmission timer fires, either for the handshake packets or for the RTO
 	TimeOfFirstRTO() time.Time
+
+	// OnConnectionMigration resets the RTT estimate and the congestion controller, since they were measured on the old path
+	OnConnectionMigration()
+
+	// SetMaxPacingRate limits the pacing rate, in addition to the limit imposed by the congestion controller. 0 means no limit.
+	SetMaxPacingRate(rate congestion.Bandwidth)
+}
+
+// CongestionStats is a snapshot of the state of the congestion controller
+type CongestionStats struct {
+	CongestionWindow   protocol.ByteCount
+	SlowStartThreshold protocol.ByteCount
+	// BandwidthEstimate is derived from the congestion window and the smoothed RTT
+	BandwidthEstimate congestion.Bandwidth
+	// PacingRate is the rate at which packets are sent out
+	PacingRate        congestion.Bandwidth
+	BytesInFlight     protocol.ByteCount
+	CongestionLimited bool
+
+	// ForwardQueuingDelay is the queuing delay on the path to the peer, estimated from the receive timestamps in ACK frames.
+	// It is 0 if the peer doesn't send timestamps.
+	ForwardQueuingDelay time.Duration
+	// ReverseQueuingDelay is the queuing delay on the path from the peer, estimated from the latest RTT sample and the ForwardQueuingDelay.
+	// It is 0 if the peer doesn't send timestamps.
+	ReverseQueuingDelay time.Duration
+}
+
+// LossStats counts lost and retransmitted packets over the lifetime of a connection
+type LossStats struct {
+	// PacketsLost is the number of packets that were declared lost, either by fast retransmit or by an RTO
+	PacketsLost uint64
+	// PacketsRetransmitted is the number of lost packets whose frames were retransmitted
+	PacketsRetransmitted uint64
+	// SpuriousLosses is the number of packets that were declared lost, but acknowledged later
+	SpuriousLosses uint64
+	// RTOCount is the number of retransmission timeouts
+	RTOCount uint64
 }
 
 // ReceivedPacketHandler handles ACKs needed to send for incoming packets
 type ReceivedPacketHandler interface {
-	ReceivedPacket(packetNumber protocol.PacketNumber) error
+	// ReceivedPacket registers a received packet. If shouldInstigateAck is false, the packet is acknowledged, but doesn't cause an ACK to be sent.
+	ReceivedPacket(packetNumber protocol.PacketNumber, rcvTime time.Time, shouldInstigateAck bool) error
 	ReceivedStopWaiting(*frames.StopWaitingFrame) error
 
 	GetAckFrame(dequeue bool) (*frames.AckFrame, error)
+	// ShouldAckImmediately returns true if an ACK should be sent right away, instead of being delayed
+	ShouldAckImmediately() bool
+	// GetAckRanges returns the ranges of received packets that are still held to be acknowledged, starting with the highest range
+	GetAckRanges() []frames.AckRange
 }
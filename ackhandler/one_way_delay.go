@@ -0,0 +1,53 @@
+package ackhandler
+
+import "time"
+
+// the first timestamp of an ACK frame is a 32 bit value in microseconds, so it wraps around after about 71 minutes
+const ackTimestampWrap = (1 << 32) * time.Microsecond
+
+// oneWayDelayEstimator estimates the queuing delay on the path to the peer from the receive timestamps in ACK frames.
+// The clocks of the two endpoints are not synchronized, so the one-way delay itself can't be measured.
+// Every sample contains the same (unknown) clock offset though, which cancels out when subtracting the smallest sample.
+// Clock drift is not taken into account.
+type oneWayDelayEstimator struct {
+	startTime time.Time
+
+	hasSample   bool
+	minDelay    time.Duration
+	latestDelay time.Duration
+}
+
+func newOneWayDelayEstimator(startTime time.Time) oneWayDelayEstimator {
+	return oneWayDelayEstimator{startTime: startTime}
+}
+
+// UpdateDelay adds a sample for a packet sent at sendTime, which the peer received at receivedTime, relative to the start of its connection
+func (e *oneWayDelayEstimator) UpdateDelay(sendTime time.Time, receivedTime time.Duration) {
+	delay := receivedTime - sendTime.Sub(e.startTime)
+	if e.hasSample {
+		// undo the wrap-around of the peer's timestamps
+		for delay < e.minDelay-ackTimestampWrap/2 {
+			delay += ackTimestampWrap
+		}
+	}
+	if !e.hasSample || delay < e.minDelay {
+		e.minDelay = delay
+	}
+	e.hasSample = true
+	e.latestDelay = delay
+}
+
+// QueuingDelay is the difference between the latest and the smallest one-way delay, or 0 if no sample was taken yet
+func (e *oneWayDelayEstimator) QueuingDelay() time.Duration {
+	return e.latestDelay - e.minDelay
+}
+
+// HasSample says if any timestamps were received
+func (e *oneWayDelayEstimator) HasSample() bool {
+	return e.hasSample
+}
+
+// Reset deletes all samples, e.g. when the path changed
+func (e *oneWayDelayEstimator) Reset() {
+	*e = newOneWayDelayEstimator(e.startTime)
+}
@@ -0,0 +1,53 @@
+package ackhandler
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("One-way delay estimator", func() {
+	var (
+		estimator oneWayDelayEstimator
+		start     time.Time
+	)
+
+	BeforeEach(func() {
+		start = time.Now()
+		estimator = newOneWayDelayEstimator(start)
+	})
+
+	It("has no queuing delay before the first sample", func() {
+		Expect(estimator.HasSample()).To(BeFalse())
+		Expect(estimator.QueuingDelay()).To(BeZero())
+	})
+
+	It("uses the smallest sample as the baseline", func() {
+		// the peer's clock is 1 hour ahead
+		estimator.UpdateDelay(start.Add(time.Second), time.Hour+time.Second+20*time.Millisecond)
+		Expect(estimator.QueuingDelay()).To(BeZero())
+		estimator.UpdateDelay(start.Add(2*time.Second), time.Hour+2*time.Second+10*time.Millisecond)
+		Expect(estimator.QueuingDelay()).To(BeZero())
+		estimator.UpdateDelay(start.Add(3*time.Second), time.Hour+3*time.Second+50*time.Millisecond)
+		Expect(estimator.QueuingDelay()).To(Equal(40 * time.Millisecond))
+		Expect(estimator.HasSample()).To(BeTrue())
+	})
+
+	It("handles the wrap-around of the peer's timestamps", func() {
+		sendTime := start.Add(ackTimestampWrap - time.Second)
+		estimator.UpdateDelay(sendTime, ackTimestampWrap-time.Second+10*time.Millisecond)
+		// the peer's timestamp wrapped around
+		estimator.UpdateDelay(sendTime.Add(2*time.Second), time.Second+30*time.Millisecond)
+		Expect(estimator.QueuingDelay()).To(Equal(20 * time.Millisecond))
+	})
+
+	It("resets", func() {
+		estimator.UpdateDelay(start, 10*time.Millisecond)
+		estimator.UpdateDelay(start, 30*time.Millisecond)
+		estimator.Reset()
+		Expect(estimator.HasSample()).To(BeFalse())
+		Expect(estimator.QueuingDelay()).To(BeZero())
+		Expect(estimator.startTime).To(Equal(start))
+	})
+})
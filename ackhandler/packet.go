@@ -19,6 +19,16 @@ type Packet struct {
 	SendTime time.Time
 }
 
+// IsHandshakePacket returns true if the packet contains data sent on the crypto stream
+func (p *Packet) IsHandshakePacket() bool {
+	for _, frame := range p.Frames {
+		if streamFrame, isStreamFrame := frame.(*frames.StreamFrame); isStreamFrame && streamFrame.StreamID == protocol.CryptoStreamID {
+			return true
+		}
+	}
+	return false
+}
+
 // GetStreamFramesForRetransmission gets all the streamframes for retransmission
 func (p *Packet) GetStreamFramesForRetransmission() []*frames.StreamFrame {
 	var streamFrames []*frames.StreamFrame
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"time"
 
+	"github.com/lucas-clemente/quic-go/congestion"
 	"github.com/lucas-clemente/quic-go/frames"
 	"github.com/lucas-clemente/quic-go/protocol"
 )
@@ -18,6 +19,8 @@ var (
 var errInvalidPacketNumber = errors.New("ReceivedPacketHandler: Invalid packet number")
 
 type receivedPacketHandler struct {
+	clock congestion.Clock
+
 	largestObserved    protocol.PacketNumber
 	ignorePacketsBelow protocol.PacketNumber
 	currentAckFrame    *frames.AckFrame
@@ -26,16 +29,55 @@ type receivedPacketHandler struct {
 	packetHistory *receivedPacketHistory
 
 	largestObservedReceivedTime time.Time
+
+	// an ACK is sent immediately once ackElicitingThreshold packets were received since the last ACK
+	ackElicitingThreshold   int
+	packetsReceivedSinceAck int
+	// an ACK is sent immediately if a packet arrived out of order
+	receivedOutOfOrder bool
+
+	// old ACK ranges are deleted, even if the peer doesn't send a StopWaiting
+	maxAckRanges   int
+	maxAckRangeAge time.Duration
+
+	// packets that were reported in multiple ACKs are deleted after a few RTTs
+	rttStats *congestion.RTTStats
+	sentAcks []sentAck
+
+	// if sendTimestamps is set, the receive times of the packets received since the last ACK are sent in the next ACK, relative to startTime
+	sendTimestamps bool
+	startTime      time.Time
+	timestamps     []frames.AckTimestamp
 }
 
-// NewReceivedPacketHandler creates a new receivedPacketHandler
-func NewReceivedPacketHandler() ReceivedPacketHandler {
+// sentAck is an ACK frame that was sent
+type sentAck struct {
+	largestAcked protocol.PacketNumber
+	sendTime     time.Time
+}
+
+// NewReceivedPacketHandler creates a new receivedPacketHandler.
+// It keeps at most maxAckRanges ACK ranges, and deletes ACK ranges that didn't receive a packet for maxAckRangeAge.
+// Packets that were reported in protocol.AcksBeforeReceivedPacketGC ACKs are deleted after protocol.RTTsBeforeReceivedPacketGC RTTs.
+// If more than maxTrackedPackets packets or maxTrackedAckRanges ACK ranges are outstanding, ReceivedPacket returns an error.
+// If sendTimestamps is set, ACK frames contain the receive times of packets, relative to the creation of the handler.
+func NewReceivedPacketHandler(clock congestion.Clock, rttStats *congestion.RTTStats, ackElicitingThreshold int, maxAckRanges int, maxAckRangeAge time.Duration, maxTrackedPackets int, maxTrackedAckRanges int, sendTimestamps bool) ReceivedPacketHandler {
 	return &receivedPacketHandler{
-		packetHistory: newReceivedPacketHistory(),
+		clock:                 clock,
+		sendTimestamps:        sendTimestamps,
+		startTime:             clock.Now(),
+		rttStats:              rttStats,
+		packetHistory:         newReceivedPacketHistory(maxTrackedPackets, maxTrackedAckRanges),
+		ackElicitingThreshold: ackElicitingThreshold,
+		maxAckRanges:          maxAckRanges,
+		maxAckRangeAge:        maxAckRangeAge,
 	}
 }
 
-func (h *receivedPacketHandler) ReceivedPacket(packetNumber protocol.PacketNumber) error {
+// ReceivedPacket is called for every packet received. The rcvTime is used to calculate the ack delay.
+// Packets that only contain ACK, StopWaiting and padding frames don't instigate an ACK, otherwise two endpoints would keep acknowledging each other's ACKs.
+// They are still reported in the next ACK that is sent for other reasons.
+func (h *receivedPacketHandler) ReceivedPacket(packetNumber protocol.PacketNumber, rcvTime time.Time, shouldInstigateAck bool) error {
 	if packetNumber == 0 {
 		return errInvalidPacketNumber
 	}
@@ -50,22 +92,54 @@ func (h *receivedPacketHandler) ReceivedPacket(packetNumber protocol.PacketNumbe
 		return ErrDuplicatePacket
 	}
 
-	err := h.packetHistory.ReceivedPacket(packetNumber)
+	err := h.packetHistory.ReceivedPacket(packetNumber, rcvTime)
 	if err != nil {
 		return err
 	}
+	h.packetHistory.DeleteOldRanges(h.maxAckRanges, rcvTime.Add(-h.maxAckRangeAge))
+	if h.sendTimestamps {
+		h.addTimestamp(packetNumber, rcvTime)
+	}
 
-	h.stateChanged = true
 	h.currentAckFrame = nil
-
+	isOutOfOrder := packetNumber < h.largestObserved || packetNumber > h.largestObserved+1
 	if packetNumber > h.largestObserved {
 		h.largestObserved = packetNumber
-		h.largestObservedReceivedTime = time.Now()
+		h.largestObservedReceivedTime = rcvTime
+	}
+
+	if !shouldInstigateAck {
+		return nil
+	}
+
+	h.stateChanged = true
+	h.packetsReceivedSinceAck++
+
+	// the packet either fills a gap, or it creates a new one
+	// in both cases, the peer should be informed as soon as possible, so that its loss detection can react
+	if isOutOfOrder {
+		h.receivedOutOfOrder = true
 	}
 
 	return nil
 }
 
+// addTimestamp records the receive time of a packet, to be sent in the next ACK frame
+func (h *receivedPacketHandler) addTimestamp(packetNumber protocol.PacketNumber, rcvTime time.Time) {
+	if len(h.timestamps) >= protocol.MaxAckTimestamps {
+		return
+	}
+	t := rcvTime.Sub(h.startTime)
+	if t < 0 { // the first packet was received before the session was created
+		t = 0
+	}
+	// timestamps are sent ordered by the receive time
+	if len(h.timestamps) > 0 && t < h.timestamps[len(h.timestamps)-1].ReceivedTime {
+		return
+	}
+	h.timestamps = append(h.timestamps, frames.AckTimestamp{PacketNumber: packetNumber, ReceivedTime: t})
+}
+
 func (h *receivedPacketHandler) ReceivedStopWaiting(f *frames.StopWaitingFrame) error {
 	// ignore if StopWaiting is unneeded, because we already received a StopWaiting with a higher LeastUnacked
 	if h.ignorePacketsBelow >= f.LeastUnacked {
@@ -83,24 +157,98 @@ func (h *receivedPacketHandler) GetAckFrame(dequeue bool) (*frames.AckFrame, err
 		return nil, nil
 	}
 
+	timestamps := h.timestamps
 	if dequeue {
 		h.stateChanged = false
+		h.packetsReceivedSinceAck = 0
+		h.receivedOutOfOrder = false
+		h.timestamps = nil
+		h.sentAck(h.clock.Now())
 	}
 
 	if h.currentAckFrame != nil {
+		h.setAckDelay(h.currentAckFrame)
 		return h.currentAckFrame, nil
 	}
 
 	ackRanges := h.packetHistory.GetAckRanges()
 	h.currentAckFrame = &frames.AckFrame{
 		LargestAcked:       h.largestObserved,
-		LowestAcked:        ackRanges[len(ackRanges)-1].FirstPacketNumber,
 		PacketReceivedTime: h.largestObservedReceivedTime,
 	}
+	h.setAckRanges(h.currentAckFrame, ackRanges)
+	for _, ts := range timestamps {
+		// the packet number of a timestamp is encoded relative to the LargestAcked
+		if h.largestObserved-ts.PacketNumber <= 0xFF {
+			h.currentAckFrame.Timestamps = append(h.currentAckFrame.Timestamps, ts)
+		}
+	}
 
-	if len(ackRanges) > 1 {
-		h.currentAckFrame.AckRanges = ackRanges
+	// drop the oldest ACK ranges, until the frame is small enough
+	// an ACK frame can't contain more than 256 ACK ranges anyway
+	if len(ackRanges) > 0x100 {
+		h.setAckRanges(h.currentAckFrame, ackRanges[:0x100])
+	}
+	for len(h.currentAckFrame.AckRanges) > 0 {
+		length, err := h.currentAckFrame.MinLength(protocol.VersionWhatever)
+		if err != nil {
+			return nil, err
+		}
+		if length <= protocol.MaxAckFrameSize {
+			break
+		}
+		h.setAckRanges(h.currentAckFrame, h.currentAckFrame.AckRanges[:len(h.currentAckFrame.AckRanges)-1])
 	}
 
+	h.setAckDelay(h.currentAckFrame)
 	return h.currentAckFrame, nil
 }
+
+// setAckDelay sets the DelayTime of an ACK frame to the time since the largest acked packet was received
+func (h *receivedPacketHandler) setAckDelay(f *frames.AckFrame) {
+	if f.PacketReceivedTime.IsZero() {
+		f.DelayTime = 0
+		return
+	}
+	f.DelayTime = h.clock.Now().Sub(f.PacketReceivedTime)
+}
+
+// sentAck is called every time an ACK is sent. It deletes all packets that were reported in enough ACKs, long enough ago.
+func (h *receivedPacketHandler) sentAck(now time.Time) {
+	h.sentAcks = append(h.sentAcks, sentAck{largestAcked: h.largestObserved, sendTime: now})
+	if len(h.sentAcks) > protocol.AcksBeforeReceivedPacketGC {
+		h.sentAcks = h.sentAcks[1:]
+	}
+	if len(h.sentAcks) < protocol.AcksBeforeReceivedPacketGC {
+		return
+	}
+	srtt := h.rttStats.SmoothedRTT()
+	if srtt == 0 {
+		return
+	}
+	// all packets up to the largestAcked of the oldest ACK were reported in all ACKs sent since then
+	oldest := h.sentAcks[0]
+	if now.Sub(oldest.sendTime) < protocol.RTTsBeforeReceivedPacketGC*srtt {
+		return
+	}
+	h.packetHistory.DeleteBelow(oldest.largestAcked + 1)
+	h.sentAcks = h.sentAcks[1:]
+}
+
+// setAckRanges sets the ACK ranges and the LowestAcked of an ACK frame
+func (h *receivedPacketHandler) setAckRanges(f *frames.AckFrame, ackRanges []frames.AckRange) {
+	f.LowestAcked = ackRanges[len(ackRanges)-1].FirstPacketNumber
+	if len(ackRanges) > 1 {
+		f.AckRanges = ackRanges
+	} else {
+		f.AckRanges = nil
+	}
+}
+
+func (h *receivedPacketHandler) ShouldAckImmediately() bool {
+	return h.receivedOutOfOrder || h.packetsReceivedSinceAck >= h.ackElicitingThreshold
+}
+
+func (h *receivedPacketHandler) GetAckRanges() []frames.AckRange {
+	return h.packetHistory.GetAckRanges()
+}
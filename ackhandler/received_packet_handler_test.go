@@ -1,10 +1,14 @@
 package ackhandler
 
 import (
+	"bytes"
+	"testing"
 	"time"
 
+	"github.com/lucas-clemente/quic-go/congestion"
 	"github.com/lucas-clemente/quic-go/frames"
 	"github.com/lucas-clemente/quic-go/protocol"
+	"github.com/lucas-clemente/quic-go/utils"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -16,61 +20,62 @@ var _ = Describe("receivedPacketHandler", func() {
 	)
 
 	BeforeEach(func() {
-		handler = NewReceivedPacketHandler().(*receivedPacketHandler)
+		handler = NewReceivedPacketHandler(congestion.DefaultClock{}, congestion.NewRTTStats(), protocol.DefaultAckElicitingThreshold, protocol.DefaultMaxAckRanges, protocol.DefaultMaxAckRangeAge, protocol.DefaultMaxTrackedReceivedPackets, protocol.DefaultMaxTrackedReceivedAckRanges, false).(*receivedPacketHandler)
 	})
 
 	Context("accepting packets", func() {
 		It("handles a packet that arrives late", func() {
-			err := handler.ReceivedPacket(protocol.PacketNumber(1))
+			err := handler.ReceivedPacket(protocol.PacketNumber(1), time.Now(), true)
 			Expect(err).ToNot(HaveOccurred())
-			err = handler.ReceivedPacket(protocol.PacketNumber(3))
+			err = handler.ReceivedPacket(protocol.PacketNumber(3), time.Now(), true)
 			Expect(err).ToNot(HaveOccurred())
-			err = handler.ReceivedPacket(protocol.PacketNumber(2))
+			err = handler.ReceivedPacket(protocol.PacketNumber(2), time.Now(), true)
 			Expect(err).ToNot(HaveOccurred())
 		})
 
 		It("rejects packets with packet number 0", func() {
-			err := handler.ReceivedPacket(protocol.PacketNumber(0))
+			err := handler.ReceivedPacket(protocol.PacketNumber(0), time.Now(), true)
 			Expect(err).To(MatchError(errInvalidPacketNumber))
 		})
 
 		It("rejects a duplicate package", func() {
 			for i := 1; i < 5; i++ {
-				err := handler.ReceivedPacket(protocol.PacketNumber(i))
+				err := handler.ReceivedPacket(protocol.PacketNumber(i), time.Now(), true)
 				Expect(err).ToNot(HaveOccurred())
 			}
-			err := handler.ReceivedPacket(4)
+			err := handler.ReceivedPacket(4, time.Now(), true)
 			Expect(err).To(MatchError(ErrDuplicatePacket))
 		})
 
 		It("ignores a packet with PacketNumber less than the LeastUnacked of a previously received StopWaiting", func() {
-			err := handler.ReceivedPacket(5)
+			err := handler.ReceivedPacket(5, time.Now(), true)
 			Expect(err).ToNot(HaveOccurred())
 			err = handler.ReceivedStopWaiting(&frames.StopWaitingFrame{LeastUnacked: 10})
 			Expect(err).ToNot(HaveOccurred())
-			err = handler.ReceivedPacket(9)
+			err = handler.ReceivedPacket(9, time.Now(), true)
 			Expect(err).To(MatchError(ErrPacketSmallerThanLastStopWaiting))
 		})
 
 		It("does not ignore a packet with PacketNumber equal to LeastUnacked of a previously received StopWaiting", func() {
-			err := handler.ReceivedPacket(5)
+			err := handler.ReceivedPacket(5, time.Now(), true)
 			Expect(err).ToNot(HaveOccurred())
 			err = handler.ReceivedStopWaiting(&frames.StopWaitingFrame{LeastUnacked: 10})
 			Expect(err).ToNot(HaveOccurred())
-			err = handler.ReceivedPacket(10)
+			err = handler.ReceivedPacket(10, time.Now(), true)
 			Expect(err).ToNot(HaveOccurred())
 		})
 
 		It("saves the time when each packet arrived", func() {
-			err := handler.ReceivedPacket(protocol.PacketNumber(3))
+			rcvTime := time.Now().Add(-5 * time.Millisecond)
+			err := handler.ReceivedPacket(protocol.PacketNumber(3), rcvTime, true)
 			Expect(err).ToNot(HaveOccurred())
-			Expect(handler.largestObservedReceivedTime).To(BeTemporally("~", time.Now(), 10*time.Millisecond))
+			Expect(handler.largestObservedReceivedTime).To(Equal(rcvTime))
 		})
 
 		It("updates the largestObserved and the largestObservedReceivedTime", func() {
 			handler.largestObserved = 3
 			handler.largestObservedReceivedTime = time.Now().Add(-1 * time.Second)
-			err := handler.ReceivedPacket(5)
+			err := handler.ReceivedPacket(5, time.Now(), true)
 			Expect(err).ToNot(HaveOccurred())
 			Expect(handler.largestObserved).To(Equal(protocol.PacketNumber(5)))
 			Expect(handler.largestObservedReceivedTime).To(BeTemporally("~", time.Now(), 10*time.Millisecond))
@@ -80,27 +85,117 @@ var _ = Describe("receivedPacketHandler", func() {
 			timestamp := time.Now().Add(-1 * time.Second)
 			handler.largestObserved = 5
 			handler.largestObservedReceivedTime = timestamp
-			err := handler.ReceivedPacket(4)
+			err := handler.ReceivedPacket(4, time.Now(), true)
 			Expect(err).ToNot(HaveOccurred())
 			Expect(handler.largestObserved).To(Equal(protocol.PacketNumber(5)))
 			Expect(handler.largestObservedReceivedTime).To(Equal(timestamp))
 		})
 
 		It("doesn't store more than MaxTrackedReceivedPackets packets", func() {
-			err := handler.ReceivedPacket(1)
+			err := handler.ReceivedPacket(1, time.Now(), true)
 			Expect(err).ToNot(HaveOccurred())
-			for i := protocol.PacketNumber(3); i < 3+protocol.MaxTrackedReceivedPackets-1; i++ {
-				err := handler.ReceivedPacket(protocol.PacketNumber(i))
+			for i := protocol.PacketNumber(3); i < 3+protocol.DefaultMaxTrackedReceivedPackets-1; i++ {
+				err := handler.ReceivedPacket(protocol.PacketNumber(i), time.Now(), true)
 				Expect(err).ToNot(HaveOccurred())
 			}
-			err = handler.ReceivedPacket(protocol.PacketNumber(protocol.MaxTrackedReceivedPackets) + 10)
+			err = handler.ReceivedPacket(protocol.PacketNumber(protocol.DefaultMaxTrackedReceivedPackets)+10, time.Now(), true)
 			Expect(err).To(MatchError(errTooManyOutstandingReceivedPackets))
 		})
 
+		It("deletes the oldest ACK ranges", func() {
+			handler = NewReceivedPacketHandler(congestion.DefaultClock{}, congestion.NewRTTStats(), protocol.DefaultAckElicitingThreshold, 2, protocol.DefaultMaxAckRangeAge, protocol.DefaultMaxTrackedReceivedPackets, protocol.DefaultMaxTrackedReceivedAckRanges, false).(*receivedPacketHandler)
+			for i := protocol.PacketNumber(1); i < 10; i += 2 {
+				err := handler.ReceivedPacket(i, time.Now(), true)
+				Expect(err).ToNot(HaveOccurred())
+			}
+			ack, err := handler.GetAckFrame(true)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ack.AckRanges).To(Equal([]frames.AckRange{
+				{FirstPacketNumber: 9, LastPacketNumber: 9},
+				{FirstPacketNumber: 7, LastPacketNumber: 7},
+			}))
+			Expect(ack.LowestAcked).To(Equal(protocol.PacketNumber(7)))
+			Expect(handler.ReceivedPacket(2, time.Now(), true)).To(MatchError(ErrDuplicatePacket))
+		})
+
+		It("deletes old ACK ranges", func() {
+			handler = NewReceivedPacketHandler(congestion.DefaultClock{}, congestion.NewRTTStats(), protocol.DefaultAckElicitingThreshold, protocol.DefaultMaxAckRanges, time.Second, protocol.DefaultMaxTrackedReceivedPackets, protocol.DefaultMaxTrackedReceivedAckRanges, false).(*receivedPacketHandler)
+			err := handler.ReceivedPacket(1, time.Now().Add(-time.Minute), true)
+			Expect(err).ToNot(HaveOccurred())
+			err = handler.ReceivedPacket(3, time.Now(), true)
+			Expect(err).ToNot(HaveOccurred())
+			ack, err := handler.GetAckFrame(true)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ack.HasMissingRanges()).To(BeFalse())
+			Expect(ack.LowestAcked).To(Equal(protocol.PacketNumber(3)))
+		})
+
+		Context("garbage collection", func() {
+			var rttStats *congestion.RTTStats
+
+			BeforeEach(func() {
+				rttStats = congestion.NewRTTStats()
+				rttStats.UpdateRTT(10*time.Millisecond, 0, time.Now())
+				handler = NewReceivedPacketHandler(congestion.DefaultClock{}, rttStats, protocol.DefaultAckElicitingThreshold, protocol.DefaultMaxAckRanges, protocol.DefaultMaxAckRangeAge, protocol.DefaultMaxTrackedReceivedPackets, protocol.DefaultMaxTrackedReceivedAckRanges, false).(*receivedPacketHandler)
+				for i := protocol.PacketNumber(1); i <= 5; i++ {
+					err := handler.ReceivedPacket(i, time.Now(), true)
+					Expect(err).ToNot(HaveOccurred())
+				}
+			})
+
+			It("deletes packets that were reported in multiple ACKs a few RTTs ago", func() {
+				now := time.Now()
+				handler.sentAck(now.Add(-time.Second))
+				err := handler.ReceivedPacket(6, time.Now(), true)
+				Expect(err).ToNot(HaveOccurred())
+				handler.sentAck(now.Add(-time.Second))
+				handler.sentAck(now)
+				Expect(handler.packetHistory.ranges.Len()).To(Equal(1))
+				Expect(handler.packetHistory.ranges.Front().Value).To(Equal(utils.PacketInterval{Start: 6, End: 6}))
+				Expect(handler.ReceivedPacket(3, time.Now(), true)).To(MatchError(ErrDuplicatePacket))
+				Expect(handler.sentAcks).To(HaveLen(protocol.AcksBeforeReceivedPacketGC - 1))
+			})
+
+			It("doesn't delete packets that weren't reported in enough ACKs", func() {
+				now := time.Now()
+				handler.sentAck(now.Add(-time.Second))
+				handler.sentAck(now)
+				Expect(handler.packetHistory.ranges.Front().Value).To(Equal(utils.PacketInterval{Start: 1, End: 5}))
+			})
+
+			It("doesn't delete packets that were reported less than a few RTTs ago", func() {
+				now := time.Now()
+				for i := 0; i < protocol.AcksBeforeReceivedPacketGC; i++ {
+					handler.sentAck(now)
+				}
+				Expect(handler.packetHistory.ranges.Front().Value).To(Equal(utils.PacketInterval{Start: 1, End: 5}))
+			})
+
+			It("uses the clock to record when an ACK was sent", func() {
+				clock := mockClock(time.Now().Add(time.Hour))
+				handler.clock = &clock
+				_, err := handler.GetAckFrame(true)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(handler.sentAcks).To(HaveLen(1))
+				Expect(handler.sentAcks[0].sendTime).To(Equal(clock.Now()))
+			})
+
+			It("doesn't delete packets if there's no RTT estimate yet", func() {
+				handler.rttStats = congestion.NewRTTStats()
+				now := time.Now()
+				for i := 0; i < protocol.AcksBeforeReceivedPacketGC; i++ {
+					handler.sentAck(now.Add(-time.Hour))
+				}
+				handler.sentAck(now)
+				Expect(handler.packetHistory.ranges.Front().Value).To(Equal(utils.PacketInterval{Start: 1, End: 5}))
+			})
+		})
+
 		It("passes on errors from receivedPacketHistory", func() {
+			handler = NewReceivedPacketHandler(congestion.DefaultClock{}, congestion.NewRTTStats(), protocol.DefaultAckElicitingThreshold, protocol.DefaultMaxTrackedReceivedAckRanges, protocol.DefaultMaxAckRangeAge, protocol.DefaultMaxTrackedReceivedPackets, protocol.DefaultMaxTrackedReceivedAckRanges, false).(*receivedPacketHandler)
 			var err error
-			for i := protocol.PacketNumber(0); i < 5*protocol.MaxTrackedReceivedAckRanges; i++ {
-				err = handler.ReceivedPacket(2*i + 1)
+			for i := protocol.PacketNumber(0); i < 5*protocol.DefaultMaxTrackedReceivedAckRanges; i++ {
+				err = handler.ReceivedPacket(2*i+1, time.Now(), true)
 				// this will eventually return an error
 				// details about when exactly the receivedPacketHistory errors are tested there
 				if err != nil {
@@ -120,7 +215,7 @@ var _ = Describe("receivedPacketHandler", func() {
 
 		It("increase the ignorePacketsBelow number, even if all packets below the LeastUnacked were already acked", func() {
 			for i := 1; i < 20; i++ {
-				err := handler.ReceivedPacket(protocol.PacketNumber(i))
+				err := handler.ReceivedPacket(protocol.PacketNumber(i), time.Now(), true)
 				Expect(err).ToNot(HaveOccurred())
 			}
 			err := handler.ReceivedStopWaiting(&frames.StopWaitingFrame{LeastUnacked: protocol.PacketNumber(12)})
@@ -140,9 +235,9 @@ var _ = Describe("receivedPacketHandler", func() {
 
 	Context("ACK package generation", func() {
 		It("generates a simple ACK frame", func() {
-			err := handler.ReceivedPacket(protocol.PacketNumber(1))
+			err := handler.ReceivedPacket(protocol.PacketNumber(1), time.Now(), true)
 			Expect(err).ToNot(HaveOccurred())
-			err = handler.ReceivedPacket(protocol.PacketNumber(2))
+			err = handler.ReceivedPacket(protocol.PacketNumber(2), time.Now(), true)
 			Expect(err).ToNot(HaveOccurred())
 			ack, err := handler.GetAckFrame(true)
 			Expect(err).ToNot(HaveOccurred())
@@ -152,9 +247,9 @@ var _ = Describe("receivedPacketHandler", func() {
 		})
 
 		It("generates an ACK frame with missing packets", func() {
-			err := handler.ReceivedPacket(protocol.PacketNumber(1))
+			err := handler.ReceivedPacket(protocol.PacketNumber(1), time.Now(), true)
 			Expect(err).ToNot(HaveOccurred())
-			err = handler.ReceivedPacket(protocol.PacketNumber(4))
+			err = handler.ReceivedPacket(protocol.PacketNumber(4), time.Now(), true)
 			Expect(err).ToNot(HaveOccurred())
 			ack, err := handler.GetAckFrame(true)
 			Expect(err).ToNot(HaveOccurred())
@@ -165,10 +260,81 @@ var _ = Describe("receivedPacketHandler", func() {
 			Expect(ack.AckRanges[1]).To(Equal(frames.AckRange{FirstPacketNumber: 1, LastPacketNumber: 1}))
 		})
 
+		It("uses the receive time of the largest packet for the ack delay", func() {
+			rcvTime := time.Now().Add(-10 * time.Millisecond)
+			err := handler.ReceivedPacket(protocol.PacketNumber(2), rcvTime, true)
+			Expect(err).ToNot(HaveOccurred())
+			err = handler.ReceivedPacket(protocol.PacketNumber(1), time.Now(), true)
+			Expect(err).ToNot(HaveOccurred())
+			ack, err := handler.GetAckFrame(true)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ack.PacketReceivedTime).To(Equal(rcvTime))
+		})
+
+		It("uses the clock for the ack delay", func() {
+			clock := mockClock(time.Now().Add(time.Hour))
+			handler.clock = &clock
+			err := handler.ReceivedPacket(protocol.PacketNumber(1), clock.Now(), true)
+			Expect(err).ToNot(HaveOccurred())
+			clock.Advance(10 * time.Millisecond)
+			ack, err := handler.GetAckFrame(false)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ack.DelayTime).To(Equal(10 * time.Millisecond))
+			// the delay is updated when the same ACK frame is sent again
+			clock.Advance(5 * time.Millisecond)
+			ack, err = handler.GetAckFrame(true)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ack.DelayTime).To(Equal(15 * time.Millisecond))
+		})
+
+		It("uses a zero ack delay if the receive time is unknown", func() {
+			err := handler.ReceivedPacket(protocol.PacketNumber(1), time.Time{}, true)
+			Expect(err).ToNot(HaveOccurred())
+			ack, err := handler.GetAckFrame(true)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ack.DelayTime).To(BeZero())
+		})
+
+		It("limits the size of the ACK frame by dropping the oldest ACK ranges", func() {
+			// every second packet is missing, so every packet creates a new ACK range
+			for i := protocol.PacketNumber(1); i < 2*1000; i += 2 {
+				err := handler.ReceivedPacket(i, time.Now(), true)
+				Expect(err).ToNot(HaveOccurred())
+			}
+			ack, err := handler.GetAckFrame(true)
+			Expect(err).ToNot(HaveOccurred())
+			length, err := ack.MinLength(protocol.VersionWhatever)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(length).To(BeNumerically("<=", protocol.MaxAckFrameSize))
+			Expect(ack.LargestAcked).To(Equal(protocol.PacketNumber(2*1000 - 1)))
+			Expect(ack.AckRanges[0]).To(Equal(frames.AckRange{FirstPacketNumber: 2*1000 - 1, LastPacketNumber: 2*1000 - 1}))
+			Expect(ack.LowestAcked).To(Equal(ack.AckRanges[len(ack.AckRanges)-1].FirstPacketNumber))
+			Expect(ack.LowestAcked).To(BeNumerically(">", 1))
+			b := &bytes.Buffer{}
+			err = ack.Write(b, protocol.VersionWhatever)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(protocol.ByteCount(b.Len())).To(BeNumerically("<=", protocol.MaxAckFrameSize))
+		})
+
+		It("limits the size of the ACK frame when the gaps between the ACK ranges are large", func() {
+			// gaps larger than 255 packets need multiple ACK blocks
+			for i := protocol.PacketNumber(1); i <= 500; i++ {
+				err := handler.ReceivedPacket(1000*i, time.Now(), true)
+				Expect(err).ToNot(HaveOccurred())
+			}
+			ack, err := handler.GetAckFrame(true)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ack.LargestAcked).To(Equal(protocol.PacketNumber(500 * 1000)))
+			b := &bytes.Buffer{}
+			err = ack.Write(b, protocol.VersionWhatever)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(protocol.ByteCount(b.Len())).To(BeNumerically("<=", protocol.MaxAckFrameSize))
+		})
+
 		It("does not generate an ACK if an ACK has already been sent for the largest Packet", func() {
-			err := handler.ReceivedPacket(protocol.PacketNumber(1))
+			err := handler.ReceivedPacket(protocol.PacketNumber(1), time.Now(), true)
 			Expect(err).ToNot(HaveOccurred())
-			err = handler.ReceivedPacket(protocol.PacketNumber(2))
+			err = handler.ReceivedPacket(protocol.PacketNumber(2), time.Now(), true)
 			Expect(err).ToNot(HaveOccurred())
 			ack, err := handler.GetAckFrame(true)
 			Expect(err).ToNot(HaveOccurred())
@@ -179,7 +345,7 @@ var _ = Describe("receivedPacketHandler", func() {
 		})
 
 		It("does not dequeue an ACK frame if told so", func() {
-			err := handler.ReceivedPacket(protocol.PacketNumber(2))
+			err := handler.ReceivedPacket(protocol.PacketNumber(2), time.Now(), true)
 			Expect(err).ToNot(HaveOccurred())
 			ack, err := handler.GetAckFrame(false)
 			Expect(err).ToNot(HaveOccurred())
@@ -193,7 +359,7 @@ var _ = Describe("receivedPacketHandler", func() {
 		})
 
 		It("returns a cached ACK frame if the ACK was not dequeued", func() {
-			err := handler.ReceivedPacket(protocol.PacketNumber(2))
+			err := handler.ReceivedPacket(protocol.PacketNumber(2), time.Now(), true)
 			Expect(err).ToNot(HaveOccurred())
 			ack, err := handler.GetAckFrame(false)
 			Expect(err).ToNot(HaveOccurred())
@@ -205,12 +371,12 @@ var _ = Describe("receivedPacketHandler", func() {
 		})
 
 		It("generates a new ACK (and deletes the cached one) when a new packet arrives", func() {
-			err := handler.ReceivedPacket(protocol.PacketNumber(1))
+			err := handler.ReceivedPacket(protocol.PacketNumber(1), time.Now(), true)
 			Expect(err).ToNot(HaveOccurred())
 			ack, _ := handler.GetAckFrame(true)
 			Expect(ack).ToNot(BeNil())
 			Expect(ack.LargestAcked).To(Equal(protocol.PacketNumber(1)))
-			err = handler.ReceivedPacket(protocol.PacketNumber(3))
+			err = handler.ReceivedPacket(protocol.PacketNumber(3), time.Now(), true)
 			Expect(err).ToNot(HaveOccurred())
 			ack, _ = handler.GetAckFrame(true)
 			Expect(ack).ToNot(BeNil())
@@ -218,14 +384,14 @@ var _ = Describe("receivedPacketHandler", func() {
 		})
 
 		It("generates a new ACK when an out-of-order packet arrives", func() {
-			err := handler.ReceivedPacket(protocol.PacketNumber(1))
+			err := handler.ReceivedPacket(protocol.PacketNumber(1), time.Now(), true)
 			Expect(err).ToNot(HaveOccurred())
-			err = handler.ReceivedPacket(protocol.PacketNumber(3))
+			err = handler.ReceivedPacket(protocol.PacketNumber(3), time.Now(), true)
 			Expect(err).ToNot(HaveOccurred())
 			ack, _ := handler.GetAckFrame(true)
 			Expect(ack).ToNot(BeNil())
 			Expect(ack.AckRanges).To(HaveLen(2))
-			err = handler.ReceivedPacket(protocol.PacketNumber(2))
+			err = handler.ReceivedPacket(protocol.PacketNumber(2), time.Now(), true)
 			Expect(err).ToNot(HaveOccurred())
 			ack, _ = handler.GetAckFrame(true)
 			Expect(ack).ToNot(BeNil())
@@ -233,13 +399,13 @@ var _ = Describe("receivedPacketHandler", func() {
 		})
 
 		It("doesn't send old ACK ranges after receiving a StopWaiting", func() {
-			err := handler.ReceivedPacket(5)
+			err := handler.ReceivedPacket(5, time.Now(), true)
 			Expect(err).ToNot(HaveOccurred())
-			err = handler.ReceivedPacket(10)
+			err = handler.ReceivedPacket(10, time.Now(), true)
 			Expect(err).ToNot(HaveOccurred())
-			err = handler.ReceivedPacket(11)
+			err = handler.ReceivedPacket(11, time.Now(), true)
 			Expect(err).ToNot(HaveOccurred())
-			err = handler.ReceivedPacket(12)
+			err = handler.ReceivedPacket(12, time.Now(), true)
 			Expect(err).ToNot(HaveOccurred())
 			err = handler.ReceivedStopWaiting(&frames.StopWaitingFrame{LeastUnacked: protocol.PacketNumber(11)})
 			Expect(err).ToNot(HaveOccurred())
@@ -253,7 +419,7 @@ var _ = Describe("receivedPacketHandler", func() {
 
 		It("deletes packets from the packetHistory after receiving a StopWaiting, after continuously received packets", func() {
 			for i := 1; i <= 12; i++ {
-				err := handler.ReceivedPacket(protocol.PacketNumber(i))
+				err := handler.ReceivedPacket(protocol.PacketNumber(i), time.Now(), true)
 				Expect(err).ToNot(HaveOccurred())
 			}
 			err := handler.ReceivedStopWaiting(&frames.StopWaitingFrame{LeastUnacked: protocol.PacketNumber(6)})
@@ -267,4 +433,228 @@ var _ = Describe("receivedPacketHandler", func() {
 			Expect(ack.HasMissingRanges()).To(BeFalse())
 		})
 	})
+
+	Context("packets that don't instigate ACKs", func() {
+		It("doesn't generate an ACK frame", func() {
+			err := handler.ReceivedPacket(1, time.Now(), false)
+			Expect(err).ToNot(HaveOccurred())
+			ack, err := handler.GetAckFrame(true)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ack).To(BeNil())
+			Expect(handler.ShouldAckImmediately()).To(BeFalse())
+		})
+
+		It("doesn't ack immediately when such a packet creates a gap", func() {
+			err := handler.ReceivedPacket(3, time.Now(), false)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(handler.ShouldAckImmediately()).To(BeFalse())
+		})
+
+		It("includes the packet in the next ACK frame", func() {
+			err := handler.ReceivedPacket(1, time.Now(), true)
+			Expect(err).ToNot(HaveOccurred())
+			ack, err := handler.GetAckFrame(false)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ack.LargestAcked).To(Equal(protocol.PacketNumber(1)))
+			err = handler.ReceivedPacket(2, time.Now(), false)
+			Expect(err).ToNot(HaveOccurred())
+			ack, err = handler.GetAckFrame(true)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ack.LargestAcked).To(Equal(protocol.PacketNumber(2)))
+			Expect(ack.LowestAcked).To(Equal(protocol.PacketNumber(1)))
+		})
+
+		It("detects duplicates", func() {
+			err := handler.ReceivedPacket(1, time.Now(), false)
+			Expect(err).ToNot(HaveOccurred())
+			err = handler.ReceivedPacket(1, time.Now(), true)
+			Expect(err).To(MatchError(ErrDuplicatePacket))
+		})
+	})
+
+	Context("ACK-eliciting threshold", func() {
+		It("doesn't ack immediately before the threshold is reached", func() {
+			err := handler.ReceivedPacket(1, time.Now(), true)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(handler.ShouldAckImmediately()).To(BeFalse())
+		})
+
+		It("acks immediately once the threshold is reached", func() {
+			for i := protocol.PacketNumber(1); i <= protocol.DefaultAckElicitingThreshold; i++ {
+				err := handler.ReceivedPacket(i, time.Now(), true)
+				Expect(err).ToNot(HaveOccurred())
+			}
+			Expect(handler.ShouldAckImmediately()).To(BeTrue())
+		})
+
+		It("doesn't count duplicate packets", func() {
+			err := handler.ReceivedPacket(1, time.Now(), true)
+			Expect(err).ToNot(HaveOccurred())
+			err = handler.ReceivedPacket(1, time.Now(), true)
+			Expect(err).To(MatchError(ErrDuplicatePacket))
+			Expect(handler.ShouldAckImmediately()).To(BeFalse())
+		})
+
+		It("resets the counter when an ACK is dequeued", func() {
+			for i := protocol.PacketNumber(1); i <= protocol.DefaultAckElicitingThreshold; i++ {
+				err := handler.ReceivedPacket(i, time.Now(), true)
+				Expect(err).ToNot(HaveOccurred())
+			}
+			_, err := handler.GetAckFrame(false)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(handler.ShouldAckImmediately()).To(BeTrue())
+			_, err = handler.GetAckFrame(true)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(handler.ShouldAckImmediately()).To(BeFalse())
+		})
+
+		It("acks immediately when a packet creates a gap", func() {
+			err := handler.ReceivedPacket(3, time.Now(), true)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(handler.ShouldAckImmediately()).To(BeTrue())
+		})
+
+		It("acks immediately when a packet fills a gap", func() {
+			handler = NewReceivedPacketHandler(congestion.DefaultClock{}, congestion.NewRTTStats(), 10, protocol.DefaultMaxAckRanges, protocol.DefaultMaxAckRangeAge, protocol.DefaultMaxTrackedReceivedPackets, protocol.DefaultMaxTrackedReceivedAckRanges, false).(*receivedPacketHandler)
+			err := handler.ReceivedPacket(1, time.Now(), true)
+			Expect(err).ToNot(HaveOccurred())
+			err = handler.ReceivedPacket(3, time.Now(), true)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = handler.GetAckFrame(true)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(handler.ShouldAckImmediately()).To(BeFalse())
+			err = handler.ReceivedPacket(2, time.Now(), true)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(handler.ShouldAckImmediately()).To(BeTrue())
+		})
+
+		It("doesn't ack immediately when packets arrive in order", func() {
+			handler = NewReceivedPacketHandler(congestion.DefaultClock{}, congestion.NewRTTStats(), 10, protocol.DefaultMaxAckRanges, protocol.DefaultMaxAckRangeAge, protocol.DefaultMaxTrackedReceivedPackets, protocol.DefaultMaxTrackedReceivedAckRanges, false).(*receivedPacketHandler)
+			for i := protocol.PacketNumber(1); i < 5; i++ {
+				err := handler.ReceivedPacket(i, time.Now(), true)
+				Expect(err).ToNot(HaveOccurred())
+			}
+			Expect(handler.ShouldAckImmediately()).To(BeFalse())
+		})
+
+		It("uses the configured threshold", func() {
+			handler = NewReceivedPacketHandler(congestion.DefaultClock{}, congestion.NewRTTStats(), 1, protocol.DefaultMaxAckRanges, protocol.DefaultMaxAckRangeAge, protocol.DefaultMaxTrackedReceivedPackets, protocol.DefaultMaxTrackedReceivedAckRanges, false).(*receivedPacketHandler)
+			err := handler.ReceivedPacket(1, time.Now(), true)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(handler.ShouldAckImmediately()).To(BeTrue())
+		})
+	})
+
+	Context("timestamps", func() {
+		var start time.Time
+
+		BeforeEach(func() {
+			handler = NewReceivedPacketHandler(congestion.DefaultClock{}, congestion.NewRTTStats(), protocol.DefaultAckElicitingThreshold, protocol.DefaultMaxAckRanges, protocol.DefaultMaxAckRangeAge, protocol.DefaultMaxTrackedReceivedPackets, protocol.DefaultMaxTrackedReceivedAckRanges, true).(*receivedPacketHandler)
+			start = handler.startTime
+		})
+
+		It("doesn't send timestamps, if disabled", func() {
+			handler = NewReceivedPacketHandler(congestion.DefaultClock{}, congestion.NewRTTStats(), protocol.DefaultAckElicitingThreshold, protocol.DefaultMaxAckRanges, protocol.DefaultMaxAckRangeAge, protocol.DefaultMaxTrackedReceivedPackets, protocol.DefaultMaxTrackedReceivedAckRanges, false).(*receivedPacketHandler)
+			err := handler.ReceivedPacket(1, time.Now(), true)
+			Expect(err).ToNot(HaveOccurred())
+			ack, err := handler.GetAckFrame(true)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ack.Timestamps).To(BeEmpty())
+		})
+
+		It("sends the receive times of the packets received since the last ACK", func() {
+			err := handler.ReceivedPacket(1, start.Add(10*time.Millisecond), true)
+			Expect(err).ToNot(HaveOccurred())
+			err = handler.ReceivedPacket(2, start.Add(20*time.Millisecond), true)
+			Expect(err).ToNot(HaveOccurred())
+			ack, err := handler.GetAckFrame(false)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ack.Timestamps).To(Equal([]frames.AckTimestamp{
+				{PacketNumber: 1, ReceivedTime: 10 * time.Millisecond},
+				{PacketNumber: 2, ReceivedTime: 20 * time.Millisecond},
+			}))
+			ack, err = handler.GetAckFrame(true)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ack.Timestamps).To(HaveLen(2))
+			err = handler.ReceivedPacket(3, start.Add(30*time.Millisecond), true)
+			Expect(err).ToNot(HaveOccurred())
+			ack, err = handler.GetAckFrame(true)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ack.Timestamps).To(Equal([]frames.AckTimestamp{{PacketNumber: 3, ReceivedTime: 30 * time.Millisecond}}))
+		})
+
+		It("skips packets that were received before the previous packet", func() {
+			err := handler.ReceivedPacket(1, start.Add(20*time.Millisecond), true)
+			Expect(err).ToNot(HaveOccurred())
+			err = handler.ReceivedPacket(2, start.Add(10*time.Millisecond), true)
+			Expect(err).ToNot(HaveOccurred())
+			ack, err := handler.GetAckFrame(true)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ack.Timestamps).To(Equal([]frames.AckTimestamp{{PacketNumber: 1, ReceivedTime: 20 * time.Millisecond}}))
+		})
+
+		It("sends at most protocol.MaxAckTimestamps timestamps", func() {
+			for i := 1; i <= protocol.MaxAckTimestamps+5; i++ {
+				err := handler.ReceivedPacket(protocol.PacketNumber(i), start.Add(time.Duration(i)*time.Millisecond), true)
+				Expect(err).ToNot(HaveOccurred())
+			}
+			ack, err := handler.GetAckFrame(true)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ack.Timestamps).To(HaveLen(protocol.MaxAckTimestamps))
+			Expect(ack.Timestamps[0].PacketNumber).To(Equal(protocol.PacketNumber(1)))
+		})
+
+		It("omits timestamps of packets too far below the largest observed packet", func() {
+			err := handler.ReceivedPacket(1, start.Add(10*time.Millisecond), true)
+			Expect(err).ToNot(HaveOccurred())
+			err = handler.ReceivedPacket(0x1000, start.Add(20*time.Millisecond), true)
+			Expect(err).ToNot(HaveOccurred())
+			ack, err := handler.GetAckFrame(true)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ack.Timestamps).To(Equal([]frames.AckTimestamp{{PacketNumber: 0x1000, ReceivedTime: 20 * time.Millisecond}}))
+		})
+	})
+
+	Context("ACK ranges", func() {
+		It("returns no ranges before receiving a packet", func() {
+			Expect(handler.GetAckRanges()).To(BeEmpty())
+		})
+
+		It("returns the ranges of received packets", func() {
+			for _, p := range []protocol.PacketNumber{1, 2, 3, 6, 7} {
+				err := handler.ReceivedPacket(p, time.Now(), true)
+				Expect(err).ToNot(HaveOccurred())
+			}
+			Expect(handler.GetAckRanges()).To(Equal([]frames.AckRange{
+				{FirstPacketNumber: 6, LastPacketNumber: 7},
+				{FirstPacketNumber: 1, LastPacketNumber: 3},
+			}))
+		})
+	})
 })
+
+func BenchmarkReceivedPacketHandler(b *testing.B) {
+	clock := congestion.DefaultClock{}
+	handler := NewReceivedPacketHandler(clock, congestion.NewRTTStats(), protocol.DefaultAckElicitingThreshold, protocol.DefaultMaxAckRanges, protocol.DefaultMaxAckRangeAge, protocol.DefaultMaxTrackedReceivedPackets, protocol.DefaultMaxTrackedReceivedAckRanges, false)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 1; i <= b.N; i++ {
+		// skip every 10th packet, such that the ACK frames contain ranges
+		if i%10 == 0 {
+			continue
+		}
+		if err := handler.ReceivedPacket(protocol.PacketNumber(i), clock.Now(), true); err != nil {
+			b.Fatal(err)
+		}
+		if i%2 == 0 {
+			if _, err := handler.GetAckFrame(true); err != nil {
+				b.Fatal(err)
+			}
+		}
+		if i > 100 && i%100 == 1 {
+			if err := handler.ReceivedStopWaiting(&frames.StopWaitingFrame{LeastUnacked: protocol.PacketNumber(i - 50)}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
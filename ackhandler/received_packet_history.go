@@ -1,6 +1,8 @@
 package ackhandler
 
 import (
+	"time"
+
 	"github.com/lucas-clemente/quic-go/frames"
 	"github.com/lucas-clemente/quic-go/protocol"
 	"github.com/lucas-clemente/quic-go/qerr"
@@ -10,9 +12,14 @@ import (
 type receivedPacketHistory struct {
 	ranges *utils.PacketIntervalList
 
-	// the map is used as a replacement for a set here. The bool is always supposed to be set to true
-	receivedPacketNumbers         map[protocol.PacketNumber]bool
+	// the map contains the time when each packet was received
+	receivedPacketNumbers         map[protocol.PacketNumber]time.Time
 	lowestInReceivedPacketNumbers protocol.PacketNumber
+	// the time when the newest packet of each range was received
+	rangeRcvTimes map[*utils.PacketIntervalElement]time.Time
+
+	maxTrackedPackets   int
+	maxTrackedAckRanges int
 }
 
 var (
@@ -21,27 +28,31 @@ var (
 )
 
 // newReceivedPacketHistory creates a new received packet history
-func newReceivedPacketHistory() *receivedPacketHistory {
+// It returns an error if more than maxTrackedPackets packets or maxTrackedAckRanges ACK ranges would have to be tracked.
+func newReceivedPacketHistory(maxTrackedPackets, maxTrackedAckRanges int) *receivedPacketHistory {
 	return &receivedPacketHistory{
 		ranges:                utils.NewPacketIntervalList(),
-		receivedPacketNumbers: make(map[protocol.PacketNumber]bool),
+		receivedPacketNumbers: make(map[protocol.PacketNumber]time.Time),
+		rangeRcvTimes:         make(map[*utils.PacketIntervalElement]time.Time),
+		maxTrackedPackets:     maxTrackedPackets,
+		maxTrackedAckRanges:   maxTrackedAckRanges,
 	}
 }
 
-// ReceivedPacket registers a packet with PacketNumber p and updates the ranges
-func (h *receivedPacketHistory) ReceivedPacket(p protocol.PacketNumber) error {
-	if h.ranges.Len() >= protocol.MaxTrackedReceivedAckRanges {
+// ReceivedPacket registers a packet with PacketNumber p, received at rcvTime, and updates the ranges
+func (h *receivedPacketHistory) ReceivedPacket(p protocol.PacketNumber, rcvTime time.Time) error {
+	if h.ranges.Len() >= h.maxTrackedAckRanges {
 		return errTooManyOutstandingReceivedAckRanges
 	}
 
-	if len(h.receivedPacketNumbers) >= protocol.MaxTrackedReceivedPackets {
+	if len(h.receivedPacketNumbers) >= h.maxTrackedPackets {
 		return errTooManyOutstandingReceivedPackets
 	}
 
-	h.receivedPacketNumbers[p] = true
+	h.receivedPacketNumbers[p] = rcvTime
 
 	if h.ranges.Len() == 0 {
-		h.ranges.PushBack(utils.PacketInterval{Start: p, End: p})
+		h.rangeRcvTimes[h.ranges.PushBack(utils.PacketInterval{Start: p, End: p})] = rcvTime
 		return nil
 	}
 
@@ -62,9 +73,12 @@ func (h *receivedPacketHistory) ReceivedPacket(p protocol.PacketNumber) error {
 
 		// if a range was extended (either at the beginning or at the end, maybe it is possible to merge two ranges into one)
 		if rangeExtended {
+			h.updateRangeRcvTime(el, rcvTime)
 			prev := el.Prev()
 			if prev != nil && prev.Value.End+1 == el.Value.Start { // merge two ranges
 				prev.Value.End = el.Value.End
+				h.updateRangeRcvTime(prev, h.rangeRcvTimes[el])
+				delete(h.rangeRcvTimes, el)
 				h.ranges.Remove(el)
 				return nil
 			}
@@ -73,17 +87,23 @@ func (h *receivedPacketHistory) ReceivedPacket(p protocol.PacketNumber) error {
 
 		// create a new range at the end
 		if p > el.Value.End {
-			h.ranges.InsertAfter(utils.PacketInterval{Start: p, End: p}, el)
+			h.rangeRcvTimes[h.ranges.InsertAfter(utils.PacketInterval{Start: p, End: p}, el)] = rcvTime
 			return nil
 		}
 	}
 
 	// create a new range at the beginning
-	h.ranges.InsertBefore(utils.PacketInterval{Start: p, End: p}, h.ranges.Front())
+	h.rangeRcvTimes[h.ranges.InsertBefore(utils.PacketInterval{Start: p, End: p}, h.ranges.Front())] = rcvTime
 
 	return nil
 }
 
+func (h *receivedPacketHistory) updateRangeRcvTime(el *utils.PacketIntervalElement, rcvTime time.Time) {
+	if rcvTime.After(h.rangeRcvTimes[el]) {
+		h.rangeRcvTimes[el] = rcvTime
+	}
+}
+
 // DeleteBelow deletes all entries below the leastUnacked packet number
 func (h *receivedPacketHistory) DeleteBelow(leastUnacked protocol.PacketNumber) {
 	h.lowestInReceivedPacketNumbers = utils.MaxPacketNumber(h.lowestInReceivedPacketNumbers, leastUnacked)
@@ -101,6 +121,7 @@ func (h *receivedPacketHistory) DeleteBelow(leastUnacked protocol.PacketNumber)
 			for i := el.Value.Start; i <= el.Value.End; i++ {
 				delete(h.receivedPacketNumbers, i)
 			}
+			delete(h.rangeRcvTimes, el)
 			h.ranges.Remove(el)
 		} else { // no ranges affected. Nothing to do
 			return
@@ -108,6 +129,19 @@ func (h *receivedPacketHistory) DeleteBelow(leastUnacked protocol.PacketNumber)
 	}
 }
 
+// DeleteOldRanges deletes the oldest ranges, such that at most maxRanges ranges are kept.
+// It also deletes ranges whose newest packet was received before minRcvTime.
+// Just like after a StopWaiting, packets below the deleted ranges will be regarded as duplicates.
+func (h *receivedPacketHistory) DeleteOldRanges(maxRanges int, minRcvTime time.Time) {
+	for h.ranges.Len() > 0 {
+		front := h.ranges.Front()
+		if h.ranges.Len() <= maxRanges && !h.rangeRcvTimes[front].Before(minRcvTime) {
+			return
+		}
+		h.DeleteBelow(front.Value.End + 1)
+	}
+}
+
 // IsDuplicate determines if a packet should be regarded as a duplicate packet
 // note that after receiving a StopWaitingFrame, all packets below the LeastUnacked should be regarded as duplicates, even if the packet was just delayed
 func (h *receivedPacketHistory) IsDuplicate(p protocol.PacketNumber) bool {
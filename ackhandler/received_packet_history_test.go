@@ -1,6 +1,8 @@
 package ackhandler
 
 import (
+	"time"
+
 	"github.com/lucas-clemente/quic-go/frames"
 	"github.com/lucas-clemente/quic-go/protocol"
 	"github.com/lucas-clemente/quic-go/utils"
@@ -14,7 +16,7 @@ var _ = Describe("receivedPacketHistory", func() {
 	)
 
 	BeforeEach(func() {
-		hist = newReceivedPacketHistory()
+		hist = newReceivedPacketHistory(protocol.DefaultMaxTrackedReceivedPackets, protocol.DefaultMaxTrackedReceivedAckRanges)
 	})
 
 	// check if the ranges PacketIntervalList contains exactly the same packet number as the receivedPacketNumbers
@@ -46,54 +48,64 @@ var _ = Describe("receivedPacketHistory", func() {
 			}
 		}
 
+		// finally check if there's a receive time for every range
+		if len(hist.rangeRcvTimes) != hist.ranges.Len() {
+			return false
+		}
+		for el := hist.ranges.Front(); el != nil; el = el.Next() {
+			if _, ok := hist.rangeRcvTimes[el]; !ok {
+				return false
+			}
+		}
+
 		return true
 	}
 
 	Context("ranges", func() {
 		It("adds the first packet", func() {
-			hist.ReceivedPacket(4)
+			hist.ReceivedPacket(4, time.Now())
 			Expect(hist.ranges.Len()).To(Equal(1))
 			Expect(hist.ranges.Front().Value).To(Equal(utils.PacketInterval{Start: 4, End: 4}))
 			Expect(historiesConsistent()).To(BeTrue())
 		})
 
 		It("doesn't care about duplicate packets", func() {
-			hist.ReceivedPacket(4)
+			hist.ReceivedPacket(4, time.Now())
 			Expect(hist.ranges.Len()).To(Equal(1))
 			Expect(hist.ranges.Front().Value).To(Equal(utils.PacketInterval{Start: 4, End: 4}))
 			Expect(historiesConsistent()).To(BeTrue())
 		})
 
 		It("adds a few consecutive packets", func() {
-			hist.ReceivedPacket(4)
-			hist.ReceivedPacket(5)
-			hist.ReceivedPacket(6)
+			hist.ReceivedPacket(4, time.Now())
+			hist.ReceivedPacket(5, time.Now())
+			hist.ReceivedPacket(6, time.Now())
 			Expect(hist.ranges.Len()).To(Equal(1))
 			Expect(hist.ranges.Front().Value).To(Equal(utils.PacketInterval{Start: 4, End: 6}))
 			Expect(historiesConsistent()).To(BeTrue())
 		})
 
 		It("doesn't care about a duplicate packet contained in an existing range", func() {
-			hist.ReceivedPacket(4)
-			hist.ReceivedPacket(5)
-			hist.ReceivedPacket(6)
-			hist.ReceivedPacket(5)
+			hist.ReceivedPacket(4, time.Now())
+			hist.ReceivedPacket(5, time.Now())
+			hist.ReceivedPacket(6, time.Now())
+			hist.ReceivedPacket(5, time.Now())
 			Expect(hist.ranges.Len()).To(Equal(1))
 			Expect(hist.ranges.Front().Value).To(Equal(utils.PacketInterval{Start: 4, End: 6}))
 			Expect(historiesConsistent()).To(BeTrue())
 		})
 
 		It("extends a range at the front", func() {
-			hist.ReceivedPacket(4)
-			hist.ReceivedPacket(3)
+			hist.ReceivedPacket(4, time.Now())
+			hist.ReceivedPacket(3, time.Now())
 			Expect(hist.ranges.Len()).To(Equal(1))
 			Expect(hist.ranges.Front().Value).To(Equal(utils.PacketInterval{Start: 3, End: 4}))
 			Expect(historiesConsistent()).To(BeTrue())
 		})
 
 		It("creates a new range when a packet is lost", func() {
-			hist.ReceivedPacket(4)
-			hist.ReceivedPacket(6)
+			hist.ReceivedPacket(4, time.Now())
+			hist.ReceivedPacket(6, time.Now())
 			Expect(hist.ranges.Len()).To(Equal(2))
 			Expect(hist.ranges.Front().Value).To(Equal(utils.PacketInterval{Start: 4, End: 4}))
 			Expect(hist.ranges.Back().Value).To(Equal(utils.PacketInterval{Start: 6, End: 6}))
@@ -101,10 +113,10 @@ var _ = Describe("receivedPacketHistory", func() {
 		})
 
 		It("creates a new range in between two ranges", func() {
-			hist.ReceivedPacket(4)
-			hist.ReceivedPacket(10)
+			hist.ReceivedPacket(4, time.Now())
+			hist.ReceivedPacket(10, time.Now())
 			Expect(hist.ranges.Len()).To(Equal(2))
-			hist.ReceivedPacket(7)
+			hist.ReceivedPacket(7, time.Now())
 			Expect(hist.ranges.Len()).To(Equal(3))
 			Expect(hist.ranges.Front().Value).To(Equal(utils.PacketInterval{Start: 4, End: 4}))
 			Expect(hist.ranges.Front().Next().Value).To(Equal(utils.PacketInterval{Start: 7, End: 7}))
@@ -113,8 +125,8 @@ var _ = Describe("receivedPacketHistory", func() {
 		})
 
 		It("creates a new range before an existing range for a belated packet", func() {
-			hist.ReceivedPacket(6)
-			hist.ReceivedPacket(4)
+			hist.ReceivedPacket(6, time.Now())
+			hist.ReceivedPacket(4, time.Now())
 			Expect(hist.ranges.Len()).To(Equal(2))
 			Expect(hist.ranges.Front().Value).To(Equal(utils.PacketInterval{Start: 4, End: 4}))
 			Expect(hist.ranges.Back().Value).To(Equal(utils.PacketInterval{Start: 6, End: 6}))
@@ -122,9 +134,9 @@ var _ = Describe("receivedPacketHistory", func() {
 		})
 
 		It("extends a previous range at the end", func() {
-			hist.ReceivedPacket(4)
-			hist.ReceivedPacket(7)
-			hist.ReceivedPacket(5)
+			hist.ReceivedPacket(4, time.Now())
+			hist.ReceivedPacket(7, time.Now())
+			hist.ReceivedPacket(5, time.Now())
 			Expect(hist.ranges.Len()).To(Equal(2))
 			Expect(hist.ranges.Front().Value).To(Equal(utils.PacketInterval{Start: 4, End: 5}))
 			Expect(hist.ranges.Back().Value).To(Equal(utils.PacketInterval{Start: 7, End: 7}))
@@ -132,9 +144,9 @@ var _ = Describe("receivedPacketHistory", func() {
 		})
 
 		It("extends a range at the front", func() {
-			hist.ReceivedPacket(4)
-			hist.ReceivedPacket(7)
-			hist.ReceivedPacket(6)
+			hist.ReceivedPacket(4, time.Now())
+			hist.ReceivedPacket(7, time.Now())
+			hist.ReceivedPacket(6, time.Now())
 			Expect(hist.ranges.Len()).To(Equal(2))
 			Expect(hist.ranges.Front().Value).To(Equal(utils.PacketInterval{Start: 4, End: 4}))
 			Expect(hist.ranges.Back().Value).To(Equal(utils.PacketInterval{Start: 6, End: 7}))
@@ -142,22 +154,22 @@ var _ = Describe("receivedPacketHistory", func() {
 		})
 
 		It("closes a range", func() {
-			hist.ReceivedPacket(6)
-			hist.ReceivedPacket(4)
+			hist.ReceivedPacket(6, time.Now())
+			hist.ReceivedPacket(4, time.Now())
 			Expect(hist.ranges.Len()).To(Equal(2))
-			hist.ReceivedPacket(5)
+			hist.ReceivedPacket(5, time.Now())
 			Expect(hist.ranges.Len()).To(Equal(1))
 			Expect(hist.ranges.Front().Value).To(Equal(utils.PacketInterval{Start: 4, End: 6}))
 			Expect(historiesConsistent()).To(BeTrue())
 		})
 
 		It("closes a range in the middle", func() {
-			hist.ReceivedPacket(1)
-			hist.ReceivedPacket(10)
-			hist.ReceivedPacket(4)
-			hist.ReceivedPacket(6)
+			hist.ReceivedPacket(1, time.Now())
+			hist.ReceivedPacket(10, time.Now())
+			hist.ReceivedPacket(4, time.Now())
+			hist.ReceivedPacket(6, time.Now())
 			Expect(hist.ranges.Len()).To(Equal(4))
-			hist.ReceivedPacket(5)
+			hist.ReceivedPacket(5, time.Now())
 			Expect(hist.ranges.Len()).To(Equal(3))
 			Expect(hist.ranges.Front().Value).To(Equal(utils.PacketInterval{Start: 1, End: 1}))
 			Expect(hist.ranges.Front().Next().Value).To(Equal(utils.PacketInterval{Start: 4, End: 6}))
@@ -174,9 +186,9 @@ var _ = Describe("receivedPacketHistory", func() {
 		})
 
 		It("deletes a range", func() {
-			hist.ReceivedPacket(4)
-			hist.ReceivedPacket(5)
-			hist.ReceivedPacket(10)
+			hist.ReceivedPacket(4, time.Now())
+			hist.ReceivedPacket(5, time.Now())
+			hist.ReceivedPacket(10, time.Now())
 			hist.DeleteBelow(6)
 			Expect(hist.ranges.Len()).To(Equal(1))
 			Expect(hist.ranges.Front().Value).To(Equal(utils.PacketInterval{Start: 10, End: 10}))
@@ -184,9 +196,9 @@ var _ = Describe("receivedPacketHistory", func() {
 		})
 
 		It("deletes multiple ranges", func() {
-			hist.ReceivedPacket(1)
-			hist.ReceivedPacket(5)
-			hist.ReceivedPacket(10)
+			hist.ReceivedPacket(1, time.Now())
+			hist.ReceivedPacket(5, time.Now())
+			hist.ReceivedPacket(10, time.Now())
 			hist.DeleteBelow(8)
 			Expect(hist.ranges.Len()).To(Equal(1))
 			Expect(hist.ranges.Front().Value).To(Equal(utils.PacketInterval{Start: 10, End: 10}))
@@ -194,10 +206,10 @@ var _ = Describe("receivedPacketHistory", func() {
 		})
 
 		It("adjusts a range, if leastUnacked lies inside it", func() {
-			hist.ReceivedPacket(3)
-			hist.ReceivedPacket(4)
-			hist.ReceivedPacket(5)
-			hist.ReceivedPacket(6)
+			hist.ReceivedPacket(3, time.Now())
+			hist.ReceivedPacket(4, time.Now())
+			hist.ReceivedPacket(5, time.Now())
+			hist.ReceivedPacket(6, time.Now())
 			hist.DeleteBelow(4)
 			Expect(hist.ranges.Len()).To(Equal(1))
 			Expect(hist.ranges.Front().Value).To(Equal(utils.PacketInterval{Start: 4, End: 6}))
@@ -205,9 +217,9 @@ var _ = Describe("receivedPacketHistory", func() {
 		})
 
 		It("adjusts a range, if leastUnacked is the last of the range", func() {
-			hist.ReceivedPacket(4)
-			hist.ReceivedPacket(5)
-			hist.ReceivedPacket(10)
+			hist.ReceivedPacket(4, time.Now())
+			hist.ReceivedPacket(5, time.Now())
+			hist.ReceivedPacket(10, time.Now())
 			hist.DeleteBelow(5)
 			Expect(hist.ranges.Len()).To(Equal(2))
 			Expect(hist.ranges.Front().Value).To(Equal(utils.PacketInterval{Start: 5, End: 5}))
@@ -216,44 +228,115 @@ var _ = Describe("receivedPacketHistory", func() {
 		})
 
 		It("keeps a one-packet range, if leastUnacked is exactly that value", func() {
-			hist.ReceivedPacket(4)
+			hist.ReceivedPacket(4, time.Now())
 			hist.DeleteBelow(4)
 			Expect(hist.ranges.Len()).To(Equal(1))
 			Expect(hist.ranges.Front().Value).To(Equal(utils.PacketInterval{Start: 4, End: 4}))
 			Expect(historiesConsistent()).To(BeTrue())
 		})
 
+		Context("deleting old ranges", func() {
+			It("deletes the oldest ranges if there are too many", func() {
+				hist.ReceivedPacket(1, time.Now())
+				hist.ReceivedPacket(3, time.Now())
+				hist.ReceivedPacket(5, time.Now())
+				hist.ReceivedPacket(7, time.Now())
+				hist.DeleteOldRanges(2, time.Time{})
+				Expect(hist.ranges.Len()).To(Equal(2))
+				Expect(hist.ranges.Front().Value).To(Equal(utils.PacketInterval{Start: 5, End: 5}))
+				Expect(hist.IsDuplicate(2)).To(BeTrue())
+				Expect(historiesConsistent()).To(BeTrue())
+			})
+
+			It("deletes ranges that didn't receive packets for a long time", func() {
+				now := time.Now()
+				hist.ReceivedPacket(1, now.Add(-time.Minute))
+				hist.ReceivedPacket(2, now.Add(-time.Minute))
+				hist.ReceivedPacket(4, now.Add(-time.Minute))
+				hist.ReceivedPacket(5, now)
+				hist.ReceivedPacket(7, now)
+				hist.DeleteOldRanges(10, now.Add(-time.Second))
+				Expect(hist.ranges.Len()).To(Equal(2))
+				Expect(hist.ranges.Front().Value).To(Equal(utils.PacketInterval{Start: 4, End: 5}))
+				Expect(historiesConsistent()).To(BeTrue())
+			})
+
+			It("keeps ranges that were extended at the beginning recently", func() {
+				now := time.Now()
+				hist.ReceivedPacket(2, now.Add(-time.Minute))
+				hist.ReceivedPacket(1, now)
+				hist.DeleteOldRanges(10, now.Add(-time.Second))
+				Expect(hist.ranges.Len()).To(Equal(1))
+				Expect(hist.ranges.Front().Value).To(Equal(utils.PacketInterval{Start: 1, End: 2}))
+				Expect(historiesConsistent()).To(BeTrue())
+			})
+
+			It("uses the newest receive time when merging ranges", func() {
+				now := time.Now()
+				hist.ReceivedPacket(3, now.Add(-time.Minute))
+				hist.ReceivedPacket(1, now)
+				hist.ReceivedPacket(2, now.Add(-time.Minute))
+				hist.DeleteOldRanges(10, now.Add(-time.Second))
+				Expect(hist.ranges.Len()).To(Equal(1))
+				Expect(hist.ranges.Front().Value).To(Equal(utils.PacketInterval{Start: 1, End: 3}))
+				Expect(historiesConsistent()).To(BeTrue())
+			})
+
+			It("keeps recent ranges", func() {
+				hist.ReceivedPacket(1, time.Now())
+				hist.ReceivedPacket(3, time.Now())
+				hist.DeleteOldRanges(10, time.Now().Add(-time.Second))
+				Expect(hist.ranges.Len()).To(Equal(2))
+				Expect(historiesConsistent()).To(BeTrue())
+			})
+		})
+
 		Context("DoS protection", func() {
 			It("doesn't create more than MaxTrackedReceivedAckRanges ranges", func() {
-				for i := protocol.PacketNumber(1); i <= protocol.MaxTrackedReceivedAckRanges; i++ {
-					err := hist.ReceivedPacket(2 * i)
+				for i := protocol.PacketNumber(1); i <= protocol.DefaultMaxTrackedReceivedAckRanges; i++ {
+					err := hist.ReceivedPacket(2*i, time.Now())
 					Expect(err).ToNot(HaveOccurred())
 				}
-				err := hist.ReceivedPacket(2*protocol.MaxTrackedReceivedAckRanges + 2)
+				err := hist.ReceivedPacket(2*protocol.DefaultMaxTrackedReceivedAckRanges+2, time.Now())
 				Expect(err).To(MatchError(errTooManyOutstandingReceivedAckRanges))
 				Expect(historiesConsistent()).To(BeTrue())
 			})
 
 			It("doesn't store more than MaxTrackedReceivedPackets packets", func() {
-				err := hist.ReceivedPacket(1)
+				err := hist.ReceivedPacket(1, time.Now())
 				Expect(err).ToNot(HaveOccurred())
-				for i := protocol.PacketNumber(3); i < 3+protocol.MaxTrackedReceivedPackets-1; i++ {
-					err := hist.ReceivedPacket(protocol.PacketNumber(i))
+				for i := protocol.PacketNumber(3); i < 3+protocol.DefaultMaxTrackedReceivedPackets-1; i++ {
+					err := hist.ReceivedPacket(protocol.PacketNumber(i), time.Now())
 					Expect(err).ToNot(HaveOccurred())
 				}
-				err = hist.ReceivedPacket(protocol.PacketNumber(protocol.MaxTrackedReceivedPackets) + 10)
+				err = hist.ReceivedPacket(protocol.PacketNumber(protocol.DefaultMaxTrackedReceivedPackets)+10, time.Now())
 				Expect(err).To(MatchError(errTooManyOutstandingReceivedPackets))
 			})
 
+			It("uses the configured limit for ACK ranges", func() {
+				hist = newReceivedPacketHistory(100, 2)
+				Expect(hist.ReceivedPacket(1, time.Now())).To(Succeed())
+				Expect(hist.ReceivedPacket(3, time.Now())).To(Succeed())
+				Expect(hist.ReceivedPacket(5, time.Now())).To(MatchError(errTooManyOutstandingReceivedAckRanges))
+			})
+
+			It("uses the configured limit for packets", func() {
+				hist = newReceivedPacketHistory(3, 100)
+				Expect(hist.ReceivedPacket(1, time.Now())).To(Succeed())
+				Expect(hist.ReceivedPacket(2, time.Now())).To(Succeed())
+				Expect(hist.ReceivedPacket(3, time.Now())).To(Succeed())
+				Expect(hist.ReceivedPacket(4, time.Now())).To(MatchError(errTooManyOutstandingReceivedPackets))
+			})
+
 			It("doesn't consider already deleted ranges for MaxTrackedReceivedAckRanges", func() {
-				for i := protocol.PacketNumber(1); i <= protocol.MaxTrackedReceivedAckRanges; i++ {
-					err := hist.ReceivedPacket(2 * i)
+				for i := protocol.PacketNumber(1); i <= protocol.DefaultMaxTrackedReceivedAckRanges; i++ {
+					err := hist.ReceivedPacket(2*i, time.Now())
 					Expect(err).ToNot(HaveOccurred())
 				}
-				err := hist.ReceivedPacket(2*protocol.MaxTrackedReceivedAckRanges + 2)
+				err := hist.ReceivedPacket(2*protocol.DefaultMaxTrackedReceivedAckRanges+2, time.Now())
 				Expect(err).To(MatchError(errTooManyOutstandingReceivedAckRanges))
-				hist.DeleteBelow(protocol.MaxTrackedReceivedAckRanges) // deletes about half of the ranges
-				err = hist.ReceivedPacket(2*protocol.MaxTrackedReceivedAckRanges + 4)
+				hist.DeleteBelow(protocol.DefaultMaxTrackedReceivedAckRanges) // deletes about half of the ranges
+				err = hist.ReceivedPacket(2*protocol.DefaultMaxTrackedReceivedAckRanges+4, time.Now())
 				Expect(err).ToNot(HaveOccurred())
 				Expect(historiesConsistent()).To(BeTrue())
 			})
@@ -262,9 +345,9 @@ var _ = Describe("receivedPacketHistory", func() {
 
 	Context("duplicate packet detection", func() {
 		It("detects duplicates for existing ranges", func() {
-			hist.ReceivedPacket(2)
-			hist.ReceivedPacket(4)
-			hist.ReceivedPacket(5)
+			hist.ReceivedPacket(2, time.Now())
+			hist.ReceivedPacket(4, time.Now())
+			hist.ReceivedPacket(5, time.Now())
 			Expect(hist.IsDuplicate(1)).To(BeFalse())
 			Expect(hist.IsDuplicate(2)).To(BeTrue())
 			Expect(hist.IsDuplicate(3)).To(BeFalse())
@@ -274,9 +357,9 @@ var _ = Describe("receivedPacketHistory", func() {
 		})
 
 		It("detects duplicates after a range has been deleted", func() {
-			hist.ReceivedPacket(2)
-			hist.ReceivedPacket(3)
-			hist.ReceivedPacket(6)
+			hist.ReceivedPacket(2, time.Now())
+			hist.ReceivedPacket(3, time.Now())
+			hist.ReceivedPacket(6, time.Now())
 			hist.DeleteBelow(5)
 			for i := 1; i < 5; i++ {
 				Expect(hist.IsDuplicate(protocol.PacketNumber(i))).To(BeTrue())
@@ -293,21 +376,21 @@ var _ = Describe("receivedPacketHistory", func() {
 		})
 
 		It("gets a single ACK range", func() {
-			hist.ReceivedPacket(4)
-			hist.ReceivedPacket(5)
+			hist.ReceivedPacket(4, time.Now())
+			hist.ReceivedPacket(5, time.Now())
 			ackRanges := hist.GetAckRanges()
 			Expect(ackRanges).To(HaveLen(1))
 			Expect(ackRanges[0]).To(Equal(frames.AckRange{FirstPacketNumber: 4, LastPacketNumber: 5}))
 		})
 
 		It("gets multiple ACK ranges", func() {
-			hist.ReceivedPacket(4)
-			hist.ReceivedPacket(5)
-			hist.ReceivedPacket(6)
-			hist.ReceivedPacket(1)
-			hist.ReceivedPacket(11)
-			hist.ReceivedPacket(10)
-			hist.ReceivedPacket(2)
+			hist.ReceivedPacket(4, time.Now())
+			hist.ReceivedPacket(5, time.Now())
+			hist.ReceivedPacket(6, time.Now())
+			hist.ReceivedPacket(1, time.Now())
+			hist.ReceivedPacket(11, time.Now())
+			hist.ReceivedPacket(10, time.Now())
+			hist.ReceivedPacket(2, time.Now())
 			ackRanges := hist.GetAckRanges()
 			Expect(ackRanges).To(HaveLen(3))
 			Expect(ackRanges[0]).To(Equal(frames.AckRange{FirstPacketNumber: 10, LastPacketNumber: 11}))
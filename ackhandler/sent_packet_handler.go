@@ -25,6 +25,8 @@ var (
 var errPacketNumberNotIncreasing = errors.New("Already sent a packet with a higher packet number.")
 
 type sentPacketHandler struct {
+	clock congestion.Clock
+
 	lastSentPacketNumber protocol.PacketNumber
 	lastSentPacketTime   time.Time
 	skippedPackets       []protocol.PacketNumber
@@ -42,31 +44,69 @@ type sentPacketHandler struct {
 
 	rttStats   *congestion.RTTStats
 	congestion congestion.SendAlgorithm
+	pacer      *congestion.Pacer
+	// maxPacingRate limits the pacing rate. 0 means no limit.
+	maxPacingRate congestion.Bandwidth
+	// sharedPacer limits the sending rate of all connections of the server together. It is nil if there's no limit.
+	sharedPacer *congestion.SharedPacer
 
 	consecutiveRTOCount uint32
-}
 
-// NewSentPacketHandler creates a new sentPacketHandler
-func NewSentPacketHandler(rttStats *congestion.RTTStats) SentPacketHandler {
-	congestion := congestion.NewCubicSender(
-		congestion.DefaultClock{},
-		rttStats,
-		false, /* don't use reno since chromium doesn't (why?) */
-		protocol.InitialCongestionWindow,
-		protocol.DefaultMaxCongestionWindow,
-	)
+	// lost handshake packets are retransmitted on a shorter timer than the RTO
+	handshakePacketsInFlight            int
+	lastSentHandshakePacketTime         time.Time
+	consecutiveHandshakeRetransmissions uint32
+
+	// lostPackets contains the packet numbers of lost packets, until we know that the peer won't acknowledge them anymore.
+	// It is used to detect spurious losses.
+	lostPackets map[protocol.PacketNumber]struct{}
+	lossStats   LossStats
+
+	oneWayDelay oneWayDelayEstimator
+}
 
-	return &sentPacketHandler{
+// NewSentPacketHandler creates a new sentPacketHandler.
+// If maxPacingRate is 0, the pacing rate is not limited.
+// The pacer allows sending pacingBurstSize packets back-to-back.
+// If sharedPacer is not nil, it is consulted in addition to the connection's pacer.
+func NewSentPacketHandler(clock congestion.Clock, rttStats *congestion.RTTStats, cong congestion.SendAlgorithm, maxPacingRate congestion.Bandwidth, pacingBurstSize int, sharedPacer *congestion.SharedPacer) SentPacketHandler {
+	h := &sentPacketHandler{
+		clock:              clock,
 		packetHistory:      NewPacketList(),
 		stopWaitingManager: stopWaitingManager{},
 		rttStats:           rttStats,
-		congestion:         congestion,
+		congestion:         cong,
+		maxPacingRate:      maxPacingRate,
+		sharedPacer:        sharedPacer,
+		lostPackets:        make(map[protocol.PacketNumber]struct{}),
+		oneWayDelay:        newOneWayDelayEstimator(clock.Now()),
 	}
+	h.pacer = congestion.NewPacer(h.pacingRate, protocol.ByteCount(pacingBurstSize)*protocol.MaxPacketSize)
+	return h
+}
+
+// pacingRate returns the rate at which packets are paced
+func (h *sentPacketHandler) pacingRate() congestion.Bandwidth {
+	rate := h.congestion.BandwidthEstimate()
+	// Pace faster than the bandwidth estimate, so that the pacer doesn't limit the growth of the congestion window
+	if h.congestion.InSlowStart() {
+		rate *= 2
+	} else {
+		rate = rate * 5 / 4
+	}
+	if h.maxPacingRate != 0 && (rate == 0 || rate > h.maxPacingRate) {
+		rate = h.maxPacingRate
+	}
+	return rate
 }
 
 func (h *sentPacketHandler) ackPacket(packetElement *PacketElement) {
 	packet := &packetElement.Value
 	h.bytesInFlight -= packet.Length
+	if packet.IsHandshakePacket() {
+		h.handshakePacketsInFlight--
+		h.consecutiveHandshakeRetransmissions = 0
+	}
 	h.packetHistory.Remove(packetElement)
 }
 
@@ -89,7 +129,12 @@ func (h *sentPacketHandler) nackPacket(packetElement *PacketElement) bool {
 func (h *sentPacketHandler) queuePacketForRetransmission(packetElement *PacketElement) {
 	packet := &packetElement.Value
 	h.bytesInFlight -= packet.Length
+	if packet.IsHandshakePacket() {
+		h.handshakePacketsInFlight--
+	}
 	h.retransmissionQueue = append(h.retransmissionQueue, packet)
+	h.lostPackets[packet.PacketNumber] = struct{}{}
+	h.lossStats.PacketsLost++
 
 	h.packetHistory.Remove(packetElement)
 
@@ -118,16 +163,30 @@ func (h *sentPacketHandler) SentPacket(packet *Packet) error {
 		}
 	}
 
-	now := time.Now()
+	now := h.clock.Now()
 	h.lastSentPacketTime = now
 	packet.SendTime = now
 	if packet.Length == 0 {
 		return errors.New("SentPacketHandler: packet cannot be empty")
 	}
 	h.bytesInFlight += packet.Length
+	if packet.IsHandshakePacket() {
+		h.handshakePacketsInFlight++
+		h.lastSentHandshakePacketTime = now
+	}
+
+	for _, f := range packet.Frames {
+		if swf, ok := f.(*frames.StopWaitingFrame); ok {
+			h.stopWaitingManager.SentStopWaitingFrame(swf)
+		}
+	}
 
 	h.lastSentPacketNumber = packet.PacketNumber
 	h.packetHistory.PushBack(*packet)
+	h.pacer.SentPacket(now, packet.Length)
+	if h.sharedPacer != nil {
+		h.sharedPacer.SentPacket(now, packet.Length)
+	}
 
 	h.congestion.OnPacketSent(
 		now,
@@ -152,6 +211,8 @@ func (h *sentPacketHandler) ReceivedAck(ackFrame *frames.AckFrame, withPacketNum
 
 	h.largestReceivedPacketWithAck = withPacketNumber
 
+	h.detectSpuriousLosses(ackFrame)
+
 	// ignore repeated ACK (ACKs that don't have a higher LargestAcked than the last ACK)
 	if ackFrame.LargestAcked <= h.largestInOrderAcked() {
 		return nil
@@ -165,12 +226,22 @@ func (h *sentPacketHandler) ReceivedAck(ackFrame *frames.AckFrame, withPacketNum
 	}
 
 	h.LargestAcked = ackFrame.LargestAcked
+	h.updateOneWayDelay(ackFrame)
+
+	// the congestion controller needs the bytes in flight before this ACK was processed, in order to detect application-limited periods
+	priorInFlight := h.bytesInFlight
 
 	var ackedPackets congestion.PacketVector
 	var lostPackets congestion.PacketVector
 	ackRangeIndex := 0
 	rttUpdated := false
 
+	// persistent congestion can only be detected if there was an RTT sample before the lost packets were sent
+	canDetectPersistentCongestion := h.rttStats.SmoothedRTT() != 0
+	persistentCongestionPeriod := protocol.PersistentCongestionThreshold * h.getBaseRTO()
+	var lostRun lossPeriod
+	persistentCongestion := false
+
 	var el, elNext *PacketElement
 	for el = h.packetHistory.Front(); el != nil; el = elNext {
 		// determine the next list element right at the beginning, because el.Next() is not avaible anymore, when the list element is deleted (i.e. when the packet is ACKed)
@@ -183,6 +254,10 @@ func (h *sentPacketHandler) ReceivedAck(ackFrame *frames.AckFrame, withPacketNum
 			retransmitted := h.nackPacket(el)
 			if retransmitted {
 				lostPackets = append(lostPackets, congestion.PacketInfo{Number: packetNumber, Length: packet.Length})
+				lostRun.add(packet.SendTime)
+				persistentCongestion = persistentCongestion || lostRun.duration() >= persistentCongestionPeriod
+			} else {
+				lostRun.reset()
 			}
 			continue
 		}
@@ -191,7 +266,9 @@ func (h *sentPacketHandler) ReceivedAck(ackFrame *frames.AckFrame, withPacketNum
 		if packetNumber == h.LargestAcked {
 			rttUpdated = true
 			timeDelta := rcvTime.Sub(packet.SendTime)
-			h.rttStats.UpdateRTT(timeDelta, ackFrame.DelayTime, rcvTime)
+			// don't trust the peer to report a reasonable ack delay
+			ackDelay := utils.MinDuration(ackFrame.DelayTime, protocol.MaxAckDelay)
+			h.rttStats.UpdateRTT(timeDelta, ackDelay, rcvTime)
 			if utils.Debug() {
 				utils.Debugf("\tEstimated RTT: %dms", h.rttStats.SmoothedRTT()/time.Millisecond)
 			}
@@ -215,10 +292,15 @@ func (h *sentPacketHandler) ReceivedAck(ackFrame *frames.AckFrame, withPacketNum
 				}
 				h.ackPacket(el)
 				ackedPackets = append(ackedPackets, congestion.PacketInfo{Number: packetNumber, Length: packet.Length})
+				lostRun.reset()
 			} else {
 				retransmitted := h.nackPacket(el)
 				if retransmitted {
 					lostPackets = append(lostPackets, congestion.PacketInfo{Number: packetNumber, Length: packet.Length})
+					lostRun.add(packet.SendTime)
+					persistentCongestion = persistentCongestion || lostRun.duration() >= persistentCongestionPeriod
+				} else {
+					lostRun.reset()
 				}
 			}
 		} else {
@@ -238,11 +320,17 @@ func (h *sentPacketHandler) ReceivedAck(ackFrame *frames.AckFrame, withPacketNum
 
 	h.congestion.OnCongestionEvent(
 		rttUpdated,
-		h.BytesInFlight(),
+		priorInFlight,
 		ackedPackets,
 		lostPackets,
 	)
 
+	if persistentCongestion && canDetectPersistentCongestion {
+		utils.Infof("Persistent congestion detected. Collapsing the congestion window.")
+		h.rttStats.OnPersistentCongestion()
+		h.congestion.OnPersistentCongestion()
+	}
+
 	return nil
 }
 
@@ -254,8 +342,17 @@ func (h *sentPacketHandler) DequeuePacketForRetransmission() *Packet {
 	if len(h.retransmissionQueue) > 0 {
 		queueLen := len(h.retransmissionQueue)
 		// packets are usually NACKed in descending order. So use the slice as a stack
-		packet := h.retransmissionQueue[queueLen-1]
-		h.retransmissionQueue = h.retransmissionQueue[:queueLen-1]
+		index := queueLen - 1
+		// handshake packets are retransmitted first, since the connection can't make progress without them
+		for i := queueLen - 1; i >= 0; i-- {
+			if h.retransmissionQueue[i].IsHandshakePacket() {
+				index = i
+				break
+			}
+		}
+		packet := h.retransmissionQueue[index]
+		h.retransmissionQueue = append(h.retransmissionQueue[:index], h.retransmissionQueue[index+1:]...)
+		h.lossStats.PacketsRetransmitted++
 		return packet
 	}
 
@@ -275,11 +372,102 @@ func (h *sentPacketHandler) GetStopWaitingFrame(force bool) *frames.StopWaitingF
 }
 
 func (h *sentPacketHandler) SendingAllowed() bool {
-	congestionLimited := h.BytesInFlight() > h.congestion.GetCongestionWindow()
+	congestionLimited := h.isCongestionLimited()
 	maxTrackedLimited := protocol.PacketNumber(len(h.retransmissionQueue)+h.packetHistory.Len()) >= protocol.MaxTrackedSentPackets
 	return !(congestionLimited || maxTrackedLimited)
 }
 
+func (h *sentPacketHandler) TimeUntilSend() time.Time {
+	t := h.pacer.TimeUntilSend()
+	if h.sharedPacer != nil {
+		if st := h.sharedPacer.TimeUntilSend(); st.After(t) {
+			t = st
+		}
+	}
+	return t
+}
+
+func (h *sentPacketHandler) isCongestionLimited() bool {
+	return h.BytesInFlight() > h.congestion.GetCongestionWindow()
+}
+
+func (h *sentPacketHandler) GetCongestionStats() CongestionStats {
+	return CongestionStats{
+		CongestionWindow:   h.congestion.GetCongestionWindow(),
+		SlowStartThreshold: h.congestion.GetSlowStartThreshold(),
+		BandwidthEstimate:  h.congestion.BandwidthEstimate(),
+		PacingRate:         h.pacingRate(),
+		BytesInFlight:      h.BytesInFlight(),
+		CongestionLimited:  h.isCongestionLimited(),
+
+		ForwardQueuingDelay: h.oneWayDelay.QueuingDelay(),
+		ReverseQueuingDelay: h.reverseQueuingDelay(),
+	}
+}
+
+// reverseQueuingDelay estimates the queuing delay on the path from the peer.
+// It is the part of the queuing delay of the latest RTT sample that can't be attributed to the path to the peer.
+func (h *sentPacketHandler) reverseQueuingDelay() time.Duration {
+	if !h.oneWayDelay.HasSample() {
+		return 0
+	}
+	delay := h.rttStats.LatestRTT() - h.rttStats.MinRTT() - h.oneWayDelay.QueuingDelay()
+	if delay < 0 {
+		return 0
+	}
+	return delay
+}
+
+func (h *sentPacketHandler) GetLossStats() LossStats {
+	return h.lossStats
+}
+
+// updateOneWayDelay takes a one-way delay sample for every packet with a receive timestamp in the ACK frame.
+// It has to be called before the acknowledged packets are removed from the packet history.
+func (h *sentPacketHandler) updateOneWayDelay(ackFrame *frames.AckFrame) {
+	if len(ackFrame.Timestamps) == 0 {
+		return
+	}
+	receivedTimes := make(map[protocol.PacketNumber]time.Duration, len(ackFrame.Timestamps))
+	for _, ts := range ackFrame.Timestamps {
+		receivedTimes[ts.PacketNumber] = ts.ReceivedTime
+	}
+	for el := h.packetHistory.Front(); el != nil && len(receivedTimes) > 0; el = el.Next() {
+		packet := el.Value
+		if packet.PacketNumber > ackFrame.LargestAcked {
+			break
+		}
+		if receivedTime, ok := receivedTimes[packet.PacketNumber]; ok {
+			h.oneWayDelay.UpdateDelay(packet.SendTime, receivedTime)
+			delete(receivedTimes, packet.PacketNumber)
+		}
+	}
+}
+
+// detectSpuriousLosses counts packets that were declared lost, but are acknowledged by the ACK frame
+func (h *sentPacketHandler) detectSpuriousLosses(ackFrame *frames.AckFrame) {
+	for p := range h.lostPackets {
+		if ackFrame.AcksPacket(p) {
+			utils.Debugf("\tPacket 0x%x was declared lost, but was acknowledged", p)
+			h.lossStats.SpuriousLosses++
+			delete(h.lostPackets, p)
+		} else if p < ackFrame.LowestAcked {
+			// the peer won't acknowledge this packet anymore
+			delete(h.lostPackets, p)
+		}
+	}
+}
+
+func (h *sentPacketHandler) OnConnectionMigration() {
+	h.rttStats.OnConnectionMigration()
+	h.congestion.OnConnectionMigration()
+	h.oneWayDelay.Reset()
+}
+
+func (h *sentPacketHandler) SetMaxPacingRate(rate congestion.Bandwidth) {
+	h.maxPacingRate = rate
+}
+
 func (h *sentPacketHandler) CheckForError() error {
 	length := len(h.retransmissionQueue) + h.packetHistory.Len()
 	if protocol.PacketNumber(length) > protocol.MaxTrackedSentPackets {
@@ -289,7 +477,12 @@ func (h *sentPacketHandler) CheckForError() error {
 }
 
 func (h *sentPacketHandler) MaybeQueueRTOs() {
-	if time.Now().Before(h.TimeOfFirstRTO()) {
+	if h.handshakePacketsInFlight > 0 && !h.clock.Now().Before(h.timeOfHandshakeRetransmission()) {
+		h.queueHandshakePackets()
+		return
+	}
+
+	if h.clock.Now().Before(h.timeOfRTO()) {
 		return
 	}
 
@@ -302,8 +495,9 @@ func (h *sentPacketHandler) MaybeQueueRTOs() {
 	}
 
 	// Reset the RTO timer here, since it's not clear that this packet contained any retransmittable frames
-	h.lastSentPacketTime = time.Now()
+	h.lastSentPacketTime = h.clock.Now()
 	h.consecutiveRTOCount++
+	h.lossStats.RTOCount++
 }
 
 func (h *sentPacketHandler) queueRTO(el *PacketElement) {
@@ -318,18 +512,63 @@ func (h *sentPacketHandler) queueRTO(el *PacketElement) {
 	h.queuePacketForRetransmission(el)
 }
 
+// queueHandshakePackets queues all outstanding handshake packets for retransmission.
+// Unlike an RTO, this doesn't reduce the congestion window, since the timer is much more aggressive.
+func (h *sentPacketHandler) queueHandshakePackets() {
+	var next *PacketElement
+	for el := h.packetHistory.Front(); el != nil; el = next {
+		next = el.Next()
+		if el.Value.IsHandshakePacket() {
+			utils.Debugf("\tQueueing packet 0x%x for retransmission (handshake)", el.Value.PacketNumber)
+			h.queuePacketForRetransmission(el)
+		}
+	}
+	h.lastSentHandshakePacketTime = h.clock.Now()
+	h.consecutiveHandshakeRetransmissions++
+}
+
+// getHandshakeRetransmissionDelay returns the time after which outstanding handshake packets are retransmitted
+func (h *sentPacketHandler) getHandshakeRetransmissionDelay() time.Duration {
+	rtt := h.rttStats.SmoothedRTT()
+	if rtt == 0 {
+		rtt = time.Duration(h.rttStats.InitialRTTus()) * time.Microsecond
+	}
+	delay := utils.MaxDuration(3*rtt/2, protocol.MinHandshakeRetransmissionTime)
+	// Exponential backoff
+	delay *= 1 << h.consecutiveHandshakeRetransmissions
+	return utils.MinDuration(delay, protocol.MaxRetransmissionTime)
+}
+
+func (h *sentPacketHandler) timeOfHandshakeRetransmission() time.Time {
+	return h.lastSentHandshakePacketTime.Add(h.getHandshakeRetransmissionDelay())
+}
+
 func (h *sentPacketHandler) getRTO() time.Duration {
+	rto := h.getBaseRTO()
+	// Exponential backoff
+	rto *= 1 << h.consecutiveRTOCount
+	return utils.MinDuration(rto, protocol.MaxRetransmissionTime)
+}
+
+// getBaseRTO returns the RTO without exponential backoff
+func (h *sentPacketHandler) getBaseRTO() time.Duration {
 	rto := h.congestion.RetransmissionDelay()
 	if rto == 0 {
 		rto = protocol.DefaultRetransmissionTime
 	}
 	rto = utils.MaxDuration(rto, protocol.MinRetransmissionTime)
-	// Exponential backoff
-	rto *= 1 << h.consecutiveRTOCount
-	return utils.MinDuration(rto, protocol.MaxRetransmissionTime)
+	// an RTO shorter than the min RTT would always be spurious
+	return utils.MaxDuration(rto, h.rttStats.RecentMinRTT())
 }
 
 func (h *sentPacketHandler) TimeOfFirstRTO() time.Time {
+	if h.handshakePacketsInFlight > 0 {
+		return utils.MinTime(h.timeOfRTO(), h.timeOfHandshakeRetransmission())
+	}
+	return h.timeOfRTO()
+}
+
+func (h *sentPacketHandler) timeOfRTO() time.Time {
 	if h.lastSentPacketTime.IsZero() {
 		return time.Time{}
 	}
@@ -346,3 +585,25 @@ func (h *sentPacketHandler) garbageCollectSkippedPackets() {
 	}
 	h.skippedPackets = h.skippedPackets[deleteIndex:]
 }
+
+// a lossPeriod is a run of consecutive packets that were all declared lost
+type lossPeriod struct {
+	start time.Time
+	end   time.Time
+}
+
+func (l *lossPeriod) add(sendTime time.Time) {
+	if l.start.IsZero() {
+		l.start = sendTime
+	}
+	l.end = sendTime
+}
+
+func (l *lossPeriod) reset() {
+	*l = lossPeriod{}
+}
+
+// duration returns the time between sending the first and the last lost packet
+func (l *lossPeriod) duration() time.Duration {
+	return l.end.Sub(l.start)
+}
@@ -1,6 +1,7 @@
 package ackhandler
 
 import (
+	"testing"
 	"time"
 
 	"github.com/lucas-clemente/quic-go/congestion"
@@ -10,11 +11,28 @@ import (
 	. "github.com/onsi/gomega"
 )
 
+type mockClock time.Time
+
+func (c *mockClock) Now() time.Time {
+	return time.Time(*c)
+}
+
+func (c *mockClock) Advance(d time.Duration) {
+	*c = mockClock(time.Time(*c).Add(d))
+}
+
+func (c *mockClock) NewTimer(time.Duration) congestion.Timer {
+	panic("not implemented")
+}
+
 type mockCongestion struct {
 	nCalls                  int
 	argsOnPacketSent        []interface{}
 	argsOnCongestionEvent   []interface{}
 	onRetransmissionTimeout bool
+	onConnectionMigration   bool
+	onPersistentCongestion  bool
+	inSlowStart             bool
 }
 
 func (m *mockCongestion) TimeUntilSend(now time.Time, bytesInFlight protocol.ByteCount) time.Duration {
@@ -32,9 +50,21 @@ func (m *mockCongestion) GetCongestionWindow() protocol.ByteCount {
 	return protocol.DefaultTCPMSS
 }
 
-func (m *mockCongestion) OnCongestionEvent(rttUpdated bool, bytesInFlight protocol.ByteCount, ackedPackets congestion.PacketVector, lostPackets congestion.PacketVector) {
+func (m *mockCongestion) InSlowStart() bool {
+	return m.inSlowStart
+}
+
+func (m *mockCongestion) GetSlowStartThreshold() protocol.ByteCount {
+	return 10 * protocol.DefaultTCPMSS
+}
+
+func (m *mockCongestion) BandwidthEstimate() congestion.Bandwidth {
+	return 100 * congestion.KBytesPerSecond
+}
+
+func (m *mockCongestion) OnCongestionEvent(rttUpdated bool, priorInFlight protocol.ByteCount, ackedPackets congestion.PacketVector, lostPackets congestion.PacketVector) {
 	m.nCalls++
-	m.argsOnCongestionEvent = []interface{}{rttUpdated, bytesInFlight, ackedPackets, lostPackets}
+	m.argsOnCongestionEvent = []interface{}{rttUpdated, priorInFlight, ackedPackets, lostPackets}
 }
 
 func (m *mockCongestion) OnRetransmissionTimeout(packetsRetransmitted bool) {
@@ -42,12 +72,16 @@ func (m *mockCongestion) OnRetransmissionTimeout(packetsRetransmitted bool) {
 	m.onRetransmissionTimeout = true
 }
 
+func (m *mockCongestion) OnPersistentCongestion() {
+	m.onPersistentCongestion = true
+}
+
 func (m *mockCongestion) RetransmissionDelay() time.Duration {
 	return protocol.DefaultRetransmissionTime
 }
 
 func (m *mockCongestion) SetNumEmulatedConnections(n int)         { panic("not implemented") }
-func (m *mockCongestion) OnConnectionMigration()                  { panic("not implemented") }
+func (m *mockCongestion) OnConnectionMigration()                  { m.onConnectionMigration = true }
 func (m *mockCongestion) SetSlowStartLargeReduction(enabled bool) { panic("not implemented") }
 
 var _ = Describe("SentPacketHandler", func() {
@@ -58,7 +92,8 @@ var _ = Describe("SentPacketHandler", func() {
 
 	BeforeEach(func() {
 		rttStats := &congestion.RTTStats{}
-		handler = NewSentPacketHandler(rttStats).(*sentPacketHandler)
+		cong := congestion.NewCubicSender(congestion.DefaultClock{}, rttStats, false, protocol.InitialCongestionWindow, protocol.DefaultMinCongestionWindow, protocol.DefaultMaxCongestionWindow)
+		handler = NewSentPacketHandler(congestion.DefaultClock{}, rttStats, cong, 0, protocol.DefaultPacingBurstSize, nil).(*sentPacketHandler)
 		streamFrame = frames.StreamFrame{
 			StreamID: 5,
 			Data:     []byte{0x13, 0x37},
@@ -527,11 +562,81 @@ var _ = Describe("SentPacketHandler", func() {
 			})
 
 			It("uses the DelayTime in the ack frame", func() {
+				now := time.Now()
+				getPacketElement(1).Value.SendTime = now.Add(-100 * time.Millisecond)
+				err := handler.ReceivedAck(&frames.AckFrame{LargestAcked: 1, DelayTime: 10 * time.Millisecond}, 1, now)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(handler.rttStats.LatestRTT()).To(Equal(90 * time.Millisecond))
+			})
+
+			It("limits the DelayTime in the ack frame to the MaxAckDelay", func() {
 				now := time.Now()
 				getPacketElement(1).Value.SendTime = now.Add(-10 * time.Minute)
-				err := handler.ReceivedAck(&frames.AckFrame{LargestAcked: 1, DelayTime: 5 * time.Minute}, 1, time.Now())
+				err := handler.ReceivedAck(&frames.AckFrame{LargestAcked: 1, DelayTime: 5 * time.Minute}, 1, now)
 				Expect(err).NotTo(HaveOccurred())
-				Expect(handler.rttStats.LatestRTT()).To(BeNumerically("~", 5*time.Minute, 1*time.Second))
+				Expect(handler.rttStats.LatestRTT()).To(Equal(10*time.Minute - protocol.MaxAckDelay))
+			})
+		})
+
+		Context("estimating the one-way delay", func() {
+			var start time.Time
+
+			BeforeEach(func() {
+				start = handler.oneWayDelay.startTime
+				now := time.Now()
+				getPacketElement(1).Value.SendTime = now.Add(-200 * time.Millisecond)
+				getPacketElement(2).Value.SendTime = now.Add(-100 * time.Millisecond)
+			})
+
+			// receivedAfter returns the receive timestamp of a packet that took delay to arrive at the peer
+			receivedAfter := func(p protocol.PacketNumber, delay time.Duration) frames.AckTimestamp {
+				return frames.AckTimestamp{
+					PacketNumber: p,
+					ReceivedTime: getPacketElement(p).Value.SendTime.Sub(start) + delay,
+				}
+			}
+
+			It("doesn't report queuing delays if the peer doesn't send timestamps", func() {
+				err := handler.ReceivedAck(&frames.AckFrame{LargestAcked: 2, LowestAcked: 1}, 1, time.Now())
+				Expect(err).NotTo(HaveOccurred())
+				Expect(handler.GetCongestionStats().ForwardQueuingDelay).To(BeZero())
+				Expect(handler.GetCongestionStats().ReverseQueuingDelay).To(BeZero())
+			})
+
+			It("estimates the queuing delay on the path to the peer", func() {
+				ack := &frames.AckFrame{
+					LargestAcked: 2,
+					LowestAcked:  1,
+					Timestamps:   []frames.AckTimestamp{receivedAfter(1, 50*time.Millisecond), receivedAfter(2, 80*time.Millisecond)},
+				}
+				err := handler.ReceivedAck(ack, 1, time.Now())
+				Expect(err).NotTo(HaveOccurred())
+				Expect(handler.GetCongestionStats().ForwardQueuingDelay).To(Equal(30 * time.Millisecond))
+			})
+
+			It("attributes the rest of the RTT increase to the path from the peer", func() {
+				ack := &frames.AckFrame{
+					LargestAcked: 2,
+					LowestAcked:  1,
+					Timestamps:   []frames.AckTimestamp{receivedAfter(1, 50*time.Millisecond), receivedAfter(2, 80*time.Millisecond)},
+				}
+				err := handler.ReceivedAck(ack, 1, time.Now())
+				Expect(err).NotTo(HaveOccurred())
+				handler.rttStats.UpdateRTT(time.Millisecond, 0, time.Now())
+				handler.rttStats.UpdateRTT(51*time.Millisecond, 0, time.Now())
+				Expect(handler.GetCongestionStats().ReverseQueuingDelay).To(Equal(20 * time.Millisecond))
+			})
+
+			It("resets the estimate on connection migration", func() {
+				ack := &frames.AckFrame{
+					LargestAcked: 2,
+					LowestAcked:  1,
+					Timestamps:   []frames.AckTimestamp{receivedAfter(1, 50*time.Millisecond), receivedAfter(2, 80*time.Millisecond)},
+				}
+				err := handler.ReceivedAck(ack, 1, time.Now())
+				Expect(err).NotTo(HaveOccurred())
+				handler.OnConnectionMigration()
+				Expect(handler.GetCongestionStats().ForwardQueuingDelay).To(BeZero())
 			})
 		})
 	})
@@ -609,6 +714,64 @@ var _ = Describe("SentPacketHandler", func() {
 			Expect(packet.PacketNumber).To(Equal(protocol.PacketNumber(4)))
 		})
 
+		Context("loss statistics", func() {
+			losePacket := func(p protocol.PacketNumber) {
+				for i := uint8(0); i < protocol.RetransmissionThreshold+1; i++ {
+					handler.nackPacket(getPacketElement(p))
+				}
+			}
+
+			It("counts lost and retransmitted packets", func() {
+				losePacket(2)
+				losePacket(3)
+				Expect(handler.GetLossStats().PacketsLost).To(Equal(uint64(2)))
+				Expect(handler.GetLossStats().PacketsRetransmitted).To(BeZero())
+				Expect(handler.DequeuePacketForRetransmission()).ToNot(BeNil())
+				Expect(handler.GetLossStats().PacketsRetransmitted).To(Equal(uint64(1)))
+			})
+
+			It("counts RTOs", func() {
+				handler.lastSentPacketTime = time.Now().Add(-time.Hour)
+				handler.MaybeQueueRTOs()
+				Expect(handler.GetLossStats().RTOCount).To(Equal(uint64(1)))
+				Expect(handler.GetLossStats().PacketsLost).To(Equal(uint64(2)))
+			})
+
+			It("detects spurious losses", func() {
+				losePacket(2)
+				err := handler.ReceivedAck(&frames.AckFrame{LargestAcked: 7, LowestAcked: 1}, 1, time.Now())
+				Expect(err).ToNot(HaveOccurred())
+				Expect(handler.GetLossStats().SpuriousLosses).To(Equal(uint64(1)))
+				Expect(handler.lostPackets).To(BeEmpty())
+			})
+
+			It("detects spurious losses in repeated ACKs", func() {
+				losePacket(2)
+				ack := &frames.AckFrame{
+					LargestAcked: 7,
+					LowestAcked:  1,
+					AckRanges: []frames.AckRange{
+						{FirstPacketNumber: 3, LastPacketNumber: 7},
+						{FirstPacketNumber: 1, LastPacketNumber: 1},
+					},
+				}
+				err := handler.ReceivedAck(ack, 1, time.Now())
+				Expect(err).ToNot(HaveOccurred())
+				Expect(handler.GetLossStats().SpuriousLosses).To(BeZero())
+				err = handler.ReceivedAck(&frames.AckFrame{LargestAcked: 7, LowestAcked: 1}, 2, time.Now())
+				Expect(err).ToNot(HaveOccurred())
+				Expect(handler.GetLossStats().SpuriousLosses).To(Equal(uint64(1)))
+			})
+
+			It("forgets lost packets that won't be acknowledged anymore", func() {
+				losePacket(2)
+				err := handler.ReceivedAck(&frames.AckFrame{LargestAcked: 7, LowestAcked: 3}, 1, time.Now())
+				Expect(err).ToNot(HaveOccurred())
+				Expect(handler.GetLossStats().SpuriousLosses).To(BeZero())
+				Expect(handler.lostPackets).To(BeEmpty())
+			})
+		})
+
 		Context("StopWaitings", func() {
 			It("gets a StopWaitingFrame", func() {
 				ack := frames.AckFrame{LargestAcked: 5, LowestAcked: 5}
@@ -621,6 +784,18 @@ var _ = Describe("SentPacketHandler", func() {
 				handler.queuePacketForRetransmission(getPacketElement(5))
 				Expect(handler.GetStopWaitingFrame(false)).To(Equal(&frames.StopWaitingFrame{LeastUnacked: 6}))
 			})
+
+			It("only regards a StopWaitingFrame as sent once the packet containing it was sent", func() {
+				ack := frames.AckFrame{LargestAcked: 5, LowestAcked: 5}
+				err := handler.ReceivedAck(&ack, 1, time.Now())
+				Expect(err).ToNot(HaveOccurred())
+				swf := handler.GetStopWaitingFrame(false)
+				Expect(swf).ToNot(BeNil())
+				Expect(handler.GetStopWaitingFrame(false)).To(Equal(swf))
+				err = handler.SentPacket(&Packet{PacketNumber: 11, Frames: []frames.Frame{swf}, Length: 1})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(handler.GetStopWaitingFrame(false)).To(BeNil())
+			})
 		})
 	})
 
@@ -715,9 +890,9 @@ var _ = Describe("SentPacketHandler", func() {
 			err := handler.ReceivedAck(&ack, 1, time.Now())
 			Expect(err).NotTo(HaveOccurred())
 			Expect(cong.nCalls).To(Equal(4)) // 3 * SentPacket + 1 * ReceivedAck
-			// rttUpdated, bytesInFlight, ackedPackets, lostPackets
+			// rttUpdated, priorInFlight, ackedPackets, lostPackets
 			Expect(cong.argsOnCongestionEvent[0]).To(BeTrue())
-			Expect(cong.argsOnCongestionEvent[1]).To(Equal(protocol.ByteCount(2)))
+			Expect(cong.argsOnCongestionEvent[1]).To(Equal(protocol.ByteCount(6)))
 			Expect(cong.argsOnCongestionEvent[2]).To(Equal(congestion.PacketVector{{Number: 1, Length: 1}, {Number: 3, Length: 3}}))
 			Expect(cong.argsOnCongestionEvent[3]).To(BeEmpty())
 
@@ -742,6 +917,70 @@ var _ = Describe("SentPacketHandler", func() {
 			Expect(cong.argsOnCongestionEvent[3]).To(Equal(congestion.PacketVector{{Number: 2, Length: 2}}))
 		})
 
+		Context("persistent congestion", func() {
+			// sends packets 1 to 5, with packets 1 to 4 sent at the given times, and about to be declared lost
+			sendPackets := func(sendTimes []time.Time) {
+				for i := 1; i <= 5; i++ {
+					err := handler.SentPacket(&Packet{PacketNumber: protocol.PacketNumber(i), Frames: []frames.Frame{}, Length: 1})
+					Expect(err).NotTo(HaveOccurred())
+				}
+				for i, t := range sendTimes {
+					el := getPacketElement(protocol.PacketNumber(i + 1))
+					el.Value.SendTime = t
+					el.Value.MissingReports = protocol.RetransmissionThreshold
+				}
+			}
+
+			BeforeEach(func() {
+				handler.rttStats.UpdateRTT(100*time.Millisecond, 0, time.Now())
+			})
+
+			It("detects persistent congestion", func() {
+				now := time.Now()
+				sendPackets([]time.Time{now.Add(-5 * time.Second), now.Add(-4 * time.Second), now.Add(-3 * time.Second), now.Add(-2 * time.Second)})
+				err := handler.ReceivedAck(&frames.AckFrame{LargestAcked: 5, LowestAcked: 5}, 1, now)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cong.argsOnCongestionEvent[3]).To(HaveLen(4))
+				Expect(cong.onPersistentCongestion).To(BeTrue())
+				Expect(handler.rttStats.MinRTT()).To(Equal(handler.rttStats.LatestRTT()))
+			})
+
+			It("doesn't detect persistent congestion if the lost packets were sent in a short period", func() {
+				now := time.Now()
+				sendPackets([]time.Time{now.Add(-500 * time.Millisecond), now.Add(-400 * time.Millisecond), now.Add(-300 * time.Millisecond), now.Add(-200 * time.Millisecond)})
+				err := handler.ReceivedAck(&frames.AckFrame{LargestAcked: 5, LowestAcked: 5}, 1, now)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cong.argsOnCongestionEvent[3]).To(HaveLen(4))
+				Expect(cong.onPersistentCongestion).To(BeFalse())
+			})
+
+			It("doesn't detect persistent congestion if a packet in between was acknowledged", func() {
+				now := time.Now()
+				sendPackets([]time.Time{now.Add(-5 * time.Second), now.Add(-4 * time.Second), now.Add(-3 * time.Second), now.Add(-2 * time.Second)})
+				ack := &frames.AckFrame{
+					LargestAcked: 5,
+					LowestAcked:  3,
+					AckRanges: []frames.AckRange{
+						{FirstPacketNumber: 5, LastPacketNumber: 5},
+						{FirstPacketNumber: 3, LastPacketNumber: 3},
+					},
+				}
+				err := handler.ReceivedAck(ack, 1, now)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cong.argsOnCongestionEvent[3]).To(HaveLen(3))
+				Expect(cong.onPersistentCongestion).To(BeFalse())
+			})
+
+			It("doesn't detect persistent congestion without an RTT sample", func() {
+				handler.rttStats.OnConnectionMigration()
+				now := time.Now()
+				sendPackets([]time.Time{now.Add(-5 * time.Second), now.Add(-4 * time.Second), now.Add(-3 * time.Second), now.Add(-2 * time.Second)})
+				err := handler.ReceivedAck(&frames.AckFrame{LargestAcked: 5, LowestAcked: 5}, 1, now)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cong.onPersistentCongestion).To(BeFalse())
+			})
+		})
+
 		It("allows or denies sending based on congestion", func() {
 			Expect(handler.SendingAllowed()).To(BeTrue())
 			err := handler.SentPacket(&Packet{PacketNumber: 1, Frames: []frames.Frame{}, Length: protocol.DefaultTCPMSS + 1})
@@ -768,6 +1007,131 @@ var _ = Describe("SentPacketHandler", func() {
 			Expect(cong.argsOnCongestionEvent[3]).To(Equal(congestion.PacketVector{{Number: 1, Length: 1}}))
 			Expect(cong.onRetransmissionTimeout).To(BeTrue())
 		})
+
+		It("resets the congestion controller and the RTT on connection migration", func() {
+			handler.rttStats.UpdateRTT(time.Second, 0, time.Now())
+			Expect(handler.rttStats.SmoothedRTT()).ToNot(BeZero())
+			handler.OnConnectionMigration()
+			Expect(cong.onConnectionMigration).To(BeTrue())
+			Expect(handler.rttStats.SmoothedRTT()).To(BeZero())
+		})
+
+		It("returns the congestion stats", func() {
+			err := handler.SentPacket(&Packet{PacketNumber: 1, Frames: []frames.Frame{}, Length: protocol.DefaultTCPMSS + 1})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(handler.GetCongestionStats()).To(Equal(CongestionStats{
+				CongestionWindow:   protocol.DefaultTCPMSS,
+				SlowStartThreshold: 10 * protocol.DefaultTCPMSS,
+				BandwidthEstimate:  100 * congestion.KBytesPerSecond,
+				PacingRate:         125 * congestion.KBytesPerSecond,
+				BytesInFlight:      protocol.DefaultTCPMSS + 1,
+				CongestionLimited:  true,
+			}))
+		})
+	})
+
+	Context("application-limited periods", func() {
+		var packetNumber protocol.PacketNumber
+
+		sendAndAck := func(n int) {
+			first := packetNumber + 1
+			for i := 0; i < n; i++ {
+				packetNumber++
+				err := handler.SentPacket(&Packet{PacketNumber: packetNumber, Frames: []frames.Frame{}, Length: protocol.DefaultTCPMSS})
+				Expect(err).NotTo(HaveOccurred())
+			}
+			err := handler.ReceivedAck(&frames.AckFrame{LowestAcked: first, LargestAcked: packetNumber}, packetNumber, time.Now())
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		BeforeEach(func() {
+			packetNumber = 0
+		})
+
+		It("grows the congestion window when the window is filled", func() {
+			sendAndAck(int(protocol.InitialCongestionWindow))
+			Expect(handler.congestion.GetCongestionWindow()).To(BeNumerically(">", protocol.ByteCount(protocol.InitialCongestionWindow)*protocol.DefaultTCPMSS))
+		})
+
+		It("doesn't grow the congestion window when the application doesn't fill it", func() {
+			for i := 0; i < 2*int(protocol.InitialCongestionWindow); i++ {
+				sendAndAck(1)
+			}
+			Expect(handler.congestion.GetCongestionWindow()).To(Equal(protocol.ByteCount(protocol.InitialCongestionWindow) * protocol.DefaultTCPMSS))
+		})
+	})
+
+	Context("pacing", func() {
+		var cong *mockCongestion
+
+		BeforeEach(func() {
+			cong = &mockCongestion{}
+			handler.congestion = cong
+		})
+
+		It("paces faster than the bandwidth estimate", func() {
+			Expect(handler.pacingRate()).To(Equal(125 * congestion.KBytesPerSecond))
+		})
+
+		It("paces even faster in slow start", func() {
+			cong.inSlowStart = true
+			Expect(handler.pacingRate()).To(Equal(200 * congestion.KBytesPerSecond))
+		})
+
+		It("limits the pacing rate", func() {
+			handler.maxPacingRate = 50 * congestion.KBytesPerSecond
+			Expect(handler.pacingRate()).To(Equal(50 * congestion.KBytesPerSecond))
+			Expect(handler.GetCongestionStats().PacingRate).To(Equal(50 * congestion.KBytesPerSecond))
+			handler.maxPacingRate = 500 * congestion.KBytesPerSecond
+			Expect(handler.pacingRate()).To(Equal(125 * congestion.KBytesPerSecond))
+		})
+
+		It("waits for the shared pacer", func() {
+			sharedPacer := congestion.NewSharedPacer(100*congestion.KBytesPerSecond, protocol.MaxPacketSize)
+			handler = NewSentPacketHandler(congestion.DefaultClock{}, handler.rttStats, cong, 0, protocol.DefaultPacingBurstSize, sharedPacer).(*sentPacketHandler)
+			err := handler.SentPacket(&Packet{PacketNumber: 1, Frames: []frames.Frame{}, Length: protocol.MaxPacketSize})
+			Expect(err).NotTo(HaveOccurred())
+			// the connection's pacer would allow sending a burst, but the shared pacer doesn't
+			Expect(handler.pacer.TimeUntilSend()).To(BeZero())
+			Expect(handler.TimeUntilSend()).To(BeTemporally(">", time.Now().Add(10*time.Millisecond)))
+			Expect(sharedPacer.TimeUntilSend()).To(Equal(handler.TimeUntilSend()))
+		})
+
+		It("changes the max pacing rate", func() {
+			handler.SetMaxPacingRate(50 * congestion.KBytesPerSecond)
+			Expect(handler.pacingRate()).To(Equal(50 * congestion.KBytesPerSecond))
+			handler.SetMaxPacingRate(0)
+			Expect(handler.pacingRate()).To(Equal(125 * congestion.KBytesPerSecond))
+		})
+
+		It("paces slower after lowering the max pacing rate", func() {
+			handler.SetMaxPacingRate(100 * congestion.KBytesPerSecond)
+			for i := 1; i <= protocol.DefaultPacingBurstSize+1; i++ {
+				err := handler.SentPacket(&Packet{PacketNumber: protocol.PacketNumber(i), Frames: []frames.Frame{}, Length: protocol.MaxPacketSize})
+				Expect(err).NotTo(HaveOccurred())
+			}
+			// sending a packet of MaxPacketSize takes about 13.5ms at 100 kB/s
+			Expect(handler.TimeUntilSend()).To(BeTemporally(">", time.Now().Add(10*time.Millisecond)))
+		})
+
+		It("allows sending a burst of packets, then paces", func() {
+			for i := 1; i <= protocol.DefaultPacingBurstSize; i++ {
+				Expect(handler.TimeUntilSend()).To(BeZero())
+				err := handler.SentPacket(&Packet{PacketNumber: protocol.PacketNumber(i), Frames: []frames.Frame{}, Length: protocol.MaxPacketSize})
+				Expect(err).NotTo(HaveOccurred())
+			}
+			Expect(handler.TimeUntilSend()).To(BeTemporally(">", time.Now()))
+		})
+
+		It("uses the configured burst size", func() {
+			handler = NewSentPacketHandler(congestion.DefaultClock{}, handler.rttStats, cong, 0, 2, nil).(*sentPacketHandler)
+			for i := 1; i <= 2; i++ {
+				Expect(handler.TimeUntilSend()).To(BeZero())
+				err := handler.SentPacket(&Packet{PacketNumber: protocol.PacketNumber(i), Frames: []frames.Frame{}, Length: protocol.MaxPacketSize})
+				Expect(err).NotTo(HaveOccurred())
+			}
+			Expect(handler.TimeUntilSend()).To(BeTemporally(">", time.Now()))
+		})
 	})
 
 	Context("calculating RTO", func() {
@@ -788,6 +1152,13 @@ var _ = Describe("SentPacketHandler", func() {
 			Expect(handler.getRTO()).To(Equal(protocol.MinRetransmissionTime))
 		})
 
+		It("doesn't use an RTO smaller than the min RTT", func() {
+			// a large ack delay reduces the smoothed RTT, but not the min RTT
+			handler.rttStats.UpdateRTT(time.Second, 900*time.Millisecond, time.Now())
+			Expect(handler.rttStats.SmoothedRTT()).To(Equal(100 * time.Millisecond))
+			Expect(handler.getRTO()).To(Equal(time.Second))
+		})
+
 		It("limits RTO max", func() {
 			rtt := time.Hour
 			handler.rttStats.UpdateRTT(rtt, 0, time.Now())
@@ -852,6 +1223,21 @@ var _ = Describe("SentPacketHandler", func() {
 			})
 		})
 
+		It("uses the clock to decide when the RTO fires", func() {
+			clock := mockClock(time.Now())
+			handler.clock = &clock
+			err := handler.SentPacket(&Packet{PacketNumber: 1, Frames: []frames.Frame{}, Length: 1})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(handler.TimeOfFirstRTO()).To(Equal(clock.Now().Add(protocol.DefaultRetransmissionTime)))
+			clock.Advance(protocol.DefaultRetransmissionTime - time.Millisecond)
+			handler.MaybeQueueRTOs()
+			Expect(handler.retransmissionQueue).To(BeEmpty())
+			clock.Advance(time.Millisecond)
+			handler.MaybeQueueRTOs()
+			Expect(handler.retransmissionQueue).To(HaveLen(1))
+			Expect(handler.lastSentPacketTime).To(Equal(clock.Now()))
+		})
+
 		It("works with DequeuePacketForRetransmission", func() {
 			p := &Packet{PacketNumber: 1, Frames: []frames.Frame{}, Length: 1}
 			err := handler.SentPacket(p)
@@ -861,4 +1247,110 @@ var _ = Describe("SentPacketHandler", func() {
 			Expect(handler.DequeuePacketForRetransmission().PacketNumber).To(Equal(p.PacketNumber))
 		})
 	})
+
+	Context("handshake retransmission", func() {
+		var handshakePacket, dataPacket *Packet
+
+		BeforeEach(func() {
+			handler.rttStats = congestion.NewRTTStats()
+			handshakePacket = &Packet{PacketNumber: 1, Frames: []frames.Frame{&frames.StreamFrame{StreamID: 1}}, Length: 1}
+			dataPacket = &Packet{PacketNumber: 2, Frames: []frames.Frame{&frames.StreamFrame{StreamID: 5}}, Length: 1}
+		})
+
+		It("detects handshake packets", func() {
+			Expect(handshakePacket.IsHandshakePacket()).To(BeTrue())
+			Expect(dataPacket.IsHandshakePacket()).To(BeFalse())
+		})
+
+		It("uses 1.5 times the initial RTT before the first RTT measurement", func() {
+			Expect(handler.getHandshakeRetransmissionDelay()).To(Equal(150 * time.Millisecond))
+		})
+
+		It("uses 1.5 times the smoothed RTT", func() {
+			handler.rttStats.UpdateRTT(20*time.Millisecond, 0, time.Now())
+			Expect(handler.getHandshakeRetransmissionDelay()).To(Equal(30 * time.Millisecond))
+		})
+
+		It("limits the handshake retransmission delay min", func() {
+			handler.rttStats.UpdateRTT(time.Millisecond, 0, time.Now())
+			Expect(handler.getHandshakeRetransmissionDelay()).To(Equal(protocol.MinHandshakeRetransmissionTime))
+		})
+
+		It("implements exponential backoff", func() {
+			handler.consecutiveHandshakeRetransmissions = 2
+			Expect(handler.getHandshakeRetransmissionDelay()).To(Equal(4 * 150 * time.Millisecond))
+		})
+
+		It("sets the timer earlier than the RTO if handshake packets are outstanding", func() {
+			err := handler.SentPacket(handshakePacket)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(handler.TimeOfFirstRTO().Sub(time.Now())).To(BeNumerically("~", 150*time.Millisecond, time.Millisecond))
+		})
+
+		It("only retransmits handshake packets when the handshake timer fires", func() {
+			err := handler.SentPacket(handshakePacket)
+			Expect(err).NotTo(HaveOccurred())
+			err = handler.SentPacket(dataPacket)
+			Expect(err).NotTo(HaveOccurred())
+			handler.lastSentHandshakePacketTime = time.Now().Add(-200 * time.Millisecond)
+			handler.MaybeQueueRTOs()
+			Expect(handler.retransmissionQueue).To(HaveLen(1))
+			Expect(handler.retransmissionQueue[0].PacketNumber).To(Equal(protocol.PacketNumber(1)))
+			Expect(handler.handshakePacketsInFlight).To(BeZero())
+			Expect(handler.consecutiveHandshakeRetransmissions).To(Equal(uint32(1)))
+			Expect(handler.consecutiveRTOCount).To(BeZero())
+			Expect(handler.packetHistory.Len()).To(Equal(1))
+		})
+
+		It("doesn't retransmit handshake packets before the timer fires", func() {
+			err := handler.SentPacket(handshakePacket)
+			Expect(err).NotTo(HaveOccurred())
+			handler.MaybeQueueRTOs()
+			Expect(handler.retransmissionQueue).To(BeEmpty())
+		})
+
+		It("resets the backoff when a handshake packet is acked", func() {
+			err := handler.SentPacket(handshakePacket)
+			Expect(err).NotTo(HaveOccurred())
+			handler.consecutiveHandshakeRetransmissions = 3
+			err = handler.ReceivedAck(&frames.AckFrame{LargestAcked: 1, LowestAcked: 1}, 1, time.Now())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(handler.consecutiveHandshakeRetransmissions).To(BeZero())
+			Expect(handler.handshakePacketsInFlight).To(BeZero())
+		})
+
+		It("dequeues handshake packets first", func() {
+			handler.retransmissionQueue = []*Packet{handshakePacket, dataPacket}
+			Expect(handler.DequeuePacketForRetransmission()).To(Equal(handshakePacket))
+			Expect(handler.DequeuePacketForRetransmission()).To(Equal(dataPacket))
+			Expect(handler.DequeuePacketForRetransmission()).To(BeNil())
+		})
+	})
 })
+
+func BenchmarkSentPacketHandler(b *testing.B) {
+	clock := congestion.DefaultClock{}
+	rttStats := congestion.NewRTTStats()
+	cong := congestion.NewCubicSender(clock, rttStats, false, protocol.InitialCongestionWindow, protocol.DefaultMinCongestionWindow, protocol.DefaultMaxCongestionWindow)
+	handler := NewSentPacketHandler(clock, rttStats, cong, 0, protocol.DefaultPacingBurstSize, nil)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 1; i <= b.N; i++ {
+		pn := protocol.PacketNumber(i)
+		err := handler.SentPacket(&Packet{
+			PacketNumber: pn,
+			Frames:       []frames.Frame{&frames.StreamFrame{StreamID: 5}},
+			Length:       protocol.MaxPacketSize,
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+		// ACK every other packet
+		if i%2 == 0 {
+			err = handler.ReceivedAck(&frames.AckFrame{LargestAcked: pn, LowestAcked: pn - 1}, pn, clock.Now())
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
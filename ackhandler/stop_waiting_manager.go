@@ -21,12 +21,19 @@ func (s *stopWaitingManager) GetStopWaitingFrame(force bool) *frames.StopWaiting
 		return nil
 	}
 
-	s.largestLeastUnackedSent = s.nextLeastUnacked
-	swf := &frames.StopWaitingFrame{
+	return &frames.StopWaitingFrame{
 		LeastUnacked: s.nextLeastUnacked,
 	}
-	s.lastStopWaitingFrame = swf
-	return swf
+}
+
+// SentStopWaitingFrame is called when a packet containing a StopWaitingFrame was sent
+// A StopWaitingFrame is only regarded as sent then, since the packet packer might decide not to send a packet after all
+func (s *stopWaitingManager) SentStopWaitingFrame(f *frames.StopWaitingFrame) {
+	if f.LeastUnacked <= s.largestLeastUnackedSent {
+		return
+	}
+	s.largestLeastUnackedSent = f.LeastUnacked
+	s.lastStopWaitingFrame = f
 }
 
 func (s *stopWaitingManager) ReceivedAck(ack *frames.AckFrame) {
@@ -30,15 +30,33 @@ var _ = Describe("StopWaitingManager", func() {
 
 	It("does not send the same StopWaitingFrame twice", func() {
 		manager.ReceivedAck(&frames.AckFrame{LargestAcked: 10})
-		Expect(manager.GetStopWaitingFrame(false)).ToNot(BeNil())
+		swf := manager.GetStopWaitingFrame(false)
+		Expect(swf).ToNot(BeNil())
+		manager.SentStopWaitingFrame(swf)
 		Expect(manager.GetStopWaitingFrame(false)).To(BeNil())
 	})
 
-	It("gets the same StopWaitingFrame twice, if forced", func() {
+	It("returns the StopWaitingFrame again, if it was not sent", func() {
 		manager.ReceivedAck(&frames.AckFrame{LargestAcked: 10})
 		Expect(manager.GetStopWaitingFrame(false)).ToNot(BeNil())
-		Expect(manager.GetStopWaitingFrame(true)).ToNot(BeNil())
-		Expect(manager.GetStopWaitingFrame(true)).ToNot(BeNil())
+		Expect(manager.GetStopWaitingFrame(false)).To(Equal(&frames.StopWaitingFrame{LeastUnacked: 11}))
+	})
+
+	It("gets the same StopWaitingFrame twice, if forced", func() {
+		manager.ReceivedAck(&frames.AckFrame{LargestAcked: 10})
+		swf := manager.GetStopWaitingFrame(false)
+		Expect(swf).ToNot(BeNil())
+		manager.SentStopWaitingFrame(swf)
+		Expect(manager.GetStopWaitingFrame(true)).To(Equal(swf))
+		Expect(manager.GetStopWaitingFrame(true)).To(Equal(swf))
+	})
+
+	It("ignores sent StopWaitingFrames with a lower LeastUnacked", func() {
+		manager.ReceivedAck(&frames.AckFrame{LargestAcked: 10})
+		swf := manager.GetStopWaitingFrame(false)
+		manager.SentStopWaitingFrame(swf)
+		manager.SentStopWaitingFrame(&frames.StopWaitingFrame{LeastUnacked: 5})
+		Expect(manager.GetStopWaitingFrame(true)).To(Equal(swf))
 	})
 
 	It("increases the LeastUnacked when a retransmission is queued", func() {
@@ -81,6 +81,53 @@ func setFlowControlParameters(mgr handshake.ConnectionParametersManager) {
 	})
 }
 
+// newLinkedSessions creates two sessions that are connected to each other, with the handshake already completed.
+// The returned function closes both sessions.
+func newLinkedSessions(version protocol.VersionNumber) (*Session, *Session, func()) {
+	connID := protocol.ConnectionID(mrand.Uint32())
+	config, err := populateConfig(nil)
+	Expect(err).NotTo(HaveOccurred())
+
+	c1 := newLinkedConnection(nil)
+	session1I, err := newSession(c1, version, connID, nil, config, func(SessionHandle, utils.Stream) {}, func(protocol.ConnectionID, packetHandler) {})
+	if err != nil {
+		Expect(err).NotTo(HaveOccurred())
+	}
+	session1 := session1I.(*Session)
+
+	c2 := newLinkedConnection(session1)
+	session2I, err := newSession(c2, version, connID, nil, config, func(SessionHandle, utils.Stream) {}, func(protocol.ConnectionID, packetHandler) {})
+	if err != nil {
+		Expect(err).NotTo(HaveOccurred())
+	}
+	session2 := session2I.(*Session)
+	c1.other = session2
+
+	key := make([]byte, 16)
+	iv := make([]byte, 4)
+	rand.Read(key)
+	rand.Read(iv)
+	aead, err := crypto.NewAEADAESGCM(key, key, iv, iv)
+	Expect(err).NotTo(HaveOccurred())
+	setAEAD(session1.cryptoSetup, aead)
+	setAEAD(session2.cryptoSetup, aead)
+
+	setFlowControlParameters(session1.connectionParameters)
+	setFlowControlParameters(session2.connectionParameters)
+
+	go session1.run()
+	go session2.run()
+
+	return session1, session2, func() {
+		session1.Close(nil)
+		session2.Close(nil)
+
+		// Signal connections to close
+		c1.c <- nil
+		c2.c <- nil
+	}
+}
+
 var _ = Describe("Benchmarks", func() {
 	dataLen := 50 /* MB */ * (1 << 20)
 	data := make([]byte, dataLen)
@@ -95,37 +142,7 @@ var _ = Describe("Benchmarks", func() {
 					Skip("benchmark tests disabled on windows, see #325")
 				}
 
-				connID := protocol.ConnectionID(mrand.Uint32())
-
-				c1 := newLinkedConnection(nil)
-				session1I, err := newSession(c1, version, connID, nil, func(*Session, utils.Stream) {}, func(id protocol.ConnectionID) {})
-				if err != nil {
-					Expect(err).NotTo(HaveOccurred())
-				}
-				session1 := session1I.(*Session)
-
-				c2 := newLinkedConnection(session1)
-				session2I, err := newSession(c2, version, connID, nil, func(*Session, utils.Stream) {}, func(id protocol.ConnectionID) {})
-				if err != nil {
-					Expect(err).NotTo(HaveOccurred())
-				}
-				session2 := session2I.(*Session)
-				c1.other = session2
-
-				key := make([]byte, 16)
-				iv := make([]byte, 4)
-				rand.Read(key)
-				rand.Read(iv)
-				aead, err := crypto.NewAEADAESGCM(key, key, iv, iv)
-				Expect(err).NotTo(HaveOccurred())
-				setAEAD(session1.cryptoSetup, aead)
-				setAEAD(session2.cryptoSetup, aead)
-
-				setFlowControlParameters(session1.connectionParameters)
-				setFlowControlParameters(session2.connectionParameters)
-
-				go session1.run()
-				go session2.run()
+				session1, session2, closeSessions := newLinkedSessions(version)
 
 				s1stream, err := session1.GetOrOpenStream(5)
 				Expect(err).NotTo(HaveOccurred())
@@ -157,15 +174,68 @@ var _ = Describe("Benchmarks", func() {
 					}
 				})
 
-				session1.Close(nil)
-				session2.Close(nil)
-
-				// Signal connections to close
-				c1.c <- nil
-				c2.c <- nil
+				closeSessions()
 
 				b.RecordValue("transfer rate [MB/s]", float64(dataLen)/1e6/runtime.Seconds())
 			}, 6)
+
+			Measure("many streams", func(b Benchmarker) {
+				if runtime.GOOS == "windows" {
+					Skip("benchmark tests disabled on windows, see #325")
+				}
+
+				const numStreams = 10
+				streamDataLen := 1 /* MB */ * (1 << 20)
+
+				session1, session2, closeSessions := newLinkedSessions(version)
+
+				start := time.Now()
+				durations := make(chan time.Duration, numStreams)
+				for i := 0; i < numStreams; i++ {
+					id := protocol.StreamID(5 + 2*i)
+					s1stream, err := session1.GetOrOpenStream(id)
+					Expect(err).NotTo(HaveOccurred())
+					s2stream, err := session2.GetOrOpenStream(id)
+					Expect(err).NotTo(HaveOccurred())
+
+					go func() {
+						defer GinkgoRecover()
+						_, err := io.Copy(s1stream, bytes.NewReader(data[:streamDataLen]))
+						Expect(err).NotTo(HaveOccurred())
+					}()
+					go func() {
+						defer GinkgoRecover()
+						buf := make([]byte, 1500)
+						dataRead := 0
+						for dataRead < streamDataLen {
+							n, err := s2stream.Read(buf)
+							Expect(err).NotTo(HaveOccurred())
+							dataRead += n
+						}
+						durations <- time.Since(start)
+					}()
+				}
+
+				var first, last time.Duration
+				for i := 0; i < numStreams; i++ {
+					select {
+					case d := <-durations:
+						if i == 0 {
+							first = d
+						}
+						last = d
+					case <-time.After(20 * time.Second):
+						debug.PrintStack()
+						Fail("timeout")
+					}
+				}
+
+				closeSessions()
+
+				b.RecordValue("transfer rate [MB/s]", float64(numStreams*streamDataLen)/1e6/last.Seconds())
+				// a perfectly fair scheduler finishes all streams at the same time
+				b.RecordValue("first / last stream completion time", first.Seconds()/last.Seconds())
+			}, 3)
 		})
 	}
 })
@@ -0,0 +1,47 @@
+package quic
+
+import (
+	"sync/atomic"
+
+	"github.com/lucas-clemente/quic-go/protocol"
+	"github.com/lucas-clemente/quic-go/utils"
+)
+
+// A closedSession replaces a session after it sent a CONNECTION_CLOSE.
+// The client might not have received the CONNECTION_CLOSE, and keep sending packets until its RTO fires.
+// The closedSession replies to these packets by retransmitting the CONNECTION_CLOSE packet, with an exponential backoff.
+type closedSession struct {
+	connectionID protocol.ConnectionID
+	conn         connection
+	closePacket  []byte
+
+	counter uint64 // number of packets received, accessed atomically
+}
+
+var _ packetHandler = &closedSession{}
+
+func newClosedSession(connectionID protocol.ConnectionID, conn connection, closePacket []byte) packetHandler {
+	return &closedSession{
+		connectionID: connectionID,
+		conn:         conn,
+		closePacket:  closePacket,
+	}
+}
+
+func (s *closedSession) handlePacket(p *receivedPacket) {
+	// The packet always begins with the public header, see Session.run.
+	putPacketBuffer(p.publicHeader.Raw)
+	n := atomic.AddUint64(&s.counter, 1)
+	// only reply to the 1st, 2nd, 4th, 8th, ... packet
+	if n&(n-1) != 0 {
+		return
+	}
+	utils.Debugf("Retransmitting CONNECTION_CLOSE for connection %x, after receiving %d packets", s.connectionID, n)
+	if err := s.conn.write(s.closePacket); err != nil {
+		utils.Errorf("Error retransmitting CONNECTION_CLOSE for connection %x: %s", s.connectionID, err.Error())
+	}
+}
+
+func (s *closedSession) run() {}
+
+func (s *closedSession) Close(error) error { return nil }
@@ -0,0 +1,46 @@
+package quic
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("closed session", func() {
+	var (
+		session packetHandler
+		conn    *mockConnection
+	)
+
+	BeforeEach(func() {
+		conn = &mockConnection{}
+		session = newClosedSession(0x1337, conn, []byte("connection close"))
+	})
+
+	It("retransmits the CONNECTION_CLOSE packet with an exponential backoff", func() {
+		var written []int
+		for i := 1; i <= 20; i++ {
+			session.handlePacket(&receivedPacket{publicHeader: &PublicHeader{Raw: getPacketBuffer()}})
+			if len(conn.written) > len(written) {
+				written = append(written, i)
+			}
+		}
+		Expect(written).To(Equal([]int{1, 2, 4, 8, 16}))
+		for _, p := range conn.written {
+			Expect(p).To(Equal([]byte("connection close")))
+		}
+	})
+
+	It("returns the packet buffer to the pool", func() {
+		Expect(func() {
+			session.handlePacket(&receivedPacket{publicHeader: &PublicHeader{Raw: getPacketBuffer()}})
+			session.handlePacket(&receivedPacket{publicHeader: &PublicHeader{Raw: getPacketBuffer()}})
+			session.handlePacket(&receivedPacket{publicHeader: &PublicHeader{Raw: getPacketBuffer()}})
+		}).ToNot(Panic())
+		Expect(conn.written).To(HaveLen(2))
+	})
+
+	It("does nothing when closed", func() {
+		Expect(session.Close(nil)).To(Succeed())
+		Expect(conn.written).To(BeEmpty())
+	})
+})
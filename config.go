@@ -0,0 +1,303 @@
+package quic
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/lucas-clemente/quic-go/congestion"
+	"github.com/lucas-clemente/quic-go/crypto"
+	"github.com/lucas-clemente/quic-go/handshake"
+	"github.com/lucas-clemente/quic-go/protocol"
+	"github.com/lucas-clemente/quic-go/utils"
+)
+
+// CongestionControlAlgorithm is a congestion control algorithm
+type CongestionControlAlgorithm int
+
+const (
+	// CongestionControlCubic is TCP Cubic, as implemented by Chromium. It is the default.
+	CongestionControlCubic CongestionControlAlgorithm = iota
+	// CongestionControlNewReno is TCP NewReno (RFC 6582)
+	CongestionControlNewReno
+)
+
+// AEADPreference determines which AEAD the server asks clients to use
+type AEADPreference int
+
+const (
+	// AEADPreferenceAuto prefers AES-GCM if the CPU has hardware support for AES, and ChaCha20-Poly1305 otherwise. It is the default.
+	AEADPreferenceAuto AEADPreference = iota
+	// AEADPreferenceAESGCM prefers AES-GCM
+	AEADPreferenceAESGCM
+	// AEADPreferenceChaCha20 prefers ChaCha20-Poly1305
+	AEADPreferenceChaCha20
+)
+
+// Config contains all configuration data needed for a QUIC server.
+// The zero value is a valid configuration, and uses the defaults for all options.
+// All congestion window sizes are given in bytes, and rounded down to full packets.
+type Config struct {
+	// CongestionControl is the congestion control algorithm used for all sessions
+	CongestionControl CongestionControlAlgorithm
+	// InitialCongestionWindow is the initial congestion window.
+	// It must be between protocol.MinInitialCongestionWindow and protocol.MaxInitialCongestionWindow packets,
+	// and between MinCongestionWindow and MaxCongestionWindow.
+	// If not set, protocol.InitialCongestionWindow packets are used, limited by MinCongestionWindow and MaxCongestionWindow.
+	InitialCongestionWindow protocol.ByteCount
+	// MinCongestionWindow is the size the congestion window never shrinks below, not even after retransmission timeouts.
+	// It must be at least protocol.DefaultMinCongestionWindow packets, which is also the default.
+	MinCongestionWindow protocol.ByteCount
+	// MaxCongestionWindow is the size the congestion window never grows beyond.
+	// It must not be larger than protocol.DefaultMaxCongestionWindow packets, which is also the default.
+	MaxCongestionWindow protocol.ByteCount
+	// MaxPacingRate limits the rate at which packets are sent. It can be used to cap the bandwidth used by a connection.
+	// If not set, the pacing rate is only determined by the congestion controller.
+	MaxPacingRate congestion.Bandwidth
+	// PacingBurstSize is the number of packets that may be sent back-to-back, if the pacing budget allows it.
+	// Smaller values reduce queueing delay, larger values allow higher throughput on paths with a large bandwidth-delay product.
+	// It must not be larger than protocol.MaxPacingBurstSize. If not set, protocol.DefaultPacingBurstSize is used.
+	PacingBurstSize int
+	// MaxServerBandwidth limits the rate at which all sessions of the server together send packets.
+	// This caps the bandwidth used by the server, independent of the number of connections. If not set, there's no limit.
+	// The limit applies to each Server created with this Config separately.
+	MaxServerBandwidth congestion.Bandwidth
+	// AckElicitingThreshold is the number of received packets after which an ACK is sent immediately.
+	// Otherwise, the ACK is delayed by up to protocol.AckSendDelay, in the hope that it can be sent together with other frames.
+	// It must not be larger than protocol.MaxAckElicitingThreshold. If not set, protocol.DefaultAckElicitingThreshold is used.
+	AckElicitingThreshold int
+	// MaxAckRanges is the number of ACK ranges that are kept. If more ranges are created, the oldest ones are deleted, even if the peer didn't send a StopWaiting frame.
+	// It must not be larger than MaxTrackedReceivedAckRanges. If not set, protocol.DefaultMaxAckRanges is used, limited by MaxTrackedReceivedAckRanges.
+	MaxAckRanges int
+	// MaxTrackedReceivedPackets is the number of received packets that are tracked until the peer acknowledges the ACKs for them.
+	// If the peer causes more packets to be outstanding, the connection is closed with a TooManyOutstandingReceivedPackets error.
+	// Links with a high bandwidth and a high loss rate might need larger values. If not set, protocol.DefaultMaxTrackedReceivedPackets is used.
+	MaxTrackedReceivedPackets int
+	// MaxTrackedReceivedAckRanges is the number of ACK ranges of received packets that are tracked.
+	// If the peer causes more ranges to be outstanding, the connection is closed with a TooManyOutstandingReceivedPackets error.
+	// If not set, protocol.DefaultMaxTrackedReceivedAckRanges is used.
+	MaxTrackedReceivedAckRanges int
+	// MaxAckRangeAge is the time after which an ACK range that didn't receive any packets is deleted.
+	// If not set, protocol.DefaultMaxAckRangeAge is used.
+	MaxAckRangeAge time.Duration
+	// MaxStreamOutOfOrderData is the number of bytes that are buffered per stream, if data arrives out of order.
+	// If a peer sends more data beyond a gap, the connection is closed.
+	// It must not be larger than protocol.MaxReceiveStreamFlowControlWindow, which is also the default.
+	MaxStreamOutOfOrderData protocol.ByteCount
+	// MaxStreamSendBuffer is the number of bytes that are buffered per stream for sending.
+	// Stream.Write blocks while this many bytes are buffered, so fast writers can't make the session buffer large amounts of data
+	// that can't be sent yet because of flow control or congestion control. If not set, protocol.DefaultMaxStreamSendBuffer is used.
+	MaxStreamSendBuffer protocol.ByteCount
+	// MaxConnectionReceiveBuffer limits the data buffered for all streams of a session together, both in order and out of order.
+	// It is enforced by never increasing the connection-level receive window beyond it, even if the stream-level windows would allow more data.
+	// The crypto and the headers stream don't contribute to connection-level flow control, and are only limited by their stream-level windows.
+	// It must be between protocol.ReceiveConnectionFlowControlWindow and protocol.MaxReceiveConnectionFlowControlWindow, which is also the default.
+	MaxConnectionReceiveBuffer protocol.ByteCount
+	// WindowUpdateThreshold determines when a WINDOW_UPDATE is sent: once less than this fraction of the receive window increment is left.
+	// Larger values make it less likely that the client stalls, at the cost of more WINDOW_UPDATEs. It must be between 0 and 1.
+	// If not set, protocol.DefaultWindowUpdateThreshold is used.
+	WindowUpdateThreshold float64
+	// WindowUpdateRTTs makes sessions also send a WINDOW_UPDATE when data was read and this number of RTTs passed since the last one, even if the threshold wasn't reached.
+	// If not set, WINDOW_UPDATEs are only sent based on the WindowUpdateThreshold.
+	WindowUpdateRTTs int
+	// DisableActiveMigration makes sessions ignore packets that are sent from a different IP address than the previous packets.
+	// Changes of the port only, as caused by NAT rebindings, are still accepted.
+	// This is useful behind load balancers that route by the 4-tuple.
+	DisableActiveMigration bool
+	// KeepAliveInterval makes sessions send a PING frame when no packet was received from the client for this time.
+	// This keeps NAT bindings alive. It should be shorter than the idle timeout. If not set, no keep-alives are sent.
+	KeepAliveInterval time.Duration
+	// DiscoverKeepAliveInterval makes sessions search for the NAT binding timeout of the path, by sending keep-alives after different idle periods between MinKeepAliveInterval and KeepAliveInterval.
+	// The longest interval that keeps the binding alive is used, which reduces the number of keep-alives, e.g. to save radio wakeups of mobile clients.
+	// While searching, a keep-alive can be sent after the binding expired, making the client unreachable until it sends a packet.
+	DiscoverKeepAliveInterval bool
+	// MinKeepAliveInterval is the shortest keep-alive interval used by DiscoverKeepAliveInterval.
+	// If not set, protocol.DefaultMinKeepAliveInterval is used, or the KeepAliveInterval if it is shorter.
+	MinKeepAliveInterval time.Duration
+	// ReplayFilter is used to detect replayed CHLOs, which are then rejected instead of being accepted for a 0-RTT handshake.
+	// If not set, a handshake.NewMemoryReplayFilter with a window of protocol.DefaultReplayWindow is used.
+	// Servers sharing the same server config need to share a ReplayFilter as well.
+	ReplayFilter handshake.ReplayFilter
+	// AcceptEarlyData is called for every client that tries to do a 0-RTT handshake, with the client's IP and the requested server name (SNI).
+	// If it returns false, the CHLO is rejected, and the handshake completes after another round trip, when the client echoes the server nonce of the REJ.
+	// The client then has to resend the data it sent along with the rejected CHLO.
+	// If not set, early data is accepted from all clients.
+	AcceptEarlyData func(ip net.IP, sni string) bool
+	// AEADPreference determines the order in which the AEADs are offered to clients, which use the first one they support.
+	// By default, it depends on the CPU: software implementations of AES-GCM are much slower than ChaCha20-Poly1305.
+	// ChaCha20-Poly1305 requires Go 1.18. When built with an older Go version, only AES-GCM is offered, regardless of the preference.
+	AEADPreference AEADPreference
+	// STKSecrets are the secrets used to create and verify source address tokens (STKs), which clients cache to do 0-RTT handshakes.
+	// New tokens are created using the first secret, tokens created using any of the other secrets are still accepted.
+	// Servers behind a load balancer should use the same secrets. The secrets can be rotated at runtime using Server.RotateSTKSecret.
+	// If not set, a random secret is used.
+	STKSecrets [][]byte
+	// EnableDebugSnapshots allows taking DebugSnapshots of the internal state of sessions, using Session.DebugSnapshot.
+	// This is useful for debugging stuck connections. Snapshots are only taken when requested.
+	EnableDebugSnapshots bool
+	// SendAckTimestamps makes sessions report the receive times of packets in ACK frames.
+	// The client can use them to estimate the one-way delays and queuing on both directions of the path.
+	// Receive timestamps sent by the client are always used, see SessionStats.ForwardQueuingDelay.
+	SendAckTimestamps bool
+	// ConnectionEvents are notified about state changes of every session
+	ConnectionEvents ConnectionEvents
+
+	// serverPacer enforces MaxServerBandwidth. It is created by populateConfig and shared by all sessions of a server.
+	serverPacer *congestion.SharedPacer
+	// clock is the clock of all sessions, including their run loop timers. It defaults to the wall clock, and is only replaced in tests.
+	clock congestion.Clock
+}
+
+// populateConfig returns a copy of config, with all unset values set to their defaults.
+// It returns an error if config contains invalid values.
+func populateConfig(config *Config) (*Config, error) {
+	c := &Config{}
+	if config != nil {
+		*c = *config
+	}
+	if c.MinCongestionWindow == 0 {
+		c.MinCongestionWindow = protocol.DefaultMinCongestionWindow * protocol.DefaultTCPMSS
+	}
+	if c.MaxCongestionWindow == 0 {
+		c.MaxCongestionWindow = protocol.DefaultMaxCongestionWindow * protocol.DefaultTCPMSS
+	}
+	if c.InitialCongestionWindow == 0 {
+		c.InitialCongestionWindow = protocol.InitialCongestionWindow * protocol.DefaultTCPMSS
+		c.InitialCongestionWindow = utils.MaxByteCount(c.InitialCongestionWindow, c.MinCongestionWindow)
+		c.InitialCongestionWindow = utils.MinByteCount(c.InitialCongestionWindow, c.MaxCongestionWindow)
+	}
+	if c.PacingBurstSize == 0 {
+		c.PacingBurstSize = protocol.DefaultPacingBurstSize
+	}
+	if c.PacingBurstSize < 0 || c.PacingBurstSize > protocol.MaxPacingBurstSize {
+		return nil, fmt.Errorf("invalid pacing burst size: %d packets (must be between 1 and %d packets)", c.PacingBurstSize, protocol.MaxPacingBurstSize)
+	}
+	if c.AckElicitingThreshold == 0 {
+		c.AckElicitingThreshold = protocol.DefaultAckElicitingThreshold
+	}
+	if c.AckElicitingThreshold < 0 || c.AckElicitingThreshold > protocol.MaxAckElicitingThreshold {
+		return nil, fmt.Errorf("invalid ACK-eliciting threshold: %d packets (must be between 1 and %d packets)", c.AckElicitingThreshold, protocol.MaxAckElicitingThreshold)
+	}
+	if c.MaxTrackedReceivedPackets == 0 {
+		c.MaxTrackedReceivedPackets = protocol.DefaultMaxTrackedReceivedPackets
+	}
+	if c.MaxTrackedReceivedPackets < 0 {
+		return nil, fmt.Errorf("invalid max tracked received packets: %d", c.MaxTrackedReceivedPackets)
+	}
+	if c.MaxTrackedReceivedAckRanges == 0 {
+		c.MaxTrackedReceivedAckRanges = protocol.DefaultMaxTrackedReceivedAckRanges
+	}
+	if c.MaxTrackedReceivedAckRanges < 0 {
+		return nil, fmt.Errorf("invalid max tracked received ACK ranges: %d", c.MaxTrackedReceivedAckRanges)
+	}
+	if c.MaxAckRanges == 0 {
+		c.MaxAckRanges = utils.Min(protocol.DefaultMaxAckRanges, c.MaxTrackedReceivedAckRanges)
+	}
+	if c.MaxAckRanges < 0 || c.MaxAckRanges > c.MaxTrackedReceivedAckRanges {
+		return nil, fmt.Errorf("invalid max ACK ranges: %d (must be between 1 and %d)", c.MaxAckRanges, c.MaxTrackedReceivedAckRanges)
+	}
+	if c.MaxAckRangeAge == 0 {
+		c.MaxAckRangeAge = protocol.DefaultMaxAckRangeAge
+	}
+	if c.MaxAckRangeAge < 0 {
+		return nil, fmt.Errorf("invalid max ACK range age: %s", c.MaxAckRangeAge)
+	}
+	if c.MaxStreamOutOfOrderData == 0 {
+		c.MaxStreamOutOfOrderData = protocol.MaxReceiveStreamFlowControlWindow
+	}
+	if c.MaxStreamOutOfOrderData > protocol.MaxReceiveStreamFlowControlWindow {
+		return nil, fmt.Errorf("invalid max stream out-of-order data: %d bytes (must be at most %d bytes)", c.MaxStreamOutOfOrderData, protocol.MaxReceiveStreamFlowControlWindow)
+	}
+	if c.MaxStreamSendBuffer == 0 {
+		c.MaxStreamSendBuffer = protocol.DefaultMaxStreamSendBuffer
+	}
+	if c.MaxConnectionReceiveBuffer == 0 {
+		c.MaxConnectionReceiveBuffer = protocol.MaxReceiveConnectionFlowControlWindow
+	}
+	if c.MaxConnectionReceiveBuffer < protocol.ReceiveConnectionFlowControlWindow || c.MaxConnectionReceiveBuffer > protocol.MaxReceiveConnectionFlowControlWindow {
+		return nil, fmt.Errorf("invalid max connection receive buffer: %d bytes (must be between %d and %d bytes)", c.MaxConnectionReceiveBuffer, protocol.ReceiveConnectionFlowControlWindow, protocol.MaxReceiveConnectionFlowControlWindow)
+	}
+	if c.WindowUpdateThreshold == 0 {
+		c.WindowUpdateThreshold = protocol.DefaultWindowUpdateThreshold
+	}
+	if c.WindowUpdateThreshold < 0 || c.WindowUpdateThreshold > 1 {
+		return nil, fmt.Errorf("invalid window update threshold: %g (must be between 0 and 1)", c.WindowUpdateThreshold)
+	}
+	if c.WindowUpdateRTTs < 0 {
+		return nil, fmt.Errorf("invalid window update RTTs: %d", c.WindowUpdateRTTs)
+	}
+	if c.KeepAliveInterval < 0 {
+		return nil, fmt.Errorf("invalid keep-alive interval: %s", c.KeepAliveInterval)
+	}
+	if c.DiscoverKeepAliveInterval && c.KeepAliveInterval == 0 {
+		return nil, errors.New("DiscoverKeepAliveInterval requires a KeepAliveInterval")
+	}
+	if c.MinKeepAliveInterval == 0 {
+		c.MinKeepAliveInterval = utils.MinDuration(protocol.DefaultMinKeepAliveInterval, c.KeepAliveInterval)
+	}
+	if c.MinKeepAliveInterval < 0 || (c.KeepAliveInterval > 0 && c.MinKeepAliveInterval > c.KeepAliveInterval) {
+		return nil, fmt.Errorf("invalid min keep-alive interval: %s (must be at most the keep-alive interval)", c.MinKeepAliveInterval)
+	}
+	if c.MaxServerBandwidth > 0 {
+		c.serverPacer = congestion.NewSharedPacer(c.MaxServerBandwidth, protocol.ServerBandwidthBurstSize)
+	}
+	if c.AEADPreference < AEADPreferenceAuto || c.AEADPreference > AEADPreferenceChaCha20 {
+		return nil, fmt.Errorf("invalid AEAD preference: %d", c.AEADPreference)
+	}
+	if c.ReplayFilter == nil {
+		c.ReplayFilter = handshake.NewMemoryReplayFilter(protocol.DefaultReplayWindow)
+	}
+	if c.clock == nil {
+		c.clock = congestion.DefaultClock{}
+	}
+	initialWindow := c.initialCongestionWindowPackets()
+	minWindow := c.minCongestionWindowPackets()
+	maxWindow := c.maxCongestionWindowPackets()
+	if minWindow < protocol.DefaultMinCongestionWindow {
+		return nil, fmt.Errorf("invalid min congestion window: %d bytes (must be at least %d packets)", c.MinCongestionWindow, protocol.DefaultMinCongestionWindow)
+	}
+	if maxWindow > protocol.DefaultMaxCongestionWindow {
+		return nil, fmt.Errorf("invalid max congestion window: %d bytes (must be at most %d packets)", c.MaxCongestionWindow, protocol.DefaultMaxCongestionWindow)
+	}
+	if minWindow > maxWindow {
+		return nil, errors.New("invalid congestion window: the min congestion window must not be larger than the max congestion window")
+	}
+	if initialWindow < protocol.MinInitialCongestionWindow || initialWindow > protocol.MaxInitialCongestionWindow {
+		return nil, fmt.Errorf("invalid initial congestion window: %d bytes (must be between %d and %d packets)", c.InitialCongestionWindow, protocol.MinInitialCongestionWindow, protocol.MaxInitialCongestionWindow)
+	}
+	if initialWindow < minWindow || initialWindow > maxWindow {
+		return nil, errors.New("invalid initial congestion window: must be between the min and the max congestion window")
+	}
+	return c, nil
+}
+
+// aeads returns the AEADs offered by the server config, or nil if the default should be used
+func (c *Config) aeads() []handshake.Tag {
+	if !crypto.SupportsChacha20Poly1305 {
+		return nil
+	}
+	switch c.AEADPreference {
+	case AEADPreferenceAESGCM:
+		return []handshake.Tag{handshake.TagAESG, handshake.TagCC20}
+	case AEADPreferenceChaCha20:
+		return []handshake.Tag{handshake.TagCC20, handshake.TagAESG}
+	default:
+		return nil
+	}
+}
+
+// initialCongestionWindowPackets returns the initial congestion window in packets
+func (c *Config) initialCongestionWindowPackets() protocol.PacketNumber {
+	return protocol.PacketNumber(c.InitialCongestionWindow / protocol.DefaultTCPMSS)
+}
+
+// minCongestionWindowPackets returns the min congestion window in packets
+func (c *Config) minCongestionWindowPackets() protocol.PacketNumber {
+	return protocol.PacketNumber(c.MinCongestionWindow / protocol.DefaultTCPMSS)
+}
+
+// maxCongestionWindowPackets returns the max congestion window in packets
+func (c *Config) maxCongestionWindowPackets() protocol.PacketNumber {
+	return protocol.PacketNumber(c.MaxCongestionWindow / protocol.DefaultTCPMSS)
+}
@@ -0,0 +1,423 @@
+package quic
+
+import (
+	"time"
+
+	"github.com/lucas-clemente/quic-go/congestion"
+	"github.com/lucas-clemente/quic-go/crypto"
+	"github.com/lucas-clemente/quic-go/handshake"
+	"github.com/lucas-clemente/quic-go/protocol"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Config", func() {
+	It("populates an empty config", func() {
+		config, err := populateConfig(nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(config.CongestionControl).To(Equal(CongestionControlCubic))
+		Expect(config.InitialCongestionWindow).To(Equal(protocol.InitialCongestionWindow * protocol.DefaultTCPMSS))
+	})
+
+	It("doesn't modify the original config", func() {
+		orig := &Config{}
+		config, err := populateConfig(orig)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(config).ToNot(BeIdenticalTo(orig))
+		Expect(orig.InitialCongestionWindow).To(BeZero())
+	})
+
+	Context("initial congestion window", func() {
+		It("uses the configured value", func() {
+			config, err := populateConfig(&Config{InitialCongestionWindow: 10 * protocol.DefaultTCPMSS})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.initialCongestionWindowPackets()).To(Equal(protocol.PacketNumber(10)))
+		})
+
+		It("rounds down to full packets", func() {
+			config, err := populateConfig(&Config{InitialCongestionWindow: 10*protocol.DefaultTCPMSS + 100})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.initialCongestionWindowPackets()).To(Equal(protocol.PacketNumber(10)))
+		})
+
+		It("accepts the bounds", func() {
+			_, err := populateConfig(&Config{InitialCongestionWindow: protocol.MinInitialCongestionWindow * protocol.DefaultTCPMSS})
+			Expect(err).ToNot(HaveOccurred())
+			_, err = populateConfig(&Config{InitialCongestionWindow: protocol.MaxInitialCongestionWindow * protocol.DefaultTCPMSS})
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("errors when it is too small", func() {
+			_, err := populateConfig(&Config{InitialCongestionWindow: protocol.MinInitialCongestionWindow*protocol.DefaultTCPMSS - 1})
+			Expect(err).To(MatchError("invalid initial congestion window: 2919 bytes (must be between 2 and 200 packets)"))
+		})
+
+		It("errors when it is too large", func() {
+			_, err := populateConfig(&Config{InitialCongestionWindow: (protocol.MaxInitialCongestionWindow + 1) * protocol.DefaultTCPMSS})
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("limits the default value by the min and max congestion window", func() {
+			config, err := populateConfig(&Config{MaxCongestionWindow: 20 * protocol.DefaultTCPMSS})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.initialCongestionWindowPackets()).To(Equal(protocol.PacketNumber(20)))
+			config, err = populateConfig(&Config{MinCongestionWindow: 40 * protocol.DefaultTCPMSS})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.initialCongestionWindowPackets()).To(Equal(protocol.PacketNumber(40)))
+		})
+
+		It("errors when it is not between the min and the max congestion window", func() {
+			_, err := populateConfig(&Config{InitialCongestionWindow: 10 * protocol.DefaultTCPMSS, MinCongestionWindow: 20 * protocol.DefaultTCPMSS})
+			Expect(err).To(MatchError("invalid initial congestion window: must be between the min and the max congestion window"))
+			_, err = populateConfig(&Config{InitialCongestionWindow: 30 * protocol.DefaultTCPMSS, MaxCongestionWindow: 20 * protocol.DefaultTCPMSS})
+			Expect(err).To(MatchError("invalid initial congestion window: must be between the min and the max congestion window"))
+		})
+	})
+
+	Context("pacing burst size", func() {
+		It("uses the default", func() {
+			config, err := populateConfig(nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.PacingBurstSize).To(Equal(protocol.DefaultPacingBurstSize))
+		})
+
+		It("uses the configured value", func() {
+			config, err := populateConfig(&Config{PacingBurstSize: 3})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.PacingBurstSize).To(Equal(3))
+		})
+
+		It("errors when it is too large", func() {
+			_, err := populateConfig(&Config{PacingBurstSize: protocol.MaxPacingBurstSize + 1})
+			Expect(err).To(MatchError("invalid pacing burst size: 101 packets (must be between 1 and 100 packets)"))
+		})
+
+		It("errors when it is negative", func() {
+			_, err := populateConfig(&Config{PacingBurstSize: -1})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("ACK-eliciting threshold", func() {
+		It("uses the default", func() {
+			config, err := populateConfig(nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.AckElicitingThreshold).To(Equal(protocol.DefaultAckElicitingThreshold))
+		})
+
+		It("uses the configured value", func() {
+			config, err := populateConfig(&Config{AckElicitingThreshold: 5})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.AckElicitingThreshold).To(Equal(5))
+		})
+
+		It("errors when it is too large", func() {
+			_, err := populateConfig(&Config{AckElicitingThreshold: protocol.MaxAckElicitingThreshold + 1})
+			Expect(err).To(MatchError("invalid ACK-eliciting threshold: 11 packets (must be between 1 and 10 packets)"))
+		})
+	})
+
+	Context("ACK ranges", func() {
+		It("uses the defaults", func() {
+			config, err := populateConfig(nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.MaxAckRanges).To(Equal(protocol.DefaultMaxAckRanges))
+			Expect(config.MaxAckRangeAge).To(Equal(protocol.DefaultMaxAckRangeAge))
+		})
+
+		It("uses the configured values", func() {
+			config, err := populateConfig(&Config{MaxAckRanges: 10, MaxAckRangeAge: time.Second})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.MaxAckRanges).To(Equal(10))
+			Expect(config.MaxAckRangeAge).To(Equal(time.Second))
+		})
+
+		It("limits the default max ACK ranges by the max tracked received ACK ranges", func() {
+			config, err := populateConfig(&Config{MaxTrackedReceivedAckRanges: 10})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.MaxAckRanges).To(Equal(10))
+		})
+
+		It("errors when more ACK ranges than the max tracked received ACK ranges are configured", func() {
+			_, err := populateConfig(&Config{MaxAckRanges: 11, MaxTrackedReceivedAckRanges: 10})
+			Expect(err).To(MatchError("invalid max ACK ranges: 11 (must be between 1 and 10)"))
+		})
+
+		It("errors when too many ACK ranges are configured", func() {
+			_, err := populateConfig(&Config{MaxAckRanges: protocol.DefaultMaxTrackedReceivedAckRanges + 1})
+			Expect(err).To(MatchError("invalid max ACK ranges: 1001 (must be between 1 and 1000)"))
+		})
+
+		It("errors when the max ACK range age is negative", func() {
+			_, err := populateConfig(&Config{MaxAckRangeAge: -time.Second})
+			Expect(err).To(MatchError("invalid max ACK range age: -1s"))
+		})
+	})
+
+	Context("received packet tracking", func() {
+		It("uses the defaults", func() {
+			config, err := populateConfig(nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.MaxTrackedReceivedPackets).To(Equal(protocol.DefaultMaxTrackedReceivedPackets))
+			Expect(config.MaxTrackedReceivedAckRanges).To(Equal(protocol.DefaultMaxTrackedReceivedAckRanges))
+		})
+
+		It("uses the configured values", func() {
+			config, err := populateConfig(&Config{MaxTrackedReceivedPackets: 10000, MaxTrackedReceivedAckRanges: 5000})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.MaxTrackedReceivedPackets).To(Equal(10000))
+			Expect(config.MaxTrackedReceivedAckRanges).To(Equal(5000))
+		})
+
+		It("errors when the max tracked received packets is negative", func() {
+			_, err := populateConfig(&Config{MaxTrackedReceivedPackets: -1})
+			Expect(err).To(MatchError("invalid max tracked received packets: -1"))
+		})
+
+		It("errors when the max tracked received ACK ranges is negative", func() {
+			_, err := populateConfig(&Config{MaxTrackedReceivedAckRanges: -1})
+			Expect(err).To(MatchError("invalid max tracked received ACK ranges: -1"))
+		})
+	})
+
+	Context("max stream out-of-order data", func() {
+		It("uses the default", func() {
+			config, err := populateConfig(nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.MaxStreamOutOfOrderData).To(Equal(protocol.MaxReceiveStreamFlowControlWindow))
+		})
+
+		It("uses the configured value", func() {
+			config, err := populateConfig(&Config{MaxStreamOutOfOrderData: 1000})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.MaxStreamOutOfOrderData).To(Equal(protocol.ByteCount(1000)))
+		})
+
+		It("errors when it is larger than the max flow control window", func() {
+			_, err := populateConfig(&Config{MaxStreamOutOfOrderData: protocol.MaxReceiveStreamFlowControlWindow + 1})
+			Expect(err).To(MatchError("invalid max stream out-of-order data: 1048577 bytes (must be at most 1048576 bytes)"))
+		})
+	})
+
+	Context("max stream send buffer", func() {
+		It("uses the default", func() {
+			config, err := populateConfig(nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.MaxStreamSendBuffer).To(Equal(protocol.DefaultMaxStreamSendBuffer))
+		})
+
+		It("uses the configured value", func() {
+			config, err := populateConfig(&Config{MaxStreamSendBuffer: 1000})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.MaxStreamSendBuffer).To(Equal(protocol.ByteCount(1000)))
+		})
+	})
+
+	Context("max connection receive buffer", func() {
+		It("uses the default", func() {
+			config, err := populateConfig(nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.MaxConnectionReceiveBuffer).To(Equal(protocol.MaxReceiveConnectionFlowControlWindow))
+		})
+
+		It("uses the configured value", func() {
+			config, err := populateConfig(&Config{MaxConnectionReceiveBuffer: 100000})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.MaxConnectionReceiveBuffer).To(Equal(protocol.ByteCount(100000)))
+		})
+
+		It("errors when it is smaller than the initial window", func() {
+			_, err := populateConfig(&Config{MaxConnectionReceiveBuffer: protocol.ReceiveConnectionFlowControlWindow - 1})
+			Expect(err).To(MatchError("invalid max connection receive buffer: 49151 bytes (must be between 49152 and 1572864 bytes)"))
+		})
+
+		It("errors when it is larger than the max flow control window", func() {
+			_, err := populateConfig(&Config{MaxConnectionReceiveBuffer: protocol.MaxReceiveConnectionFlowControlWindow + 1})
+			Expect(err).To(MatchError("invalid max connection receive buffer: 1572865 bytes (must be between 49152 and 1572864 bytes)"))
+		})
+	})
+
+	Context("window updates", func() {
+		It("uses the defaults", func() {
+			config, err := populateConfig(nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.WindowUpdateThreshold).To(Equal(protocol.DefaultWindowUpdateThreshold))
+			Expect(config.WindowUpdateRTTs).To(BeZero())
+		})
+
+		It("uses the configured values", func() {
+			config, err := populateConfig(&Config{WindowUpdateThreshold: 0.75, WindowUpdateRTTs: 2})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.WindowUpdateThreshold).To(Equal(0.75))
+			Expect(config.WindowUpdateRTTs).To(Equal(2))
+		})
+
+		It("errors when the threshold is too large", func() {
+			_, err := populateConfig(&Config{WindowUpdateThreshold: 1.5})
+			Expect(err).To(MatchError("invalid window update threshold: 1.5 (must be between 0 and 1)"))
+		})
+
+		It("errors when the threshold is negative", func() {
+			_, err := populateConfig(&Config{WindowUpdateThreshold: -0.5})
+			Expect(err).To(MatchError("invalid window update threshold: -0.5 (must be between 0 and 1)"))
+		})
+
+		It("errors when the RTTs are negative", func() {
+			_, err := populateConfig(&Config{WindowUpdateRTTs: -1})
+			Expect(err).To(MatchError("invalid window update RTTs: -1"))
+		})
+	})
+
+	Context("replay filter", func() {
+		It("uses an in-memory replay filter by default", func() {
+			config, err := populateConfig(nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.ReplayFilter).ToNot(BeNil())
+		})
+
+		It("uses the configured replay filter", func() {
+			filter := handshake.NewMemoryReplayFilter(time.Second)
+			config, err := populateConfig(&Config{ReplayFilter: filter})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.ReplayFilter).To(BeIdenticalTo(filter))
+		})
+	})
+
+	Context("AEAD preference", func() {
+		It("uses the default order by default", func() {
+			config, err := populateConfig(nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.aeads()).To(BeNil())
+		})
+
+		It("only offers AES-GCM if ChaCha20-Poly1305 is not supported", func() {
+			if crypto.SupportsChacha20Poly1305 {
+				Skip("ChaCha20-Poly1305 is supported")
+			}
+			config, err := populateConfig(&Config{AEADPreference: AEADPreferenceChaCha20})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.aeads()).To(BeNil())
+		})
+
+		It("prefers AES-GCM", func() {
+			if !crypto.SupportsChacha20Poly1305 {
+				Skip("ChaCha20-Poly1305 is not supported")
+			}
+			config, err := populateConfig(&Config{AEADPreference: AEADPreferenceAESGCM})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.aeads()).To(Equal([]handshake.Tag{handshake.TagAESG, handshake.TagCC20}))
+		})
+
+		It("prefers ChaCha20-Poly1305", func() {
+			if !crypto.SupportsChacha20Poly1305 {
+				Skip("ChaCha20-Poly1305 is not supported")
+			}
+			config, err := populateConfig(&Config{AEADPreference: AEADPreferenceChaCha20})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.aeads()).To(Equal([]handshake.Tag{handshake.TagCC20, handshake.TagAESG}))
+		})
+
+		It("errors on invalid values", func() {
+			_, err := populateConfig(&Config{AEADPreference: 42})
+			Expect(err).To(MatchError("invalid AEAD preference: 42"))
+		})
+	})
+
+	Context("max server bandwidth", func() {
+		It("doesn't limit the bandwidth by default", func() {
+			config, err := populateConfig(nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.serverPacer).To(BeNil())
+		})
+
+		It("creates a shared pacer", func() {
+			config, err := populateConfig(&Config{MaxServerBandwidth: 10 * congestion.KBytesPerSecond})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.serverPacer).ToNot(BeNil())
+		})
+
+		It("creates a new shared pacer for every server", func() {
+			c := &Config{MaxServerBandwidth: 10 * congestion.KBytesPerSecond}
+			config1, err := populateConfig(c)
+			Expect(err).ToNot(HaveOccurred())
+			config2, err := populateConfig(c)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config1.serverPacer).ToNot(BeIdenticalTo(config2.serverPacer))
+		})
+	})
+
+	Context("keep-alives", func() {
+		It("disables keep-alives by default", func() {
+			config, err := populateConfig(nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.KeepAliveInterval).To(BeZero())
+		})
+
+		It("uses the default min interval", func() {
+			config, err := populateConfig(&Config{KeepAliveInterval: time.Minute})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.MinKeepAliveInterval).To(Equal(protocol.DefaultMinKeepAliveInterval))
+		})
+
+		It("limits the default min interval by the interval", func() {
+			config, err := populateConfig(&Config{KeepAliveInterval: 5 * time.Second})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.MinKeepAliveInterval).To(Equal(5 * time.Second))
+		})
+
+		It("uses the configured values", func() {
+			config, err := populateConfig(&Config{KeepAliveInterval: time.Minute, MinKeepAliveInterval: 20 * time.Second, DiscoverKeepAliveInterval: true})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.KeepAliveInterval).To(Equal(time.Minute))
+			Expect(config.MinKeepAliveInterval).To(Equal(20 * time.Second))
+			Expect(config.DiscoverKeepAliveInterval).To(BeTrue())
+		})
+
+		It("errors when the interval is negative", func() {
+			_, err := populateConfig(&Config{KeepAliveInterval: -time.Second})
+			Expect(err).To(MatchError("invalid keep-alive interval: -1s"))
+		})
+
+		It("errors when discovery is enabled without an interval", func() {
+			_, err := populateConfig(&Config{DiscoverKeepAliveInterval: true})
+			Expect(err).To(MatchError("DiscoverKeepAliveInterval requires a KeepAliveInterval"))
+		})
+
+		It("errors when the min interval is larger than the interval", func() {
+			_, err := populateConfig(&Config{KeepAliveInterval: 10 * time.Second, MinKeepAliveInterval: 20 * time.Second})
+			Expect(err).To(MatchError("invalid min keep-alive interval: 20s (must be at most the keep-alive interval)"))
+		})
+	})
+
+	Context("min and max congestion window", func() {
+		It("uses the defaults", func() {
+			config, err := populateConfig(nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.minCongestionWindowPackets()).To(Equal(protocol.PacketNumber(protocol.DefaultMinCongestionWindow)))
+			Expect(config.maxCongestionWindowPackets()).To(Equal(protocol.PacketNumber(protocol.DefaultMaxCongestionWindow)))
+		})
+
+		It("uses the configured values", func() {
+			config, err := populateConfig(&Config{MinCongestionWindow: 4 * protocol.DefaultTCPMSS, MaxCongestionWindow: 100 * protocol.DefaultTCPMSS})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.minCongestionWindowPackets()).To(Equal(protocol.PacketNumber(4)))
+			Expect(config.maxCongestionWindowPackets()).To(Equal(protocol.PacketNumber(100)))
+		})
+
+		It("errors when the min congestion window is too small", func() {
+			_, err := populateConfig(&Config{MinCongestionWindow: protocol.DefaultTCPMSS})
+			Expect(err).To(MatchError("invalid min congestion window: 1460 bytes (must be at least 2 packets)"))
+		})
+
+		It("errors when the max congestion window is too large", func() {
+			_, err := populateConfig(&Config{MaxCongestionWindow: (protocol.DefaultMaxCongestionWindow + 1) * protocol.DefaultTCPMSS})
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("errors when the min congestion window is larger than the max congestion window", func() {
+			_, err := populateConfig(&Config{MinCongestionWindow: 50 * protocol.DefaultTCPMSS, MaxCongestionWindow: 40 * protocol.DefaultTCPMSS})
+			Expect(err).To(MatchError("invalid congestion window: the min congestion window must not be larger than the max congestion window"))
+		})
+	})
+})
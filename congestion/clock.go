@@ -2,9 +2,20 @@ package congestion
 
 import "time"
 
-// A Clock returns the current time
+// A Clock returns the current time, and creates timers that fire according to it
 type Clock interface {
 	Now() time.Time
+	NewTimer(d time.Duration) Timer
+}
+
+// A Timer sends the current time on its channel once it fires, like a time.Timer
+type Timer interface {
+	// Chan returns the channel the time is sent on
+	Chan() <-chan time.Time
+	// Reset changes the timer to fire after duration d. It returns true if the timer was active.
+	Reset(d time.Duration) bool
+	// Stop prevents the timer from firing. It returns true if the timer was active.
+	Stop() bool
 }
 
 // DefaultClock implements the Clock interface using the Go stdlib clock.
@@ -16,3 +27,16 @@ var _ Clock = DefaultClock{}
 func (DefaultClock) Now() time.Time {
 	return time.Now()
 }
+
+// NewTimer creates a Timer backed by a time.Timer
+func (DefaultClock) NewTimer(d time.Duration) Timer {
+	return &defaultTimer{time.NewTimer(d)}
+}
+
+type defaultTimer struct {
+	*time.Timer
+}
+
+func (t *defaultTimer) Chan() <-chan time.Time {
+	return t.C
+}
@@ -9,7 +9,7 @@ import (
 
 const (
 	maxBurstBytes                                        = 3 * protocol.DefaultTCPMSS
-	defaultMinimumCongestionWindow protocol.PacketNumber = 2
+	defaultMinimumCongestionWindow protocol.PacketNumber = protocol.DefaultMinCongestionWindow
 	renoBeta                       float32               = 0.7 // Reno backoff factor.
 )
 
@@ -61,13 +61,13 @@ type cubicSender struct {
 }
 
 // NewCubicSender makes a new cubic sender
-func NewCubicSender(clock Clock, rttStats *RTTStats, reno bool, initialCongestionWindow, initialMaxCongestionWindow protocol.PacketNumber) SendAlgorithmWithDebugInfo {
+func NewCubicSender(clock Clock, rttStats *RTTStats, reno bool, initialCongestionWindow, minCongestionWindow, initialMaxCongestionWindow protocol.PacketNumber) SendAlgorithmWithDebugInfo {
 	return &cubicSender{
 		rttStats:                   rttStats,
 		initialCongestionWindow:    initialCongestionWindow,
 		initialMaxCongestionWindow: initialMaxCongestionWindow,
 		congestionWindow:           initialCongestionWindow,
-		minCongestionWindow:        defaultMinimumCongestionWindow,
+		minCongestionWindow:        minCongestionWindow,
 		slowstartThreshold:         initialMaxCongestionWindow,
 		maxTCPCongestionWindow:     initialMaxCongestionWindow,
 		numConnections:             defaultNumConnections,
@@ -130,15 +130,15 @@ func (c *cubicSender) SlowstartThreshold() protocol.PacketNumber {
 // latest_rtt sample has been taken, |byte_in_flight| the bytes in flight
 // prior to the congestion event.  |ackedPackets| and |lostPackets| are
 // any packets considered acked or lost as a result of the congestion event.
-func (c *cubicSender) OnCongestionEvent(rttUpdated bool, bytesInFlight protocol.ByteCount, ackedPackets PacketVector, lostPackets PacketVector) {
-	if rttUpdated && c.InSlowStart() && c.hybridSlowStart.ShouldExitSlowStart(c.rttStats.LatestRTT(), c.rttStats.MinRTT(), c.GetCongestionWindow()/protocol.DefaultTCPMSS) {
+func (c *cubicSender) OnCongestionEvent(rttUpdated bool, priorInFlight protocol.ByteCount, ackedPackets PacketVector, lostPackets PacketVector) {
+	if rttUpdated && c.InSlowStart() && c.hybridSlowStart.ShouldExitSlowStart(c.rttStats.LatestRTT(), c.GetCongestionWindow()/protocol.DefaultTCPMSS) {
 		c.ExitSlowstart()
 	}
 	for _, i := range lostPackets {
-		c.onPacketLost(i.Number, i.Length, bytesInFlight)
+		c.onPacketLost(i.Number, i.Length, priorInFlight)
 	}
 	for _, i := range ackedPackets {
-		c.onPacketAcked(i.Number, i.Length, bytesInFlight)
+		c.onPacketAcked(i.Number, i.Length, priorInFlight)
 	}
 }
 
@@ -220,7 +220,10 @@ func (c *cubicSender) maybeIncreaseCwnd(ackedPacketNumber protocol.PacketNumber,
 	}
 	if c.InSlowStart() {
 		// TCP slow start, exponential growth, increase by one for each ACK.
-		c.congestionWindow++
+		// After an RTT increase was detected, HyStart++ slows down the growth.
+		if c.hybridSlowStart.ShouldIncreaseWindow() {
+			c.congestionWindow++
+		}
 		return
 	}
 	if c.reno {
@@ -280,6 +283,13 @@ func (c *cubicSender) OnRetransmissionTimeout(packetsRetransmitted bool) {
 	c.congestionWindow = c.minCongestionWindow
 }
 
+// OnPersistentCongestion is called when all packets sent during multiple RTOs were lost
+func (c *cubicSender) OnPersistentCongestion() {
+	c.hybridSlowStart.Restart()
+	c.cubic.Reset()
+	c.congestionWindow = c.minCongestionWindow
+}
+
 // OnConnectionMigration is called when the connection is migrated (?)
 func (c *cubicSender) OnConnectionMigration() {
 	c.hybridSlowStart.Restart()
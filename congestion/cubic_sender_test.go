@@ -21,6 +21,10 @@ func (c *mockClock) Advance(d time.Duration) {
 	*c = mockClock(time.Time(*c).Add(d))
 }
 
+func (c *mockClock) NewTimer(time.Duration) Timer {
+	panic("not implemented")
+}
+
 const MaxCongestionWindow = protocol.PacketNumber(200)
 
 var _ = Describe("Cubic Sender", func() {
@@ -39,7 +43,7 @@ var _ = Describe("Cubic Sender", func() {
 		ackedPacketNumber = 0
 		clock = mockClock{}
 		rttStats = NewRTTStats()
-		sender = NewCubicSender(&clock, rttStats, true /*reno*/, initialCongestionWindowPackets, MaxCongestionWindow)
+		sender = NewCubicSender(&clock, rttStats, true /*reno*/, initialCongestionWindowPackets, defaultMinimumCongestionWindow, MaxCongestionWindow)
 	})
 
 	SendAvailableSendWindowLen := func(packetLength protocol.ByteCount) int {
@@ -400,6 +404,17 @@ var _ = Describe("Cubic Sender", func() {
 		Expect(sender.GetCongestionWindow()).To(Equal(defaultWindowTCP))
 	})
 
+	It("persistent congestion", func() {
+		SendAvailableSendWindow()
+		LoseNPackets(1)
+		ssthresh := sender.SlowstartThreshold()
+
+		// Expect the window to collapse to the minimum, the slow start threshold is already reduced by the loss.
+		sender.OnPersistentCongestion()
+		Expect(sender.GetCongestionWindow()).To(Equal(protocol.ByteCount(2 * protocol.DefaultTCPMSS)))
+		Expect(sender.SlowstartThreshold()).To(Equal(ssthresh))
+	})
+
 	It("retransmission delay", func() {
 		const kRttMs = 10 * time.Millisecond
 		const kDeviationMs = 3 * time.Millisecond
@@ -430,7 +445,7 @@ var _ = Describe("Cubic Sender", func() {
 	It("slow start max send window", func() {
 		const kMaxCongestionWindowTCP = 50
 		const kNumberOfAcks = 100
-		sender = NewCubicSender(&clock, rttStats, false, initialCongestionWindowPackets, kMaxCongestionWindowTCP)
+		sender = NewCubicSender(&clock, rttStats, false, initialCongestionWindowPackets, defaultMinimumCongestionWindow, kMaxCongestionWindowTCP)
 
 		for i := 0; i < kNumberOfAcks; i++ {
 			// Send our full send window.
@@ -444,7 +459,7 @@ var _ = Describe("Cubic Sender", func() {
 	It("tcp reno max congestion window", func() {
 		const kMaxCongestionWindowTCP = 50
 		const kNumberOfAcks = 1000
-		sender = NewCubicSender(&clock, rttStats, false, initialCongestionWindowPackets, kMaxCongestionWindowTCP)
+		sender = NewCubicSender(&clock, rttStats, false, initialCongestionWindowPackets, defaultMinimumCongestionWindow, kMaxCongestionWindowTCP)
 
 		SendAvailableSendWindow()
 		AckNPackets(2)
@@ -466,7 +481,7 @@ var _ = Describe("Cubic Sender", func() {
 		// Set to 10000 to compensate for small cubic alpha.
 		const kNumberOfAcks = 10000
 
-		sender = NewCubicSender(&clock, rttStats, false, initialCongestionWindowPackets, kMaxCongestionWindowTCP)
+		sender = NewCubicSender(&clock, rttStats, false, initialCongestionWindowPackets, defaultMinimumCongestionWindow, kMaxCongestionWindowTCP)
 
 		SendAvailableSendWindow()
 		AckNPackets(2)
@@ -486,7 +501,7 @@ var _ = Describe("Cubic Sender", func() {
 	It("tcp cubic reset epoch on quiescence", func() {
 		const kMaxCongestionWindow = 50
 		const kMaxCongestionWindowBytes = kMaxCongestionWindow * protocol.DefaultTCPMSS
-		sender = NewCubicSender(&clock, rttStats, false, initialCongestionWindowPackets, kMaxCongestionWindow)
+		sender = NewCubicSender(&clock, rttStats, false, initialCongestionWindowPackets, defaultMinimumCongestionWindow, kMaxCongestionWindow)
 
 		num_sent := SendAvailableSendWindow()
 
@@ -526,7 +541,7 @@ var _ = Describe("Cubic Sender", func() {
 	It("tcp cubic shifted epoch on quiescence", func() {
 		const kMaxCongestionWindow = 50
 		const kMaxCongestionWindowBytes = kMaxCongestionWindow * protocol.DefaultTCPMSS
-		sender = NewCubicSender(&clock, rttStats, false, initialCongestionWindowPackets, kMaxCongestionWindow)
+		sender = NewCubicSender(&clock, rttStats, false, initialCongestionWindowPackets, defaultMinimumCongestionWindow, kMaxCongestionWindow)
 
 		num_sent := SendAvailableSendWindow()
 
@@ -20,22 +20,44 @@ const hybridStartDelayFactorExp = 3 // 2^3 = 8
 const hybridStartDelayMinThresholdUs = int64(4000)
 const hybridStartDelayMaxThresholdUs = int64(16000)
 
-// HybridSlowStart implements the TCP hybrid slow start algorithm
+// In conservative slow start, the congestion window grows by one packet for every hybridStartCSSGrowthDivisor ACKs.
+const hybridStartCSSGrowthDivisor = 4
+
+// Number of rounds spent in conservative slow start before exiting slow start.
+const hybridStartCSSRounds = 5
+
+// HybridSlowStart implements the HyStart++ slow start algorithm.
+// When the RTT increases during slow start, it first enters conservative slow start (CSS),
+// where the congestion window grows more slowly. If the RTT increase was spurious, it returns to slow start.
+// Otherwise, it exits slow start after hybridStartCSSRounds rounds.
 type HybridSlowStart struct {
 	endPacketNumber      protocol.PacketNumber
 	lastSentPacketNumber protocol.PacketNumber
 	started              bool
 	currentMinRTT        time.Duration
+	lastRoundMinRTT      time.Duration
 	rttSampleCount       uint32
 	hystartFound         bool
+
+	inConservativeSlowStart bool
+	cssBaselineMinRTT       time.Duration
+	cssRounds               int
+	cssAckCount             int
 }
 
 // StartReceiveRound is called for the start of each receive round (burst) in the slow start phase.
 func (s *HybridSlowStart) StartReceiveRound(lastSent protocol.PacketNumber) {
 	s.endPacketNumber = lastSent
+	s.lastRoundMinRTT = s.currentMinRTT
 	s.currentMinRTT = 0
 	s.rttSampleCount = 0
 	s.started = true
+	if s.inConservativeSlowStart {
+		s.cssRounds++
+		if s.cssRounds >= hybridStartCSSRounds {
+			s.hystartFound = true
+		}
+	}
 }
 
 // IsEndOfRound returns true if this ack is the last packet number of our current slow start round.
@@ -46,43 +68,66 @@ func (s *HybridSlowStart) IsEndOfRound(ack protocol.PacketNumber) bool {
 // ShouldExitSlowStart should be called on every new ack frame, since a new
 // RTT measurement can be made then.
 // rtt: the RTT for this ack packet.
-// minRTT: is the lowest delay (RTT) we have seen during the session.
 // congestionWindow: the congestion window in packets.
-func (s *HybridSlowStart) ShouldExitSlowStart(latestRTT time.Duration, minRTT time.Duration, congestionWindow protocol.ByteCount) bool {
+func (s *HybridSlowStart) ShouldExitSlowStart(latestRTT time.Duration, congestionWindow protocol.ByteCount) bool {
 	if !s.started {
 		// Time to start the hybrid slow start.
 		s.StartReceiveRound(s.lastSentPacketNumber)
 	}
 	if s.hystartFound {
-		return true
+		return congestionWindow >= hybridStartLowWindow
 	}
-	// Second detection parameter - delay increase detection.
+	// Delay increase detection.
 	// Compare the minimum delay (s.currentMinRTT) of the current
-	// burst of packets relative to the minimum delay during the session.
-	// Note: we only look at the first few(8) packets in each burst, since we
-	// only want to compare the lowest RTT of the burst relative to previous
-	// bursts.
+	// burst of packets relative to the minimum delay of the last burst.
 	s.rttSampleCount++
-	if s.rttSampleCount <= hybridStartMinSamples {
-		if s.currentMinRTT == 0 || s.currentMinRTT > latestRTT {
-			s.currentMinRTT = latestRTT
-		}
+	if s.currentMinRTT == 0 || s.currentMinRTT > latestRTT {
+		s.currentMinRTT = latestRTT
 	}
-	// We only need to check this once per round.
-	if s.rttSampleCount == hybridStartMinSamples {
-		// Divide minRTT by 8 to get a rtt increase threshold for exiting.
-		minRTTincreaseThresholdUs := int64(minRTT / time.Microsecond >> hybridStartDelayFactorExp)
-		// Ensure the rtt threshold is never less than 2ms or more than 16ms.
-		minRTTincreaseThresholdUs = utils.MinInt64(minRTTincreaseThresholdUs, hybridStartDelayMaxThresholdUs)
-		minRTTincreaseThreshold := time.Duration(utils.MaxInt64(minRTTincreaseThresholdUs, hybridStartDelayMinThresholdUs)) * time.Microsecond
-
-		if s.currentMinRTT > (minRTT + minRTTincreaseThreshold) {
-			s.hystartFound = true
+	if s.rttSampleCount < hybridStartMinSamples || s.lastRoundMinRTT == 0 {
+		return false
+	}
+	if s.inConservativeSlowStart {
+		// The RTT increase was spurious. Resume slow start.
+		if s.currentMinRTT < s.cssBaselineMinRTT {
+			s.inConservativeSlowStart = false
+			s.cssRounds = 0
+			s.cssAckCount = 0
 		}
+		return false
 	}
-	// Exit from slow start if the cwnd is greater than 16 and
-	// increasing delay is found.
-	return congestionWindow >= hybridStartLowWindow && s.hystartFound
+	// Divide lastRoundMinRTT by 8 to get a rtt increase threshold for entering conservative slow start.
+	minRTTincreaseThresholdUs := int64(s.lastRoundMinRTT / time.Microsecond >> hybridStartDelayFactorExp)
+	// Ensure the rtt threshold is never less than 4ms or more than 16ms.
+	minRTTincreaseThresholdUs = utils.MinInt64(minRTTincreaseThresholdUs, hybridStartDelayMaxThresholdUs)
+	minRTTincreaseThreshold := time.Duration(utils.MaxInt64(minRTTincreaseThresholdUs, hybridStartDelayMinThresholdUs)) * time.Microsecond
+
+	if s.currentMinRTT >= s.lastRoundMinRTT+minRTTincreaseThreshold {
+		s.inConservativeSlowStart = true
+		s.cssBaselineMinRTT = s.currentMinRTT
+		s.cssRounds = 0
+		s.cssAckCount = 0
+	}
+	return false
+}
+
+// InConservativeSlowStart returns true if an RTT increase was detected, and the congestion window should grow more slowly
+func (s *HybridSlowStart) InConservativeSlowStart() bool {
+	return s.inConservativeSlowStart
+}
+
+// ShouldIncreaseWindow should be called for every acknowledged packet in slow start.
+// In conservative slow start, it only returns true for every hybridStartCSSGrowthDivisor-th packet.
+func (s *HybridSlowStart) ShouldIncreaseWindow() bool {
+	if !s.inConservativeSlowStart {
+		return true
+	}
+	s.cssAckCount++
+	if s.cssAckCount < hybridStartCSSGrowthDivisor {
+		return false
+	}
+	s.cssAckCount = 0
+	return true
 }
 
 // OnPacketSent is called when a packet was sent
@@ -108,4 +153,9 @@ func (s *HybridSlowStart) Started() bool {
 func (s *HybridSlowStart) Restart() {
 	s.started = false
 	s.hystartFound = false
+	s.currentMinRTT = 0
+	s.lastRoundMinRTT = 0
+	s.inConservativeSlowStart = false
+	s.cssRounds = 0
+	s.cssAckCount = 0
 }
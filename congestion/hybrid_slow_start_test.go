@@ -47,29 +47,103 @@ var _ = Describe("Hybrid slow start", func() {
 		Expect(slowStart.IsEndOfRound(packet_number)).To(BeTrue())
 	})
 
-	It("works with delay", func() {
+	Context("HyStart++", func() {
 		rtt := 60 * time.Millisecond
 		// We expect to detect the increase at +1/8 of the RTT; hence at a typical
 		// RTT of 60ms the detection will happen at 67.5 ms.
 		const kHybridStartMinSamples = 8 // Number of acks required to trigger.
 
-		end_packet_number := protocol.PacketNumber(1)
-		end_packet_number++
-		slowStart.StartReceiveRound(end_packet_number)
+		var endPacketNumber protocol.PacketNumber
 
-		// Will not trigger since our lowest RTT in our burst is the same as the long
-		// term RTT provided.
-		for n := 0; n < kHybridStartMinSamples; n++ {
-			Expect(slowStart.ShouldExitSlowStart(rtt+time.Duration(n)*time.Millisecond, rtt, 100)).To(BeFalse())
-		}
-		end_packet_number++
-		slowStart.StartReceiveRound(end_packet_number)
-		for n := 1; n < kHybridStartMinSamples; n++ {
-			Expect(slowStart.ShouldExitSlowStart(rtt+(time.Duration(n)+10)*time.Millisecond, rtt, 100)).To(BeFalse())
+		// runs one round with kHybridStartMinSamples RTT samples, and returns if slow start should be exited
+		runRound := func(minRTT time.Duration) bool {
+			endPacketNumber++
+			slowStart.StartReceiveRound(endPacketNumber)
+			var exit bool
+			for n := 0; n < kHybridStartMinSamples; n++ {
+				exit = slowStart.ShouldExitSlowStart(minRTT+time.Duration(kHybridStartMinSamples-1-n)*time.Millisecond, 100)
+			}
+			return exit
 		}
-		// Expect to trigger since all packets in this burst was above the long term
-		// RTT provided.
-		Expect(slowStart.ShouldExitSlowStart(rtt+10*time.Millisecond, rtt, 100)).To(BeTrue())
-	})
 
+		BeforeEach(func() {
+			endPacketNumber = 1
+		})
+
+		It("doesn't enter conservative slow start if the RTT doesn't increase", func() {
+			for i := 0; i < 10; i++ {
+				Expect(runRound(rtt)).To(BeFalse())
+				Expect(slowStart.InConservativeSlowStart()).To(BeFalse())
+			}
+		})
+
+		It("enters conservative slow start if the RTT increases", func() {
+			Expect(runRound(rtt)).To(BeFalse())
+			// Will not trigger before kHybridStartMinSamples samples were taken.
+			endPacketNumber++
+			slowStart.StartReceiveRound(endPacketNumber)
+			for n := 1; n < kHybridStartMinSamples; n++ {
+				Expect(slowStart.ShouldExitSlowStart(rtt+(time.Duration(n)+10)*time.Millisecond, 100)).To(BeFalse())
+				Expect(slowStart.InConservativeSlowStart()).To(BeFalse())
+			}
+			// Expect to trigger since all packets in this burst was above the RTT of the last round.
+			Expect(slowStart.ShouldExitSlowStart(rtt+10*time.Millisecond, 100)).To(BeFalse())
+			Expect(slowStart.InConservativeSlowStart()).To(BeTrue())
+		})
+
+		It("exits slow start after a number of rounds in conservative slow start", func() {
+			Expect(runRound(rtt)).To(BeFalse())
+			Expect(runRound(rtt + 10*time.Millisecond)).To(BeFalse())
+			Expect(slowStart.InConservativeSlowStart()).To(BeTrue())
+			for i := 1; i < hybridStartCSSRounds; i++ {
+				Expect(runRound(rtt + 10*time.Millisecond)).To(BeFalse())
+			}
+			Expect(runRound(rtt + 10*time.Millisecond)).To(BeTrue())
+		})
+
+		It("doesn't exit slow start for small congestion windows", func() {
+			Expect(runRound(rtt)).To(BeFalse())
+			for i := 0; i < hybridStartCSSRounds; i++ {
+				Expect(runRound(rtt + 10*time.Millisecond)).To(BeFalse())
+			}
+			endPacketNumber++
+			slowStart.StartReceiveRound(endPacketNumber)
+			Expect(slowStart.ShouldExitSlowStart(rtt+10*time.Millisecond, hybridStartLowWindow-1)).To(BeFalse())
+			Expect(slowStart.ShouldExitSlowStart(rtt+10*time.Millisecond, hybridStartLowWindow)).To(BeTrue())
+		})
+
+		It("resumes slow start if the RTT decreases again", func() {
+			Expect(runRound(rtt)).To(BeFalse())
+			Expect(runRound(rtt + 10*time.Millisecond)).To(BeFalse())
+			Expect(slowStart.InConservativeSlowStart()).To(BeTrue())
+			Expect(runRound(rtt)).To(BeFalse())
+			Expect(slowStart.InConservativeSlowStart()).To(BeFalse())
+			for i := 0; i < 2*hybridStartCSSRounds; i++ {
+				Expect(runRound(rtt)).To(BeFalse())
+			}
+		})
+
+		It("slows down the growth of the congestion window in conservative slow start", func() {
+			Expect(slowStart.ShouldIncreaseWindow()).To(BeTrue())
+			Expect(runRound(rtt)).To(BeFalse())
+			Expect(runRound(rtt + 10*time.Millisecond)).To(BeFalse())
+			Expect(slowStart.InConservativeSlowStart()).To(BeTrue())
+			var increases int
+			for i := 0; i < 4*hybridStartCSSGrowthDivisor; i++ {
+				if slowStart.ShouldIncreaseWindow() {
+					increases++
+				}
+			}
+			Expect(increases).To(Equal(4))
+		})
+
+		It("resets the state when restarting", func() {
+			Expect(runRound(rtt)).To(BeFalse())
+			Expect(runRound(rtt + 10*time.Millisecond)).To(BeFalse())
+			Expect(slowStart.InConservativeSlowStart()).To(BeTrue())
+			slowStart.Restart()
+			Expect(slowStart.InConservativeSlowStart()).To(BeFalse())
+			Expect(slowStart.Started()).To(BeFalse())
+		})
+	})
 })
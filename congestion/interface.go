@@ -11,9 +11,13 @@ type SendAlgorithm interface {
 	TimeUntilSend(now time.Time, bytesInFlight protocol.ByteCount) time.Duration
 	OnPacketSent(sentTime time.Time, bytesInFlight protocol.ByteCount, packetNumber protocol.PacketNumber, bytes protocol.ByteCount, isRetransmittable bool) bool
 	GetCongestionWindow() protocol.ByteCount
-	OnCongestionEvent(rttUpdated bool, bytesInFlight protocol.ByteCount, ackedPackets PacketVector, lostPackets PacketVector)
+	InSlowStart() bool
+	GetSlowStartThreshold() protocol.ByteCount
+	BandwidthEstimate() Bandwidth
+	OnCongestionEvent(rttUpdated bool, priorInFlight protocol.ByteCount, ackedPackets PacketVector, lostPackets PacketVector)
 	SetNumEmulatedConnections(n int)
 	OnRetransmissionTimeout(packetsRetransmitted bool)
+	OnPersistentCongestion()
 	OnConnectionMigration()
 	RetransmissionDelay() time.Duration
 
@@ -24,7 +28,6 @@ type SendAlgorithm interface {
 // SendAlgorithmWithDebugInfo adds some debug functions to SendAlgorithm
 type SendAlgorithmWithDebugInfo interface {
 	SendAlgorithm
-	BandwidthEstimate() Bandwidth
 
 	// Stuff only used in testing
 
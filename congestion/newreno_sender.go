@@ -0,0 +1,179 @@
+package congestion
+
+import (
+	"time"
+
+	"github.com/lucas-clemente/quic-go/protocol"
+	"github.com/lucas-clemente/quic-go/utils"
+)
+
+// newRenoSender implements the NewReno congestion control algorithm, as described in RFC 6582.
+// It is a lot more conservative than cubic, and intended as a baseline for experiments.
+type newRenoSender struct {
+	rttStats *RTTStats
+
+	// Congestion window in bytes.
+	congestionWindow protocol.ByteCount
+	// Slow start threshold in bytes.
+	slowStartThreshold protocol.ByteCount
+
+	minCongestionWindow     protocol.ByteCount
+	maxCongestionWindow     protocol.ByteCount
+	initialCongestionWindow protocol.ByteCount
+
+	// Bytes acknowledged since the last increase of the congestion window during congestion avoidance.
+	bytesAckedInCongestionAvoidance protocol.ByteCount
+
+	largestSentPacketNumber  protocol.PacketNumber
+	largestAckedPacketNumber protocol.PacketNumber
+	// Track the largest packet number outstanding when a CWND cutback occurs.
+	// All losses of packets sent before the cutback belong to the same loss event.
+	largestSentAtLastCutback protocol.PacketNumber
+}
+
+// NewNewRenoSender makes a new NewReno sender
+func NewNewRenoSender(rttStats *RTTStats, initialCongestionWindow, minCongestionWindow, initialMaxCongestionWindow protocol.PacketNumber) SendAlgorithm {
+	return &newRenoSender{
+		rttStats:                rttStats,
+		initialCongestionWindow: protocol.ByteCount(initialCongestionWindow) * protocol.DefaultTCPMSS,
+		congestionWindow:        protocol.ByteCount(initialCongestionWindow) * protocol.DefaultTCPMSS,
+		minCongestionWindow:     protocol.ByteCount(minCongestionWindow) * protocol.DefaultTCPMSS,
+		maxCongestionWindow:     protocol.ByteCount(initialMaxCongestionWindow) * protocol.DefaultTCPMSS,
+		slowStartThreshold:      protocol.ByteCount(initialMaxCongestionWindow) * protocol.DefaultTCPMSS,
+	}
+}
+
+func (n *newRenoSender) TimeUntilSend(now time.Time, bytesInFlight protocol.ByteCount) time.Duration {
+	if n.congestionWindow > bytesInFlight {
+		return 0
+	}
+	return utils.InfDuration
+}
+
+func (n *newRenoSender) OnPacketSent(sentTime time.Time, bytesInFlight protocol.ByteCount, packetNumber protocol.PacketNumber, bytes protocol.ByteCount, isRetransmittable bool) bool {
+	// Only update bytesInFlight for data packets.
+	if !isRetransmittable {
+		return false
+	}
+	n.largestSentPacketNumber = packetNumber
+	return true
+}
+
+func (n *newRenoSender) GetCongestionWindow() protocol.ByteCount {
+	return n.congestionWindow
+}
+
+func (n *newRenoSender) GetSlowStartThreshold() protocol.ByteCount {
+	return n.slowStartThreshold
+}
+
+// BandwidthEstimate returns the current bandwidth estimate
+func (n *newRenoSender) BandwidthEstimate() Bandwidth {
+	srtt := n.rttStats.SmoothedRTT()
+	if srtt == 0 {
+		// If we haven't measured an rtt, the bandwidth estimate is unknown.
+		return 0
+	}
+	return BandwidthFromDelta(n.congestionWindow, srtt)
+}
+
+// InSlowStart returns true if the sender is in slow start
+func (n *newRenoSender) InSlowStart() bool {
+	return n.congestionWindow < n.slowStartThreshold
+}
+
+func (n *newRenoSender) inRecovery() bool {
+	return n.largestAckedPacketNumber <= n.largestSentAtLastCutback && n.largestAckedPacketNumber != 0
+}
+
+func (n *newRenoSender) OnCongestionEvent(rttUpdated bool, priorInFlight protocol.ByteCount, ackedPackets PacketVector, lostPackets PacketVector) {
+	for _, p := range lostPackets {
+		n.onPacketLost(p.Number)
+	}
+	for _, p := range ackedPackets {
+		n.onPacketAcked(p.Number, p.Length, priorInFlight)
+	}
+}
+
+func (n *newRenoSender) onPacketAcked(packetNumber protocol.PacketNumber, ackedBytes protocol.ByteCount, priorInFlight protocol.ByteCount) {
+	n.largestAckedPacketNumber = utils.MaxPacketNumber(packetNumber, n.largestAckedPacketNumber)
+	// Don't increase the congestion window during fast recovery.
+	if n.inRecovery() {
+		return
+	}
+	// Don't increase the congestion window if it is not fully utilized.
+	if !n.isCwndLimited(priorInFlight) {
+		return
+	}
+	if n.InSlowStart() {
+		n.congestionWindow = utils.MinByteCount(n.congestionWindow+ackedBytes, n.maxCongestionWindow)
+		return
+	}
+	// Congestion avoidance: increase the congestion window by one packet per round trip.
+	n.bytesAckedInCongestionAvoidance += ackedBytes
+	if n.bytesAckedInCongestionAvoidance >= n.congestionWindow {
+		n.bytesAckedInCongestionAvoidance -= n.congestionWindow
+		n.congestionWindow = utils.MinByteCount(n.congestionWindow+protocol.DefaultTCPMSS, n.maxCongestionWindow)
+	}
+}
+
+func (n *newRenoSender) isCwndLimited(bytesInFlight protocol.ByteCount) bool {
+	if bytesInFlight >= n.congestionWindow {
+		return true
+	}
+	availableBytes := n.congestionWindow - bytesInFlight
+	slowStartLimited := n.InSlowStart() && bytesInFlight > n.congestionWindow/2
+	return slowStartLimited || availableBytes <= maxBurstBytes
+}
+
+func (n *newRenoSender) onPacketLost(packetNumber protocol.PacketNumber) {
+	// Only react to the first loss in a window, all other losses belong to the same loss event.
+	if packetNumber <= n.largestSentAtLastCutback {
+		return
+	}
+	n.congestionWindow = utils.MaxByteCount(n.congestionWindow/2, n.minCongestionWindow)
+	n.slowStartThreshold = n.congestionWindow
+	n.bytesAckedInCongestionAvoidance = 0
+	n.largestSentAtLastCutback = n.largestSentPacketNumber
+}
+
+// SetNumEmulatedConnections is not supported by NewReno
+func (n *newRenoSender) SetNumEmulatedConnections(int) {}
+
+// OnRetransmissionTimeout is called on an retransmission timeout
+func (n *newRenoSender) OnRetransmissionTimeout(packetsRetransmitted bool) {
+	n.largestSentAtLastCutback = 0
+	if !packetsRetransmitted {
+		return
+	}
+	n.slowStartThreshold = n.congestionWindow / 2
+	n.congestionWindow = n.minCongestionWindow
+	n.bytesAckedInCongestionAvoidance = 0
+}
+
+// OnPersistentCongestion is called when all packets sent during multiple RTOs were lost
+func (n *newRenoSender) OnPersistentCongestion() {
+	n.congestionWindow = n.minCongestionWindow
+	n.bytesAckedInCongestionAvoidance = 0
+}
+
+// OnConnectionMigration is called when the connection is migrated
+func (n *newRenoSender) OnConnectionMigration() {
+	n.largestSentPacketNumber = 0
+	n.largestAckedPacketNumber = 0
+	n.largestSentAtLastCutback = 0
+	n.bytesAckedInCongestionAvoidance = 0
+	n.congestionWindow = n.initialCongestionWindow
+	n.slowStartThreshold = n.maxCongestionWindow
+}
+
+// RetransmissionDelay gives the time to retransmission
+func (n *newRenoSender) RetransmissionDelay() time.Duration {
+	if n.rttStats.SmoothedRTT() == 0 {
+		return 0
+	}
+	return n.rttStats.SmoothedRTT() + n.rttStats.MeanDeviation()*4
+}
+
+// SetSlowStartLargeReduction is not supported by NewReno
+func (n *newRenoSender) SetSlowStartLargeReduction(bool) {}
@@ -0,0 +1,172 @@
+package congestion
+
+import (
+	"time"
+
+	"github.com/lucas-clemente/quic-go/protocol"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("NewReno Sender", func() {
+	const initialWindow = 10 * protocol.DefaultTCPMSS
+
+	var (
+		sender            *newRenoSender
+		rttStats          *RTTStats
+		bytesInFlight     protocol.ByteCount
+		packetNumber      protocol.PacketNumber
+		ackedPacketNumber protocol.PacketNumber
+	)
+
+	BeforeEach(func() {
+		bytesInFlight = 0
+		packetNumber = 1
+		ackedPacketNumber = 0
+		rttStats = NewRTTStats()
+		sender = NewNewRenoSender(rttStats, 10, defaultMinimumCongestionWindow, MaxCongestionWindow).(*newRenoSender)
+	})
+
+	sendAvailableSendWindow := func() int {
+		var packetsSent int
+		for sender.TimeUntilSend(time.Now(), bytesInFlight) == 0 {
+			sender.OnPacketSent(time.Now(), bytesInFlight, packetNumber, protocol.DefaultTCPMSS, true)
+			packetNumber++
+			packetsSent++
+			bytesInFlight += protocol.DefaultTCPMSS
+		}
+		return packetsSent
+	}
+
+	ackNPackets := func(n int) {
+		var ackedPackets PacketVector
+		for i := 0; i < n; i++ {
+			ackedPacketNumber++
+			ackedPackets = append(ackedPackets, PacketInfo{Number: ackedPacketNumber, Length: protocol.DefaultTCPMSS})
+		}
+		sender.OnCongestionEvent(true, bytesInFlight, ackedPackets, nil)
+		bytesInFlight -= protocol.ByteCount(n) * protocol.DefaultTCPMSS
+	}
+
+	loseNPackets := func(n int) {
+		var lostPackets PacketVector
+		for i := 0; i < n; i++ {
+			ackedPacketNumber++
+			lostPackets = append(lostPackets, PacketInfo{Number: ackedPacketNumber, Length: protocol.DefaultTCPMSS})
+		}
+		sender.OnCongestionEvent(false, bytesInFlight, nil, lostPackets)
+		bytesInFlight -= protocol.ByteCount(n) * protocol.DefaultTCPMSS
+	}
+
+	It("has the right values at startup", func() {
+		Expect(sender.GetCongestionWindow()).To(Equal(initialWindow))
+		Expect(sender.GetSlowStartThreshold()).To(Equal(protocol.ByteCount(MaxCongestionWindow) * protocol.DefaultTCPMSS))
+		Expect(sender.TimeUntilSend(time.Now(), 0)).To(BeZero())
+		Expect(sender.BandwidthEstimate()).To(BeZero())
+	})
+
+	It("doesn't allow sending when the congestion window is full", func() {
+		Expect(sendAvailableSendWindow()).To(Equal(10))
+		Expect(sender.TimeUntilSend(time.Now(), bytesInFlight)).ToNot(BeZero())
+	})
+
+	It("doesn't count non-retransmittable packets", func() {
+		Expect(sender.OnPacketSent(time.Now(), 0, 1, protocol.DefaultTCPMSS, false)).To(BeFalse())
+		Expect(sender.largestSentPacketNumber).To(BeZero())
+	})
+
+	It("doubles the congestion window every round trip in slow start", func() {
+		for i := 0; i < 3; i++ {
+			n := sendAvailableSendWindow()
+			ackNPackets(n)
+		}
+		Expect(sender.GetCongestionWindow()).To(Equal(8 * initialWindow))
+	})
+
+	It("doesn't grow the congestion window when application limited", func() {
+		for i := 0; i < 20; i++ {
+			sender.OnPacketSent(time.Now(), bytesInFlight, packetNumber, protocol.DefaultTCPMSS, true)
+			packetNumber++
+			bytesInFlight += protocol.DefaultTCPMSS
+			ackNPackets(1)
+		}
+		Expect(sender.GetCongestionWindow()).To(Equal(initialWindow))
+	})
+
+	It("halves the congestion window on loss, once per window", func() {
+		sendAvailableSendWindow()
+		loseNPackets(1)
+		Expect(sender.GetCongestionWindow()).To(Equal(initialWindow / 2))
+		Expect(sender.GetSlowStartThreshold()).To(Equal(initialWindow / 2))
+		Expect(sender.inRecovery()).To(BeFalse()) // nothing acked yet
+		// another loss of a packet sent before the cutback belongs to the same loss event
+		loseNPackets(1)
+		Expect(sender.GetCongestionWindow()).To(Equal(initialWindow / 2))
+	})
+
+	It("doesn't grow the congestion window during recovery", func() {
+		sendAvailableSendWindow()
+		loseNPackets(1)
+		ackNPackets(1)
+		Expect(sender.inRecovery()).To(BeTrue())
+		Expect(sender.GetCongestionWindow()).To(Equal(initialWindow / 2))
+	})
+
+	It("grows the congestion window by one packet per round trip in congestion avoidance", func() {
+		sendAvailableSendWindow()
+		loseNPackets(1)
+		// ack all outstanding packets to exit recovery
+		ackNPackets(int(bytesInFlight / protocol.DefaultTCPMSS))
+		Expect(sender.inRecovery()).To(BeTrue())
+		cwnd := sender.GetCongestionWindow()
+		// the next round trip
+		n := sendAvailableSendWindow()
+		ackNPackets(n)
+		Expect(sender.inRecovery()).To(BeFalse())
+		Expect(sender.GetCongestionWindow()).To(Equal(cwnd + protocol.DefaultTCPMSS))
+	})
+
+	It("doesn't grow the congestion window beyond the maximum", func() {
+		for i := 0; i < 10; i++ {
+			n := sendAvailableSendWindow()
+			ackNPackets(n)
+		}
+		Expect(sender.GetCongestionWindow()).To(Equal(protocol.ByteCount(MaxCongestionWindow) * protocol.DefaultTCPMSS))
+	})
+
+	It("collapses the congestion window on an RTO", func() {
+		sendAvailableSendWindow()
+		sender.OnRetransmissionTimeout(true)
+		Expect(sender.GetCongestionWindow()).To(Equal(protocol.ByteCount(defaultMinimumCongestionWindow) * protocol.DefaultTCPMSS))
+		Expect(sender.GetSlowStartThreshold()).To(Equal(initialWindow / 2))
+	})
+
+	It("doesn't change the congestion window on an RTO if no packets were retransmitted", func() {
+		sender.OnRetransmissionTimeout(false)
+		Expect(sender.GetCongestionWindow()).To(Equal(initialWindow))
+	})
+
+	It("collapses the congestion window on persistent congestion", func() {
+		sendAvailableSendWindow()
+		loseNPackets(1)
+		ssthresh := sender.GetSlowStartThreshold()
+		sender.OnPersistentCongestion()
+		Expect(sender.GetCongestionWindow()).To(Equal(protocol.ByteCount(defaultMinimumCongestionWindow) * protocol.DefaultTCPMSS))
+		Expect(sender.GetSlowStartThreshold()).To(Equal(ssthresh))
+	})
+
+	It("resets on connection migration", func() {
+		sendAvailableSendWindow()
+		loseNPackets(1)
+		sender.OnConnectionMigration()
+		Expect(sender.GetCongestionWindow()).To(Equal(initialWindow))
+		Expect(sender.GetSlowStartThreshold()).To(Equal(protocol.ByteCount(MaxCongestionWindow) * protocol.DefaultTCPMSS))
+		Expect(sender.largestSentAtLastCutback).To(BeZero())
+	})
+
+	It("calculates the retransmission delay", func() {
+		Expect(sender.RetransmissionDelay()).To(BeZero())
+		rttStats.UpdateRTT(100*time.Millisecond, 0, time.Now())
+		Expect(sender.RetransmissionDelay()).To(Equal(rttStats.SmoothedRTT() + 4*rttStats.MeanDeviation()))
+	})
+})
@@ -0,0 +1,66 @@
+package congestion
+
+import (
+	"time"
+
+	"github.com/lucas-clemente/quic-go/protocol"
+	"github.com/lucas-clemente/quic-go/utils"
+)
+
+// A Pacer spreads out packets over time, instead of sending a whole congestion window in one burst.
+// It is a token bucket: the budget grows with the pacing rate, up to the max burst size.
+type Pacer struct {
+	getPacingRate func() Bandwidth
+	maxBurstSize  protocol.ByteCount
+
+	budgetAtLastSent protocol.ByteCount
+	lastSentTime     time.Time
+}
+
+// NewPacer creates a new Pacer. If getPacingRate returns 0, packets are not paced.
+func NewPacer(getPacingRate func() Bandwidth, maxBurstSize protocol.ByteCount) *Pacer {
+	return &Pacer{
+		getPacingRate:    getPacingRate,
+		maxBurstSize:     maxBurstSize,
+		budgetAtLastSent: maxBurstSize,
+	}
+}
+
+// SentPacket is called for every packet sent
+func (p *Pacer) SentPacket(sendTime time.Time, size protocol.ByteCount) {
+	budget := p.Budget(sendTime)
+	if size > budget {
+		p.budgetAtLastSent = 0
+	} else {
+		p.budgetAtLastSent = budget - size
+	}
+	p.lastSentTime = sendTime
+}
+
+// Budget returns the number of bytes that may be sent at time now
+func (p *Pacer) Budget(now time.Time) protocol.ByteCount {
+	rate := p.getPacingRate()
+	if rate == 0 || p.lastSentTime.IsZero() {
+		return p.maxBurstSize
+	}
+	newBudget := float64(rate) / float64(BytesPerSecond) * now.Sub(p.lastSentTime).Seconds()
+	if newBudget >= float64(p.maxBurstSize) {
+		return p.maxBurstSize
+	}
+	return utils.MinByteCount(p.maxBurstSize, p.budgetAtLastSent+protocol.ByteCount(newBudget))
+}
+
+// TimeUntilSend returns when the next packet may be sent.
+// It returns the zero time if the budget allows sending a full packet right away.
+func (p *Pacer) TimeUntilSend() time.Time {
+	if p.budgetAtLastSent >= protocol.MaxPacketSize {
+		return time.Time{}
+	}
+	rate := p.getPacingRate()
+	if rate == 0 {
+		return time.Time{}
+	}
+	missing := float64(protocol.MaxPacketSize - p.budgetAtLastSent)
+	delay := time.Duration(missing / (float64(rate) / float64(BytesPerSecond)) * float64(time.Second))
+	return p.lastSentTime.Add(delay)
+}
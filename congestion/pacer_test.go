@@ -0,0 +1,67 @@
+package congestion
+
+import (
+	"time"
+
+	"github.com/lucas-clemente/quic-go/protocol"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Pacer", func() {
+	const burstSize = 10 * protocol.MaxPacketSize
+
+	var (
+		pacer *Pacer
+		rate  Bandwidth
+	)
+
+	BeforeEach(func() {
+		rate = 1000 * KBytesPerSecond
+		pacer = NewPacer(func() Bandwidth { return rate }, burstSize)
+	})
+
+	It("allows a burst at the beginning", func() {
+		Expect(pacer.Budget(time.Now())).To(Equal(burstSize))
+		Expect(pacer.TimeUntilSend()).To(BeZero())
+	})
+
+	It("reduces the budget when sending packets", func() {
+		now := time.Now()
+		pacer.SentPacket(now, 1000)
+		Expect(pacer.Budget(now)).To(Equal(burstSize - 1000))
+	})
+
+	It("paces packets after the burst was sent", func() {
+		now := time.Now()
+		for i := 0; i < 10; i++ {
+			Expect(pacer.TimeUntilSend()).To(BeZero())
+			pacer.SentPacket(now, protocol.MaxPacketSize)
+		}
+		Expect(pacer.Budget(now)).To(BeZero())
+		// at 1 MB/s, it takes 1.35 ms until the budget allows sending a 1350 byte packet
+		Expect(pacer.TimeUntilSend()).To(Equal(now.Add(1350 * time.Microsecond)))
+	})
+
+	It("increases the budget over time", func() {
+		now := time.Now()
+		pacer.SentPacket(now, burstSize)
+		Expect(pacer.Budget(now.Add(time.Millisecond))).To(Equal(protocol.ByteCount(1000)))
+	})
+
+	It("doesn't increase the budget beyond the burst size", func() {
+		now := time.Now()
+		pacer.SentPacket(now, burstSize)
+		Expect(pacer.Budget(now.Add(time.Hour))).To(Equal(burstSize))
+	})
+
+	It("doesn't pace if the rate is unknown", func() {
+		rate = 0
+		now := time.Now()
+		for i := 0; i < 20; i++ {
+			pacer.SentPacket(now, protocol.MaxPacketSize)
+		}
+		Expect(pacer.TimeUntilSend()).To(BeZero())
+		Expect(pacer.Budget(now)).To(Equal(burstSize))
+	})
+})
@@ -167,7 +167,19 @@ func (r *RTTStats) OnConnectionMigration() {
 	r.meanDeviation = 0
 	r.initialRTTus = initialRTTus
 	r.numMinRTTsamplesRemaining = 0
-	r.recentMinRTTwindow = utils.InfDuration
+	// the recentMinRTTwindow is configuration, not a measurement, so it is not reset
+	r.recentMinRTT = rttSample{}
+	r.halfWindowRTT = rttSample{}
+	r.quarterWindowRTT = rttSample{}
+}
+
+// OnPersistentCongestion is called when persistent congestion was detected.
+// The path might have changed significantly, so the RTT estimation is restarted from the latest sample.
+func (r *RTTStats) OnPersistentCongestion() {
+	r.minRTT = r.latestRTT
+	r.smoothedRTT = r.latestRTT
+	r.meanDeviation = r.latestRTT / 2
+	r.numMinRTTsamplesRemaining = 0
 	r.recentMinRTT = rttSample{}
 	r.halfWindowRTT = rttSample{}
 	r.quarterWindowRTT = rttSample{}
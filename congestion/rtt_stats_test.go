@@ -211,4 +211,21 @@ var _ = Describe("RTT stats", func() {
 		Expect(rttStats.RecentMinRTT()).To(Equal(time.Duration(0)))
 	})
 
+	It("restarts the RTT estimation on persistent congestion", func() {
+		rttStats.UpdateRTT(100*time.Millisecond, 0, time.Time{})
+		rttStats.UpdateRTT(300*time.Millisecond, 0, time.Time{})
+		rttStats.OnPersistentCongestion()
+		Expect(rttStats.LatestRTT()).To(Equal(300 * time.Millisecond))
+		Expect(rttStats.MinRTT()).To(Equal(300 * time.Millisecond))
+		Expect(rttStats.SmoothedRTT()).To(Equal(300 * time.Millisecond))
+		Expect(rttStats.MeanDeviation()).To(Equal(150 * time.Millisecond))
+		Expect(rttStats.RecentMinRTT()).To(BeZero())
+	})
+
+	It("keeps the recent min RTT window after a connection migration", func() {
+		rttStats.SetRecentMinRTTwindow(99 * time.Millisecond)
+		rttStats.OnConnectionMigration()
+		Expect(rttStats.recentMinRTTwindow).To(Equal(99 * time.Millisecond))
+	})
+
 })
@@ -0,0 +1,35 @@
+package congestion
+
+import (
+	"sync"
+	"time"
+
+	"github.com/lucas-clemente/quic-go/protocol"
+)
+
+// A SharedPacer limits the combined sending rate of multiple connections, e.g. of all sessions of a server.
+// Every connection consults it in addition to its own Pacer. It is safe for concurrent use.
+type SharedPacer struct {
+	mutex sync.Mutex
+	pacer *Pacer
+}
+
+// NewSharedPacer creates a new SharedPacer that allows sending at rate, with bursts of up to maxBurstSize bytes
+func NewSharedPacer(rate Bandwidth, maxBurstSize protocol.ByteCount) *SharedPacer {
+	return &SharedPacer{pacer: NewPacer(func() Bandwidth { return rate }, maxBurstSize)}
+}
+
+// SentPacket is called for every packet sent by any of the connections
+func (p *SharedPacer) SentPacket(sendTime time.Time, size protocol.ByteCount) {
+	p.mutex.Lock()
+	p.pacer.SentPacket(sendTime, size)
+	p.mutex.Unlock()
+}
+
+// TimeUntilSend returns when the next packet may be sent.
+// It returns the zero time if the budget allows sending a full packet right away.
+func (p *SharedPacer) TimeUntilSend() time.Time {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.pacer.TimeUntilSend()
+}
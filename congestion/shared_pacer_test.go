@@ -0,0 +1,50 @@
+package congestion
+
+import (
+	"sync"
+	"time"
+
+	"github.com/lucas-clemente/quic-go/protocol"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Shared Pacer", func() {
+	const burstSize = 10 * protocol.MaxPacketSize
+
+	var pacer *SharedPacer
+
+	BeforeEach(func() {
+		pacer = NewSharedPacer(1000*KBytesPerSecond, burstSize)
+	})
+
+	It("allows a burst at the beginning", func() {
+		Expect(pacer.TimeUntilSend()).To(BeZero())
+	})
+
+	It("paces packets after the burst was sent", func() {
+		now := time.Now()
+		for i := 0; i < 10; i++ {
+			Expect(pacer.TimeUntilSend()).To(BeZero())
+			pacer.SentPacket(now, protocol.MaxPacketSize)
+		}
+		// sending a full packet at 1000 kB/s takes 1.35ms
+		Expect(pacer.TimeUntilSend()).To(Equal(now.Add(1350 * time.Microsecond)))
+	})
+
+	It("is used by multiple connections concurrently", func() {
+		now := time.Now()
+		var wg sync.WaitGroup
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				pacer.SentPacket(now, protocol.MaxPacketSize)
+				pacer.SentPacket(now, protocol.MaxPacketSize)
+			}()
+		}
+		wg.Wait()
+		Expect(pacer.TimeUntilSend()).To(Equal(now.Add(1350 * time.Microsecond)))
+	})
+})
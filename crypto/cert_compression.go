@@ -149,8 +149,10 @@ func splitHashes(hashes []byte) ([]uint64, error) {
 	return res, nil
 }
 
+// hashCert calculates the hash of a certificate, as used in the CCRT tag and for cached certificate entries.
+// Chrome uses the 64 bit FNV-1a hash.
 func hashCert(cert []byte) uint64 {
-	h := fnv.New64()
+	h := fnv.New64a()
 	h.Write(cert)
 	return h.Sum64()
 }
@@ -13,7 +13,7 @@ import (
 )
 
 func byteHash(d []byte) []byte {
-	h := fnv.New64()
+	h := fnv.New64a()
 	h.Write(d)
 	s := h.Sum64()
 	res := make([]byte, 8)
@@ -153,6 +153,10 @@ var _ = Describe("Cert compression", func() {
 		Expect(compressed).To(Equal(expected))
 	})
 
+	It("hashes certificates with FNV-1a, like Chrome does for cached certificates", func() {
+		Expect(hashCert([]byte("a"))).To(Equal(uint64(0xaf63dc4c8601ec8c)))
+	})
+
 	It("rejects invalid CCS / CCRT hashes", func() {
 		cert := []byte{0xde, 0xca, 0xfb, 0xad}
 		chain := [][]byte{cert}
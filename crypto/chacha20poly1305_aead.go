@@ -1,16 +1,27 @@
-// +build ignore
+// +build go1.18
 
 package crypto
 
 import (
 	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
 	"errors"
 
-	"github.com/aead/chacha20"
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/poly1305"
 
 	"github.com/lucas-clemente/quic-go/protocol"
 )
 
+const chacha20Poly1305TagSize = 12
+
+var errChacha20Poly1305Auth = errors.New("chacha20poly1305: message authentication failed")
+
+// SupportsChacha20Poly1305 says if NewAEADChacha20Poly1305 is available.
+// It requires golang.org/x/crypto/chacha20 and golang.org/x/crypto/poly1305, which need Go 1.18.
+const SupportsChacha20Poly1305 = true
+
 type aeadChacha20Poly1305 struct {
 	otherIV   []byte
 	myIV      []byte
@@ -18,24 +29,19 @@ type aeadChacha20Poly1305 struct {
 	decrypter cipher.AEAD
 }
 
-// NewAEADChacha20Poly1305 creates a AEAD using chacha20poly1305
+// NewAEADChacha20Poly1305 creates a AEAD using chacha20poly1305 with 12 bytes tag size
+//
+// golang.org/x/crypto/chacha20poly1305 only supports the full 16 byte tag, so the
+// RFC 7539 construction is assembled from the chacha20 and poly1305 packages,
+// and the tag is truncated.
 func NewAEADChacha20Poly1305(otherKey []byte, myKey []byte, otherIV []byte, myIV []byte) (AEAD, error) {
 	if len(myKey) != 32 || len(otherKey) != 32 || len(myIV) != 4 || len(otherIV) != 4 {
 		return nil, errors.New("chacha20poly1305: expected 32-byte keys and 4-byte IVs")
 	}
-	// copy because ChaCha20Poly1305 expects array pointers
-	var MyKey, OtherKey [32]byte
-	copy(MyKey[:], myKey)
-	copy(OtherKey[:], otherKey)
-
-	encrypter, err := chacha20.NewChaCha20Poly1305WithTagSize(&MyKey, 12)
-	if err != nil {
-		return nil, err
-	}
-	decrypter, err := chacha20.NewChaCha20Poly1305WithTagSize(&OtherKey, 12)
-	if err != nil {
-		return nil, err
-	}
+	encrypter := &chacha20Poly1305{}
+	copy(encrypter.key[:], myKey)
+	decrypter := &chacha20Poly1305{}
+	copy(decrypter.key[:], otherKey)
 	return &aeadChacha20Poly1305{
 		otherIV:   otherIV,
 		myIV:      myIV,
@@ -51,3 +57,86 @@ func (aead *aeadChacha20Poly1305) Open(dst, src []byte, packetNumber protocol.Pa
 func (aead *aeadChacha20Poly1305) Seal(dst, src []byte, packetNumber protocol.PacketNumber, associatedData []byte) []byte {
 	return aead.encrypter.Seal(dst, makeNonce(aead.myIV, packetNumber), src, associatedData)
 }
+
+// chacha20Poly1305 implements the RFC 7539 AEAD as a cipher.AEAD with a truncated tag
+type chacha20Poly1305 struct {
+	key [chacha20.KeySize]byte
+}
+
+var _ cipher.AEAD = &chacha20Poly1305{}
+
+func (c *chacha20Poly1305) NonceSize() int { return chacha20.NonceSize }
+func (c *chacha20Poly1305) Overhead() int  { return chacha20Poly1305TagSize }
+
+func (c *chacha20Poly1305) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	s, polyKey := c.newCipher(nonce)
+	ret, out := sliceForAppend(dst, len(plaintext)+chacha20Poly1305TagSize)
+	ciphertext := out[:len(plaintext)]
+	s.XORKeyStream(ciphertext, plaintext)
+	tag := computeChacha20Poly1305Tag(polyKey, ciphertext, additionalData)
+	copy(out[len(plaintext):], tag[:chacha20Poly1305TagSize])
+	return ret
+}
+
+func (c *chacha20Poly1305) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(ciphertext) < chacha20Poly1305TagSize {
+		return nil, errChacha20Poly1305Auth
+	}
+	tagStart := len(ciphertext) - chacha20Poly1305TagSize
+	s, polyKey := c.newCipher(nonce)
+	tag := computeChacha20Poly1305Tag(polyKey, ciphertext[:tagStart], additionalData)
+	if subtle.ConstantTimeCompare(tag[:chacha20Poly1305TagSize], ciphertext[tagStart:]) != 1 {
+		return nil, errChacha20Poly1305Auth
+	}
+	ret, out := sliceForAppend(dst, tagStart)
+	s.XORKeyStream(out, ciphertext[:tagStart])
+	return ret, nil
+}
+
+// newCipher returns the keystream positioned at block 1, and the one-time poly1305 key taken from block 0
+func (c *chacha20Poly1305) newCipher(nonce []byte) (*chacha20.Cipher, *[32]byte) {
+	if len(nonce) != chacha20.NonceSize {
+		panic("chacha20poly1305: bad nonce length passed to Open or Seal")
+	}
+	s, err := chacha20.NewUnauthenticatedCipher(c.key[:], nonce)
+	if err != nil {
+		panic(err)
+	}
+	var polyKey [32]byte
+	s.XORKeyStream(polyKey[:], polyKey[:])
+	s.SetCounter(1)
+	return s, &polyKey
+}
+
+func computeChacha20Poly1305Tag(polyKey *[32]byte, ciphertext, additionalData []byte) [poly1305.TagSize]byte {
+	var padding [16]byte
+	mac := poly1305.New(polyKey)
+	mac.Write(additionalData)
+	if r := len(additionalData) % 16; r != 0 {
+		mac.Write(padding[:16-r])
+	}
+	mac.Write(ciphertext)
+	if r := len(ciphertext) % 16; r != 0 {
+		mac.Write(padding[:16-r])
+	}
+	var lengths [16]byte
+	binary.LittleEndian.PutUint64(lengths[:8], uint64(len(additionalData)))
+	binary.LittleEndian.PutUint64(lengths[8:], uint64(len(ciphertext)))
+	mac.Write(lengths[:])
+	var tag [poly1305.TagSize]byte
+	mac.Sum(tag[:0])
+	return tag
+}
+
+// sliceForAppend extends in by n bytes, reusing its capacity if possible.
+// head is the extended slice, tail the n newly appended bytes.
+func sliceForAppend(in []byte, n int) (head, tail []byte) {
+	if total := len(in) + n; cap(in) >= total {
+		head = in[:total]
+	} else {
+		head = make([]byte, total)
+		copy(head, in)
+	}
+	tail = head[len(in):]
+	return
+}
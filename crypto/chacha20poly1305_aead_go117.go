@@ -0,0 +1,14 @@
+// +build !go1.18
+
+package crypto
+
+import "errors"
+
+// SupportsChacha20Poly1305 says if NewAEADChacha20Poly1305 is available.
+// It requires golang.org/x/crypto/chacha20 and golang.org/x/crypto/poly1305, which need Go 1.18.
+const SupportsChacha20Poly1305 = false
+
+// NewAEADChacha20Poly1305 always fails, since ChaCha20-Poly1305 is not supported before Go 1.18
+func NewAEADChacha20Poly1305(otherKey []byte, myKey []byte, otherIV []byte, myIV []byte) (AEAD, error) {
+	return nil, errors.New("chacha20poly1305: requires Go 1.18")
+}
@@ -1,9 +1,10 @@
-// +build ignore
+// +build go1.18
 
 package crypto
 
 import (
 	"crypto/rand"
+	"encoding/hex"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -56,6 +57,41 @@ var _ = Describe("Chacha20poly1305", func() {
 		Expect(err).To(HaveOccurred())
 	})
 
+	It("fails with a modified ciphertext", func() {
+		b := alice.Seal(nil, []byte("foobar"), 42, []byte("aad"))
+		b[0] ^= 0xff
+		_, err := bob.Open(nil, b, 42, []byte("aad"))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("fails with a too short ciphertext", func() {
+		_, err := bob.Open(nil, make([]byte, 11), 42, []byte("aad"))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("seals and opens in place", func() {
+		src := make([]byte, 6, 6+12)
+		copy(src, "foobar")
+		b := alice.Seal(src[:0], src, 42, []byte("aad"))
+		text, err := bob.Open(b[:0], b, 42, []byte("aad"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(text).To(Equal([]byte("foobar")))
+	})
+
+	It("matches the RFC 7539 test vector, with the tag truncated to 12 bytes", func() {
+		key, _ := hex.DecodeString("808182838485868788898a8b8c8d8e8f909192939495969798999a9b9c9d9e9f")
+		nonce, _ := hex.DecodeString("070000004041424344454647")
+		aad, _ := hex.DecodeString("50515253c0c1c2c3c4c5c6c7")
+		plaintext := []byte("Ladies and Gentlemen of the class of '99: If I could offer you only one tip for the future, sunscreen would be it.")
+		expected, _ := hex.DecodeString("d31a8d34648e60db7b86afbc53ef7ec2a4aded51296e08fea9e2b5a736ee62d63dbea45e8ca9671282fafb69da92728b1a71de0a9e060b2905d6a5b67ecd3b3692ddbd7f2d778b8c9803aee328091b58fab324e4fad675945585808b4831d7bc3ff4def08e4b7a9de576d26586cec64b6116" + "1ae10b594f09e26a7e902ecb")
+		c := &chacha20Poly1305{}
+		copy(c.key[:], key)
+		Expect(c.Seal(nil, nonce, plaintext, aad)).To(Equal(expected))
+		text, err := c.Open(nil, nonce, expected, aad)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(text).To(Equal(plaintext))
+	})
+
 	It("rejects wrong key and iv sizes", func() {
 		var err error
 		e := "chacha20poly1305: expected 32-byte keys and 4-byte IVs"
@@ -0,0 +1,12 @@
+// +build go1.18
+
+package crypto
+
+import "golang.org/x/sys/cpu"
+
+// HasAESHardwareSupport says if the CPU has instructions for AES and the GHASH multiplication.
+// Without them, AES-GCM is implemented in software, which is much slower than ChaCha20-Poly1305.
+var HasAESHardwareSupport = (cpu.X86.HasAES && cpu.X86.HasPCLMULQDQ) ||
+	(cpu.ARM64.HasAES && cpu.ARM64.HasPMULL) ||
+	(cpu.ARM.HasAES && cpu.ARM.HasPMULL) ||
+	(cpu.S390X.HasAES && cpu.S390X.HasGHASH)
@@ -0,0 +1,7 @@
+// +build !go1.18
+
+package crypto
+
+// HasAESHardwareSupport says if the CPU has instructions for AES and the GHASH multiplication.
+// Detecting them requires golang.org/x/sys/cpu, which needs Go 1.18. Before that, AES-GCM is the only AEAD anyway.
+var HasAESHardwareSupport = true
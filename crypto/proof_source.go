@@ -41,7 +41,8 @@ func (ps *proofSource) SignServerProof(sni string, chlo []byte, serverConfigData
 
 	opts := crypto.SignerOpts(crypto.SHA256)
 
-	if _, ok = key.(*rsa.PrivateKey); ok {
+	// The key doesn't need to be an *rsa.PrivateKey, it might also be held by an HSM or a key management service.
+	if _, ok = key.Public().(*rsa.PublicKey); ok {
 		opts = &rsa.PSSOptions{SaltLength: 32, Hash: crypto.SHA256}
 	}
 
@@ -19,6 +19,11 @@ import (
 	. "github.com/onsi/gomega"
 )
 
+// opaqueSigner hides the type of the private key, like a crypto.Signer backed by an HSM
+type opaqueSigner struct {
+	crypto.Signer
+}
+
 type ecdsaSignature struct {
 	R, S *big.Int
 }
@@ -62,6 +67,25 @@ var _ = Describe("ProofRsa", func() {
 		})
 	})
 
+	Context("when using a crypto.Signer", func() {
+		It("gives valid RSA signatures", func() {
+			key := testdata.GetTLSConfig().Certificates[0].PrivateKey.(*rsa.PrivateKey)
+			config := &tls.Config{
+				Certificates: []tls.Certificate{{
+					Certificate: testdata.GetTLSConfig().Certificates[0].Certificate,
+					PrivateKey:  &opaqueSigner{Signer: key},
+				}},
+			}
+			kd, err := NewProofSource(config)
+			Expect(err).ToNot(HaveOccurred())
+			signature, err := kd.SignServerProof("", []byte{'C', 'H', 'L', 'O'}, []byte{'S', 'C', 'F', 'G'})
+			Expect(err).ToNot(HaveOccurred())
+			data := []byte("W\xA6\xFC\xDE\xC7\xD2>c\xE6\xB5\xF6\tq\x9E|<~1\xA33\x01\xCA=\x19\xBD\xC1\xE4\xB0\xBA\x9B\x16%")
+			err = rsa.VerifyPSS(&key.PublicKey, crypto.SHA256, data, signature, &rsa.PSSOptions{SaltLength: 32})
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+
 	Context("when using ECDSA", func() {
 		var (
 			key    crypto.Signer
@@ -11,6 +11,7 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"sync"
 	"time"
 
 	"github.com/lucas-clemente/quic-go/protocol"
@@ -24,6 +25,9 @@ type StkSource interface {
 	NewToken(ip net.IP) ([]byte, error)
 	// VerifyToken verifies if a token matches a given IP address and is not outdated
 	VerifyToken(ip net.IP, data []byte) error
+	// Rotate makes secret the secret used for new tokens.
+	// Tokens created with the previous secret are still accepted, tokens created with older secrets are not.
+	Rotate(secret []byte) error
 }
 
 type sourceAddressToken struct {
@@ -50,7 +54,9 @@ func parseToken(data []byte) (*sourceAddressToken, error) {
 }
 
 type stkSource struct {
-	aead cipher.AEAD
+	// the first AEAD is used to create new tokens, all of them are used to verify tokens
+	aeads []cipher.AEAD
+	mutex sync.RWMutex
 }
 
 const stkKeySize = 16
@@ -59,8 +65,21 @@ const stkKeySize = 16
 // at 16 :)
 const stkNonceSize = 16
 
-// NewStkSource creates a source for source address tokens
-func NewStkSource(secret []byte) (StkSource, error) {
+// NewStkSource creates a source for source address tokens.
+// New tokens are created using secret. Tokens created using any of the previousSecrets are still accepted.
+func NewStkSource(secret []byte, previousSecrets ...[]byte) (StkSource, error) {
+	aeads := make([]cipher.AEAD, 0, 1+len(previousSecrets))
+	for _, s := range append([][]byte{secret}, previousSecrets...) {
+		aead, err := newStkAEAD(s)
+		if err != nil {
+			return nil, err
+		}
+		aeads = append(aeads, aead)
+	}
+	return &stkSource{aeads: aeads}, nil
+}
+
+func newStkAEAD(secret []byte) (cipher.AEAD, error) {
 	key, err := deriveKey(secret)
 	if err != nil {
 		return nil, err
@@ -69,27 +88,37 @@ func NewStkSource(secret []byte) (StkSource, error) {
 	if err != nil {
 		return nil, err
 	}
-	aead, err := cipher.NewGCMWithNonceSize(c, stkNonceSize)
-	if err != nil {
-		return nil, err
-	}
-	return &stkSource{aead: aead}, nil
+	return cipher.NewGCMWithNonceSize(c, stkNonceSize)
 }
 
 func (s *stkSource) NewToken(ip net.IP) ([]byte, error) {
-	return encryptToken(s.aead, &sourceAddressToken{
+	s.mutex.RLock()
+	aead := s.aeads[0]
+	s.mutex.RUnlock()
+	return encryptToken(aead, &sourceAddressToken{
 		ip:        ip,
 		timestamp: uint64(time.Now().Unix()),
 	})
 }
 
+func (s *stkSource) Rotate(secret []byte) error {
+	aead, err := newStkAEAD(secret)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	s.aeads = []cipher.AEAD{aead, s.aeads[0]}
+	s.mutex.Unlock()
+	return nil
+}
+
 func (s *stkSource) VerifyToken(ip net.IP, data []byte) error {
 	if len(data) < stkNonceSize {
 		return errors.New("STK too short")
 	}
 	nonce := data[:stkNonceSize]
 
-	res, err := s.aead.Open(nil, nonce, data[stkNonceSize:], nil)
+	res, err := s.open(nonce, data[stkNonceSize:])
 	if err != nil {
 		return err
 	}
@@ -110,6 +139,21 @@ func (s *stkSource) VerifyToken(ip net.IP, data []byte) error {
 	return nil
 }
 
+// open tries to decrypt a token with all AEADs, starting with the current one
+func (s *stkSource) open(nonce, ciphertext []byte) ([]byte, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	var err error
+	for _, aead := range s.aeads {
+		var res []byte
+		res, err = aead.Open(nil, nonce, ciphertext, nil)
+		if err == nil {
+			return res, nil
+		}
+	}
+	return nil, err
+}
+
 func deriveKey(secret []byte) ([]byte, error) {
 	r := hkdf.New(sha256.New, secret, nil, []byte("QUIC source address token key"))
 	key := make([]byte, stkKeySize)
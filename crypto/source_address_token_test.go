@@ -95,7 +95,7 @@ var _ = Describe("Source Address Tokens", func() {
 		})
 
 		It("should reject outdated tokens", func() {
-			stk, err := encryptToken(source.aead, &sourceAddressToken{
+			stk, err := encryptToken(source.aeads[0], &sourceAddressToken{
 				ip:        ip4,
 				timestamp: uint64(time.Now().Unix() - protocol.STKExpiryTimeSec - 1),
 			})
@@ -106,7 +106,7 @@ var _ = Describe("Source Address Tokens", func() {
 
 		It("should reject tokens with wrong IP addresses", func() {
 			otherIP := net.ParseIP("4.3.2.1")
-			stk, err := encryptToken(source.aead, &sourceAddressToken{
+			stk, err := encryptToken(source.aeads[0], &sourceAddressToken{
 				ip:        otherIP,
 				timestamp: uint64(time.Now().Unix()),
 			})
@@ -114,5 +114,50 @@ var _ = Describe("Source Address Tokens", func() {
 			err = source.VerifyToken(ip4, stk)
 			Expect(err).To(MatchError("invalid ip in STK"))
 		})
+
+		Context("key rotation", func() {
+			It("accepts tokens created with previous secrets", func() {
+				oldSource, err := NewStkSource([]byte("OLD SECRET"))
+				Expect(err).NotTo(HaveOccurred())
+				stk, err := oldSource.NewToken(ip4)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(source.VerifyToken(ip4, stk)).To(HaveOccurred())
+				source, err := NewStkSource(secret, []byte("OLD SECRET"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(source.VerifyToken(ip4, stk)).To(Succeed())
+			})
+
+			It("creates new tokens with the current secret", func() {
+				source, err := NewStkSource(secret, []byte("OLD SECRET"))
+				Expect(err).NotTo(HaveOccurred())
+				stk, err := source.NewToken(ip4)
+				Expect(err).NotTo(HaveOccurred())
+				currentSource, err := NewStkSource(secret)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(currentSource.VerifyToken(ip4, stk)).To(Succeed())
+			})
+
+			It("still accepts tokens after rotating the secret once", func() {
+				stk, err := source.NewToken(ip4)
+				Expect(err).NotTo(HaveOccurred())
+				err = source.Rotate([]byte("NEW SECRET"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(source.VerifyToken(ip4, stk)).To(Succeed())
+				newStk, err := source.NewToken(ip4)
+				Expect(err).NotTo(HaveOccurred())
+				newSource, err := NewStkSource([]byte("NEW SECRET"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(newSource.VerifyToken(ip4, newStk)).To(Succeed())
+			})
+
+			It("rejects tokens after rotating the secret twice", func() {
+				stk, err := source.NewToken(ip4)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(source.Rotate([]byte("NEW SECRET"))).To(Succeed())
+				Expect(source.Rotate([]byte("NEWER SECRET"))).To(Succeed())
+				Expect(source.aeads).To(HaveLen(2))
+				Expect(source.VerifyToken(ip4, stk)).To(HaveOccurred())
+			})
+		})
 	})
 })
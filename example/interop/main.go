@@ -0,0 +1,76 @@
+// The interop server serves files from a directory over QUIC, for testing against other QUIC implementations.
+// Every request is logged together with the address of the connection it was received on.
+package main
+
+import (
+	"flag"
+	"net/http"
+	"path"
+	"runtime"
+	"time"
+
+	"github.com/lucas-clemente/quic-go/h2quic"
+	"github.com/lucas-clemente/quic-go/utils"
+)
+
+// loggingResponseWriter records the status code and the number of bytes of a response
+type loggingResponseWriter struct {
+	http.ResponseWriter
+
+	status int
+	bytes  int
+}
+
+func (w *loggingResponseWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *loggingResponseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += n
+	return n, err
+}
+
+func logRequests(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		utils.Infof("%s: %s %s", r.RemoteAddr, r.Method, r.URL.Path)
+		lw := &loggingResponseWriter{ResponseWriter: w}
+		handler.ServeHTTP(lw, r)
+		utils.Infof("%s: %s %s: status %d, %d bytes in %s", r.RemoteAddr, r.Method, r.URL.Path, lw.status, lw.bytes, time.Since(start))
+	})
+}
+
+func getCertDir() string {
+	_, filename, _, ok := runtime.Caller(0)
+	if !ok {
+		panic("Failed to get current frame")
+	}
+
+	return path.Dir(path.Dir(filename))
+}
+
+func main() {
+	verbose := flag.Bool("v", false, "verbose, log every packet of every connection")
+	bind := flag.String("bind", "0.0.0.0:6121", "address to listen on")
+	certPath := flag.String("certpath", getCertDir(), "certificate directory")
+	www := flag.String("www", "/var/www", "directory to serve files from")
+	flag.Parse()
+
+	if *verbose {
+		utils.SetLogLevel(utils.LogLevelDebug)
+	} else {
+		utils.SetLogLevel(utils.LogLevelInfo)
+	}
+
+	handler := logRequests(http.FileServer(http.Dir(*www)))
+	utils.Infof("Serving %s on %s", *www, *bind)
+	err := h2quic.ListenAndServeQUIC(*bind, *certPath+"/fullchain.pem", *certPath+"/privkey.pem", handler)
+	if err != nil {
+		utils.Errorf("%s", err.Error())
+	}
+}
@@ -11,9 +11,13 @@ import (
 )
 
 type flowControlManager struct {
+	clock                congestion.Clock
 	connectionParameters handshake.ConnectionParametersManager
 	rttStats             *congestion.RTTStats
 
+	windowUpdateThreshold float64
+	windowUpdateRTTs      int
+
 	streamFlowController               map[protocol.StreamID]*flowController
 	contributesToConnectionFlowControl map[protocol.StreamID]bool
 	mutex                              sync.RWMutex
@@ -28,16 +32,24 @@ var (
 
 var errMapAccess = errors.New("Error accessing the flowController map.")
 
-// NewFlowControlManager creates a new flow control manager
-func NewFlowControlManager(connectionParameters handshake.ConnectionParametersManager, rttStats *congestion.RTTStats) FlowControlManager {
+// NewFlowControlManager creates a new flow control manager.
+// A WINDOW_UPDATE is sent once less than windowUpdateThreshold of the window increment is left.
+// If windowUpdateRTTs is not 0, a WINDOW_UPDATE is also sent if data was read and windowUpdateRTTs RTTs passed since the last one.
+// The connection-level receive window is never increased beyond maxReceiveConnectionWindow, which limits the data buffered for all streams together.
+func NewFlowControlManager(clock congestion.Clock, connectionParameters handshake.ConnectionParametersManager, rttStats *congestion.RTTStats, windowUpdateThreshold float64, windowUpdateRTTs int, maxReceiveConnectionWindow protocol.ByteCount) FlowControlManager {
 	fcm := flowControlManager{
+		clock:                              clock,
 		connectionParameters:               connectionParameters,
 		rttStats:                           rttStats,
+		windowUpdateThreshold:              windowUpdateThreshold,
+		windowUpdateRTTs:                   windowUpdateRTTs,
 		streamFlowController:               make(map[protocol.StreamID]*flowController),
 		contributesToConnectionFlowControl: make(map[protocol.StreamID]bool),
 	}
 	// initialize connection level flow controller
-	fcm.streamFlowController[0] = newFlowController(0, connectionParameters, rttStats)
+	connectionFlowController := newFlowController(clock, 0, connectionParameters, rttStats, windowUpdateThreshold, windowUpdateRTTs)
+	connectionFlowController.maxReceiveFlowControlWindowIncrement = maxReceiveConnectionWindow
+	fcm.streamFlowController[0] = connectionFlowController
 	fcm.contributesToConnectionFlowControl[0] = false
 	return &fcm
 }
@@ -51,7 +63,7 @@ func (f *flowControlManager) NewStream(streamID protocol.StreamID, contributesTo
 		return
 	}
 
-	f.streamFlowController[streamID] = newFlowController(streamID, f.connectionParameters, f.rttStats)
+	f.streamFlowController[streamID] = newFlowController(f.clock, streamID, f.connectionParameters, f.rttStats, f.windowUpdateThreshold, f.windowUpdateRTTs)
 	f.contributesToConnectionFlowControl[streamID] = contributesToConnectionFlow
 }
 
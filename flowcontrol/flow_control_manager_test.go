@@ -17,12 +17,18 @@ var _ = Describe("Flow Control Manager", func() {
 			receiveStreamFlowControlWindow:     0x100,
 			receiveConnectionFlowControlWindow: 0x200,
 		}
-		fcm = NewFlowControlManager(cpm, &congestion.RTTStats{}).(*flowControlManager)
+		fcm = NewFlowControlManager(congestion.DefaultClock{}, cpm, &congestion.RTTStats{}, protocol.DefaultWindowUpdateThreshold, 0, protocol.MaxReceiveConnectionFlowControlWindow).(*flowControlManager)
 	})
 
 	It("creates a connection level flow controller", func() {
 		Expect(fcm.streamFlowController).To(HaveKey(protocol.StreamID(0)))
 		Expect(fcm.contributesToConnectionFlowControl).To(HaveKey(protocol.StreamID(0)))
+		Expect(fcm.streamFlowController[0].maxReceiveFlowControlWindowIncrement).To(Equal(protocol.MaxReceiveConnectionFlowControlWindow))
+	})
+
+	It("limits the connection level receive window", func() {
+		fcm = NewFlowControlManager(congestion.DefaultClock{}, cpm, &congestion.RTTStats{}, protocol.DefaultWindowUpdateThreshold, 0, 0x300).(*flowControlManager)
+		Expect(fcm.streamFlowController[0].maxReceiveFlowControlWindowIncrement).To(Equal(protocol.ByteCount(0x300)))
 	})
 
 	Context("creating new streams", func() {
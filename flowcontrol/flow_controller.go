@@ -10,6 +10,7 @@ import (
 )
 
 type flowController struct {
+	clock    congestion.Clock
 	streamID protocol.StreamID
 
 	connectionParameters handshake.ConnectionParametersManager
@@ -18,7 +19,14 @@ type flowController struct {
 	bytesSent             protocol.ByteCount
 	sendFlowControlWindow protocol.ByteCount
 
+	// lastWindowUpdateTime is the time of the last WINDOW_UPDATE sent because the window was used up. It is used to adjust the window increment.
 	lastWindowUpdateTime time.Time
+	// lastWindowUpdateSentTime is the time of the last WINDOW_UPDATE of any kind. It is used to send WINDOW_UPDATEs after windowUpdateRTTs RTTs.
+	lastWindowUpdateSentTime time.Time
+	// a WINDOW_UPDATE is sent once less than windowUpdateThreshold of the window increment is left
+	windowUpdateThreshold float64
+	// if not 0, a WINDOW_UPDATE is also sent if data was read and windowUpdateRTTs RTTs passed since the last one
+	windowUpdateRTTs int
 
 	bytesRead                            protocol.ByteCount
 	highestReceived                      protocol.ByteCount
@@ -28,11 +36,14 @@ type flowController struct {
 }
 
 // newFlowController gets a new flow controller
-func newFlowController(streamID protocol.StreamID, connectionParameters handshake.ConnectionParametersManager, rttStats *congestion.RTTStats) *flowController {
+func newFlowController(clock congestion.Clock, streamID protocol.StreamID, connectionParameters handshake.ConnectionParametersManager, rttStats *congestion.RTTStats, windowUpdateThreshold float64, windowUpdateRTTs int) *flowController {
 	fc := flowController{
-		streamID:             streamID,
-		connectionParameters: connectionParameters,
-		rttStats:             rttStats,
+		clock:                 clock,
+		streamID:              streamID,
+		connectionParameters:  connectionParameters,
+		rttStats:              rttStats,
+		windowUpdateThreshold: windowUpdateThreshold,
+		windowUpdateRTTs:      windowUpdateRTTs,
 	}
 
 	if streamID == 0 {
@@ -111,17 +122,33 @@ func (c *flowController) AddBytesRead(n protocol.ByteCount) {
 func (c *flowController) MaybeTriggerWindowUpdate() (bool, protocol.ByteCount) {
 	diff := c.receiveFlowControlWindow - c.bytesRead
 
-	// Chromium implements the same threshold
-	if diff < (c.receiveFlowControlWindowIncrement / 2) {
+	now := c.clock.Now()
+	if diff < protocol.ByteCount(c.windowUpdateThreshold*float64(c.receiveFlowControlWindowIncrement)) {
 		c.maybeAdjustWindowIncrement()
-		c.lastWindowUpdateTime = time.Now()
+		c.lastWindowUpdateTime = now
+	} else if !c.windowUpdateDue() {
+		return false, 0
+	}
 
-		c.receiveFlowControlWindow = c.bytesRead + c.receiveFlowControlWindowIncrement
+	c.lastWindowUpdateSentTime = now
+	c.receiveFlowControlWindow = c.bytesRead + c.receiveFlowControlWindowIncrement
+	return true, c.receiveFlowControlWindow
+}
 
-		return true, c.receiveFlowControlWindow
+// windowUpdateDue returns true if windowUpdateRTTs RTTs passed since the last WINDOW_UPDATE, and a new one would increase the window
+// These WINDOW_UPDATEs don't increase the window increment, since they are not sent because the window is used up too fast
+func (c *flowController) windowUpdateDue() bool {
+	if c.windowUpdateRTTs == 0 || c.lastWindowUpdateSentTime.IsZero() {
+		return false
 	}
-
-	return false, 0
+	if c.bytesRead+c.receiveFlowControlWindowIncrement <= c.receiveFlowControlWindow {
+		return false
+	}
+	rtt := c.rttStats.SmoothedRTT()
+	if rtt == 0 {
+		return false
+	}
+	return c.clock.Now().Sub(c.lastWindowUpdateSentTime) >= time.Duration(c.windowUpdateRTTs)*rtt
 }
 
 // maybeAdjustWindowIncrement increases the receiveFlowControlWindowIncrement if we're sending WindowUpdates too often
@@ -130,12 +157,13 @@ func (c *flowController) maybeAdjustWindowIncrement() {
 		return
 	}
 
-	rtt := c.rttStats.SmoothedRTT()
+	// the smoothed RTT can be smaller than the min RTT, if the peer reported large ack delays
+	rtt := utils.MaxDuration(c.rttStats.SmoothedRTT(), c.rttStats.RecentMinRTT())
 	if rtt == 0 {
 		return
 	}
 
-	timeSinceLastWindowUpdate := time.Now().Sub(c.lastWindowUpdateTime)
+	timeSinceLastWindowUpdate := c.clock.Now().Sub(c.lastWindowUpdateTime)
 
 	// interval between the window updates is sufficiently large, no need to increase the increment
 	if timeSinceLastWindowUpdate >= 2*rtt {
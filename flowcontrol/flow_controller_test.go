@@ -44,11 +44,16 @@ func (m *mockConnectionParametersManager) TruncateConnectionID() bool { panic("n
 
 var _ handshake.ConnectionParametersManager = &mockConnectionParametersManager{}
 
+type mockClock time.Time
+
+func (c mockClock) Now() time.Time                          { return time.Time(c) }
+func (c mockClock) NewTimer(time.Duration) congestion.Timer { panic("not implemented") }
+
 var _ = Describe("Flow controller", func() {
 	var controller *flowController
 
 	BeforeEach(func() {
-		controller = &flowController{}
+		controller = &flowController{clock: congestion.DefaultClock{}, windowUpdateThreshold: protocol.DefaultWindowUpdateThreshold}
 		controller.rttStats = &congestion.RTTStats{}
 	})
 
@@ -67,26 +72,26 @@ var _ = Describe("Flow controller", func() {
 		})
 
 		It("reads the stream send and receive windows when acting as stream-level flow controller", func() {
-			fc := newFlowController(5, cpm, rttStats)
+			fc := newFlowController(congestion.DefaultClock{}, 5, cpm, rttStats, protocol.DefaultWindowUpdateThreshold, 0)
 			Expect(fc.streamID).To(Equal(protocol.StreamID(5)))
 			Expect(fc.receiveFlowControlWindow).To(Equal(protocol.ByteCount(2000)))
 			Expect(fc.maxReceiveFlowControlWindowIncrement).To(Equal(protocol.MaxReceiveStreamFlowControlWindow))
 		})
 
 		It("reads the stream send and receive windows when acting as connection-level flow controller", func() {
-			fc := newFlowController(0, cpm, rttStats)
+			fc := newFlowController(congestion.DefaultClock{}, 0, cpm, rttStats, protocol.DefaultWindowUpdateThreshold, 0)
 			Expect(fc.streamID).To(Equal(protocol.StreamID(0)))
 			Expect(fc.receiveFlowControlWindow).To(Equal(protocol.ByteCount(4000)))
 			Expect(fc.maxReceiveFlowControlWindowIncrement).To(Equal(protocol.MaxReceiveConnectionFlowControlWindow))
 		})
 
 		It("does not set the stream flow control windows for sending", func() {
-			fc := newFlowController(5, cpm, rttStats)
+			fc := newFlowController(congestion.DefaultClock{}, 5, cpm, rttStats, protocol.DefaultWindowUpdateThreshold, 0)
 			Expect(fc.sendFlowControlWindow).To(BeZero())
 		})
 
 		It("does not set the connection flow control windows for sending", func() {
-			fc := newFlowController(0, cpm, rttStats)
+			fc := newFlowController(congestion.DefaultClock{}, 0, cpm, rttStats, protocol.DefaultWindowUpdateThreshold, 0)
 			Expect(fc.sendFlowControlWindow).To(BeZero())
 		})
 	})
@@ -192,6 +197,16 @@ var _ = Describe("Flow controller", func() {
 			Expect(offset).To(Equal(readPosition + receiveFlowControlWindowIncrement))
 			Expect(controller.receiveFlowControlWindow).To(Equal(readPosition + receiveFlowControlWindowIncrement))
 			Expect(controller.lastWindowUpdateTime).To(BeTemporally("~", time.Now(), 5*time.Millisecond))
+			Expect(controller.lastWindowUpdateSentTime).To(Equal(controller.lastWindowUpdateTime))
+		})
+
+		It("uses the clock to record the time of the window update", func() {
+			now := time.Now().Add(time.Hour)
+			controller.clock = mockClock(now)
+			controller.bytesRead = receiveFlowControlWindow
+			updateNecessary, _ := controller.MaybeTriggerWindowUpdate()
+			Expect(updateNecessary).To(BeTrue())
+			Expect(controller.lastWindowUpdateTime).To(Equal(now))
 		})
 
 		It("doesn't trigger a window update when not necessary", func() {
@@ -204,6 +219,60 @@ var _ = Describe("Flow controller", func() {
 			Expect(controller.lastWindowUpdateTime).To(Equal(lastWindowUpdateTime))
 		})
 
+		It("uses the configured threshold", func() {
+			controller.windowUpdateThreshold = 0.9
+			controller.bytesRead = receiveFlowControlWindow - receiveFlowControlWindowIncrement/2
+			updateNecessary, _ := controller.MaybeTriggerWindowUpdate()
+			Expect(updateNecessary).To(BeTrue())
+		})
+
+		Context("WINDOW_UPDATEs after a number of RTTs", func() {
+			BeforeEach(func() {
+				controller.windowUpdateRTTs = 2
+				controller.rttStats.UpdateRTT(10*time.Millisecond, 0, time.Now())
+				controller.bytesRead = receiveFlowControlWindow - receiveFlowControlWindowIncrement + 1
+			})
+
+			It("triggers a window update after the RTTs passed", func() {
+				controller.lastWindowUpdateSentTime = time.Now().Add(-25 * time.Millisecond)
+				updateNecessary, offset := controller.MaybeTriggerWindowUpdate()
+				Expect(updateNecessary).To(BeTrue())
+				Expect(offset).To(Equal(controller.bytesRead + receiveFlowControlWindowIncrement))
+				Expect(controller.lastWindowUpdateSentTime).To(BeTemporally("~", time.Now(), 5*time.Millisecond))
+				// the window increment is only increased if the window is used up too fast
+				Expect(controller.receiveFlowControlWindowIncrement).To(Equal(receiveFlowControlWindowIncrement))
+			})
+
+			It("doesn't reset the time used to adjust the window increment", func() {
+				lastWindowUpdateTime := time.Now().Add(-time.Hour)
+				controller.lastWindowUpdateTime = lastWindowUpdateTime
+				controller.lastWindowUpdateSentTime = time.Now().Add(-25 * time.Millisecond)
+				updateNecessary, _ := controller.MaybeTriggerWindowUpdate()
+				Expect(updateNecessary).To(BeTrue())
+				Expect(controller.lastWindowUpdateTime).To(Equal(lastWindowUpdateTime))
+			})
+
+			It("doesn't trigger a window update before the RTTs passed", func() {
+				controller.lastWindowUpdateSentTime = time.Now().Add(-15 * time.Millisecond)
+				updateNecessary, _ := controller.MaybeTriggerWindowUpdate()
+				Expect(updateNecessary).To(BeFalse())
+			})
+
+			It("doesn't trigger a window update if no data was read", func() {
+				controller.lastWindowUpdateSentTime = time.Now().Add(-time.Hour)
+				controller.bytesRead = receiveFlowControlWindow - receiveFlowControlWindowIncrement
+				updateNecessary, _ := controller.MaybeTriggerWindowUpdate()
+				Expect(updateNecessary).To(BeFalse())
+			})
+
+			It("doesn't trigger a window update if disabled", func() {
+				controller.windowUpdateRTTs = 0
+				controller.lastWindowUpdateSentTime = time.Now().Add(-time.Hour)
+				updateNecessary, _ := controller.MaybeTriggerWindowUpdate()
+				Expect(updateNecessary).To(BeFalse())
+			})
+		})
+
 		It("updates the highestReceived", func() {
 			controller.highestReceived = 1337
 			increment := controller.UpdateHighestReceived(1338)
@@ -274,6 +343,15 @@ var _ = Describe("Flow controller", func() {
 				Expect(controller.receiveFlowControlWindowIncrement).To(Equal(oldIncrement))
 			})
 
+			It("uses the min RTT if it is larger than the smoothed RTT", func() {
+				// a large ack delay reduces the smoothed RTT to 5ms, but not the min RTT
+				controller.rttStats.UpdateRTT(20*time.Millisecond, 15*time.Millisecond, time.Now())
+				Expect(controller.rttStats.SmoothedRTT()).To(Equal(5 * time.Millisecond))
+				controller.lastWindowUpdateTime = time.Now().Add(-19 * time.Millisecond)
+				controller.maybeAdjustWindowIncrement()
+				Expect(controller.receiveFlowControlWindowIncrement).To(Equal(2 * oldIncrement))
+			})
+
 			It("doesn't increase the increment to a value higher than the maxReceiveFlowControlWindowIncrement", func() {
 				setRtt(10 * time.Millisecond)
 				controller.lastWindowUpdateTime = time.Now().Add(-19 * time.Millisecond)
@@ -19,16 +19,31 @@ var (
 var (
 	errInconsistentAckLargestAcked = errors.New("internal inconsistency: LargestAcked does not match ACK ranges")
 	errInconsistentAckLowestAcked  = errors.New("internal inconsistency: LowestAcked does not match ACK ranges")
+	errInvalidAckTimestamps        = errors.New("internal inconsistency: ACK timestamps can't be written")
 )
 
+// ErrInvalidAckTimestamp occurs when a timestamp in an ACK frame refers to a packet number that is not acknowledged
+var ErrInvalidAckTimestamp = errors.New("AckFrame: ACK frame contains a timestamp for an invalid packet number")
+
+// An AckTimestamp is the time a packet was received, as reported in an ACK frame
+type AckTimestamp struct {
+	PacketNumber protocol.PacketNumber
+	// ReceivedTime is relative to the start of the connection of the endpoint that sent the ACK frame
+	ReceivedTime time.Duration
+}
+
 // An AckFrame is an ACK frame in QUIC
 type AckFrame struct {
 	LargestAcked protocol.PacketNumber
 	LowestAcked  protocol.PacketNumber
 	AckRanges    []AckRange // has to be ordered. The ACK range with the highest FirstPacketNumber goes first, the ACK range with the lowest FirstPacketNumber goes last
 
-	DelayTime          time.Duration
-	PacketReceivedTime time.Time // only for received packets. Will not be modified for received ACKs frames
+	DelayTime          time.Duration // for received packets, it is set from the PacketReceivedTime by the ReceivedPacketHandler
+	PacketReceivedTime time.Time     // only for received packets. Will not be modified for received ACKs frames
+
+	// Timestamps are ordered by the time the packets were received. At most 0xFF timestamps can be written.
+	// Every packet number has to lie within 0xFF of LargestAcked.
+	Timestamps []AckTimestamp
 }
 
 // ParseAckFrame reads an ACK frame
@@ -159,28 +174,43 @@ func ParseAckFrame(r *bytes.Reader, version protocol.VersionNumber) (*AckFrame,
 	}
 
 	if numTimestamp > 0 {
-		// Delta Largest acked
-		_, err = r.ReadByte()
-		if err != nil {
-			return nil, err
-		}
-		// First Timestamp
-		_, err = utils.ReadUint32(r)
-		if err != nil {
-			return nil, err
-		}
-
-		for i := 0; i < int(numTimestamp)-1; i++ {
+		frame.Timestamps = make([]AckTimestamp, numTimestamp)
+		var receivedTime time.Duration
+		for i := 0; i < int(numTimestamp); i++ {
 			// Delta Largest acked
-			_, err = r.ReadByte()
+			var delta byte
+			delta, err = r.ReadByte()
 			if err != nil {
 				return nil, err
 			}
+			if protocol.PacketNumber(delta) >= frame.LargestAcked {
+				return nil, ErrInvalidAckTimestamp
+			}
+			packetNumber := frame.LargestAcked - protocol.PacketNumber(delta)
+			if !frame.AcksPacket(packetNumber) {
+				return nil, ErrInvalidAckTimestamp
+			}
 
-			// Time Since Previous Timestamp
-			_, err = utils.ReadUint16(r)
-			if err != nil {
-				return nil, err
+			if i == 0 {
+				// First Timestamp, relative to the start of the connection
+				var t uint32
+				t, err = utils.ReadUint32(r)
+				if err != nil {
+					return nil, err
+				}
+				receivedTime = time.Duration(t) * time.Microsecond
+			} else {
+				// Time Since Previous Timestamp
+				var t uint64
+				t, err = utils.ReadUfloat16(r)
+				if err != nil {
+					return nil, err
+				}
+				receivedTime += time.Duration(t) * time.Microsecond
+			}
+			frame.Timestamps[i] = AckTimestamp{
+				PacketNumber: packetNumber,
+				ReceivedTime: receivedTime,
 			}
 		}
 	}
@@ -220,7 +250,6 @@ func (f *AckFrame) Write(b *bytes.Buffer, version protocol.VersionNumber) error
 		utils.WriteUint48(b, uint64(f.LargestAcked))
 	}
 
-	f.DelayTime = time.Now().Sub(f.PacketReceivedTime)
 	utils.WriteUfloat16(b, uint64(f.DelayTime/time.Microsecond))
 
 	var numRanges uint64
@@ -323,8 +352,29 @@ func (f *AckFrame) Write(b *bytes.Buffer, version protocol.VersionNumber) error
 		return errors.New("BUG: Inconsistent number of ACK ranges written")
 	}
 
-	b.WriteByte(0) // no timestamps
+	return f.writeTimestamps(b)
+}
 
+func (f *AckFrame) writeTimestamps(b *bytes.Buffer) error {
+	if len(f.Timestamps) > 0xFF {
+		return errInvalidAckTimestamps
+	}
+	b.WriteByte(uint8(len(f.Timestamps)))
+	for i, ts := range f.Timestamps {
+		if ts.PacketNumber > f.LargestAcked || f.LargestAcked-ts.PacketNumber > 0xFF {
+			return errInvalidAckTimestamps
+		}
+		b.WriteByte(uint8(f.LargestAcked - ts.PacketNumber))
+		if i == 0 {
+			utils.WriteUint32(b, uint32(ts.ReceivedTime/time.Microsecond))
+			continue
+		}
+		delta := ts.ReceivedTime - f.Timestamps[i-1].ReceivedTime
+		if delta < 0 {
+			return errInvalidAckTimestamps
+		}
+		utils.WriteUfloat16(b, uint64(delta/time.Microsecond))
+	}
 	return nil
 }
 
@@ -342,7 +392,10 @@ func (f *AckFrame) MinLength(version protocol.VersionNumber) (protocol.ByteCount
 		length += missingSequenceNumberDeltaLen
 	}
 
-	length += (1 + 2) * 0 /* TODO: num_timestamps */
+	if len(f.Timestamps) > 0 {
+		// Delta Largest Acked and First Timestamp, followed by Delta Largest Acked and Time Since Previous Timestamp
+		length += 1 + 4 + (1+2)*protocol.ByteCount(len(f.Timestamps)-1)
+	}
 
 	return length, nil
 }
@@ -70,11 +70,41 @@ var _ = Describe("AckFrame", func() {
 
 		It("parses a frame with multiple timestamps", func() {
 			b := bytes.NewReader([]byte{0x40, 0x10, 0x0, 0x0, 0x10, 0x4, 0x1, 0x6b, 0x26, 0x4, 0x0, 0x3, 0, 0, 0x2, 0, 0, 0x1, 0, 0})
-			_, err := ParseAckFrame(b, protocol.VersionWhatever)
+			frame, err := ParseAckFrame(b, protocol.VersionWhatever)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(frame.Timestamps).To(Equal([]AckTimestamp{
+				{PacketNumber: 0xf, ReceivedTime: 0x4266b * time.Microsecond},
+				{PacketNumber: 0xd, ReceivedTime: 0x4266b * time.Microsecond},
+				{PacketNumber: 0xe, ReceivedTime: 0x4266b * time.Microsecond},
+				{PacketNumber: 0xf, ReceivedTime: 0x4266b * time.Microsecond},
+			}))
+			Expect(b.Len()).To(BeZero())
+		})
+
+		It("parses the time since the previous timestamp", func() {
+			b := bytes.NewReader([]byte{0x40, 0x10, 0x0, 0x0, 0x10, 0x2, 0x1, 0x10, 0x0, 0x0, 0x0, 0x0, 0x20, 0x0})
+			frame, err := ParseAckFrame(b, protocol.VersionWhatever)
 			Expect(err).ToNot(HaveOccurred())
+			Expect(frame.Timestamps).To(Equal([]AckTimestamp{
+				{PacketNumber: 0xf, ReceivedTime: 0x10 * time.Microsecond},
+				{PacketNumber: 0x10, ReceivedTime: 0x30 * time.Microsecond},
+			}))
 			Expect(b.Len()).To(BeZero())
 		})
 
+		It("errors when a timestamp refers to an invalid packet number", func() {
+			b := bytes.NewReader([]byte{0x40, 0x10, 0x0, 0x0, 0x10, 0x1, 0x10, 0x0, 0x0, 0x0, 0x0})
+			_, err := ParseAckFrame(b, protocol.VersionWhatever)
+			Expect(err).To(MatchError(ErrInvalidAckTimestamp))
+		})
+
+		It("errors when a timestamp refers to a packet that is not acknowledged", func() {
+			// acknowledges the packets 8 to 0x10, and contains a timestamp for packet 6
+			b := bytes.NewReader([]byte{0x40, 0x10, 0x0, 0x0, 0x9, 0x1, 0xa, 0x0, 0x0, 0x0, 0x0})
+			_, err := ParseAckFrame(b, protocol.VersionWhatever)
+			Expect(err).To(MatchError(ErrInvalidAckTimestamp))
+		})
+
 		It("errors when the ACK range is too large", func() {
 			// LargestAcked: 0x1c
 			// Length: 0x1d => LowestAcked would be -1
@@ -282,6 +312,78 @@ var _ = Describe("AckFrame", func() {
 			b = &bytes.Buffer{}
 		})
 
+		Context("ack delay", func() {
+			It("writes the ack delay", func() {
+				frameOrig := &AckFrame{
+					LargestAcked: 1,
+					LowestAcked:  1,
+					DelayTime:    10 * time.Millisecond,
+				}
+				err := frameOrig.Write(b, protocol.VersionWhatever)
+				Expect(err).ToNot(HaveOccurred())
+				frame, err := ParseAckFrame(bytes.NewReader(b.Bytes()), protocol.VersionWhatever)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(frame.DelayTime).To(BeNumerically("~", 10*time.Millisecond, 100*time.Microsecond))
+			})
+
+			It("writes a zero ack delay", func() {
+				frameOrig := &AckFrame{
+					LargestAcked: 1,
+					LowestAcked:  1,
+				}
+				err := frameOrig.Write(b, protocol.VersionWhatever)
+				Expect(err).ToNot(HaveOccurred())
+				frame, err := ParseAckFrame(bytes.NewReader(b.Bytes()), protocol.VersionWhatever)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(frame.DelayTime).To(BeZero())
+			})
+		})
+
+		Context("timestamps", func() {
+			It("writes timestamps", func() {
+				frameOrig := &AckFrame{
+					LargestAcked: 0x1337,
+					LowestAcked:  0x1300,
+					Timestamps: []AckTimestamp{
+						{PacketNumber: 0x1336, ReceivedTime: 10 * time.Second},
+						{PacketNumber: 0x1337, ReceivedTime: 10*time.Second + 500*time.Microsecond},
+						// the time since the previous timestamp is encoded as a ufloat16, so it has to be representable exactly
+						{PacketNumber: 0x1300, ReceivedTime: 10*time.Second + 500*time.Microsecond + (1<<20)*time.Microsecond},
+					},
+				}
+				err := frameOrig.Write(b, protocol.VersionWhatever)
+				Expect(err).ToNot(HaveOccurred())
+				r := bytes.NewReader(b.Bytes())
+				frame, err := ParseAckFrame(r, protocol.VersionWhatever)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(frame.Timestamps).To(Equal(frameOrig.Timestamps))
+				Expect(r.Len()).To(BeZero())
+			})
+
+			It("errors if a timestamp is too far below the LargestAcked", func() {
+				f := &AckFrame{
+					LargestAcked: 0x1337,
+					LowestAcked:  0x1000,
+					Timestamps:   []AckTimestamp{{PacketNumber: 0x1337 - 0x100}},
+				}
+				err := f.Write(b, protocol.VersionWhatever)
+				Expect(err).To(MatchError(errInvalidAckTimestamps))
+			})
+
+			It("errors if the timestamps are not ordered by time", func() {
+				f := &AckFrame{
+					LargestAcked: 0x1337,
+					LowestAcked:  0x1000,
+					Timestamps: []AckTimestamp{
+						{PacketNumber: 0x1337, ReceivedTime: time.Second},
+						{PacketNumber: 0x1336, ReceivedTime: time.Millisecond},
+					},
+				}
+				err := f.Write(b, protocol.VersionWhatever)
+				Expect(err).To(MatchError(errInvalidAckTimestamps))
+			})
+		})
+
 		Context("self-consistency", func() {
 			It("writes a simple ACK frame", func() {
 				frameOrig := &AckFrame{
@@ -729,6 +831,20 @@ var _ = Describe("AckFrame", func() {
 		})
 
 		Context("min length", func() {
+			It("has the proper min length for an ACK with timestamps", func() {
+				f := &AckFrame{
+					LargestAcked: 0x1337,
+					LowestAcked:  0x1300,
+					Timestamps: []AckTimestamp{
+						{PacketNumber: 0x1336, ReceivedTime: time.Second},
+						{PacketNumber: 0x1337, ReceivedTime: 2 * time.Second},
+					},
+				}
+				err := f.Write(b, protocol.VersionWhatever)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(f.MinLength(0)).To(Equal(protocol.ByteCount(b.Len())))
+			})
+
 			It("has proper min length", func() {
 				f := &AckFrame{
 					LargestAcked: 1,
@@ -0,0 +1,13 @@
+package frames
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestFramesFuzzing(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Frames Fuzzing Suite")
+}
@@ -0,0 +1,100 @@
+// Package frames contains a go-fuzz entrypoint for the frame parsers.
+//
+// To run it, install go-fuzz and execute
+//
+//	go-fuzz-build github.com/lucas-clemente/quic-go/fuzzing/frames
+//	go-fuzz -bin=frames-fuzz.zip -workdir=fuzzing/frames
+package frames
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/lucas-clemente/quic-go/frames"
+	"github.com/lucas-clemente/quic-go/protocol"
+)
+
+// the packet number and packet number length used for parsing STOP_WAITING frames
+const (
+	packetNumber    protocol.PacketNumber    = 0x1337
+	packetNumberLen protocol.PacketNumberLen = protocol.PacketNumberLen2
+)
+
+// Fuzz parses data as a single frame, for every supported version.
+// It panics if a parser panics, or if a successfully parsed frame doesn't survive a round trip.
+func Fuzz(data []byte) int {
+	if len(data) == 0 {
+		return 0
+	}
+
+	var interesting bool
+	for _, version := range protocol.SupportedVersions {
+		frame, err := parseFrame(bytes.NewReader(data), version)
+		if err != nil || frame == nil {
+			continue
+		}
+		interesting = true
+
+		// A parsed frame might not be writable, e.g. if it contains too many ACK ranges.
+		// Once it was written, parsing and writing it again must produce the same bytes.
+		b := &bytes.Buffer{}
+		if err := frame.Write(b, version); err != nil {
+			continue
+		}
+		written := b.Bytes()
+		frame2, err := parseFrame(bytes.NewReader(written), version)
+		if err != nil {
+			panic(fmt.Sprintf("failed to parse written frame %#v (%#x): %s", frame, written, err))
+		}
+		b2 := &bytes.Buffer{}
+		if err := frame2.Write(b2, version); err != nil {
+			panic(fmt.Sprintf("failed to write reparsed frame %#v: %s", frame2, err))
+		}
+		if !bytes.Equal(written, b2.Bytes()) {
+			panic(fmt.Sprintf("round trip inconsistency for %#v: %#x vs. %#x", frame, written, b2.Bytes()))
+		}
+	}
+	if interesting {
+		return 1
+	}
+	return 0
+}
+
+// parseFrame dispatches on the type byte, the same way the packet unpacker does
+func parseFrame(r *bytes.Reader, version protocol.VersionNumber) (frames.Frame, error) {
+	typeByte, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	r.UnreadByte()
+
+	if typeByte&0x80 == 0x80 {
+		return frames.ParseStreamFrame(r)
+	}
+	if typeByte&0xc0 == 0x40 {
+		return frames.ParseAckFrame(r, version)
+	}
+	switch typeByte {
+	case 0x01:
+		return frames.ParseRstStreamFrame(r)
+	case 0x02:
+		return frames.ParseConnectionCloseFrame(r)
+	case 0x03:
+		return frames.ParseGoawayFrame(r)
+	case 0x04:
+		return frames.ParseWindowUpdateFrame(r)
+	case 0x05:
+		return frames.ParseBlockedFrame(r)
+	case 0x06:
+		f, err := frames.ParseStopWaitingFrame(r, packetNumber, packetNumberLen, version)
+		if err != nil {
+			return nil, err
+		}
+		f.PacketNumber = packetNumber
+		f.PacketNumberLen = packetNumberLen
+		return f, nil
+	case 0x07:
+		return frames.ParsePingFrame(r)
+	}
+	return nil, nil
+}
@@ -0,0 +1,22 @@
+package frames
+
+import (
+	"io/ioutil"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Fuzzing", func() {
+	It("replays the corpus", func() {
+		files, err := ioutil.ReadDir("corpus")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(files).ToNot(BeEmpty())
+		for _, f := range files {
+			data, err := ioutil.ReadFile(filepath.Join("corpus", f.Name()))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(func() { Fuzz(data) }).ToNot(Panic(), "input: "+f.Name())
+		}
+	})
+})
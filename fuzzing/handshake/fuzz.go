@@ -0,0 +1,35 @@
+// Package handshake contains a go-fuzz entrypoint for the crypto handshake message parser.
+//
+// To run it, install go-fuzz and execute
+//
+//	go-fuzz-build github.com/lucas-clemente/quic-go/fuzzing/handshake
+//	go-fuzz -bin=handshake-fuzz.zip -workdir=fuzzing/handshake
+package handshake
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+
+	"github.com/lucas-clemente/quic-go/handshake"
+)
+
+// Fuzz parses data as a crypto handshake message.
+// It panics if the parser panics, or if a parsed message doesn't survive a round trip.
+func Fuzz(data []byte) int {
+	tag, msg, err := handshake.ParseHandshakeMessage(bytes.NewReader(data))
+	if err != nil {
+		return 0
+	}
+
+	b := &bytes.Buffer{}
+	handshake.WriteHandshakeMessage(b, tag, msg)
+	tag2, msg2, err := handshake.ParseHandshakeMessage(bytes.NewReader(b.Bytes()))
+	if err != nil {
+		panic(fmt.Sprintf("failed to parse written message %#x: %s", b.Bytes(), err))
+	}
+	if tag2 != tag || !reflect.DeepEqual(msg2, msg) {
+		panic(fmt.Sprintf("round trip inconsistency: %#v vs. %#v", msg, msg2))
+	}
+	return 1
+}
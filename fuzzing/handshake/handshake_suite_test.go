@@ -0,0 +1,13 @@
+package handshake
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestHandshakeFuzzing(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Handshake Fuzzing Suite")
+}
@@ -0,0 +1,41 @@
+// Package header contains a go-fuzz entrypoint for the public header parser.
+//
+// To run it, install go-fuzz and execute
+//
+//	go-fuzz-build github.com/lucas-clemente/quic-go/fuzzing/header
+//	go-fuzz -bin=header-fuzz.zip -workdir=fuzzing/header
+package header
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/lucas-clemente/quic-go"
+	"github.com/lucas-clemente/quic-go/protocol"
+)
+
+// Fuzz parses data as a public header.
+// It panics if the parser panics, or if a regular packet's header doesn't survive a round trip.
+// Packets with the version flag set, which the server answers with a version negotiation packet, are only parsed.
+func Fuzz(data []byte) int {
+	hdr, err := quic.ParsePublicHeader(bytes.NewReader(data))
+	if err != nil {
+		return 0
+	}
+	if hdr.VersionFlag || hdr.ResetFlag {
+		return 1
+	}
+
+	b := &bytes.Buffer{}
+	if err := hdr.Write(b, protocol.VersionWhatever); err != nil {
+		panic(fmt.Sprintf("failed to write parsed header %#v: %s", hdr, err))
+	}
+	hdr2, err := quic.ParsePublicHeader(bytes.NewReader(b.Bytes()))
+	if err != nil {
+		panic(fmt.Sprintf("failed to parse written header %#x: %s", b.Bytes(), err))
+	}
+	if hdr2.ConnectionID != hdr.ConnectionID || hdr2.PacketNumber != hdr.PacketNumber || hdr2.PacketNumberLen != hdr.PacketNumberLen {
+		panic(fmt.Sprintf("round trip inconsistency: %#v vs. %#v", hdr, hdr2))
+	}
+	return 1
+}
@@ -0,0 +1,13 @@
+package header
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestHeaderFuzzing(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Header Fuzzing Suite")
+}
@@ -0,0 +1,15 @@
+// +build go1.7
+
+package h2quic
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/lucas-clemente/quic-go"
+)
+
+// withConnectionState adds the quic.ConnectionState to the context of the request
+func withConnectionState(req *http.Request, state quic.ConnectionState) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), ConnectionStateContextKey, state))
+}
@@ -0,0 +1,14 @@
+// +build !go1.7
+
+package h2quic
+
+import (
+	"net/http"
+
+	"github.com/lucas-clemente/quic-go"
+)
+
+// withConnectionState is a no-op, since http.Request doesn't have a context before Go 1.7
+func withConnectionState(req *http.Request, state quic.ConnectionState) *http.Request {
+	return req
+}
@@ -0,0 +1,23 @@
+// +build go1.7
+
+package h2quic
+
+import (
+	"net/http"
+
+	"github.com/lucas-clemente/quic-go"
+	"github.com/lucas-clemente/quic-go/protocol"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("request context", func() {
+	It("adds the connection state", func() {
+		state := quic.ConnectionState{Version: protocol.Version36, ServerName: "www.example.com"}
+		req, err := http.NewRequest("GET", "https://www.example.com", nil)
+		Expect(err).ToNot(HaveOccurred())
+		req = withConnectionState(req, state)
+		Expect(req.Context().Value(ConnectionStateContextKey)).To(Equal(state))
+	})
+})
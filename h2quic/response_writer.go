@@ -2,6 +2,7 @@ package h2quic
 
 import (
 	"bytes"
+	"io"
 	"net/http"
 	"strconv"
 	"strings"
@@ -13,22 +14,58 @@ import (
 	"golang.org/x/net/http2/hpack"
 )
 
+// A headerEncoder HPACK-encodes header fields.
+// All HEADERS frames sent on a header stream must be encoded by the same headerEncoder,
+// since its dynamic table has to be kept in sync with the peer's decoder.
+// It is not safe for concurrent use, the headerStreamMutex must be held when using it.
+type headerEncoder struct {
+	buf bytes.Buffer
+	enc *hpack.Encoder
+}
+
+// newHeaderEncoder creates a new headerEncoder. If maxTableSize is 0, the default dynamic table size of 4096 bytes is used.
+func newHeaderEncoder(maxTableSize uint32) *headerEncoder {
+	e := &headerEncoder{}
+	e.enc = hpack.NewEncoder(&e.buf)
+	if maxTableSize != 0 {
+		e.enc.SetMaxDynamicTableSizeLimit(maxTableSize)
+	}
+	return e
+}
+
+// encode encodes a header block. The returned slice is only valid until the next call to encode.
+func (e *headerEncoder) encode(fields []hpack.HeaderField) []byte {
+	e.buf.Reset()
+	for _, f := range fields {
+		e.enc.WriteField(f)
+	}
+	return e.buf.Bytes()
+}
+
+// setMaxDynamicTableSize applies the SETTINGS_HEADER_TABLE_SIZE sent by the peer.
+// The size is capped at the limit the headerEncoder was created with.
+func (e *headerEncoder) setMaxDynamicTableSize(size uint32) {
+	e.enc.SetMaxDynamicTableSize(size)
+}
+
 type responseWriter struct {
 	dataStreamID protocol.StreamID
 	dataStream   utils.Stream
 
 	headerStream      utils.Stream
 	headerStreamMutex *sync.Mutex
+	headerEncoder     *headerEncoder
 
 	header        http.Header
 	headerWritten bool
 }
 
-func newResponseWriter(headerStream utils.Stream, headerStreamMutex *sync.Mutex, dataStream utils.Stream, dataStreamID protocol.StreamID) *responseWriter {
+func newResponseWriter(headerStream utils.Stream, headerStreamMutex *sync.Mutex, headerEncoder *headerEncoder, dataStream utils.Stream, dataStreamID protocol.StreamID) *responseWriter {
 	return &responseWriter{
 		header:            http.Header{},
 		headerStream:      headerStream,
 		headerStreamMutex: headerStreamMutex,
+		headerEncoder:     headerEncoder,
 		dataStream:        dataStream,
 		dataStreamID:      dataStreamID,
 	}
@@ -44,24 +81,33 @@ func (w *responseWriter) WriteHeader(status int) {
 	}
 	w.headerWritten = true
 
-	var headers bytes.Buffer
-	enc := hpack.NewEncoder(&headers)
-	enc.WriteField(hpack.HeaderField{Name: ":status", Value: strconv.Itoa(status)})
-
+	headers := []hpack.HeaderField{{Name: ":status", Value: strconv.Itoa(status)}}
 	for k, v := range w.header {
 		for index := range v {
-			enc.WriteField(hpack.HeaderField{Name: strings.ToLower(k), Value: v[index]})
+			headers = append(headers, hpack.HeaderField{Name: strings.ToLower(k), Value: v[index]})
 		}
 	}
 
 	utils.Infof("Responding with %d", status)
+	w.writeHeaders(headers)
+}
+
+// writeContinue sends a 100 Continue interim response, unless the final response header was already written
+func (w *responseWriter) writeContinue() {
+	if w.headerWritten {
+		return
+	}
+	w.writeHeaders([]hpack.HeaderField{{Name: ":status", Value: strconv.Itoa(http.StatusContinue)}})
+}
+
+func (w *responseWriter) writeHeaders(headers []hpack.HeaderField) {
 	w.headerStreamMutex.Lock()
 	defer w.headerStreamMutex.Unlock()
 	h2framer := http2.NewFramer(w.headerStream, nil)
 	err := h2framer.WriteHeaders(http2.HeadersFrameParam{
 		StreamID:      uint32(w.dataStreamID),
 		EndHeaders:    true,
-		BlockFragment: headers.Bytes(),
+		BlockFragment: w.headerEncoder.encode(headers),
 	})
 	if err != nil {
 		utils.Errorf("could not write h2 header: %s", err.Error())
@@ -75,7 +121,38 @@ func (w *responseWriter) Write(p []byte) (int, error) {
 	return w.dataStream.Write(p)
 }
 
-func (w *responseWriter) Flush() {}
+// ReadFrom implements io.ReaderFrom, which is used by io.Copy, and therefore by http.ServeContent.
+// If the data stream implements io.ReaderFrom, the data is read directly into the stream's send buffer.
+func (w *responseWriter) ReadFrom(r io.Reader) (int64, error) {
+	if !w.headerWritten {
+		w.WriteHeader(200)
+	}
+	if rf, ok := w.dataStream.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return io.Copy(w.dataStream, r)
+}
+
+func (w *responseWriter) Flush() {
+	if !w.headerWritten {
+		w.WriteHeader(200)
+	}
+	if err := w.dataStream.Flush(); err != nil {
+		utils.Errorf("could not flush the data stream: %s", err.Error())
+	}
+}
+
+// A continueReader sends a 100 Continue interim response before the request body is read for the first time
+type continueReader struct {
+	io.Reader
+	sendContinue func()
+	once         sync.Once
+}
+
+func (r *continueReader) Read(p []byte) (int, error) {
+	r.once.Do(r.sendContinue)
+	return r.Reader.Read(p)
+}
 
 // test that we implement http.Flusher
 var _ http.Flusher = &responseWriter{}
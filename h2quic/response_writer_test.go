@@ -2,22 +2,30 @@ package h2quic
 
 import (
 	"bytes"
+	"io"
 	"net/http"
+	"strings"
 	"sync"
 
 	"github.com/lucas-clemente/quic-go/protocol"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
 )
 
 type mockStream struct {
 	id protocol.StreamID
 	bytes.Buffer
 	remoteClosed bool
+	reset        bool
+	flushed      bool
 }
 
 func (mockStream) Close() error                             { return nil }
+func (s *mockStream) Flush() error                          { s.flushed = true; return nil }
 func (s *mockStream) CloseRemote(offset protocol.ByteCount) { s.remoteClosed = true }
+func (s *mockStream) Reset(error)                           { s.reset = true }
 func (s mockStream) StreamID() protocol.StreamID            { return s.id }
 
 var _ = Describe("Response Writer", func() {
@@ -30,7 +38,7 @@ var _ = Describe("Response Writer", func() {
 	BeforeEach(func() {
 		headerStream = &mockStream{}
 		dataStream = &mockStream{}
-		w = newResponseWriter(headerStream, &sync.Mutex{}, dataStream, 5)
+		w = newResponseWriter(headerStream, &sync.Mutex{}, newHeaderEncoder(0), dataStream, 5)
 	})
 
 	It("writes status", func() {
@@ -87,6 +95,78 @@ var _ = Describe("Response Writer", func() {
 		}))
 	})
 
+	It("uses the same dynamic table for subsequent responses", func() {
+		encoder := newHeaderEncoder(0)
+		w = newResponseWriter(headerStream, &sync.Mutex{}, encoder, dataStream, 5)
+		w.Header().Add("x-custom", "foobar")
+		w.WriteHeader(http.StatusTeapot)
+		firstLen := headerStream.Len()
+		headerStream.Buffer.Reset()
+		w = newResponseWriter(headerStream, &sync.Mutex{}, encoder, dataStream, 7)
+		w.Header().Add("x-custom", "foobar")
+		w.WriteHeader(http.StatusTeapot)
+		Expect(headerStream.Len()).To(BeNumerically("<", firstLen))
+	})
+
+	It("signals dynamic table size updates", func() {
+		encoder := newHeaderEncoder(0)
+		encoder.setMaxDynamicTableSize(0)
+		Expect(encoder.encode([]hpack.HeaderField{{Name: ":status", Value: "200"}})).To(Equal([]byte{0x20, 0x88}))
+	})
+
+	It("writes 100 Continue", func() {
+		w.writeContinue()
+		frame, err := http2.NewFramer(nil, headerStream).ReadFrame()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(frame.Header().StreamID).To(Equal(uint32(5)))
+		fields, err := hpack.NewDecoder(4096, nil).DecodeFull(frame.(*http2.HeadersFrame).HeaderBlockFragment())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(fields).To(Equal([]hpack.HeaderField{{Name: ":status", Value: "100"}}))
+		Expect(w.headerWritten).To(BeFalse())
+	})
+
+	It("doesn't write 100 Continue after the response header", func() {
+		w.WriteHeader(200)
+		headerStream.Buffer.Reset()
+		w.writeContinue()
+		Expect(headerStream.Len()).To(BeZero())
+	})
+
+	It("sends 100 Continue only on the first read of the continueReader", func() {
+		var continueSent int
+		r := &continueReader{Reader: bytes.NewReader([]byte("foobar")), sendContinue: func() { continueSent++ }}
+		Expect(continueSent).To(BeZero())
+		b := make([]byte, 3)
+		_, err := r.Read(b)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = r.Read(b)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(continueSent).To(Equal(1))
+	})
+
+	It("reads data from a reader", func() {
+		n, err := w.ReadFrom(strings.NewReader("foobar"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(n).To(Equal(int64(6)))
+		Expect(headerStream.Bytes()).To(Equal([]byte{
+			0x0, 0x0, 0x1, 0x1, 0x4, 0x0, 0x0, 0x0, 0x5, 0x88,
+		}))
+		Expect(dataStream.Bytes()).To(Equal([]byte("foobar")))
+	})
+
+	It("is used by io.Copy", func() {
+		n, err := io.Copy(w, strings.NewReader("foobar"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(n).To(Equal(int64(6)))
+		Expect(dataStream.Bytes()).To(Equal([]byte("foobar")))
+	})
+
+	It("flushes the data stream", func() {
+		w.Flush()
+		Expect(dataStream.flushed).To(BeTrue())
+		Expect(headerStream.Bytes()).To(Equal([]byte{0x0, 0x0, 0x1, 0x1, 0x4, 0x0, 0x0, 0x0, 0x5, 0x88})) // 0x88 is 200
+	})
+
 	It("does not WriteHeader() twice", func() {
 		w.WriteHeader(200)
 		w.WriteHeader(500)
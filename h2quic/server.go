@@ -8,6 +8,7 @@ import (
 	"net"
 	"net/http"
 	"runtime"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -20,23 +21,57 @@ import (
 	"golang.org/x/net/http2/hpack"
 )
 
+var errHandlerPanicked = errors.New("h2quic: panic in handler")
+
+// defaultHeaderTableSize is the size of the HPACK dynamic table, as long as no SETTINGS_HEADER_TABLE_SIZE was sent
+const defaultHeaderTableSize = 4096
+
 type streamCreator interface {
 	GetOrOpenStream(protocol.StreamID) (utils.Stream, error)
+	SetStreamPriority(protocol.StreamID, protocol.StreamPriority) error
+	GoAway()
 	Close(error) error
 	RemoteAddr() *net.UDPAddr
+	ConnectionState() quic.ConnectionState
+}
+
+type contextKey struct {
+	name string
 }
 
+// ConnectionStateContextKey is a context key. It can be used in HTTP handlers with Context.Value to access the quic.ConnectionState of the session that the request was received on.
+// It is only available with Go 1.7 or later.
+var ConnectionStateContextKey = &contextKey{"quic-connection-state"}
+
 // Server is a HTTP2 server listening for QUIC connections.
 type Server struct {
 	*http.Server
 
+	// QuicConfig is the configuration of the QUIC server. If nil, the default configuration is used.
+	QuicConfig *quic.Config
+
+	// MaxDecoderHeaderTableSize is the size of the HPACK dynamic table used to decode request headers.
+	// It is advertised to the client in a SETTINGS frame. If zero, the default size of 4096 bytes is used.
+	MaxDecoderHeaderTableSize uint32
+	// MaxEncoderHeaderTableSize is an upper limit for the size of the HPACK dynamic table used to encode response headers.
+	// The client can request a smaller table. If zero, the default size of 4096 bytes is used.
+	MaxEncoderHeaderTableSize uint32
+
 	// Private flag for demo, do not use
 	CloseAfterFirstRequest bool
 
 	port uint32 // used atomically
 
-	server      *quic.Server
+	server      quic.Listener
 	serverMutex sync.Mutex
+
+	// sessionsMutex protects all the fields below
+	sessionsMutex  sync.Mutex
+	sessions       map[streamCreator]struct{}
+	activeRequests int
+	closing        bool
+	// requestsDone is closed when there are no active requests anymore, after CloseGracefully was called
+	requestsDone chan struct{}
 }
 
 // ListenAndServe listens on the UDP address s.Addr and calls s.Handler to handle HTTP/2 requests on incoming connections.
@@ -78,7 +113,7 @@ func (s *Server) serveImpl(tlsConfig *tls.Config, conn *net.UDPConn) error {
 		return errors.New("ListenAndServe may only be called once")
 	}
 	var err error
-	server, err := quic.NewServer(s.Addr, tlsConfig, s.handleStreamCb)
+	server, err := quic.NewServer(s.Addr, tlsConfig, s.QuicConfig, s.handleStreamCb)
 	if err != nil {
 		s.serverMutex.Unlock()
 		return err
@@ -91,7 +126,7 @@ func (s *Server) serveImpl(tlsConfig *tls.Config, conn *net.UDPConn) error {
 	return server.Serve(conn)
 }
 
-func (s *Server) handleStreamCb(session *quic.Session, stream utils.Stream) {
+func (s *Server) handleStreamCb(session quic.SessionHandle, stream utils.Stream) {
 	s.handleStream(session, stream)
 }
 
@@ -100,13 +135,22 @@ func (s *Server) handleStream(session streamCreator, stream utils.Stream) {
 		return
 	}
 
-	hpackDecoder := hpack.NewDecoder(4096, nil)
+	var headerStreamMutex sync.Mutex // Protects concurrent calls to Write()
+	hpackDecoder := s.newHpackDecoder()
+	hpackEncoder := newHeaderEncoder(s.MaxEncoderHeaderTableSize)
 	h2framer := http2.NewFramer(nil, stream)
 
+	if err := s.writeSettings(stream, &headerStreamMutex); err != nil {
+		utils.Errorf("could not write h2 settings: %s", err.Error())
+		return
+	}
+
+	s.addSession(session)
+
 	go func() {
-		var headerStreamMutex sync.Mutex // Protects concurrent calls to Write()
+		defer s.removeSession(session)
 		for {
-			if err := s.handleRequest(session, stream, &headerStreamMutex, hpackDecoder, h2framer); err != nil {
+			if err := s.handleRequest(session, stream, &headerStreamMutex, hpackDecoder, hpackEncoder, h2framer); err != nil {
 				// QuicErrors must originate from stream.Read() returning an error.
 				// In this case, the session has already logged the error, so we don't
 				// need to log it again.
@@ -119,11 +163,23 @@ func (s *Server) handleStream(session streamCreator, stream utils.Stream) {
 	}()
 }
 
-func (s *Server) handleRequest(session streamCreator, headerStream utils.Stream, headerStreamMutex *sync.Mutex, hpackDecoder *hpack.Decoder, h2framer *http2.Framer) error {
+func (s *Server) handleRequest(session streamCreator, headerStream utils.Stream, headerStreamMutex *sync.Mutex, hpackDecoder *hpack.Decoder, hpackEncoder *headerEncoder, h2framer *http2.Framer) error {
 	h2frame, err := h2framer.ReadFrame()
 	if err != nil {
 		return err
 	}
+	if h2priorityFrame, ok := h2frame.(*http2.PriorityFrame); ok {
+		setStreamPriority(session, h2priorityFrame.StreamID, h2priorityFrame.PriorityParam)
+		return nil
+	}
+	if h2settingsFrame, ok := h2frame.(*http2.SettingsFrame); ok {
+		if size, ok := h2settingsFrame.Value(http2.SettingHeaderTableSize); ok {
+			headerStreamMutex.Lock()
+			hpackEncoder.setMaxDynamicTableSize(size)
+			headerStreamMutex.Unlock()
+		}
+		return nil
+	}
 	h2headersFrame, ok := h2frame.(*http2.HeadersFrame)
 	if !ok {
 		return qerr.Error(qerr.InvalidHeadersStreamData, "expected a header frame")
@@ -131,18 +187,37 @@ func (s *Server) handleRequest(session streamCreator, headerStream utils.Stream,
 	if !h2headersFrame.HeadersEnded() {
 		return errors.New("http2 header continuation not implemented")
 	}
-	headers, err := hpackDecoder.DecodeFull(h2headersFrame.HeaderBlockFragment())
+	headers, headersTooLarge, err := s.decodeHeaders(hpackDecoder, h2headersFrame.HeaderBlockFragment())
 	if err != nil {
 		utils.Errorf("invalid http2 headers encoding: %s", err.Error())
 		return err
 	}
 
+	if headersTooLarge {
+		utils.Infof("Rejecting request on data stream %d: header list larger than %d bytes", h2headersFrame.StreamID, s.maxHeaderListSize())
+		dataStream, err := session.GetOrOpenStream(protocol.StreamID(h2headersFrame.StreamID))
+		if err != nil {
+			return err
+		}
+		if dataStream == nil {
+			return nil
+		}
+		responseWriter := newResponseWriter(headerStream, headerStreamMutex, hpackEncoder, dataStream, protocol.StreamID(h2headersFrame.StreamID))
+		responseWriter.WriteHeader(http.StatusRequestHeaderFieldsTooLarge)
+		dataStream.Close()
+		return nil
+	}
+
 	req, err := requestFromHeaders(headers)
 	if err != nil {
 		return err
 	}
 
 	req.RemoteAddr = session.RemoteAddr().String()
+	connState := session.ConnectionState()
+	req.TLS.HandshakeComplete = connState.HandshakeComplete
+	req.TLS.ServerName = connState.ServerName
+	req = withConnectionState(req, connState)
 
 	if utils.Debug() {
 		utils.Infof("%s %s%s, on data stream %d", req.Method, req.Host, req.RequestURI, h2headersFrame.StreamID)
@@ -154,18 +229,33 @@ func (s *Server) handleRequest(session streamCreator, headerStream utils.Stream,
 	if err != nil {
 		return err
 	}
+	if dataStream == nil {
+		// The stream was already closed, or it was refused since the session is going away
+		return nil
+	}
+
+	if h2headersFrame.HasPriority() {
+		setStreamPriority(session, h2headersFrame.StreamID, h2headersFrame.Priority)
+	}
 
 	if h2headersFrame.StreamEnded() {
 		dataStream.CloseRemote(0)
 		_, _ = dataStream.Read([]byte{0}) // read the eof
 	}
 
-	// stream's Close() closes the write side, not the read side
-	req.Body = ioutil.NopCloser(dataStream)
+	responseWriter := newResponseWriter(headerStream, headerStreamMutex, hpackEncoder, dataStream, protocol.StreamID(h2headersFrame.StreamID))
 
-	responseWriter := newResponseWriter(headerStream, headerStreamMutex, dataStream, protocol.StreamID(h2headersFrame.StreamID))
+	// stream's Close() closes the write side, not the read side
+	if !h2headersFrame.StreamEnded() && strings.EqualFold(req.Header.Get("Expect"), "100-continue") {
+		req.Header.Del("Expect")
+		req.Body = ioutil.NopCloser(&continueReader{Reader: dataStream, sendContinue: responseWriter.writeContinue})
+	} else {
+		req.Body = ioutil.NopCloser(dataStream)
+	}
 
+	s.requestStarted()
 	go func() {
+		defer s.requestFinished()
 		handler := s.Handler
 		if handler == nil {
 			handler = http.DefaultServeMux
@@ -185,12 +275,15 @@ func (s *Server) handleRequest(session streamCreator, headerStream utils.Stream,
 			handler.ServeHTTP(responseWriter, req)
 		}()
 		if panicked {
-			responseWriter.WriteHeader(500)
+			// Like the HTTP/2 server in net/http, reset the stream and keep the session alive.
+			if responseWriter.dataStream != nil {
+				responseWriter.dataStream.Reset(errHandlerPanicked)
+			}
 		} else {
 			responseWriter.WriteHeader(200)
-		}
-		if responseWriter.dataStream != nil {
-			responseWriter.dataStream.Close()
+			if responseWriter.dataStream != nil {
+				responseWriter.dataStream.Close()
+			}
 		}
 		if s.CloseAfterFirstRequest {
 			time.Sleep(100 * time.Millisecond)
@@ -201,6 +294,86 @@ func (s *Server) handleRequest(session streamCreator, headerStream utils.Stream,
 	return nil
 }
 
+// newHpackDecoder creates the decoder for the request headers of a session.
+// The client's encoder uses a dynamic table of 4096 bytes until it has processed our SETTINGS frame.
+// We therefore start with (at least) that size, and only allow the client to reduce the size afterwards.
+func (s *Server) newHpackDecoder() *hpack.Decoder {
+	tableSize := s.maxDecoderHeaderTableSize()
+	hpackDecoder := hpack.NewDecoder(utils.MaxUint32(tableSize, defaultHeaderTableSize), nil)
+	hpackDecoder.SetAllowedMaxDynamicTableSize(tableSize)
+	return hpackDecoder
+}
+
+func (s *Server) maxDecoderHeaderTableSize() uint32 {
+	if s.MaxDecoderHeaderTableSize == 0 {
+		return defaultHeaderTableSize
+	}
+	return s.MaxDecoderHeaderTableSize
+}
+
+// writeSettings sends a SETTINGS frame on the header stream, if any settings deviate from the HTTP/2 defaults.
+func (s *Server) writeSettings(headerStream utils.Stream, headerStreamMutex *sync.Mutex) error {
+	tableSize := s.maxDecoderHeaderTableSize()
+	if tableSize == defaultHeaderTableSize {
+		return nil
+	}
+	headerStreamMutex.Lock()
+	defer headerStreamMutex.Unlock()
+	return http2.NewFramer(headerStream, nil).WriteSettings(http2.Setting{ID: http2.SettingHeaderTableSize, Val: tableSize})
+}
+
+// decodeHeaders decodes a header block, enforcing the maximum header list size.
+// Header fields exceeding the limit are still decoded, so that the dynamic table stays in sync with the client's encoder.
+func (s *Server) decodeHeaders(hpackDecoder *hpack.Decoder, headerBlock []byte) ([]hpack.HeaderField, bool, error) {
+	maxHeaderListSize := s.maxHeaderListSize()
+
+	var headers []hpack.HeaderField
+	var headerListSize uint32
+	var tooLarge bool
+	hpackDecoder.SetEmitEnabled(true)
+	hpackDecoder.SetEmitFunc(func(hf hpack.HeaderField) {
+		headerListSize += hf.Size()
+		if headerListSize > maxHeaderListSize {
+			hpackDecoder.SetEmitEnabled(false)
+			tooLarge = true
+			return
+		}
+		headers = append(headers, hf)
+	})
+	defer hpackDecoder.SetEmitFunc(func(hpack.HeaderField) {})
+
+	if _, err := hpackDecoder.Write(headerBlock); err != nil {
+		return nil, false, err
+	}
+	if err := hpackDecoder.Close(); err != nil {
+		return nil, false, err
+	}
+	return headers, tooLarge, nil
+}
+
+// maxHeaderListSize is the maximum size of the (uncompressed) header list of a request, as defined in the HTTP/2 spec
+// It is set by s.Server.MaxHeaderBytes, or http.DefaultMaxHeaderBytes if that is not set.
+func (s *Server) maxHeaderListSize() uint32 {
+	if s.Server == nil || s.Server.MaxHeaderBytes <= 0 {
+		return http.DefaultMaxHeaderBytes
+	}
+	return uint32(s.Server.MaxHeaderBytes)
+}
+
+// setStreamPriority applies the HTTP/2 priority of a request to the scheduling of its data stream.
+// Stream dependencies are not supported, only the weight is taken into account.
+func setStreamPriority(session streamCreator, id uint32, priority http2.PriorityParam) {
+	if err := session.SetStreamPriority(protocol.StreamID(id), priorityFromH2Weight(priority.Weight)); err != nil {
+		utils.Debugf("Ignoring priority for stream %d: %s", id, err.Error())
+	}
+}
+
+// priorityFromH2Weight maps an HTTP/2 weight onto a QUIC stream priority.
+// The weight in a http2.PriorityParam is the actual weight minus 1, so it ranges from 0 to 255.
+func priorityFromH2Weight(weight uint8) protocol.StreamPriority {
+	return protocol.StreamPriority((255 - int(weight)) / 32)
+}
+
 // Close the server immediately, aborting requests and sending CONNECTION_CLOSE frames to connected clients.
 // Close in combination with ListenAndServe() (instead of Serve()) may race if it is called before a UDP socket is established.
 func (s *Server) Close() error {
@@ -217,8 +390,67 @@ func (s *Server) Close() error {
 // CloseGracefully shuts down the server gracefully. The server sends a GOAWAY frame first, then waits for either timeout to trigger, or for all running requests to complete.
 // CloseGracefully in combination with ListenAndServe() (instead of Serve()) may race if it is called before a UDP socket is established.
 func (s *Server) CloseGracefully(timeout time.Duration) error {
-	// TODO: implement
-	return nil
+	s.sessionsMutex.Lock()
+	if s.closing {
+		s.sessionsMutex.Unlock()
+		return errors.New("CloseGracefully may only be called once")
+	}
+	s.closing = true
+	s.requestsDone = make(chan struct{})
+	if s.activeRequests == 0 {
+		close(s.requestsDone)
+	}
+	requestsDone := s.requestsDone
+	for session := range s.sessions {
+		session.GoAway()
+	}
+	s.sessionsMutex.Unlock()
+
+	select {
+	case <-requestsDone:
+	case <-time.After(timeout):
+		utils.Infof("Timeout while waiting for requests to complete, closing the server")
+	}
+	return s.Close()
+}
+
+// addSession registers a session, so that a GOAWAY can be sent when the server is shut down
+func (s *Server) addSession(session streamCreator) {
+	s.sessionsMutex.Lock()
+	defer s.sessionsMutex.Unlock()
+	if s.closing {
+		session.GoAway()
+		return
+	}
+	if s.sessions == nil {
+		s.sessions = make(map[streamCreator]struct{})
+	}
+	s.sessions[session] = struct{}{}
+}
+
+func (s *Server) removeSession(session streamCreator) {
+	s.sessionsMutex.Lock()
+	delete(s.sessions, session)
+	s.sessionsMutex.Unlock()
+}
+
+func (s *Server) requestStarted() {
+	s.sessionsMutex.Lock()
+	s.activeRequests++
+	s.sessionsMutex.Unlock()
+}
+
+func (s *Server) requestFinished() {
+	s.sessionsMutex.Lock()
+	defer s.sessionsMutex.Unlock()
+	s.activeRequests--
+	if s.closing && s.activeRequests == 0 {
+		select {
+		case <-s.requestsDone: // already closed
+		default:
+			close(s.requestsDone)
+		}
+	}
 }
 
 // SetQuicHeaders can be used to set the proper headers that announce that this server supports QUIC.
@@ -264,7 +496,9 @@ func ListenAndServeQUIC(addr, certFile, keyFile string, handler http.Handler) er
 // ListenAndServe listens on the given network address for both, TLS and QUIC
 // connetions in parallel. It returns if one of the two returns an error.
 // http.DefaultServeMux is used when handler is nil.
+// TLS connections are served using HTTP/2, or HTTP/1.1 if the client doesn't support it.
 // The correct Alt-Svc headers for QUIC are set.
+// If the port of addr is 0, the same port is chosen for the TCP and the UDP listener.
 func ListenAndServe(addr, certFile, keyFile string, handler http.Handler) error {
 	// Load certs
 	var err error
@@ -280,34 +514,43 @@ func ListenAndServe(addr, certFile, keyFile string, handler http.Handler) error
 	}
 
 	// Open the listeners
-	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	// The TCP listener is opened first, such that the UDP listener can use the same port, if the port was chosen by the kernel
+	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
 	if err != nil {
 		return err
 	}
-	udpConn, err := net.ListenUDP("udp", udpAddr)
+	tcpConn, err := net.ListenTCP("tcp", tcpAddr)
 	if err != nil {
 		return err
 	}
-	defer udpConn.Close()
+	defer tcpConn.Close()
 
-	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+	port := tcpConn.Addr().(*net.TCPAddr).Port
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: tcpAddr.IP, Port: port, Zone: tcpAddr.Zone})
 	if err != nil {
 		return err
 	}
-	tcpConn, err := net.ListenTCP("tcp", tcpAddr)
-	if err != nil {
-		return err
-	}
-	defer tcpConn.Close()
+	defer udpConn.Close()
+
+	return serveTLSAndQUIC(tcpConn, udpConn, config, handler)
+}
+
+// serveTLSAndQUIC serves TLS connections on tcpConn and QUIC connections on udpConn, until one of the two servers returns
+func serveTLSAndQUIC(tcpConn *net.TCPListener, udpConn *net.UDPConn, config *tls.Config, handler http.Handler) error {
+	port := tcpConn.Addr().(*net.TCPAddr).Port
 
 	// Start the servers
 	httpServer := &http.Server{
-		Addr:      addr,
+		Addr:      tcpConn.Addr().String(),
 		TLSConfig: config,
 	}
+	if err := http2.ConfigureServer(httpServer, nil); err != nil {
+		return err
+	}
 
 	quicServer := &Server{
 		Server: httpServer,
+		port:   uint32(port),
 	}
 
 	if handler == nil {
@@ -318,10 +561,12 @@ func ListenAndServe(addr, certFile, keyFile string, handler http.Handler) error
 		handler.ServeHTTP(w, r)
 	})
 
-	hErr := make(chan error)
-	qErr := make(chan error)
+	// the channels are buffered, such that the goroutine of the server that returns last doesn't block forever
+	hErr := make(chan error, 1)
+	qErr := make(chan error, 1)
 	go func() {
-		hErr <- httpServer.Serve(tcpConn)
+		// http2.ConfigureServer set the ALPN protocols of httpServer.TLSConfig
+		hErr <- httpServer.Serve(tls.NewListener(tcpConn, httpServer.TLSConfig))
 	}()
 	go func() {
 		qErr <- quicServer.Serve(udpConn)
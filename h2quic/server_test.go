@@ -1,16 +1,24 @@
 package h2quic
 
 import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"os"
 	"runtime"
+	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/hpack"
 
+	"github.com/lucas-clemente/quic-go"
 	"github.com/lucas-clemente/quic-go/protocol"
 	"github.com/lucas-clemente/quic-go/testdata"
 	"github.com/lucas-clemente/quic-go/utils"
@@ -20,17 +28,34 @@ import (
 )
 
 type mockSession struct {
-	closed     bool
-	dataStream *mockStream
+	closed       bool
+	goAwayCalled bool
+	dataStream   *mockStream
+	priorities   map[protocol.StreamID]protocol.StreamPriority
 }
 
 func (s *mockSession) GetOrOpenStream(id protocol.StreamID) (utils.Stream, error) {
 	return s.dataStream, nil
 }
+func (s *mockSession) SetStreamPriority(id protocol.StreamID, priority protocol.StreamPriority) error {
+	if s.priorities == nil {
+		s.priorities = make(map[protocol.StreamID]protocol.StreamPriority)
+	}
+	s.priorities[id] = priority
+	return nil
+}
+func (s *mockSession) GoAway()           { s.goAwayCalled = true }
 func (s *mockSession) Close(error) error { s.closed = true; return nil }
 func (s *mockSession) RemoteAddr() *net.UDPAddr {
 	return &net.UDPAddr{IP: []byte{127, 0, 0, 1}, Port: 42}
 }
+func (s *mockSession) ConnectionState() quic.ConnectionState {
+	return quic.ConnectionState{
+		Version:           protocol.Version36,
+		HandshakeComplete: true,
+		ServerName:        "www.example.com",
+	}
+}
 
 var _ = Describe("H2 server", func() {
 	var (
@@ -68,6 +93,8 @@ var _ = Describe("H2 server", func() {
 				defer GinkgoRecover()
 				Expect(r.Host).To(Equal("www.example.com"))
 				Expect(r.RemoteAddr).To(Equal("127.0.0.1:42"))
+				Expect(r.TLS.ServerName).To(Equal("www.example.com"))
+				Expect(r.TLS.HandshakeComplete).To(BeTrue())
 				handlerCalled = true
 			})
 			headerStream.Write([]byte{
@@ -75,7 +102,7 @@ var _ = Describe("H2 server", func() {
 				// Taken from https://http2.github.io/http2-spec/compression.html#request.examples.with.huffman.coding
 				0x82, 0x86, 0x84, 0x41, 0x8c, 0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff,
 			})
-			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
+			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, newHeaderEncoder(0), h2framer)
 			Expect(err).NotTo(HaveOccurred())
 			Eventually(func() bool { return handlerCalled }).Should(BeTrue())
 			Expect(dataStream.remoteClosed).To(BeTrue())
@@ -88,7 +115,7 @@ var _ = Describe("H2 server", func() {
 				// Taken from https://http2.github.io/http2-spec/compression.html#request.examples.with.huffman.coding
 				0x82, 0x86, 0x84, 0x41, 0x8c, 0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff,
 			})
-			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
+			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, newHeaderEncoder(0), h2framer)
 			Expect(err).NotTo(HaveOccurred())
 			Eventually(func() []byte {
 				return headerStream.Buffer.Bytes()
@@ -104,11 +131,11 @@ var _ = Describe("H2 server", func() {
 				// Taken from https://http2.github.io/http2-spec/compression.html#request.examples.with.huffman.coding
 				0x82, 0x86, 0x84, 0x41, 0x8c, 0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff,
 			})
-			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
+			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, newHeaderEncoder(0), h2framer)
 			Expect(err).NotTo(HaveOccurred())
-			Eventually(func() []byte {
-				return headerStream.Buffer.Bytes()
-			}).Should(Equal([]byte{0x0, 0x0, 0x1, 0x1, 0x4, 0x0, 0x0, 0x0, 0x5, 0x8e})) // 0x82 is 500
+			Eventually(func() bool { return dataStream.reset }).Should(BeTrue())
+			Expect(headerStream.Buffer.Bytes()).To(BeEmpty())
+			Expect(session.closed).To(BeFalse())
 		})
 
 		It("does not close the dataStream when end of stream is not set", func() {
@@ -122,22 +149,227 @@ var _ = Describe("H2 server", func() {
 				// Taken from https://http2.github.io/http2-spec/compression.html#request.examples.with.huffman.coding
 				0x82, 0x86, 0x84, 0x41, 0x8c, 0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff,
 			})
-			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
+			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, newHeaderEncoder(0), h2framer)
 			Expect(err).NotTo(HaveOccurred())
 			Eventually(func() bool { return handlerCalled }).Should(BeTrue())
 			Expect(dataStream.remoteClosed).To(BeFalse())
 		})
 
+		Context("Expect: 100-continue", func() {
+			writeRequest := func() {
+				var headerBlock bytes.Buffer
+				enc := hpack.NewEncoder(&headerBlock)
+				enc.WriteField(hpack.HeaderField{Name: ":method", Value: "POST"})
+				enc.WriteField(hpack.HeaderField{Name: ":path", Value: "/"})
+				enc.WriteField(hpack.HeaderField{Name: ":authority", Value: "www.example.com"})
+				enc.WriteField(hpack.HeaderField{Name: "expect", Value: "100-continue"})
+				err := http2.NewFramer(headerStream, nil).WriteHeaders(http2.HeadersFrameParam{
+					StreamID:      5,
+					BlockFragment: headerBlock.Bytes(),
+					EndHeaders:    true,
+				})
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			readStatus := func() string {
+				frame, err := h2framer.ReadFrame()
+				Expect(err).NotTo(HaveOccurred())
+				fields, err := hpack.NewDecoder(4096, nil).DecodeFull(frame.(*http2.HeadersFrame).HeaderBlockFragment())
+				Expect(err).NotTo(HaveOccurred())
+				return fields[0].Value
+			}
+
+			It("sends 100 Continue when the handler reads the body", func() {
+				var handlerDone bool
+				dataStream.Write([]byte("foobar"))
+				s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					defer GinkgoRecover()
+					Expect(r.Header).ToNot(HaveKey("Expect"))
+					body := make([]byte, 6)
+					_, err := io.ReadFull(r.Body, body)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(body).To(Equal([]byte("foobar")))
+					w.WriteHeader(http.StatusCreated)
+					handlerDone = true
+				})
+				writeRequest()
+				err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, newHeaderEncoder(0), h2framer)
+				Expect(err).NotTo(HaveOccurred())
+				Eventually(func() bool { return handlerDone }).Should(BeTrue())
+				Expect(readStatus()).To(Equal("100"))
+				Expect(readStatus()).To(Equal("201"))
+			})
+
+			It("doesn't send 100 Continue when the handler doesn't read the body", func() {
+				var handlerDone bool
+				s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusForbidden)
+					handlerDone = true
+				})
+				writeRequest()
+				err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, newHeaderEncoder(0), h2framer)
+				Expect(err).NotTo(HaveOccurred())
+				Eventually(func() bool { return handlerDone }).Should(BeTrue())
+				Expect(readStatus()).To(Equal("403"))
+			})
+		})
+
+		It("rejects requests with headers exceeding MaxHeaderBytes", func() {
+			var handlerCalled bool
+			s.Server.MaxHeaderBytes = 100
+			s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				handlerCalled = true
+			})
+			var headerBlock bytes.Buffer
+			enc := hpack.NewEncoder(&headerBlock)
+			enc.WriteField(hpack.HeaderField{Name: ":method", Value: "GET"})
+			enc.WriteField(hpack.HeaderField{Name: ":path", Value: "/"})
+			enc.WriteField(hpack.HeaderField{Name: ":authority", Value: "www.example.com"})
+			enc.WriteField(hpack.HeaderField{Name: "cookie", Value: strings.Repeat("a", 50)})
+			err := http2.NewFramer(headerStream, nil).WriteHeaders(http2.HeadersFrameParam{
+				StreamID:      5,
+				BlockFragment: headerBlock.Bytes(),
+				EndHeaders:    true,
+				EndStream:     true,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			err = s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, newHeaderEncoder(0), h2framer)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(handlerCalled).To(BeFalse())
+			frame, err := h2framer.ReadFrame()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(frame).To(BeAssignableToTypeOf(&http2.HeadersFrame{}))
+			Expect(frame.Header().StreamID).To(Equal(uint32(5)))
+			fields, err := hpack.NewDecoder(4096, nil).DecodeFull(frame.(*http2.HeadersFrame).HeaderBlockFragment())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fields).To(ContainElement(hpack.HeaderField{Name: ":status", Value: "431"}))
+		})
+
+		It("rejects requests with a single header value exceeding MaxHeaderBytes, and keeps the header stream usable", func() {
+			var handlerCalled bool
+			s.Server.MaxHeaderBytes = 200
+			s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				handlerCalled = true
+			})
+			var headerBlock bytes.Buffer
+			enc := hpack.NewEncoder(&headerBlock)
+			enc.WriteField(hpack.HeaderField{Name: ":method", Value: "GET"})
+			enc.WriteField(hpack.HeaderField{Name: ":path", Value: "/"})
+			enc.WriteField(hpack.HeaderField{Name: ":authority", Value: "www.example.com"})
+			enc.WriteField(hpack.HeaderField{Name: "cookie", Value: strings.Repeat("a", 300)})
+			err := http2.NewFramer(headerStream, nil).WriteHeaders(http2.HeadersFrameParam{
+				StreamID:      5,
+				BlockFragment: headerBlock.Bytes(),
+				EndHeaders:    true,
+				EndStream:     true,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			err = s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, newHeaderEncoder(0), h2framer)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(handlerCalled).To(BeFalse())
+			frame, err := h2framer.ReadFrame()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(frame).To(BeAssignableToTypeOf(&http2.HeadersFrame{}))
+			fields, err := hpack.NewDecoder(4096, nil).DecodeFull(frame.(*http2.HeadersFrame).HeaderBlockFragment())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fields).To(ContainElement(hpack.HeaderField{Name: ":status", Value: "431"}))
+			// the next request is decoded and handled
+			headerBlock.Reset()
+			enc.WriteField(hpack.HeaderField{Name: ":method", Value: "GET"})
+			enc.WriteField(hpack.HeaderField{Name: ":path", Value: "/"})
+			enc.WriteField(hpack.HeaderField{Name: ":authority", Value: "www.example.com"})
+			err = http2.NewFramer(headerStream, nil).WriteHeaders(http2.HeadersFrameParam{
+				StreamID:      7,
+				BlockFragment: headerBlock.Bytes(),
+				EndHeaders:    true,
+				EndStream:     true,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			err = s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, newHeaderEncoder(0), h2framer)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(func() bool { return handlerCalled }).Should(BeTrue())
+		})
+
+		It("uses the default maximum header list size", func() {
+			Expect(s.maxHeaderListSize()).To(Equal(uint32(http.DefaultMaxHeaderBytes)))
+		})
+
+		It("sets the priority of the data stream from the HEADERS frame", func() {
+			s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+			headerBlock := []byte{
+				// Taken from https://http2.github.io/http2-spec/compression.html#request.examples.with.huffman.coding
+				0x82, 0x86, 0x84, 0x41, 0x8c, 0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff,
+			}
+			err := http2.NewFramer(headerStream, nil).WriteHeaders(http2.HeadersFrameParam{
+				StreamID:      5,
+				BlockFragment: headerBlock,
+				EndHeaders:    true,
+				EndStream:     true,
+				Priority:      http2.PriorityParam{Weight: 255},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			err = s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, newHeaderEncoder(0), h2framer)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(session.priorities).To(HaveKeyWithValue(protocol.StreamID(5), protocol.HighestStreamPriority))
+		})
+
+		It("handles PRIORITY frames", func() {
+			err := http2.NewFramer(headerStream, nil).WritePriority(5, http2.PriorityParam{Weight: 0})
+			Expect(err).NotTo(HaveOccurred())
+			err = s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, newHeaderEncoder(0), h2framer)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(session.priorities).To(HaveKeyWithValue(protocol.StreamID(5), protocol.LowestStreamPriority))
+		})
+
+		It("maps HTTP/2 weights to stream priorities", func() {
+			Expect(priorityFromH2Weight(255)).To(Equal(protocol.HighestStreamPriority))
+			Expect(priorityFromH2Weight(128)).To(Equal(protocol.StreamPriority(3)))
+			Expect(priorityFromH2Weight(15)).To(Equal(protocol.LowestStreamPriority))
+			Expect(priorityFromH2Weight(0)).To(Equal(protocol.LowestStreamPriority))
+		})
+
+		It("applies the header table size from SETTINGS frames", func() {
+			err := http2.NewFramer(headerStream, nil).WriteSettings(http2.Setting{ID: http2.SettingHeaderTableSize, Val: 0})
+			Expect(err).NotTo(HaveOccurred())
+			encoder := newHeaderEncoder(0)
+			err = s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, encoder, h2framer)
+			Expect(err).NotTo(HaveOccurred())
+			// the next header block starts with a dynamic table size update
+			Expect(encoder.encode([]hpack.HeaderField{{Name: ":status", Value: "200"}})).To(Equal([]byte{0x20, 0x88}))
+		})
+
 		It("errors when non-header frames are received", func() {
 			headerStream.Write([]byte{
 				0x0, 0x0, 0x06, 0x0, 0x0, 0x0, 0x0, 0x0, 0x5,
 				'f', 'o', 'o', 'b', 'a', 'r',
 			})
-			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
+			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, newHeaderEncoder(0), h2framer)
 			Expect(err).To(MatchError("InvalidHeadersStreamData: expected a header frame"))
 		})
 	})
 
+	Context("advertising settings", func() {
+		It("doesn't send a SETTINGS frame by default", func() {
+			headerStream := &mockStream{id: 3}
+			err := s.writeSettings(headerStream, &sync.Mutex{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(headerStream.Len()).To(BeZero())
+		})
+
+		It("advertises the decoder header table size", func() {
+			s.MaxDecoderHeaderTableSize = 1024
+			headerStream := &mockStream{id: 3}
+			err := s.writeSettings(headerStream, &sync.Mutex{})
+			Expect(err).NotTo(HaveOccurred())
+			frame, err := http2.NewFramer(nil, headerStream).ReadFrame()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(frame).To(BeAssignableToTypeOf(&http2.SettingsFrame{}))
+			size, ok := frame.(*http2.SettingsFrame).Value(http2.SettingHeaderTableSize)
+			Expect(ok).To(BeTrue())
+			Expect(size).To(Equal(uint32(1024)))
+		})
+	})
+
 	It("handles the header stream", func() {
 		var handlerCalled bool
 		s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -315,9 +547,120 @@ var _ = Describe("H2 server", func() {
 		}, 0.5)
 	})
 
-	It("closes gracefully", func() {
-		err := s.CloseGracefully(0)
-		Expect(err).NotTo(HaveOccurred())
+	Context("serving TLS and QUIC", func() {
+		It("serves HTTP/2 over TLS, and sets the Alt-Svc header", func() {
+			tcpConn, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+			Expect(err).ToNot(HaveOccurred())
+			defer tcpConn.Close()
+			port := tcpConn.Addr().(*net.TCPAddr).Port
+			udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+			Expect(err).ToNot(HaveOccurred())
+			defer udpConn.Close()
+			cert, err := tls.LoadX509KeyPair(testdata.GetCertificatePaths())
+			Expect(err).ToNot(HaveOccurred())
+			config := &tls.Config{Certificates: []tls.Certificate{cert}}
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				_ = serveTLSAndQUIC(tcpConn, udpConn, config, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Write([]byte("foobar"))
+				}))
+				close(done)
+			}()
+			transport := &http2.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+			defer transport.CloseIdleConnections()
+			client := &http.Client{Transport: transport}
+			rsp, err := client.Get("https://" + tcpConn.Addr().String())
+			Expect(err).ToNot(HaveOccurred())
+			defer rsp.Body.Close()
+			Expect(rsp.ProtoMajor).To(Equal(2))
+			Expect(rsp.Header.Get("Alt-Svc")).To(HavePrefix(fmt.Sprintf(`quic=":%d"`, port)))
+			body, err := ioutil.ReadAll(rsp.Body)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(body).To(Equal([]byte("foobar")))
+			// closing the TCP listener stops both servers
+			tcpConn.Close()
+			Eventually(done).Should(BeClosed())
+		})
+
+		It("errors if the certificate can't be loaded", func() {
+			err := ListenAndServe("127.0.0.1:0", "invalid", "invalid", nil)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("closing gracefully", func() {
+		requestFrame := []byte{
+			0x0, 0x0, 0x11, 0x1, 0x4, 0x0, 0x0, 0x0, 0x5,
+			// Taken from https://http2.github.io/http2-spec/compression.html#request.examples.with.huffman.coding
+			0x82, 0x86, 0x84, 0x41, 0x8c, 0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff,
+		}
+
+		It("closes gracefully", func() {
+			err := s.CloseGracefully(0)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("may only be called once", func() {
+			err := s.CloseGracefully(0)
+			Expect(err).NotTo(HaveOccurred())
+			err = s.CloseGracefully(0)
+			Expect(err).To(MatchError("CloseGracefully may only be called once"))
+		})
+
+		It("sends a GOAWAY on all sessions", func() {
+			session2 := &mockSession{}
+			s.sessions = map[streamCreator]struct{}{session: {}, session2: {}}
+			err := s.CloseGracefully(time.Second)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(session.goAwayCalled).To(BeTrue())
+			Expect(session2.goAwayCalled).To(BeTrue())
+		})
+
+		It("sends a GOAWAY on sessions that are established while closing", func() {
+			err := s.CloseGracefully(0)
+			Expect(err).NotTo(HaveOccurred())
+			s.handleStream(session, &mockStream{id: 3})
+			Expect(session.goAwayCalled).To(BeTrue())
+		})
+
+		It("waits for running requests to complete", func() {
+			unblock := make(chan struct{})
+			handlerCalled := make(chan struct{})
+			s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				close(handlerCalled)
+				<-unblock
+			})
+			headerStream := &mockStream{}
+			headerStream.Write(requestFrame)
+			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpack.NewDecoder(4096, nil), newHeaderEncoder(0), http2.NewFramer(nil, headerStream))
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(handlerCalled).Should(BeClosed())
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				err := s.CloseGracefully(time.Hour)
+				Expect(err).NotTo(HaveOccurred())
+				close(done)
+			}()
+			Consistently(done).ShouldNot(BeClosed())
+			close(unblock)
+			Eventually(done).Should(BeClosed())
+		})
+
+		It("returns after the timeout, even if requests are still running", func() {
+			unblock := make(chan struct{})
+			defer close(unblock)
+			s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				<-unblock
+			})
+			headerStream := &mockStream{}
+			headerStream.Write(requestFrame)
+			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpack.NewDecoder(4096, nil), newHeaderEncoder(0), http2.NewFramer(nil, headerStream))
+			Expect(err).NotTo(HaveOccurred())
+			err = s.CloseGracefully(10 * time.Millisecond)
+			Expect(err).NotTo(HaveOccurred())
+		})
 	})
 
 	It("at least errors in global ListenAndServeQUIC", func() {
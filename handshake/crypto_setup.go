@@ -20,6 +20,18 @@ type KeyDerivationFunction func(forwardSecure bool, sharedSecret, nonces []byte,
 // KeyExchangeFunction is used to make a new KEX
 type KeyExchangeFunction func() crypto.KeyExchange
 
+// ConnectionState records basic details about the crypto handshake
+type ConnectionState struct {
+	// HandshakeComplete is true once the first forward secure packet was received
+	HandshakeComplete bool
+	// ServerName is the server name requested by the client (SNI)
+	ServerName string
+	// AEAD is the tag of the negotiated AEAD, e.g. AESG
+	AEAD string
+	// KeyExchange is the tag of the negotiated key exchange algorithm, e.g. C255
+	KeyExchange string
+}
+
 // The CryptoSetup handles all things crypto for the Session
 type CryptoSetup struct {
 	connID               protocol.ConnectionID
@@ -34,8 +46,18 @@ type CryptoSetup struct {
 	receivedSecurePacket        bool
 	aeadChanged                 chan struct{}
 
-	keyDerivation KeyDerivationFunction
-	keyExchange   KeyExchangeFunction
+	// rejServerNonce is the server nonce sent in REJs.
+	// A CHLO that echoes it was sent after a round trip, so it is not a 0-RTT handshake that could be replayed.
+	rejServerNonce []byte
+
+	// set once the full CHLO was processed
+	sni  string
+	aead string
+	kexs string
+
+	// keyDerivations are the key derivation functions of the supported AEADs
+	keyDerivations map[Tag]KeyDerivationFunction
+	keyExchange    KeyExchangeFunction
 
 	cryptoStream utils.Stream
 
@@ -61,11 +83,14 @@ func NewCryptoSetup(
 		ip:                   ip,
 		version:              version,
 		scfg:                 scfg,
-		keyDerivation:        crypto.DeriveKeysAESGCM,
 		keyExchange:          getEphermalKEX,
 		cryptoStream:         cryptoStream,
 		connectionParameters: connectionParameters,
 		aeadChanged:          aeadChanged,
+		keyDerivations: map[Tag]KeyDerivationFunction{
+			TagAESG: crypto.DeriveKeysAESGCM,
+			TagCC20: crypto.DeriveKeysChacha20,
+		},
 	}, nil
 }
 
@@ -75,6 +100,11 @@ func (h *CryptoSetup) HandleCryptoStream() error {
 		var chloData bytes.Buffer
 		messageTag, cryptoData, err := ParseHandshakeMessage(io.TeeReader(h.cryptoStream, &chloData))
 		if err != nil {
+			// malformed messages are reported with their own error code
+			switch err.(type) {
+			case *qerr.QuicError, qerr.ErrorCode:
+				return err
+			}
 			return qerr.HandshakeFailed
 		}
 		if messageTag != TagCHLO {
@@ -121,8 +151,8 @@ func (h *CryptoSetup) handleMessage(chloData []byte, cryptoData map[Tag][]byte)
 
 	var reply []byte
 	var err error
-	if !h.isInchoateCHLO(cryptoData) {
-		// We have a CHLO with a proper server config ID, do a 0-RTT handshake
+	if !h.isInchoateCHLO(cryptoData) && (h.echoesServerNonce(cryptoData) || h.acceptEarlyData(sni) && !h.isReplayedCHLO(cryptoData)) {
+		// We have a CHLO with a proper server config ID, do a 0-RTT handshake (or complete the 1-RTT handshake, if it echoes our server nonce)
 		reply, err = h.handleCHLO(sni, chloData, cryptoData)
 		if err != nil {
 			return false, err
@@ -134,7 +164,7 @@ func (h *CryptoSetup) handleMessage(chloData []byte, cryptoData map[Tag][]byte)
 		return true, nil
 	}
 
-	// We have an inchoate or non-matching CHLO, we now send a rejection
+	// We have an inchoate, non-matching or replayed CHLO, or a client that is not allowed to do a 0-RTT handshake, we now send a rejection
 	reply, err = h.handleInchoateCHLO(sni, chloData, cryptoData)
 	if err != nil {
 		return false, err
@@ -200,6 +230,37 @@ func (h *CryptoSetup) isInchoateCHLO(cryptoData map[Tag][]byte) bool {
 	return false
 }
 
+// echoesServerNonce checks if the CHLO contains the server nonce sent in a REJ on this connection.
+// Neither the replay filter nor AcceptEarlyData apply to such a CHLO, otherwise a rejected client could never complete the handshake.
+func (h *CryptoSetup) echoesServerNonce(cryptoData map[Tag][]byte) bool {
+	return len(h.rejServerNonce) > 0 && bytes.Equal(cryptoData[TagSNO], h.rejServerNonce)
+}
+
+// acceptEarlyData asks the application if the client is allowed to do a 0-RTT handshake.
+func (h *CryptoSetup) acceptEarlyData(sni string) bool {
+	if h.scfg.AcceptEarlyData == nil {
+		return true
+	}
+	if !h.scfg.AcceptEarlyData(h.ip, sni) {
+		utils.Infof("Rejecting early data from %s", h.ip)
+		return false
+	}
+	return true
+}
+
+// isReplayedCHLO checks if the client nonce was already used for a 0-RTT handshake.
+// A replayed CHLO is rejected. A legitimate client then completes the handshake by echoing the server nonce of the REJ.
+func (h *CryptoSetup) isReplayedCHLO(cryptoData map[Tag][]byte) bool {
+	if h.scfg.ReplayFilter == nil {
+		return false
+	}
+	if h.scfg.ReplayFilter.Replayed(cryptoData[TagNONC]) {
+		utils.Infof("Rejecting replayed CHLO")
+		return true
+	}
+	return false
+}
+
 func (h *CryptoSetup) handleInchoateCHLO(sni string, chlo []byte, cryptoData map[Tag][]byte) ([]byte, error) {
 	if len(chlo) < protocol.ClientHelloMinimumSize {
 		return nil, qerr.Error(qerr.CryptoInvalidValueLength, "CHLO too small")
@@ -207,27 +268,38 @@ func (h *CryptoSetup) handleInchoateCHLO(sni string, chlo []byte, cryptoData map
 
 	token, err := h.scfg.stkSource.NewToken(h.ip)
 	if err != nil {
-		return nil, err
+		return nil, cryptoError(qerr.CryptoInternalError, err)
+	}
+
+	if h.rejServerNonce == nil {
+		h.rejServerNonce = make([]byte, 32)
+		if _, err = rand.Read(h.rejServerNonce); err != nil {
+			return nil, cryptoError(qerr.CryptoInternalError, err)
+		}
 	}
 
 	replyMap := map[Tag][]byte{
 		TagSCFG: h.scfg.Get(),
 		TagSTK:  token,
 		TagSVID: []byte("quic-go"),
+		TagSNO:  h.rejServerNonce,
 	}
 
 	if h.scfg.stkSource.VerifyToken(h.ip, cryptoData[TagSTK]) == nil {
 		proof, err := h.scfg.Sign(sni, chlo)
 		if err != nil {
-			return nil, err
+			return nil, cryptoError(qerr.CryptoInternalError, err)
 		}
 
 		commonSetHashes := cryptoData[TagCCS]
 		cachedCertsHashes := cryptoData[TagCCRT]
+		if len(commonSetHashes)%8 != 0 || len(cachedCertsHashes)%8 != 0 {
+			return nil, qerr.Error(qerr.InvalidCryptoMessageParameter, "invalid CCS or CCRT length")
+		}
 
 		certCompressed, err := h.scfg.GetCertsCompressed(sni, commonSetHashes, cachedCertsHashes)
 		if err != nil {
-			return nil, err
+			return nil, cryptoError(qerr.CryptoInternalError, err)
 		}
 		// Token was valid, send more details
 		replyMap[TagPROF] = proof
@@ -243,25 +315,25 @@ func (h *CryptoSetup) handleCHLO(sni string, data []byte, cryptoData map[Tag][]b
 	// We have a CHLO matching our server config, we can continue with the 0-RTT handshake
 	sharedSecret, err := h.scfg.kex.CalculateSharedKey(cryptoData[TagPUBS])
 	if err != nil {
-		return nil, err
+		return nil, cryptoError(qerr.InvalidCryptoMessageParameter, err)
 	}
 
 	h.mutex.Lock()
 	defer h.mutex.Unlock()
 
-	certUncompressed, err := h.scfg.signer.GetLeafCert(sni)
+	certUncompressed, err := h.scfg.GetLeafCert(sni)
 	if err != nil {
-		return nil, err
+		return nil, cryptoError(qerr.CryptoInternalError, err)
 	}
 
 	serverNonce := make([]byte, 32)
 	if _, err = rand.Read(serverNonce); err != nil {
-		return nil, err
+		return nil, cryptoError(qerr.CryptoInternalError, err)
 	}
 
 	h.diversificationNonce = make([]byte, 32)
 	if _, err = rand.Read(h.diversificationNonce); err != nil {
-		return nil, err
+		return nil, cryptoError(qerr.CryptoInternalError, err)
 	}
 
 	clientNonce := cryptoData[TagNONC]
@@ -271,7 +343,8 @@ func (h *CryptoSetup) handleCHLO(sni string, data []byte, cryptoData map[Tag][]b
 	}
 
 	aead := cryptoData[TagAEAD]
-	if !bytes.Equal(aead, []byte("AESG")) {
+	keyDerivation := h.getKeyDerivation(aead)
+	if keyDerivation == nil {
 		return nil, qerr.Error(qerr.CryptoNoSupport, "Unsupported AEAD or KEXS")
 	}
 
@@ -280,10 +353,16 @@ func (h *CryptoSetup) handleCHLO(sni string, data []byte, cryptoData map[Tag][]b
 		return nil, qerr.Error(qerr.CryptoNoSupport, "Unsupported AEAD or KEXS")
 	}
 
-	h.secureAEAD, err = h.keyDerivation(
+	// if the client echoes a server nonce, it is used for the initial keys as well
+	initialNonces := clientNonce
+	if sno := cryptoData[TagSNO]; len(sno) > 0 {
+		initialNonces = append(append([]byte{}, clientNonce...), sno...)
+	}
+
+	h.secureAEAD, err = keyDerivation(
 		false,
 		sharedSecret,
-		clientNonce,
+		initialNonces,
 		h.connID,
 		data,
 		h.scfg.Get(),
@@ -291,7 +370,7 @@ func (h *CryptoSetup) handleCHLO(sni string, data []byte, cryptoData map[Tag][]b
 		h.diversificationNonce,
 	)
 	if err != nil {
-		return nil, err
+		return nil, cryptoError(qerr.CryptoSymmetricKeySetupFailed, err)
 	}
 
 	// Generate a new curve instance to derive the forward secure key
@@ -301,10 +380,10 @@ func (h *CryptoSetup) handleCHLO(sni string, data []byte, cryptoData map[Tag][]b
 	ephermalKex := h.keyExchange()
 	ephermalSharedSecret, err := ephermalKex.CalculateSharedKey(cryptoData[TagPUBS])
 	if err != nil {
-		return nil, err
+		return nil, cryptoError(qerr.InvalidCryptoMessageParameter, err)
 	}
 
-	h.forwardSecureAEAD, err = h.keyDerivation(
+	h.forwardSecureAEAD, err = keyDerivation(
 		true,
 		ephermalSharedSecret,
 		fsNonce.Bytes(),
@@ -315,7 +394,7 @@ func (h *CryptoSetup) handleCHLO(sni string, data []byte, cryptoData map[Tag][]b
 		nil,
 	)
 	if err != nil {
-		return nil, err
+		return nil, cryptoError(qerr.CryptoSymmetricKeySetupFailed, err)
 	}
 
 	err = h.connectionParameters.SetFromMap(cryptoData)
@@ -323,11 +402,15 @@ func (h *CryptoSetup) handleCHLO(sni string, data []byte, cryptoData map[Tag][]b
 		return nil, err
 	}
 
+	h.sni = sni
+	h.aead = string(aead)
+	h.kexs = string(kexs)
+
 	replyMap := h.connectionParameters.GetSHLOMap()
 	// add crypto parameters
 	replyMap[TagPUBS] = ephermalKex.PublicKey()
 	replyMap[TagSNO] = serverNonce
-	replyMap[TagVER] = protocol.SupportedVersionsAsTags
+	replyMap[TagVER] = h.scfg.VersionTags()
 
 	var reply bytes.Buffer
 	WriteHandshakeMessage(&reply, TagSHLO, replyMap)
@@ -337,6 +420,18 @@ func (h *CryptoSetup) handleCHLO(sni string, data []byte, cryptoData map[Tag][]b
 	return reply.Bytes(), nil
 }
 
+// getKeyDerivation returns the key derivation function for the AEAD chosen by the client, or nil if it is not supported
+func (h *CryptoSetup) getKeyDerivation(aead []byte) KeyDerivationFunction {
+	if len(aead) != 4 {
+		return nil
+	}
+	tag := Tag(binary.LittleEndian.Uint32(aead))
+	if !h.scfg.supportsAEAD(tag) {
+		return nil
+	}
+	return h.keyDerivations[tag]
+}
+
 // DiversificationNonce returns a diversification nonce if required in the next packet to be Seal'ed. See LockForSealing()!
 func (h *CryptoSetup) DiversificationNonce() []byte {
 	if h.receivedForwardSecurePacket || h.secureAEAD == nil {
@@ -360,6 +455,19 @@ func (h *CryptoSetup) HandshakeComplete() bool {
 	return h.receivedForwardSecurePacket
 }
 
+// ConnectionState returns details about the crypto handshake.
+// ServerName, AEAD and KeyExchange are only set after the full CHLO has been processed.
+func (h *CryptoSetup) ConnectionState() ConnectionState {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return ConnectionState{
+		HandshakeComplete: h.receivedForwardSecurePacket,
+		ServerName:        h.sni,
+		AEAD:              h.aead,
+		KeyExchange:       h.kexs,
+	}
+}
+
 func (h *CryptoSetup) validateClientNonce(nonce []byte) error {
 	if len(nonce) != 32 {
 		return qerr.Error(qerr.InvalidCryptoMessageParameter, "invalid client nonce length")
@@ -369,3 +477,13 @@ func (h *CryptoSetup) validateClientNonce(nonce []byte) error {
 	}
 	return nil
 }
+
+// cryptoError converts an error that occurred during the handshake into a QuicError with the given error code,
+// such that the peer isn't sent an InternalError. Errors that already carry an error code are returned unchanged.
+func cryptoError(errorCode qerr.ErrorCode, err error) error {
+	switch err.(type) {
+	case *qerr.QuicError, qerr.ErrorCode:
+		return err
+	}
+	return qerr.Error(errorCode, err.Error())
+}
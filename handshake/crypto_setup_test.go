@@ -5,10 +5,13 @@ import (
 	"encoding/binary"
 	"errors"
 	"net"
+	"testing"
+	"time"
 
 	"github.com/lucas-clemente/quic-go/crypto"
 	"github.com/lucas-clemente/quic-go/protocol"
 	"github.com/lucas-clemente/quic-go/qerr"
+	"github.com/lucas-clemente/quic-go/testdata"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -16,6 +19,7 @@ import (
 
 type mockKEX struct {
 	ephermal bool
+	err      error
 }
 
 func (m *mockKEX) PublicKey() []byte {
@@ -26,6 +30,9 @@ func (m *mockKEX) PublicKey() []byte {
 }
 
 func (m *mockKEX) CalculateSharedKey(otherPublic []byte) ([]byte, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
 	if m.ephermal {
 		return []byte("shared ephermal"), nil
 	}
@@ -34,9 +41,13 @@ func (m *mockKEX) CalculateSharedKey(otherPublic []byte) ([]byte, error) {
 
 type mockSigner struct {
 	gotCHLO bool
+	err     error
 }
 
 func (s *mockSigner) SignServerProof(sni string, chlo []byte, serverConfigData []byte) ([]byte, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
 	if len(chlo) > 0 {
 		s.gotCHLO = true
 	}
@@ -49,6 +60,16 @@ func (*mockSigner) GetLeafCert(sni string) ([]byte, error) {
 	return []byte("certuncompressed"), nil
 }
 
+type mockReplayFilter struct {
+	replayed bool
+	nonces   [][]byte
+}
+
+func (f *mockReplayFilter) Replayed(nonce []byte) bool {
+	f.nonces = append(f.nonces, nonce)
+	return f.replayed
+}
+
 type mockAEAD struct {
 	forwardSecure bool
 	sharedSecret  []byte
@@ -109,7 +130,9 @@ func (s *mockStream) Write(p []byte) (int, error) {
 }
 
 func (s *mockStream) Close() error                       { panic("not implemented") }
+func (s *mockStream) Flush() error                       { panic("not implemented") }
 func (mockStream) CloseRemote(offset protocol.ByteCount) { panic("not implemented") }
+func (mockStream) Reset(error)                           { panic("not implemented") }
 func (s mockStream) StreamID() protocol.StreamID         { panic("not implemented") }
 
 type mockStkSource struct{}
@@ -132,6 +155,10 @@ func (mockStkSource) VerifyToken(ip net.IP, token []byte) error {
 	return nil
 }
 
+func (mockStkSource) Rotate(secret []byte) error {
+	panic("not implemented")
+}
+
 var _ = Describe("Crypto setup", func() {
 	var (
 		kex         *mockKEX
@@ -173,7 +200,10 @@ var _ = Describe("Crypto setup", func() {
 		cpm = NewConnectionParamatersManager(protocol.Version36)
 		cs, err = NewCryptoSetup(protocol.ConnectionID(42), ip, v, scfg, stream, cpm, aeadChanged)
 		Expect(err).NotTo(HaveOccurred())
-		cs.keyDerivation = mockKeyDerivation
+		cs.keyDerivations = map[Tag]KeyDerivationFunction{
+			TagAESG: mockKeyDerivation,
+			TagCC20: mockKeyDerivation,
+		}
 		cs.keyExchange = func() crypto.KeyExchange { return &mockKEX{ephermal: true} }
 	})
 
@@ -244,7 +274,7 @@ var _ = Describe("Crypto setup", func() {
 			Expect(response).To(HavePrefix("SHLO"))
 			Expect(response).To(ContainSubstring("ephermal pub"))
 			Expect(response).To(ContainSubstring("SNO\x00"))
-			Expect(response).To(ContainSubstring(string(protocol.SupportedVersionsAsTags)))
+			Expect(response).To(ContainSubstring(string(scfg.VersionTags())))
 			Expect(cs.secureAEAD).ToNot(BeNil())
 			Expect(cs.secureAEAD.(*mockAEAD).forwardSecure).To(BeFalse())
 			Expect(cs.secureAEAD.(*mockAEAD).sharedSecret).To(Equal([]byte("shared key")))
@@ -253,6 +283,53 @@ var _ = Describe("Crypto setup", func() {
 			Expect(cs.forwardSecureAEAD.(*mockAEAD).forwardSecure).To(BeTrue())
 		})
 
+		It("reports the connection state", func() {
+			Expect(cs.ConnectionState()).To(Equal(ConnectionState{}))
+			_, err := cs.handleCHLO("quic.clemente.io", []byte("chlo-data"), map[Tag][]byte{
+				TagPUBS: []byte("pubs-c"),
+				TagNONC: nonce32,
+				TagAEAD: aead,
+				TagKEXS: kexs,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			cs.receivedForwardSecurePacket = true
+			Expect(cs.ConnectionState()).To(Equal(ConnectionState{
+				HandshakeComplete: true,
+				ServerName:        "quic.clemente.io",
+				AEAD:              "AESG",
+				KeyExchange:       "C255",
+			}))
+		})
+
+		It("uses ChaCha20-Poly1305 if the client chooses it", func() {
+			scfg.AEADs = []Tag{TagAESG, TagCC20}
+			var usedAEAD string
+			cs.keyDerivations[TagCC20] = func(forwardSecure bool, sharedSecret, nonces []byte, connID protocol.ConnectionID, chlo []byte, scfg []byte, cert []byte, divNonce []byte) (crypto.AEAD, error) {
+				usedAEAD = "CC20"
+				return mockKeyDerivation(forwardSecure, sharedSecret, nonces, connID, chlo, scfg, cert, divNonce)
+			}
+			_, err := cs.handleCHLO("quic.clemente.io", []byte("chlo-data"), map[Tag][]byte{
+				TagPUBS: []byte("pubs-c"),
+				TagNONC: nonce32,
+				TagAEAD: []byte("CC20"),
+				TagKEXS: kexs,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(usedAEAD).To(Equal("CC20"))
+			Expect(cs.ConnectionState().AEAD).To(Equal("CC20"))
+		})
+
+		It("rejects AEADs that the server doesn't offer", func() {
+			scfg.AEADs = []Tag{TagAESG}
+			_, err := cs.handleCHLO("quic.clemente.io", []byte("chlo-data"), map[Tag][]byte{
+				TagPUBS: []byte("pubs-c"),
+				TagNONC: nonce32,
+				TagAEAD: []byte("CC20"),
+				TagKEXS: kexs,
+			})
+			Expect(err).To(MatchError(qerr.Error(qerr.CryptoNoSupport, "Unsupported AEAD or KEXS")))
+		})
+
 		It("handles long handshake", func() {
 			WriteHandshakeMessage(&stream.dataToRead, TagCHLO, map[Tag][]byte{
 				TagSNI: []byte("quic.clemente.io"),
@@ -322,6 +399,158 @@ var _ = Describe("Crypto setup", func() {
 			Expect(aeadChanged).To(Receive())
 		})
 
+		Context("accepting 0-RTT handshakes", func() {
+			var replayFilter *mockReplayFilter
+
+			// rejServerNonce parses the REJ written to the stream, and returns its server nonce
+			rejServerNonce := func() []byte {
+				tag, msg, err := ParseHandshakeMessage(bytes.NewReader(stream.dataWritten.Bytes()))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(tag).To(Equal(TagREJ))
+				Expect(msg).To(HaveKey(TagSNO))
+				return msg[TagSNO]
+			}
+
+			// writeCHLOWithServerNonce writes a full CHLO that echoes the server nonce
+			writeCHLOWithServerNonce := func(sno []byte) {
+				stream.dataWritten.Reset()
+				WriteHandshakeMessage(&stream.dataToRead, TagCHLO, map[Tag][]byte{
+					TagPAD:  bytes.Repeat([]byte{'a'}, protocol.ClientHelloMinimumSize),
+					TagSCID: scfg.ID,
+					TagSNI:  []byte("quic.clemente.io"),
+					TagNONC: nonce32,
+					TagSNO:  sno,
+					TagSTK:  validSTK,
+					TagAEAD: aead,
+					TagKEXS: kexs,
+					TagPUBS: nil,
+					TagVER:  versionTag,
+				})
+			}
+
+			BeforeEach(func() {
+				replayFilter = &mockReplayFilter{}
+				scfg.ReplayFilter = replayFilter
+				WriteHandshakeMessage(&stream.dataToRead, TagCHLO, map[Tag][]byte{
+					TagPAD:  bytes.Repeat([]byte{'a'}, protocol.ClientHelloMinimumSize),
+					TagSCID: scfg.ID,
+					TagSNI:  []byte("quic.clemente.io"),
+					TagNONC: nonce32,
+					TagSTK:  validSTK,
+					TagAEAD: aead,
+					TagKEXS: kexs,
+					TagPUBS: nil,
+					TagVER:  versionTag,
+				})
+			})
+
+			It("passes the client nonce to the replay filter", func() {
+				err := cs.HandleCryptoStream()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(stream.dataWritten.Bytes()).To(HavePrefix("SHLO"))
+				Expect(replayFilter.nonces).To(Equal([][]byte{nonce32}))
+			})
+
+			It("rejects replayed CHLOs", func() {
+				replayFilter.replayed = true
+				// the CHLO is rejected and HandleCryptoStream waits for the next CHLO
+				err := cs.HandleCryptoStream()
+				Expect(err).To(MatchError(qerr.HandshakeFailed))
+				Expect(stream.dataWritten.Bytes()).To(HavePrefix("REJ"))
+				Expect(stream.dataWritten.Bytes()).ToNot(ContainSubstring("SHLO"))
+				Expect(aeadChanged).ToNot(Receive())
+			})
+
+			It("asks the application if early data is accepted", func() {
+				var ip net.IP
+				var sni string
+				scfg.AcceptEarlyData = func(i net.IP, s string) bool {
+					ip = i
+					sni = s
+					return true
+				}
+				err := cs.HandleCryptoStream()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(stream.dataWritten.Bytes()).To(HavePrefix("SHLO"))
+				Expect(ip).To(Equal(net.ParseIP("1.2.3.4")))
+				Expect(sni).To(Equal("quic.clemente.io"))
+			})
+
+			It("rejects CHLOs if the application doesn't accept early data", func() {
+				scfg.AcceptEarlyData = func(net.IP, string) bool { return false }
+				err := cs.HandleCryptoStream()
+				Expect(err).To(MatchError(qerr.HandshakeFailed))
+				Expect(stream.dataWritten.Bytes()).To(HavePrefix("REJ"))
+				Expect(stream.dataWritten.Bytes()).ToNot(ContainSubstring("SHLO"))
+				Expect(aeadChanged).ToNot(Receive())
+				// the nonce was not used, so the client can use it for the next CHLO
+				Expect(replayFilter.nonces).To(BeEmpty())
+			})
+
+			It("sends the same server nonce in every REJ", func() {
+				replayFilter.replayed = true
+				err := cs.HandleCryptoStream()
+				Expect(err).To(MatchError(qerr.HandshakeFailed))
+				sno := rejServerNonce()
+				Expect(sno).To(HaveLen(32))
+				writeCHLOWithServerNonce([]byte("foobar"))
+				err = cs.HandleCryptoStream()
+				Expect(err).To(MatchError(qerr.HandshakeFailed))
+				Expect(rejServerNonce()).To(Equal(sno))
+			})
+
+			It("completes the handshake for a replayed CHLO, if it echoes the server nonce of the REJ", func() {
+				replayFilter.replayed = true
+				err := cs.HandleCryptoStream()
+				Expect(err).To(MatchError(qerr.HandshakeFailed))
+				writeCHLOWithServerNonce(rejServerNonce())
+				// the server nonce is used for the initial keys
+				expectedInitialNonceLen = 64
+				err = cs.HandleCryptoStream()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(stream.dataWritten.Bytes()).To(HavePrefix("SHLO"))
+				Expect(aeadChanged).To(Receive())
+			})
+
+			It("completes the handshake if the application doesn't accept early data, if the CHLO echoes the server nonce of the REJ", func() {
+				scfg.AcceptEarlyData = func(net.IP, string) bool { return false }
+				err := cs.HandleCryptoStream()
+				Expect(err).To(MatchError(qerr.HandshakeFailed))
+				writeCHLOWithServerNonce(rejServerNonce())
+				expectedInitialNonceLen = 64
+				err = cs.HandleCryptoStream()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(stream.dataWritten.Bytes()).To(HavePrefix("SHLO"))
+				Expect(aeadChanged).To(Receive())
+			})
+
+			It("doesn't accept a replayed CHLO with a server nonce that wasn't sent on this connection", func() {
+				replayFilter.replayed = true
+				stream.dataToRead.Reset()
+				writeCHLOWithServerNonce(bytes.Repeat([]byte{'a'}, 32))
+				err := cs.HandleCryptoStream()
+				Expect(err).To(MatchError(qerr.HandshakeFailed))
+				Expect(stream.dataWritten.Bytes()).To(HavePrefix("REJ"))
+				Expect(aeadChanged).ToNot(Receive())
+			})
+
+			It("completes the handshake with a client that has a skewed clock", func() {
+				scfg.ReplayFilter = NewMemoryReplayFilter(protocol.DefaultReplayWindow)
+				// the timestamp of the client nonce is an hour ahead of the server's clock
+				binary.BigEndian.PutUint32(nonce32, uint32(time.Now().Add(time.Hour).Unix()))
+				stream.dataToRead.Reset()
+				writeCHLOWithServerNonce(nil)
+				err := cs.HandleCryptoStream()
+				Expect(err).To(MatchError(qerr.HandshakeFailed))
+				writeCHLOWithServerNonce(rejServerNonce())
+				expectedInitialNonceLen = 64
+				err = cs.HandleCryptoStream()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(stream.dataWritten.Bytes()).To(HavePrefix("SHLO"))
+				Expect(aeadChanged).To(Receive())
+			})
+		})
+
 		It("recognizes inchoate CHLOs missing SCID", func() {
 			Expect(cs.isInchoateCHLO(map[Tag][]byte{TagPUBS: nil, TagSTK: validSTK})).To(BeTrue())
 		})
@@ -503,6 +732,66 @@ var _ = Describe("Crypto setup", func() {
 		Expect(err).To(MatchError(qerr.InvalidCryptoMessageType))
 	})
 
+	It("errors with the error code of a malformed message", func() {
+		stream.dataToRead.Write([]byte("CHLO"))
+		stream.dataToRead.Write([]byte{0xff, 0xff, 0, 0})
+		err := cs.HandleCryptoStream()
+		Expect(err).To(MatchError(qerr.CryptoTooManyEntries))
+	})
+
+	Context("translating handshake failures", func() {
+		chloMap := func() map[Tag][]byte {
+			return map[Tag][]byte{
+				TagPUBS: []byte("pubs-c"),
+				TagNONC: nonce32,
+				TagAEAD: aead,
+				TagKEXS: kexs,
+			}
+		}
+
+		It("errors with InvalidCryptoMessageParameter if the shared key can't be calculated", func() {
+			kex.err = errors.New("invalid public value")
+			_, err := cs.handleCHLO("", []byte("chlo-data"), chloMap())
+			Expect(err).To(MatchError("InvalidCryptoMessageParameter: invalid public value"))
+		})
+
+		It("errors with InvalidCryptoMessageParameter if the forward secure shared key can't be calculated", func() {
+			cs.keyExchange = func() crypto.KeyExchange { return &mockKEX{err: errors.New("invalid public value")} }
+			_, err := cs.handleCHLO("", []byte("chlo-data"), chloMap())
+			Expect(err).To(MatchError("InvalidCryptoMessageParameter: invalid public value"))
+		})
+
+		It("errors with CryptoSymmetricKeySetupFailed if the key derivation fails", func() {
+			cs.keyDerivations[TagAESG] = func(bool, []byte, []byte, protocol.ConnectionID, []byte, []byte, []byte, []byte) (crypto.AEAD, error) {
+				return nil, errors.New("key derivation failed")
+			}
+			_, err := cs.handleCHLO("", []byte("chlo-data"), chloMap())
+			Expect(err).To(MatchError("CryptoSymmetricKeySetupFailed: key derivation failed"))
+		})
+
+		It("errors with CryptoInternalError if the proof can't be signed", func() {
+			signer.err = errors.New("signing failed")
+			_, err := cs.handleInchoateCHLO("", bytes.Repeat([]byte{'a'}, protocol.ClientHelloMinimumSize), map[Tag][]byte{
+				TagSTK: validSTK,
+			})
+			Expect(err).To(MatchError("CryptoInternalError: signing failed"))
+		})
+
+		It("errors with InvalidCryptoMessageParameter for invalid cached certificate hashes", func() {
+			_, err := cs.handleInchoateCHLO("", bytes.Repeat([]byte{'a'}, protocol.ClientHelloMinimumSize), map[Tag][]byte{
+				TagSTK:  validSTK,
+				TagCCRT: []byte("foo"),
+			})
+			Expect(err).To(MatchError("InvalidCryptoMessageParameter: invalid CCS or CCRT length"))
+		})
+
+		It("doesn't change errors that already have an error code", func() {
+			kex.err = qerr.Error(qerr.CryptoNoSupport, "foobar")
+			_, err := cs.handleCHLO("", []byte("chlo-data"), chloMap())
+			Expect(err).To(MatchError("CryptoNoSupport: foobar"))
+		})
+	})
+
 	Context("escalating crypto", func() {
 		foobarFNVSigned := []byte{0x18, 0x6f, 0x44, 0xba, 0x97, 0x35, 0xd, 0x6f, 0xbf, 0x64, 0x3c, 0x79, 0x66, 0x6f, 0x6f, 0x62, 0x61, 0x72}
 
@@ -626,3 +915,62 @@ var _ = Describe("Crypto setup", func() {
 		})
 	})
 })
+
+// BenchmarkHandshake measures the server side of a 1-RTT handshake, using real key exchange, signing and key derivation
+func BenchmarkHandshake(b *testing.B) {
+	kex, err := crypto.NewCurve25519KEX()
+	if err != nil {
+		b.Fatal(err)
+	}
+	signer, err := crypto.NewProofSource(testdata.GetTLSConfig())
+	if err != nil {
+		b.Fatal(err)
+	}
+	scfg, err := NewServerConfig(kex, signer)
+	if err != nil {
+		b.Fatal(err)
+	}
+	clientKex, err := crypto.NewCurve25519KEX()
+	if err != nil {
+		b.Fatal(err)
+	}
+	ip := net.ParseIP("1.2.3.4")
+	stk, err := scfg.stkSource.NewToken(ip)
+	if err != nil {
+		b.Fatal(err)
+	}
+	version := protocol.SupportedVersions[len(protocol.SupportedVersions)-1]
+	versionTag := make([]byte, 4)
+	binary.LittleEndian.PutUint32(versionTag, protocol.VersionNumberToTag(version))
+	nonce := make([]byte, 32)
+	copy(nonce[4:12], scfg.obit)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		stream := &mockStream{}
+		WriteHandshakeMessage(&stream.dataToRead, TagCHLO, map[Tag][]byte{
+			TagSNI: []byte("quic.clemente.io"),
+			TagSTK: stk,
+			TagPAD: bytes.Repeat([]byte{'a'}, protocol.ClientHelloMinimumSize),
+			TagVER: versionTag,
+		})
+		WriteHandshakeMessage(&stream.dataToRead, TagCHLO, map[Tag][]byte{
+			TagSCID: scfg.ID,
+			TagSNI:  []byte("quic.clemente.io"),
+			TagNONC: nonce,
+			TagSTK:  stk,
+			TagAEAD: []byte("AESG"),
+			TagKEXS: []byte("C255"),
+			TagPUBS: clientKex.PublicKey(),
+			TagVER:  versionTag,
+		})
+		cs, err := NewCryptoSetup(protocol.ConnectionID(42), ip, version, scfg, stream, NewConnectionParamatersManager(version), make(chan struct{}, 1))
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := cs.HandleCryptoStream(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package handshake
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/lucas-clemente/quic-go/protocol"
+)
+
+// A ReplayFilter detects replayed CHLOs.
+// Every client nonce must only be accepted once for a 0-RTT handshake, otherwise an attacker could replay the data sent along with the CHLO.
+// A ReplayFilter is shared by all sessions of a server, and must be safe for concurrent use.
+type ReplayFilter interface {
+	// Replayed records the client nonce, and returns true if it was recorded before.
+	// It also returns true if it can't decide if the nonce was used before, e.g. because it is too old.
+	Replayed(nonce []byte) bool
+}
+
+type memoryReplayFilter struct {
+	window time.Duration
+	// maxNonces is the maximum number of recorded nonces
+	maxNonces int
+
+	// the map contains the timestamp of every recorded nonce
+	nonces    map[string]time.Time
+	lastPrune time.Time
+	mutex     sync.Mutex
+
+	now func() time.Time // used in tests
+}
+
+var _ ReplayFilter = &memoryReplayFilter{}
+
+// NewMemoryReplayFilter creates a ReplayFilter that keeps all client nonces in memory.
+// The first 4 bytes of a client nonce contain the time it was created at. Only nonces created within window are accepted,
+// which limits the number of nonces that have to be remembered.
+// At most protocol.MaxReplayFilterNonces nonces are remembered. Once that many were accepted within the window, all nonces are rejected.
+// Rejected clients still complete the handshake, after one more round trip.
+func NewMemoryReplayFilter(window time.Duration) ReplayFilter {
+	return &memoryReplayFilter{
+		window:    window,
+		maxNonces: protocol.MaxReplayFilterNonces,
+		nonces:    make(map[string]time.Time),
+		now:       time.Now,
+	}
+}
+
+func (f *memoryReplayFilter) Replayed(nonce []byte) bool {
+	if len(nonce) < 4 {
+		return true
+	}
+	timestamp := time.Unix(int64(binary.BigEndian.Uint32(nonce[:4])), 0)
+	now := f.now()
+	if timestamp.Before(now.Add(-f.window)) || timestamp.After(now.Add(f.window)) {
+		return true
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.prune(now)
+	if _, ok := f.nonces[string(nonce)]; ok {
+		return true
+	}
+	if len(f.nonces) >= f.maxNonces {
+		return true
+	}
+	f.nonces[string(nonce)] = timestamp
+	return false
+}
+
+// prune deletes all nonces that are too old to be accepted anyway.
+// To avoid iterating over the map on every call, this is only done once per window.
+func (f *memoryReplayFilter) prune(now time.Time) {
+	if now.Sub(f.lastPrune) < f.window {
+		return
+	}
+	f.lastPrune = now
+	for nonce, timestamp := range f.nonces {
+		if timestamp.Before(now.Add(-f.window)) {
+			delete(f.nonces, nonce)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package handshake
+
+import (
+	"encoding/binary"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Replay filter", func() {
+	var (
+		filter *memoryReplayFilter
+		now    time.Time
+	)
+
+	newNonce := func(t time.Time, b byte) []byte {
+		nonce := make([]byte, 32)
+		binary.BigEndian.PutUint32(nonce, uint32(t.Unix()))
+		nonce[31] = b
+		return nonce
+	}
+
+	BeforeEach(func() {
+		now = time.Unix(1000000, 0)
+		filter = NewMemoryReplayFilter(time.Minute).(*memoryReplayFilter)
+		filter.now = func() time.Time { return now }
+	})
+
+	It("accepts a nonce once", func() {
+		nonce := newNonce(now, 1)
+		Expect(filter.Replayed(nonce)).To(BeFalse())
+		Expect(filter.Replayed(nonce)).To(BeTrue())
+	})
+
+	It("accepts different nonces", func() {
+		Expect(filter.Replayed(newNonce(now, 1))).To(BeFalse())
+		Expect(filter.Replayed(newNonce(now, 2))).To(BeFalse())
+	})
+
+	It("rejects nonces that are too short", func() {
+		Expect(filter.Replayed([]byte{1, 2, 3})).To(BeTrue())
+	})
+
+	It("rejects nonces that are too old", func() {
+		Expect(filter.Replayed(newNonce(now.Add(-61*time.Second), 1))).To(BeTrue())
+		Expect(filter.Replayed(newNonce(now.Add(-59*time.Second), 1))).To(BeFalse())
+	})
+
+	It("rejects nonces from the future", func() {
+		Expect(filter.Replayed(newNonce(now.Add(61*time.Second), 1))).To(BeTrue())
+		Expect(filter.Replayed(newNonce(now.Add(59*time.Second), 1))).To(BeFalse())
+	})
+
+	It("rejects nonces once the maximum number of nonces is recorded", func() {
+		filter.maxNonces = 2
+		Expect(filter.Replayed(newNonce(now, 1))).To(BeFalse())
+		Expect(filter.Replayed(newNonce(now, 2))).To(BeFalse())
+		Expect(filter.Replayed(newNonce(now, 3))).To(BeTrue())
+		Expect(filter.nonces).To(HaveLen(2))
+		// once the recorded nonces are outside the window, new nonces are accepted again
+		now = now.Add(2 * time.Minute)
+		Expect(filter.Replayed(newNonce(now, 3))).To(BeFalse())
+	})
+
+	It("deletes nonces once they are outside the window", func() {
+		nonce := newNonce(now, 1)
+		Expect(filter.Replayed(nonce)).To(BeFalse())
+		Expect(filter.nonces).To(HaveLen(1))
+		now = now.Add(2 * time.Minute)
+		Expect(filter.Replayed(newNonce(now, 2))).To(BeFalse())
+		Expect(filter.nonces).To(HaveLen(1))
+		// the old nonce is still rejected, since it is too old
+		Expect(filter.Replayed(nonce)).To(BeTrue())
+	})
+})
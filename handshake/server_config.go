@@ -3,17 +3,34 @@ package handshake
 import (
 	"bytes"
 	"crypto/rand"
+	"net"
+	"sync"
 
 	"github.com/lucas-clemente/quic-go/crypto"
+	"github.com/lucas-clemente/quic-go/protocol"
 )
 
 // ServerConfig is a server config
 type ServerConfig struct {
-	ID        []byte
-	obit      []byte
-	kex       crypto.KeyExchange
-	signer    crypto.Signer
+	ID []byte
+	// ReplayFilter is used to reject replayed CHLOs. If nil, CHLOs are not checked for replays.
+	ReplayFilter ReplayFilter
+	// AcceptEarlyData decides if a client is allowed to do a 0-RTT handshake. If nil, all clients are.
+	// A client that is not allowed to gets a REJ, and completes the handshake with a CHLO echoing the server nonce of the REJ.
+	// It has to resend its data after the handshake.
+	AcceptEarlyData func(ip net.IP, sni string) bool
+	// AEADs are the AEADs offered to clients, in the order of preference. Clients choose the first one they support.
+	// If nil, DefaultAEADs() is used. It must not be changed once the server config is used.
+	// TagCC20 must only be used if crypto.SupportsChacha20Poly1305.
+	AEADs []Tag
+
+	obit        []byte
+	versionTags []byte
+	kex         crypto.KeyExchange
 	stkSource crypto.StkSource
+
+	signerMutex sync.RWMutex
+	signer      crypto.Signer
 }
 
 // NewServerConfig creates a new server config
@@ -40,21 +57,94 @@ func NewServerConfig(kex crypto.KeyExchange, signer crypto.Signer) (*ServerConfi
 	}
 
 	return &ServerConfig{
-		ID:        id,
-		obit:      obit,
-		kex:       kex,
-		signer:    signer,
-		stkSource: stkSource,
+		ID:          id,
+		obit:        obit,
+		versionTags: protocol.GetGreasedVersionTags(),
+		kex:         kex,
+		signer:      signer,
+		stkSource:   stkSource,
 	}, nil
 }
 
+// VersionTags returns the versions advertised in version negotiation packets and in the SHLO.
+// They include a reserved version, which is the same for all uses of the server config, so that clients see a consistent version list.
+func (s *ServerConfig) VersionTags() []byte {
+	return s.versionTags
+}
+
+// SetSTKSecrets sets the secrets used for source address tokens.
+// New tokens are created using secret. Tokens created using any of the previousSecrets are still accepted.
+// It must be called before the server config is used.
+func (s *ServerConfig) SetSTKSecrets(secret []byte, previousSecrets ...[]byte) error {
+	stkSource, err := crypto.NewStkSource(secret, previousSecrets...)
+	if err != nil {
+		return err
+	}
+	s.stkSource = stkSource
+	return nil
+}
+
+// RotateSTKSecret makes secret the secret used for new source address tokens.
+// Tokens created using the previous secret are still accepted, such that clients don't lose their cached state at once.
+func (s *ServerConfig) RotateSTKSecret(secret []byte) error {
+	return s.stkSource.Rotate(secret)
+}
+
+// SetSigner replaces the signer holding the certificates.
+// It can be called at any time. Handshakes that already got the old certificates may fail, the clients then retry with the new ones.
+func (s *ServerConfig) SetSigner(signer crypto.Signer) {
+	s.signerMutex.Lock()
+	s.signer = signer
+	s.signerMutex.Unlock()
+}
+
+func (s *ServerConfig) getSigner() crypto.Signer {
+	s.signerMutex.RLock()
+	defer s.signerMutex.RUnlock()
+	return s.signer
+}
+
+// DefaultAEADs returns all supported AEADs.
+// AES-GCM is preferred if the CPU has hardware support for AES, ChaCha20-Poly1305 otherwise.
+// Without crypto.SupportsChacha20Poly1305, only AES-GCM is offered.
+func DefaultAEADs() []Tag {
+	if !crypto.SupportsChacha20Poly1305 {
+		return []Tag{TagAESG}
+	}
+	if crypto.HasAESHardwareSupport {
+		return []Tag{TagAESG, TagCC20}
+	}
+	return []Tag{TagCC20, TagAESG}
+}
+
+func (s *ServerConfig) getAEADs() []Tag {
+	if s.AEADs == nil {
+		return DefaultAEADs()
+	}
+	return s.AEADs
+}
+
+// supportsAEAD says if the AEAD was offered to clients
+func (s *ServerConfig) supportsAEAD(aead Tag) bool {
+	for _, t := range s.getAEADs() {
+		if t == aead {
+			return true
+		}
+	}
+	return false
+}
+
 // Get the server config binary representation
 func (s *ServerConfig) Get() []byte {
+	aeads := make([]byte, 0, 4*len(s.getAEADs()))
+	for _, t := range s.getAEADs() {
+		aeads = append(aeads, byte(t), byte(t>>8), byte(t>>16), byte(t>>24))
+	}
 	var serverConfig bytes.Buffer
 	WriteHandshakeMessage(&serverConfig, TagSCFG, map[Tag][]byte{
 		TagSCID: s.ID,
 		TagKEXS: []byte("C255"),
-		TagAEAD: []byte("AESG"),
+		TagAEAD: aeads,
 		TagPUBS: append([]byte{0x20, 0x00, 0x00}, s.kex.PublicKey()...),
 		TagOBIT: s.obit,
 		TagEXPY: {0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
@@ -64,10 +154,15 @@ func (s *ServerConfig) Get() []byte {
 
 // Sign the server config and CHLO with the server's keyData
 func (s *ServerConfig) Sign(sni string, chlo []byte) ([]byte, error) {
-	return s.signer.SignServerProof(sni, chlo, s.Get())
+	return s.getSigner().SignServerProof(sni, chlo, s.Get())
 }
 
 // GetCertsCompressed returns the certificate data
 func (s *ServerConfig) GetCertsCompressed(sni string, commonSetHashes, compressedHashes []byte) ([]byte, error) {
-	return s.signer.GetCertsCompressed(sni, commonSetHashes, compressedHashes)
+	return s.getSigner().GetCertsCompressed(sni, commonSetHashes, compressedHashes)
+}
+
+// GetLeafCert returns the leaf certificate
+func (s *ServerConfig) GetLeafCert(sni string) ([]byte, error) {
+	return s.getSigner().GetLeafCert(sni)
 }
@@ -2,8 +2,11 @@ package handshake
 
 import (
 	"bytes"
+	"encoding/binary"
+	"net"
 
 	"github.com/lucas-clemente/quic-go/crypto"
+	"github.com/lucas-clemente/quic-go/protocol"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -29,9 +32,20 @@ var _ = Describe("ServerConfig", func() {
 		Expect(scfg1.obit).ToNot(Equal(scfg2.obit))
 	})
 
+	It("advertises the supported versions and one reserved version", func() {
+		scfg, err := NewServerConfig(kex, nil)
+		Expect(err).ToNot(HaveOccurred())
+		tags := scfg.VersionTags()
+		Expect(tags).To(HavePrefix(string(protocol.SupportedVersionsAsTags)))
+		Expect(tags).To(HaveLen(len(protocol.SupportedVersionsAsTags) + 4))
+		Expect(protocol.IsReservedVersionTag(binary.LittleEndian.Uint32(tags[len(protocol.SupportedVersionsAsTags):]))).To(BeTrue())
+		Expect(scfg.VersionTags()).To(Equal(tags))
+	})
+
 	It("gets the proper binary representation", func() {
 		scfg, err := NewServerConfig(kex, nil)
 		Expect(err).NotTo(HaveOccurred())
+		scfg.AEADs = []Tag{TagAESG}
 		expected := bytes.NewBuffer([]byte{0x53, 0x43, 0x46, 0x47, 0x6, 0x0, 0x0, 0x0, 0x41, 0x45, 0x41, 0x44, 0x4, 0x0, 0x0, 0x0, 0x53, 0x43, 0x49, 0x44, 0x14, 0x0, 0x0, 0x0, 0x50, 0x55, 0x42, 0x53, 0x37, 0x0, 0x0, 0x0, 0x4b, 0x45, 0x58, 0x53, 0x3b, 0x0, 0x0, 0x0, 0x4f, 0x42, 0x49, 0x54, 0x43, 0x0, 0x0, 0x0, 0x45, 0x58, 0x50, 0x59, 0x4b, 0x0, 0x0, 0x0, 0x41, 0x45, 0x53, 0x47})
 		expected.Write(scfg.ID)
 		expected.Write([]byte{0x20, 0x0, 0x0})
@@ -41,4 +55,92 @@ var _ = Describe("ServerConfig", func() {
 		expected.Write([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
 		Expect(scfg.Get()).To(Equal(expected.Bytes()))
 	})
+
+	Context("AEADs", func() {
+		getAEADs := func(scfg *ServerConfig) []byte {
+			tag, msg, err := ParseHandshakeMessage(bytes.NewReader(scfg.Get()))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tag).To(Equal(TagSCFG))
+			return msg[TagAEAD]
+		}
+
+		It("offers the AEADs in the order of preference", func() {
+			scfg, err := NewServerConfig(kex, nil)
+			Expect(err).NotTo(HaveOccurred())
+			scfg.AEADs = []Tag{TagCC20, TagAESG}
+			Expect(getAEADs(scfg)).To(Equal([]byte("CC20AESG")))
+			Expect(scfg.supportsAEAD(TagCC20)).To(BeTrue())
+		})
+
+		It("only offers the configured AEADs", func() {
+			scfg, err := NewServerConfig(kex, nil)
+			Expect(err).NotTo(HaveOccurred())
+			scfg.AEADs = []Tag{TagAESG}
+			Expect(getAEADs(scfg)).To(Equal([]byte("AESG")))
+			Expect(scfg.supportsAEAD(TagAESG)).To(BeTrue())
+			Expect(scfg.supportsAEAD(TagCC20)).To(BeFalse())
+		})
+
+		It("prefers AES-GCM if the CPU supports it, and ChaCha20-Poly1305 otherwise", func() {
+			scfg, err := NewServerConfig(kex, nil)
+			Expect(err).NotTo(HaveOccurred())
+			if !crypto.SupportsChacha20Poly1305 {
+				Expect(DefaultAEADs()).To(Equal([]Tag{TagAESG}))
+				Expect(getAEADs(scfg)).To(Equal([]byte("AESG")))
+			} else if crypto.HasAESHardwareSupport {
+				Expect(DefaultAEADs()).To(Equal([]Tag{TagAESG, TagCC20}))
+				Expect(getAEADs(scfg)).To(Equal([]byte("AESGCC20")))
+			} else {
+				Expect(DefaultAEADs()).To(Equal([]Tag{TagCC20, TagAESG}))
+				Expect(getAEADs(scfg)).To(Equal([]byte("CC20AESG")))
+			}
+		})
+	})
+
+	It("replaces the signer", func() {
+		scfg, err := NewServerConfig(kex, nil)
+		Expect(err).NotTo(HaveOccurred())
+		scfg.SetSigner(&mockSigner{})
+		cert, err := scfg.GetLeafCert("")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cert).To(Equal([]byte("certuncompressed")))
+		certs, err := scfg.GetCertsCompressed("", nil, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(certs).To(Equal([]byte("certcompressed")))
+		proof, err := scfg.Sign("", []byte("CHLO"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(proof).To(Equal([]byte("proof")))
+	})
+
+	Context("STK secrets", func() {
+		ip := net.ParseIP("1.2.3.4")
+
+		It("uses the configured secrets", func() {
+			scfg, err := NewServerConfig(kex, nil)
+			Expect(err).NotTo(HaveOccurred())
+			oldSource, err := crypto.NewStkSource([]byte("old secret"))
+			Expect(err).NotTo(HaveOccurred())
+			stk, err := oldSource.NewToken(ip)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(scfg.stkSource.VerifyToken(ip, stk)).To(HaveOccurred())
+			err = scfg.SetSTKSecrets([]byte("secret"), []byte("old secret"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(scfg.stkSource.VerifyToken(ip, stk)).To(Succeed())
+		})
+
+		It("rotates the secret", func() {
+			scfg, err := NewServerConfig(kex, nil)
+			Expect(err).NotTo(HaveOccurred())
+			stk, err := scfg.stkSource.NewToken(ip)
+			Expect(err).NotTo(HaveOccurred())
+			err = scfg.RotateSTKSecret([]byte("new secret"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(scfg.stkSource.VerifyToken(ip, stk)).To(Succeed())
+			newSource, err := crypto.NewStkSource([]byte("new secret"))
+			Expect(err).NotTo(HaveOccurred())
+			stk, err = scfg.stkSource.NewToken(ip)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(newSource.VerifyToken(ip, stk)).To(Succeed())
+		})
+	})
 })
@@ -66,6 +66,10 @@ const (
 	TagKEXS Tag = 'K' + 'E'<<8 + 'X'<<16 + 'S'<<24
 	// TagAEAD is the list of AEAD algos
 	TagAEAD Tag = 'A' + 'E'<<8 + 'A'<<16 + 'D'<<24
+	// TagAESG is AES-GCM with a 12 byte tag
+	TagAESG Tag = 'A' + 'E'<<8 + 'S'<<16 + 'G'<<24
+	// TagCC20 is ChaCha20-Poly1305 with a 12 byte tag
+	TagCC20 Tag = 'C' + 'C'<<8 + '2'<<16 + '0'<<24
 	// TagPUBS is the public value for the KEX
 	TagPUBS Tag = 'P' + 'U'<<8 + 'B'<<16 + 'S'<<24
 	// TagOBIT is the client orbit
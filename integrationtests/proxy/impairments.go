@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+)
+
+// Impairments configures the network conditions the UDP proxy emulates.
+// The same settings are applied independently to both directions.
+type Impairments struct {
+	// LossRate is the probability that a packet is dropped
+	LossRate float64
+	// DuplicationRate is the probability that a packet is delivered twice
+	DuplicationRate float64
+	// ReorderRate is the probability that a packet is held back by ReorderDelay,
+	// such that packets sent after it overtake it
+	ReorderRate  float64
+	ReorderDelay time.Duration
+	// Bandwidth is the link capacity in bytes per second. 0 means unlimited.
+	Bandwidth uint64
+	// Seed seeds the random number generators. If 0, a random seed is used.
+	// Using the same seed results in the same sequence of impairment decisions.
+	Seed int64
+}
+
+// A link applies the impairments to packets sent in one direction
+type link struct {
+	mutex sync.Mutex
+
+	impairments Impairments
+	rttGen      rttGenerator
+
+	// nextDeparture is the time the link becomes idle again, if the bandwidth is limited
+	nextDeparture time.Time
+}
+
+func newLink(impairments Impairments, rttMin, rttMax time.Duration, seed int64) *link {
+	return &link{
+		impairments: impairments,
+		rttGen:      newSeededRttGenerator(rttMin, rttMax, seed),
+	}
+}
+
+// getDelays returns the delays after which copies of a packet of the given size are delivered.
+// It returns no delay if the packet is lost, and two delays if it is duplicated.
+func (l *link) getDelays(now time.Time, size int) []time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	rand := l.rttGen.rand
+	if rand.Float64() < l.impairments.LossRate {
+		return nil
+	}
+
+	delay := l.rttGen.getRTT() / 2
+	if l.impairments.Bandwidth > 0 {
+		departure := l.nextDeparture
+		if departure.Before(now) {
+			departure = now
+		}
+		departure = departure.Add(time.Duration(uint64(size) * uint64(time.Second) / l.impairments.Bandwidth))
+		l.nextDeparture = departure
+		delay += departure.Sub(now)
+	}
+	if rand.Float64() < l.impairments.ReorderRate {
+		delay += l.impairments.ReorderDelay
+	}
+
+	delays := []time.Duration{delay}
+	if rand.Float64() < l.impairments.DuplicationRate {
+		delays = append(delays, delay)
+	}
+	return delays
+}
@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Impairments", func() {
+	var now time.Time
+
+	BeforeEach(func() {
+		now = time.Now()
+	})
+
+	It("delivers every packet once after half the RTT without impairments", func() {
+		l := newLink(Impairments{}, 20*time.Millisecond, 20*time.Millisecond, 1)
+		for i := 0; i < 100; i++ {
+			Expect(l.getDelays(now, 1000)).To(Equal([]time.Duration{10 * time.Millisecond}))
+		}
+	})
+
+	It("drops all packets with a loss rate of 1", func() {
+		l := newLink(Impairments{LossRate: 1}, 0, 0, 1)
+		for i := 0; i < 100; i++ {
+			Expect(l.getDelays(now, 1000)).To(BeEmpty())
+		}
+	})
+
+	It("drops the right fraction of packets", func() {
+		l := newLink(Impairments{LossRate: 0.25}, 0, 0, 1)
+		var lost int
+		for i := 0; i < 10000; i++ {
+			if len(l.getDelays(now, 1000)) == 0 {
+				lost++
+			}
+		}
+		Expect(lost).To(BeNumerically("~", 2500, 200))
+	})
+
+	It("duplicates packets", func() {
+		l := newLink(Impairments{DuplicationRate: 1}, 0, 0, 1)
+		Expect(l.getDelays(now, 1000)).To(HaveLen(2))
+	})
+
+	It("reorders packets", func() {
+		l := newLink(Impairments{ReorderRate: 1, ReorderDelay: 5 * time.Millisecond}, 10*time.Millisecond, 10*time.Millisecond, 1)
+		Expect(l.getDelays(now, 1000)).To(Equal([]time.Duration{10 * time.Millisecond}))
+	})
+
+	It("limits the bandwidth", func() {
+		l := newLink(Impairments{Bandwidth: 10000}, 0, 0, 1)
+		Expect(l.getDelays(now, 1000)).To(Equal([]time.Duration{100 * time.Millisecond}))
+		Expect(l.getDelays(now, 1000)).To(Equal([]time.Duration{200 * time.Millisecond}))
+		Expect(l.getDelays(now.Add(50*time.Millisecond), 500)).To(Equal([]time.Duration{200 * time.Millisecond}))
+		// the link was idle in the meantime
+		Expect(l.getDelays(now.Add(time.Second), 1000)).To(Equal([]time.Duration{100 * time.Millisecond}))
+	})
+
+	It("makes the same decisions when using the same seed", func() {
+		impairments := Impairments{
+			LossRate:        0.2,
+			DuplicationRate: 0.2,
+			ReorderRate:     0.2,
+			ReorderDelay:    time.Millisecond,
+		}
+		l1 := newLink(impairments, 10*time.Millisecond, 30*time.Millisecond, 42)
+		l2 := newLink(impairments, 10*time.Millisecond, 30*time.Millisecond, 42)
+		for i := 0; i < 1000; i++ {
+			Expect(l1.getDelays(now, 1000)).To(Equal(l2.getDelays(now, 1000)))
+		}
+	})
+})
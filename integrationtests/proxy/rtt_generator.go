@@ -6,15 +6,20 @@ import (
 )
 
 type rttGenerator struct {
-	min time.Duration
-	max time.Duration
+	min  time.Duration
+	max  time.Duration
+	rand *rand.Rand
 }
 
 func newRttGenerator(min, max time.Duration) rttGenerator {
-	rand.Seed(time.Now().UnixNano())
+	return newSeededRttGenerator(min, max, time.Now().UnixNano())
+}
+
+func newSeededRttGenerator(min, max time.Duration, seed int64) rttGenerator {
 	return rttGenerator{
-		min: min,
-		max: max,
+		min:  min,
+		max:  max,
+		rand: rand.New(rand.NewSource(seed)),
 	}
 }
 
@@ -25,7 +30,7 @@ func (s *rttGenerator) getRTT() time.Duration {
 
 	minns := s.min.Nanoseconds()
 	maxns := s.max.Nanoseconds()
-	rttns := rand.Int63n(maxns-minns) + minns
+	rttns := s.rand.Int63n(maxns-minns) + minns
 
 	return time.Duration(rttns) * time.Nanosecond
 }
@@ -32,7 +32,8 @@ type UDPProxy struct {
 	proxyConn          *net.UDPConn
 	dropIncomingPacket DropCallback
 	dropOutgoingPacket DropCallback
-	rttGen             rttGenerator
+	incomingLink       *link
+	outgoingLink       *link
 
 	// Mapping from client addresses (as host:port) to connection
 	clientDict map[string]*connection
@@ -40,6 +41,11 @@ type UDPProxy struct {
 
 // NewUDPProxy creates a new UDP proxy
 func NewUDPProxy(proxyPort int, serverAddress string, serverPort int, dropIncomingPacket, dropOutgoingPacket DropCallback, rttMin time.Duration, rttMax time.Duration) (*UDPProxy, error) {
+	return NewUDPProxyWithImpairments(proxyPort, serverAddress, serverPort, dropIncomingPacket, dropOutgoingPacket, rttMin, rttMax, Impairments{})
+}
+
+// NewUDPProxyWithImpairments creates a new UDP proxy that additionally applies the given impairments in both directions
+func NewUDPProxyWithImpairments(proxyPort int, serverAddress string, serverPort int, dropIncomingPacket, dropOutgoingPacket DropCallback, rttMin time.Duration, rttMax time.Duration, impairments Impairments) (*UDPProxy, error) {
 	dontDrop := func(p protocol.PacketNumber) bool {
 		return false
 	}
@@ -55,9 +61,15 @@ func NewUDPProxy(proxyPort int, serverAddress string, serverPort int, dropIncomi
 		clientDict:         make(map[string]*connection),
 		dropIncomingPacket: dropIncomingPacket,
 		dropOutgoingPacket: dropOutgoingPacket,
-		rttGen:             newRttGenerator(rttMin, rttMax),
 	}
 
+	seed := impairments.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	p.incomingLink = newLink(impairments, rttMin, rttMax, seed)
+	p.outgoingLink = newLink(impairments, rttMin, rttMax, seed+1)
+
 	saddr, err := net.ResolveUDPAddr("udp", ":"+strconv.Itoa(proxyPort))
 	if err != nil {
 		return nil, err
@@ -133,10 +145,12 @@ func (p *UDPProxy) runProxy() error {
 
 		if !p.dropIncomingPacket(hdr.PacketNumber) {
 			// Relay to server
-			go func() {
-				time.Sleep(p.rttGen.getRTT() / 2)
-				conn.ServerConn.Write(raw)
-			}()
+			for _, delay := range p.incomingLink.getDelays(time.Now(), n) {
+				go func(delay time.Duration) {
+					time.Sleep(delay)
+					conn.ServerConn.Write(raw)
+				}(delay)
+			}
 		}
 	}
 }
@@ -163,10 +177,12 @@ func (p *UDPProxy) runConnection(conn *connection) error {
 
 		if !p.dropOutgoingPacket(protocol.PacketNumber(v)) {
 			// Relay it to client
-			go func() {
-				time.Sleep(p.rttGen.getRTT() / 2)
-				p.proxyConn.WriteToUDP(raw, conn.ClientAddr)
-			}()
+			for _, delay := range p.outgoingLink.getDelays(time.Now(), n) {
+				go func(delay time.Duration) {
+					time.Sleep(delay)
+					p.proxyConn.WriteToUDP(raw, conn.ClientAddr)
+				}(delay)
+			}
 		}
 	}
 }
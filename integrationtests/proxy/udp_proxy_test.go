@@ -235,5 +235,33 @@ var _ = Describe("UDP Proxy", func() {
 				Consistently(func() []packetData { return clientReceivedPackets }).Should(HaveLen(3))
 			})
 		})
+
+		Context("Impairments", func() {
+			It("drops all packets with a loss rate of 1", func() {
+				var err error
+				proxy, err = NewUDPProxyWithImpairments(10001, "localhost", serverPort, nil, nil, 0, 0, Impairments{LossRate: 1})
+				Expect(err).ToNot(HaveOccurred())
+
+				for i := 1; i <= 6; i++ {
+					_, err := clientConn.Write(makePacket(protocol.PacketNumber(i), []byte("foobar"+strconv.Itoa(i))))
+					Expect(err).ToNot(HaveOccurred())
+				}
+				Eventually(func() map[string]*connection { return proxy.clientDict }).Should(HaveLen(1))
+				Consistently(func() []packetData { return serverReceivedPackets }).Should(BeEmpty())
+			})
+
+			It("duplicates packets", func() {
+				var err error
+				proxy, err = NewUDPProxyWithImpairments(10001, "localhost", serverPort, nil, nil, 0, 0, Impairments{DuplicationRate: 1})
+				Expect(err).ToNot(HaveOccurred())
+
+				for i := 1; i <= 3; i++ {
+					_, err := clientConn.Write(makePacket(protocol.PacketNumber(i), []byte("foobar"+strconv.Itoa(i))))
+					Expect(err).ToNot(HaveOccurred())
+				}
+				Eventually(func() []packetData { return serverReceivedPackets }).Should(HaveLen(6))
+				Consistently(func() []packetData { return serverReceivedPackets }).Should(HaveLen(6))
+			})
+		})
 	})
 })
@@ -0,0 +1,52 @@
+package quic
+
+import (
+	"net"
+	"time"
+
+	"github.com/lucas-clemente/quic-go/congestion"
+	"github.com/lucas-clemente/quic-go/protocol"
+	"github.com/lucas-clemente/quic-go/utils"
+	"golang.org/x/net/context"
+)
+
+// A SessionHandle is the part of a Session that applications use.
+// Code that depends on it instead of *Session can be tested without a network, using the mocks package.
+type SessionHandle interface {
+	// GetOrOpenStream gets or opens a stream
+	GetOrOpenStream(id protocol.StreamID) (utils.Stream, error)
+	// OpenStream opens a new stream initiated by the server
+	OpenStream(id protocol.StreamID) (utils.Stream, error)
+	// SetStreamPriority sets the priority of a stream
+	SetStreamPriority(id protocol.StreamID, priority protocol.StreamPriority) error
+	// GoAway tells the peer not to open any new streams
+	GoAway()
+	// Close the session
+	Close(error) error
+	// RemoteAddr returns the address of the peer
+	RemoteAddr() *net.UDPAddr
+	// ConnectionState returns the state of the cryptographic handshake
+	ConnectionState() ConnectionState
+	// Stats returns statistics about the session
+	Stats() SessionStats
+	// Ping sends a PING frame and returns the round-trip time once it is acknowledged
+	Ping(ctx context.Context) (time.Duration, error)
+	// SetMaxBandwidth limits the rate at which data is sent on the session, to less than the congestion controller allows.
+	// 0 goes back to the limit set by Config.MaxPacingRate.
+	SetMaxBandwidth(bandwidth congestion.Bandwidth)
+}
+
+// A Listener listens for incoming QUIC sessions
+type Listener interface {
+	// ListenAndServe listens on the configured address and serves incoming sessions
+	ListenAndServe() error
+	// Serve serves incoming sessions on an existing connection
+	Serve(conn *net.UDPConn) error
+	// Close the listener and all its sessions
+	Close() error
+}
+
+var (
+	_ SessionHandle = &Session{}
+	_ Listener      = &Server{}
+)
@@ -0,0 +1,104 @@
+package quic
+
+import (
+	"time"
+
+	"github.com/lucas-clemente/quic-go/protocol"
+)
+
+// keepAliveProber determines how long a session may be idle before a keep-alive PING is sent.
+// If discovery is enabled, it searches for the NAT binding timeout of the path, using a binary search between a minimum and a maximum interval.
+// A keep-alive PING that is acknowledged shows that the binding survived the interval.
+// A rebinding shows that it expired, and so do KeepAliveProbeLossesBeforeUnsafe lost PINGs in a row.
+// Once the search converged, the longest interval that was shown to be safe is used.
+type keepAliveProber struct {
+	minInterval time.Duration
+	maxInterval time.Duration
+	discover    bool
+
+	// safe is the longest interval known to keep the NAT binding, unsafe is the shortest interval known to lose it
+	safe   time.Duration
+	unsafe time.Duration
+
+	interval time.Duration
+	// lostProbes is the number of keep-alive PINGs sent after interval that were lost in a row
+	lostProbes int
+}
+
+// newKeepAliveProber creates a new keepAliveProber.
+// If discover is false, maxInterval is always used.
+func newKeepAliveProber(minInterval, maxInterval time.Duration, discover bool) *keepAliveProber {
+	p := &keepAliveProber{
+		minInterval: minInterval,
+		maxInterval: maxInterval,
+		discover:    discover,
+		interval:    maxInterval,
+	}
+	p.reset()
+	return p
+}
+
+func (p *keepAliveProber) reset() {
+	p.safe = p.minInterval
+	// the maximum interval is probed first
+	p.unsafe = p.maxInterval + 1
+	p.interval = p.maxInterval
+	p.lostProbes = 0
+}
+
+// Interval is the time a session may be idle before the next keep-alive PING is sent
+func (p *keepAliveProber) Interval() time.Duration {
+	return p.interval
+}
+
+// OnProbeAcked is called when a keep-alive PING sent after Interval was acknowledged
+func (p *keepAliveProber) OnProbeAcked() {
+	if !p.discover {
+		return
+	}
+	if p.interval > p.safe {
+		p.safe = p.interval
+	}
+	p.next()
+}
+
+// OnProbeLost is called when a keep-alive PING sent after Interval was not acknowledged.
+// The interval is only regarded as unsafe once KeepAliveProbeLossesBeforeUnsafe PINGs were lost in a row, until then it is probed again.
+func (p *keepAliveProber) OnProbeLost() {
+	if !p.discover {
+		return
+	}
+	p.lostProbes++
+	if p.lostProbes < protocol.KeepAliveProbeLossesBeforeUnsafe {
+		return
+	}
+	p.setUnsafe(p.interval)
+}
+
+// OnRebinding is called when the port of the peer changed after the session was idle for idleTime.
+// This is a sign that the NAT binding expired.
+func (p *keepAliveProber) OnRebinding(idleTime time.Duration) {
+	if !p.discover || idleTime >= p.unsafe {
+		return
+	}
+	p.setUnsafe(idleTime)
+}
+
+func (p *keepAliveProber) setUnsafe(interval time.Duration) {
+	p.unsafe = interval
+	// the binding timeout changed, so the interval that was known to be safe isn't safe anymore
+	if p.safe >= p.unsafe {
+		p.safe = p.minInterval
+	}
+	p.next()
+}
+
+// next chooses the interval that is probed next
+func (p *keepAliveProber) next() {
+	p.lostProbes = 0
+	if p.unsafe <= p.minInterval || p.unsafe-p.safe <= protocol.KeepAliveDiscoveryResolution {
+		p.interval = p.safe
+		return
+	}
+	p.interval = p.safe + (p.unsafe-p.safe)/2
+}
@@ -0,0 +1,128 @@
+package quic
+
+import (
+	"time"
+
+	"github.com/lucas-clemente/quic-go/protocol"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Keep-alive prober", func() {
+	var prober *keepAliveProber
+
+	Context("without discovery", func() {
+		BeforeEach(func() {
+			prober = newKeepAliveProber(10*time.Second, 60*time.Second, false)
+		})
+
+		It("always uses the max interval", func() {
+			Expect(prober.Interval()).To(Equal(60 * time.Second))
+			prober.OnProbeLost()
+			Expect(prober.Interval()).To(Equal(60 * time.Second))
+			prober.OnRebinding(20 * time.Second)
+			Expect(prober.Interval()).To(Equal(60 * time.Second))
+			prober.OnProbeAcked()
+			Expect(prober.Interval()).To(Equal(60 * time.Second))
+		})
+	})
+
+	Context("with discovery", func() {
+		// loseProbe loses enough keep-alive PINGs to regard the current interval as unsafe
+		loseProbe := func() {
+			for i := 0; i < protocol.KeepAliveProbeLossesBeforeUnsafe; i++ {
+				prober.OnProbeLost()
+			}
+		}
+
+		BeforeEach(func() {
+			prober = newKeepAliveProber(10*time.Second, 90*time.Second, true)
+		})
+
+		It("probes the max interval first", func() {
+			Expect(prober.Interval()).To(Equal(90 * time.Second))
+		})
+
+		It("probes an interval again after a single lost keep-alive", func() {
+			prober.OnProbeLost()
+			Expect(prober.Interval()).To(Equal(90 * time.Second))
+			prober.OnProbeLost()
+			Expect(prober.Interval()).To(Equal(50 * time.Second))
+		})
+
+		It("only counts keep-alives lost in a row", func() {
+			prober.OnProbeLost()
+			prober.OnProbeAcked()
+			Expect(prober.Interval()).To(Equal(90 * time.Second))
+			prober.OnProbeLost()
+			Expect(prober.Interval()).To(Equal(90 * time.Second))
+		})
+
+		It("doesn't count keep-alives lost after a different interval", func() {
+			prober.OnProbeLost()
+			prober.OnRebinding(60 * time.Second)
+			Expect(prober.Interval()).To(Equal(35 * time.Second))
+			prober.OnProbeLost()
+			Expect(prober.Interval()).To(Equal(35 * time.Second))
+		})
+
+		It("keeps the max interval if it is safe", func() {
+			prober.OnProbeAcked()
+			Expect(prober.Interval()).To(Equal(90 * time.Second))
+		})
+
+		It("does a binary search for the NAT binding timeout", func() {
+			// binding timeout of 30s
+			loseProbe()
+			Expect(prober.Interval()).To(Equal(50 * time.Second))
+			loseProbe()
+			Expect(prober.Interval()).To(Equal(30 * time.Second))
+			prober.OnProbeAcked()
+			Expect(prober.Interval()).To(Equal(40 * time.Second))
+			loseProbe()
+			Expect(prober.Interval()).To(Equal(35 * time.Second))
+			loseProbe()
+			// the search converged
+			Expect(prober.Interval()).To(Equal(30 * time.Second))
+			prober.OnProbeAcked()
+			Expect(prober.Interval()).To(Equal(30 * time.Second))
+		})
+
+		It("uses the min interval if everything else is unsafe", func() {
+			for i := 0; i < 5; i++ {
+				loseProbe()
+			}
+			Expect(prober.Interval()).To(Equal(10 * time.Second))
+			loseProbe()
+			Expect(prober.Interval()).To(Equal(10 * time.Second))
+		})
+
+		It("uses the idle time before a rebinding as an upper bound", func() {
+			prober.OnRebinding(20 * time.Second)
+			Expect(prober.Interval()).To(Equal(15 * time.Second))
+		})
+
+		It("ignores rebindings after longer idle times than known to be unsafe", func() {
+			loseProbe()
+			Expect(prober.Interval()).To(Equal(50 * time.Second))
+			prober.OnRebinding(95 * time.Second)
+			Expect(prober.Interval()).To(Equal(50 * time.Second))
+		})
+
+		It("restarts the search if an interval known to be safe becomes unsafe", func() {
+			loseProbe()
+			prober.OnProbeAcked()
+			Expect(prober.Interval()).To(Equal(70 * time.Second))
+			// the binding timeout changed to 30s
+			prober.OnRebinding(30 * time.Second)
+			Expect(prober.Interval()).To(Equal(20 * time.Second))
+		})
+
+		It("stops searching once the bounds are closer than the resolution", func() {
+			prober = newKeepAliveProber(10*time.Second, 10*time.Second+protocol.KeepAliveDiscoveryResolution, true)
+			loseProbe()
+			Expect(prober.Interval()).To(Equal(10 * time.Second))
+		})
+	})
+})
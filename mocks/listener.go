@@ -0,0 +1,69 @@
+// Automatically generated by MockGen. DO NOT EDIT!
+// Source: github.com/lucas-clemente/quic-go (interfaces: Listener)
+
+package mocks
+
+import (
+	net "net"
+
+	gomock "github.com/golang/mock/gomock"
+)
+
+// MockListener is a mock of Listener interface.
+type MockListener struct {
+	ctrl     *gomock.Controller
+	recorder *MockListenerMockRecorder
+}
+
+// MockListenerMockRecorder is the mock recorder for MockListener.
+type MockListenerMockRecorder struct {
+	mock *MockListener
+}
+
+// NewMockListener creates a new mock instance.
+func NewMockListener(ctrl *gomock.Controller) *MockListener {
+	mock := &MockListener{ctrl: ctrl}
+	mock.recorder = &MockListenerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockListener) EXPECT() *MockListenerMockRecorder {
+	return m.recorder
+}
+
+// Close mocks base method.
+func (m *MockListener) Close() error {
+	ret := m.ctrl.Call(m, "Close")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Close indicates an expected call of Close.
+func (mr *MockListenerMockRecorder) Close() *gomock.Call {
+	return mr.mock.ctrl.RecordCall(mr.mock, "Close")
+}
+
+// ListenAndServe mocks base method.
+func (m *MockListener) ListenAndServe() error {
+	ret := m.ctrl.Call(m, "ListenAndServe")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ListenAndServe indicates an expected call of ListenAndServe.
+func (mr *MockListenerMockRecorder) ListenAndServe() *gomock.Call {
+	return mr.mock.ctrl.RecordCall(mr.mock, "ListenAndServe")
+}
+
+// Serve mocks base method.
+func (m *MockListener) Serve(arg0 *net.UDPConn) error {
+	ret := m.ctrl.Call(m, "Serve", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Serve indicates an expected call of Serve.
+func (mr *MockListenerMockRecorder) Serve(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCall(mr.mock, "Serve", arg0)
+}
@@ -0,0 +1,18 @@
+// Package mocks contains gomock mocks of the interfaces that applications use.
+// They allow testing code that consumes quic-go without opening UDP sockets.
+package mocks
+
+import (
+	"github.com/lucas-clemente/quic-go"
+	"github.com/lucas-clemente/quic-go/utils"
+)
+
+//go:generate mockgen -package mocks -destination session.go github.com/lucas-clemente/quic-go SessionHandle
+//go:generate mockgen -package mocks -destination listener.go github.com/lucas-clemente/quic-go Listener
+//go:generate mockgen -package mocks -destination stream.go github.com/lucas-clemente/quic-go/utils Stream
+
+var (
+	_ quic.SessionHandle = &MockSessionHandle{}
+	_ quic.Listener      = &MockListener{}
+	_ utils.Stream       = &MockStream{}
+)
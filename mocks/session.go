@@ -0,0 +1,158 @@
+// Automatically generated by MockGen. DO NOT EDIT!
+// Source: github.com/lucas-clemente/quic-go (interfaces: SessionHandle)
+
+package mocks
+
+import (
+	net "net"
+	time "time"
+
+	gomock "github.com/golang/mock/gomock"
+	quic "github.com/lucas-clemente/quic-go"
+	congestion "github.com/lucas-clemente/quic-go/congestion"
+	protocol "github.com/lucas-clemente/quic-go/protocol"
+	utils "github.com/lucas-clemente/quic-go/utils"
+	context "golang.org/x/net/context"
+)
+
+// MockSessionHandle is a mock of SessionHandle interface.
+type MockSessionHandle struct {
+	ctrl     *gomock.Controller
+	recorder *MockSessionHandleMockRecorder
+}
+
+// MockSessionHandleMockRecorder is the mock recorder for MockSessionHandle.
+type MockSessionHandleMockRecorder struct {
+	mock *MockSessionHandle
+}
+
+// NewMockSessionHandle creates a new mock instance.
+func NewMockSessionHandle(ctrl *gomock.Controller) *MockSessionHandle {
+	mock := &MockSessionHandle{ctrl: ctrl}
+	mock.recorder = &MockSessionHandleMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockSessionHandle) EXPECT() *MockSessionHandleMockRecorder {
+	return m.recorder
+}
+
+// Close mocks base method.
+func (m *MockSessionHandle) Close(arg0 error) error {
+	ret := m.ctrl.Call(m, "Close", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Close indicates an expected call of Close.
+func (mr *MockSessionHandleMockRecorder) Close(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCall(mr.mock, "Close", arg0)
+}
+
+// ConnectionState mocks base method.
+func (m *MockSessionHandle) ConnectionState() quic.ConnectionState {
+	ret := m.ctrl.Call(m, "ConnectionState")
+	ret0, _ := ret[0].(quic.ConnectionState)
+	return ret0
+}
+
+// ConnectionState indicates an expected call of ConnectionState.
+func (mr *MockSessionHandleMockRecorder) ConnectionState() *gomock.Call {
+	return mr.mock.ctrl.RecordCall(mr.mock, "ConnectionState")
+}
+
+// GetOrOpenStream mocks base method.
+func (m *MockSessionHandle) GetOrOpenStream(arg0 protocol.StreamID) (utils.Stream, error) {
+	ret := m.ctrl.Call(m, "GetOrOpenStream", arg0)
+	ret0, _ := ret[0].(utils.Stream)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetOrOpenStream indicates an expected call of GetOrOpenStream.
+func (mr *MockSessionHandleMockRecorder) GetOrOpenStream(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCall(mr.mock, "GetOrOpenStream", arg0)
+}
+
+// GoAway mocks base method.
+func (m *MockSessionHandle) GoAway() {
+	m.ctrl.Call(m, "GoAway")
+}
+
+// GoAway indicates an expected call of GoAway.
+func (mr *MockSessionHandleMockRecorder) GoAway() *gomock.Call {
+	return mr.mock.ctrl.RecordCall(mr.mock, "GoAway")
+}
+
+// OpenStream mocks base method.
+func (m *MockSessionHandle) OpenStream(arg0 protocol.StreamID) (utils.Stream, error) {
+	ret := m.ctrl.Call(m, "OpenStream", arg0)
+	ret0, _ := ret[0].(utils.Stream)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// OpenStream indicates an expected call of OpenStream.
+func (mr *MockSessionHandleMockRecorder) OpenStream(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCall(mr.mock, "OpenStream", arg0)
+}
+
+// Ping mocks base method.
+func (m *MockSessionHandle) Ping(arg0 context.Context) (time.Duration, error) {
+	ret := m.ctrl.Call(m, "Ping", arg0)
+	ret0, _ := ret[0].(time.Duration)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Ping indicates an expected call of Ping.
+func (mr *MockSessionHandleMockRecorder) Ping(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCall(mr.mock, "Ping", arg0)
+}
+
+// RemoteAddr mocks base method.
+func (m *MockSessionHandle) RemoteAddr() *net.UDPAddr {
+	ret := m.ctrl.Call(m, "RemoteAddr")
+	ret0, _ := ret[0].(*net.UDPAddr)
+	return ret0
+}
+
+// RemoteAddr indicates an expected call of RemoteAddr.
+func (mr *MockSessionHandleMockRecorder) RemoteAddr() *gomock.Call {
+	return mr.mock.ctrl.RecordCall(mr.mock, "RemoteAddr")
+}
+
+// SetMaxBandwidth mocks base method.
+func (m *MockSessionHandle) SetMaxBandwidth(arg0 congestion.Bandwidth) {
+	m.ctrl.Call(m, "SetMaxBandwidth", arg0)
+}
+
+// SetMaxBandwidth indicates an expected call of SetMaxBandwidth.
+func (mr *MockSessionHandleMockRecorder) SetMaxBandwidth(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCall(mr.mock, "SetMaxBandwidth", arg0)
+}
+
+// SetStreamPriority mocks base method.
+func (m *MockSessionHandle) SetStreamPriority(arg0 protocol.StreamID, arg1 protocol.StreamPriority) error {
+	ret := m.ctrl.Call(m, "SetStreamPriority", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetStreamPriority indicates an expected call of SetStreamPriority.
+func (mr *MockSessionHandleMockRecorder) SetStreamPriority(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCall(mr.mock, "SetStreamPriority", arg0, arg1)
+}
+
+// Stats mocks base method.
+func (m *MockSessionHandle) Stats() quic.SessionStats {
+	ret := m.ctrl.Call(m, "Stats")
+	ret0, _ := ret[0].(quic.SessionStats)
+	return ret0
+}
+
+// Stats indicates an expected call of Stats.
+func (mr *MockSessionHandleMockRecorder) Stats() *gomock.Call {
+	return mr.mock.ctrl.RecordCall(mr.mock, "Stats")
+}
@@ -0,0 +1,114 @@
+// Automatically generated by MockGen. DO NOT EDIT!
+// Source: github.com/lucas-clemente/quic-go/utils (interfaces: Stream)
+
+package mocks
+
+import (
+	gomock "github.com/golang/mock/gomock"
+	protocol "github.com/lucas-clemente/quic-go/protocol"
+)
+
+// MockStream is a mock of Stream interface.
+type MockStream struct {
+	ctrl     *gomock.Controller
+	recorder *MockStreamMockRecorder
+}
+
+// MockStreamMockRecorder is the mock recorder for MockStream.
+type MockStreamMockRecorder struct {
+	mock *MockStream
+}
+
+// NewMockStream creates a new mock instance.
+func NewMockStream(ctrl *gomock.Controller) *MockStream {
+	mock := &MockStream{ctrl: ctrl}
+	mock.recorder = &MockStreamMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockStream) EXPECT() *MockStreamMockRecorder {
+	return m.recorder
+}
+
+// Close mocks base method.
+func (m *MockStream) Close() error {
+	ret := m.ctrl.Call(m, "Close")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Close indicates an expected call of Close.
+func (mr *MockStreamMockRecorder) Close() *gomock.Call {
+	return mr.mock.ctrl.RecordCall(mr.mock, "Close")
+}
+
+// CloseRemote mocks base method.
+func (m *MockStream) CloseRemote(arg0 protocol.ByteCount) {
+	m.ctrl.Call(m, "CloseRemote", arg0)
+}
+
+// CloseRemote indicates an expected call of CloseRemote.
+func (mr *MockStreamMockRecorder) CloseRemote(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCall(mr.mock, "CloseRemote", arg0)
+}
+
+// Flush mocks base method.
+func (m *MockStream) Flush() error {
+	ret := m.ctrl.Call(m, "Flush")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Flush indicates an expected call of Flush.
+func (mr *MockStreamMockRecorder) Flush() *gomock.Call {
+	return mr.mock.ctrl.RecordCall(mr.mock, "Flush")
+}
+
+// Read mocks base method.
+func (m *MockStream) Read(arg0 []byte) (int, error) {
+	ret := m.ctrl.Call(m, "Read", arg0)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Read indicates an expected call of Read.
+func (mr *MockStreamMockRecorder) Read(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCall(mr.mock, "Read", arg0)
+}
+
+// Reset mocks base method.
+func (m *MockStream) Reset(arg0 error) {
+	m.ctrl.Call(m, "Reset", arg0)
+}
+
+// Reset indicates an expected call of Reset.
+func (mr *MockStreamMockRecorder) Reset(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCall(mr.mock, "Reset", arg0)
+}
+
+// StreamID mocks base method.
+func (m *MockStream) StreamID() protocol.StreamID {
+	ret := m.ctrl.Call(m, "StreamID")
+	ret0, _ := ret[0].(protocol.StreamID)
+	return ret0
+}
+
+// StreamID indicates an expected call of StreamID.
+func (mr *MockStreamMockRecorder) StreamID() *gomock.Call {
+	return mr.mock.ctrl.RecordCall(mr.mock, "StreamID")
+}
+
+// Write mocks base method.
+func (m *MockStream) Write(arg0 []byte) (int, error) {
+	ret := m.ctrl.Call(m, "Write", arg0)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Write indicates an expected call of Write.
+func (mr *MockStreamMockRecorder) Write(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCall(mr.mock, "Write", arg0)
+}
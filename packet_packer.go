@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"sort"
+	"sync"
 
 	"github.com/lucas-clemente/quic-go/frames"
 	"github.com/lucas-clemente/quic-go/handshake"
@@ -16,6 +18,43 @@ type packedPacket struct {
 	frames []frames.Frame
 }
 
+// Control frames are packed in the order of their priority, before all StreamFrames.
+// Frames with the same priority are packed in the order they were queued.
+// Stream-level control frames only get protocol.MaxStreamControlFramesSizePerPacket bytes of a packet before the StreamFrames,
+// so that a burst of them doesn't starve the stream data. They may use the space that is left after the StreamFrames.
+const (
+	controlFramePriorityAck = iota
+	controlFramePriorityConnection
+	controlFramePriorityStream
+)
+
+// controlFramePriority returns the priority of a control frame. Frames with a lower value are packed first.
+func controlFramePriority(f frames.Frame) int {
+	switch frame := f.(type) {
+	case *frames.AckFrame:
+		return controlFramePriorityAck
+	case *frames.WindowUpdateFrame:
+		if frame.StreamID != 0 {
+			return controlFramePriorityStream
+		}
+	case *frames.BlockedFrame:
+		if frame.StreamID != 0 {
+			return controlFramePriorityStream
+		}
+	case *frames.RstStreamFrame:
+		return controlFramePriorityStream
+	}
+	return controlFramePriorityConnection
+}
+
+type byControlFramePriority []frames.Frame
+
+func (s byControlFramePriority) Len() int      { return len(s) }
+func (s byControlFramePriority) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byControlFramePriority) Less(i, j int) bool {
+	return controlFramePriority(s[i]) < controlFramePriority(s[j])
+}
+
 type packetPacker struct {
 	connectionID protocol.ConnectionID
 	version      protocol.VersionNumber
@@ -25,8 +64,15 @@ type packetPacker struct {
 
 	connectionParameters handshake.ConnectionParametersManager
 
-	streamFramer  *streamFramer
-	controlFrames []frames.Frame
+	streamFramer *streamFramer
+
+	// controlFramesMutex protects controlFrames, since QueueControlFrameForNextPacket may be called from outside the run loop
+	controlFramesMutex sync.Mutex
+	controlFrames      []frames.Frame
+
+	// the peer doesn't acknowledge packets that only contain ACK and StopWaiting frames
+	// every protocol.MaxNonRetransmittablePackets such packets, a PING is added, so that the peer can garbage collect its received packet history
+	numNonRetransmittablePackets int
 }
 
 func newPacketPacker(connectionID protocol.ConnectionID, cryptoSetup *handshake.CryptoSetup, connectionParameters handshake.ConnectionParametersManager, streamFramer *streamFramer, version protocol.VersionNumber) *packetPacker {
@@ -50,7 +96,9 @@ func (p *packetPacker) PackPacket(stopWaitingFrame *frames.StopWaitingFrame, con
 
 func (p *packetPacker) packPacket(stopWaitingFrame *frames.StopWaitingFrame, controlFrames []frames.Frame, leastUnacked protocol.PacketNumber, onlySendOneControlFrame, maySendOnlyAck bool) (*packedPacket, error) {
 	if len(controlFrames) > 0 {
+		p.controlFramesMutex.Lock()
 		p.controlFrames = append(p.controlFrames, controlFrames...)
+		p.controlFramesMutex.Unlock()
 	}
 
 	currentPacketNumber := p.packetNumberGenerator.Peek()
@@ -110,6 +158,17 @@ func (p *packetPacker) packPacket(stopWaitingFrame *frames.StopWaitingFrame, con
 		}
 	}
 
+	if !onlySendOneControlFrame {
+		if shouldInstigateAck(payloadFrames) {
+			p.numNonRetransmittablePackets = 0
+		} else if p.numNonRetransmittablePackets >= protocol.MaxNonRetransmittablePackets {
+			payloadFrames = append(payloadFrames, &frames.PingFrame{})
+			p.numNonRetransmittablePackets = 0
+		} else {
+			p.numNonRetransmittablePackets++
+		}
+	}
+
 	raw := getPacketBuffer()
 	buffer := bytes.NewBuffer(raw)
 
@@ -161,15 +220,25 @@ func (p *packetPacker) composeNextPacket(stopWaitingFrame *frames.StopWaitingFra
 		payloadLength += minLength
 	}
 
+	p.controlFramesMutex.Lock()
+	defer p.controlFramesMutex.Unlock()
+	sort.Stable(byControlFramePriority(p.controlFrames))
+	var streamControlFramesLength protocol.ByteCount
 	for len(p.controlFrames) > 0 {
-		frame := p.controlFrames[len(p.controlFrames)-1]
+		frame := p.controlFrames[0]
 		minLength, _ := frame.MinLength(p.version) // controlFrames does not contain any StopWaitingFrames. So it will *never* return an error
 		if payloadLength+minLength > maxFrameSize {
 			break
 		}
+		if controlFramePriority(frame) == controlFramePriorityStream {
+			if streamControlFramesLength+minLength > protocol.MaxStreamControlFramesSizePerPacket {
+				break
+			}
+			streamControlFramesLength += minLength
+		}
 		payloadFrames = append(payloadFrames, frame)
 		payloadLength += minLength
-		p.controlFrames = p.controlFrames[:len(p.controlFrames)-1]
+		p.controlFrames = p.controlFrames[1:]
 	}
 
 	if payloadLength > maxFrameSize {
@@ -186,6 +255,24 @@ func (p *packetPacker) composeNextPacket(stopWaitingFrame *frames.StopWaitingFra
 		fs[len(fs)-1].DataLenPresent = false
 	}
 
+	// fill the space not used by StreamFrames with the control frames that exceeded their budget
+	// they are packed before the StreamFrames, since the last StreamFrame doesn't have a DataLen
+	var streamFramesLength protocol.ByteCount
+	for _, f := range fs {
+		minLength, _ := f.MinLength(p.version) // StreamFrames never return an error
+		streamFramesLength += minLength + f.DataLen()
+	}
+	for len(p.controlFrames) > 0 {
+		frame := p.controlFrames[0]
+		minLength, _ := frame.MinLength(p.version)
+		if payloadLength+streamFramesLength+minLength > maxFrameSize-2 {
+			break
+		}
+		payloadFrames = append(payloadFrames, frame)
+		payloadLength += minLength
+		p.controlFrames = p.controlFrames[1:]
+	}
+
 	// TODO: Simplify
 	for _, f := range fs {
 		payloadFrames = append(payloadFrames, f)
@@ -199,5 +286,7 @@ func (p *packetPacker) composeNextPacket(stopWaitingFrame *frames.StopWaitingFra
 }
 
 func (p *packetPacker) QueueControlFrameForNextPacket(f frames.Frame) {
+	p.controlFramesMutex.Lock()
 	p.controlFrames = append(p.controlFrames, f)
+	p.controlFramesMutex.Unlock()
 }
@@ -2,6 +2,7 @@ package quic
 
 import (
 	"bytes"
+	"testing"
 
 	"github.com/lucas-clemente/quic-go/frames"
 	"github.com/lucas-clemente/quic-go/handshake"
@@ -167,6 +168,88 @@ var _ = Describe("Packet packer", func() {
 		Expect(payloadFrames).To(HaveLen(10))
 	})
 
+	Context("control frame priorities", func() {
+		It("packs ACKs first", func() {
+			ack := &frames.AckFrame{LargestAcked: 1}
+			rst := &frames.RstStreamFrame{StreamID: 5}
+			wuf := &frames.WindowUpdateFrame{StreamID: 0}
+			packer.controlFrames = []frames.Frame{rst, wuf, ack}
+			payloadFrames, err := packer.composeNextPacket(nil, publicHeaderLen)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(payloadFrames).To(Equal([]frames.Frame{ack, wuf, rst}))
+		})
+
+		It("packs connection-level control frames before stream-level control frames", func() {
+			streamBlocked := &frames.BlockedFrame{StreamID: 5}
+			streamWuf := &frames.WindowUpdateFrame{StreamID: 5}
+			connBlocked := &frames.BlockedFrame{StreamID: 0}
+			goaway := &frames.GoawayFrame{}
+			packer.controlFrames = []frames.Frame{streamBlocked, streamWuf, connBlocked, goaway}
+			payloadFrames, err := packer.composeNextPacket(nil, publicHeaderLen)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(payloadFrames).To(Equal([]frames.Frame{connBlocked, goaway, streamBlocked, streamWuf}))
+		})
+
+		It("limits the size of stream-level control frames if there's stream data to send", func() {
+			streamFramer.AddFrameForRetransmission(&frames.StreamFrame{StreamID: 5, Data: bytes.Repeat([]byte{'f'}, 2000)})
+			blockedFrame := &frames.BlockedFrame{StreamID: 0x1337}
+			minLength, _ := blockedFrame.MinLength(0)
+			for i := 0; i < int(protocol.MaxPacketSize/minLength); i++ {
+				packer.controlFrames = append(packer.controlFrames, blockedFrame)
+			}
+			payloadFrames, err := packer.composeNextPacket(nil, publicHeaderLen)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(payloadFrames[len(payloadFrames)-1]).To(BeAssignableToTypeOf(&frames.StreamFrame{}))
+			streamFrame := payloadFrames[len(payloadFrames)-1].(*frames.StreamFrame)
+			Expect(streamFrame.DataLen()).To(BeNumerically(">=", protocol.MaxFrameAndPublicHeaderSize-publicHeaderLen-protocol.MaxStreamControlFramesSizePerPacket-10))
+			Expect(protocol.ByteCount(len(payloadFrames)-1) * minLength).To(BeNumerically("<=", protocol.MaxStreamControlFramesSizePerPacket))
+		})
+
+		It("uses the space left by StreamFrames for stream-level control frames", func() {
+			streamFramer.AddFrameForRetransmission(&frames.StreamFrame{StreamID: 5, Data: []byte("foobar")})
+			blockedFrame := &frames.BlockedFrame{StreamID: 0x1337}
+			minLength, _ := blockedFrame.MinLength(0)
+			for i := 0; i < int(protocol.MaxPacketSize/minLength); i++ {
+				packer.controlFrames = append(packer.controlFrames, blockedFrame)
+			}
+			payloadFrames, err := packer.composeNextPacket(nil, publicHeaderLen)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(payloadFrames[len(payloadFrames)-1]).To(BeAssignableToTypeOf(&frames.StreamFrame{}))
+			Expect(protocol.ByteCount(len(payloadFrames)-1) * minLength).To(BeNumerically(">", protocol.MaxStreamControlFramesSizePerPacket))
+			var length protocol.ByteCount
+			for _, f := range payloadFrames {
+				l, _ := f.MinLength(0)
+				length += l
+			}
+			Expect(length + 6).To(BeNumerically("<=", protocol.MaxFrameAndPublicHeaderSize-publicHeaderLen))
+		})
+
+		It("doesn't limit the size of connection-level control frames", func() {
+			streamFramer.AddFrameForRetransmission(&frames.StreamFrame{StreamID: 5, Data: bytes.Repeat([]byte{'f'}, 2000)})
+			wuf := &frames.WindowUpdateFrame{StreamID: 0}
+			minLength, _ := wuf.MinLength(0)
+			maxFramesPerPacket := int((protocol.MaxFrameAndPublicHeaderSize - publicHeaderLen) / minLength)
+			for i := 0; i < maxFramesPerPacket; i++ {
+				packer.controlFrames = append(packer.controlFrames, wuf)
+			}
+			payloadFrames, err := packer.composeNextPacket(nil, publicHeaderLen)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(payloadFrames[:maxFramesPerPacket]).ToNot(ContainElement(BeAssignableToTypeOf(&frames.StreamFrame{})))
+			Expect(packer.controlFrames).To(BeEmpty())
+		})
+
+		It("packs control frames before StreamFrames", func() {
+			streamFramer.AddFrameForRetransmission(&frames.StreamFrame{StreamID: 5, Data: []byte("foobar")})
+			rst := &frames.RstStreamFrame{StreamID: 7}
+			packer.controlFrames = []frames.Frame{rst}
+			payloadFrames, err := packer.composeNextPacket(nil, publicHeaderLen)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(payloadFrames).To(HaveLen(2))
+			Expect(payloadFrames[0]).To(Equal(rst))
+			Expect(payloadFrames[1]).To(BeAssignableToTypeOf(&frames.StreamFrame{}))
+		})
+	})
+
 	It("only increases the packet number when there is an actual packet to send", func() {
 		packer.packetNumberGenerator.nextToSkip = 1000
 		p, err := packer.PackPacket(nil, []frames.Frame{}, 0, true)
@@ -417,4 +500,63 @@ var _ = Describe("Packet packer", func() {
 		Expect(err).NotTo(HaveOccurred())
 		Expect(p).ToNot(BeNil())
 	})
+
+	Context("packets that only contain ACKs", func() {
+		It("adds a PING frame when too many packets only contained ACKs", func() {
+			for i := 0; i < protocol.MaxNonRetransmittablePackets; i++ {
+				p, err := packer.PackPacket(nil, []frames.Frame{&frames.AckFrame{}}, 0, true)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(p.frames).To(HaveLen(1))
+			}
+			p, err := packer.PackPacket(nil, []frames.Frame{&frames.AckFrame{}}, 0, true)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(p.frames).To(HaveLen(2))
+			Expect(p.frames[1]).To(Equal(&frames.PingFrame{}))
+			// the counter is reset
+			p, err = packer.PackPacket(nil, []frames.Frame{&frames.AckFrame{}}, 0, true)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(p.frames).To(HaveLen(1))
+		})
+
+		It("resets the counter when a packet contains other frames", func() {
+			for i := 0; i < protocol.MaxNonRetransmittablePackets; i++ {
+				_, err := packer.PackPacket(nil, []frames.Frame{&frames.AckFrame{}}, 0, true)
+				Expect(err).NotTo(HaveOccurred())
+			}
+			p, err := packer.PackPacket(nil, []frames.Frame{&frames.AckFrame{}, &frames.WindowUpdateFrame{}}, 0, true)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(p.frames).To(HaveLen(2))
+			p, err = packer.PackPacket(nil, []frames.Frame{&frames.AckFrame{}}, 0, true)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(p.frames).To(HaveLen(1))
+		})
+	})
 })
+
+func BenchmarkPackPacket(b *testing.B) {
+	fcm := newMockFlowControlHandler()
+	fcm.sendWindowSizes[5] = protocol.MaxByteCount
+	cpm := &mockConnectionParametersManager{}
+	streamFramer := newStreamFramer(newStreamsMap(nil, cpm), fcm)
+	packer := &packetPacker{
+		cryptoSetup:           &handshake.CryptoSetup{},
+		connectionParameters:  cpm,
+		packetNumberGenerator: newPacketNumberGenerator(protocol.SkipPacketAveragePeriodLength),
+		streamFramer:          streamFramer,
+		version:               protocol.Version34,
+	}
+	data := make([]byte, 1000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		streamFramer.AddFrameForRetransmission(&frames.StreamFrame{StreamID: 5, Data: data})
+		p, err := packer.PackPacket(nil, nil, 0, true)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if p == nil {
+			b.Fatal("no packet packed")
+		}
+		putPacketBuffer(p.raw)
+	}
+}
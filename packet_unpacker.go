@@ -15,6 +15,19 @@ type unpackedPacket struct {
 	frames []frames.Frame
 }
 
+// shouldInstigateAck determines if a packet contains any frames other than ACK and StopWaiting frames.
+// Packets that only contain ACK and StopWaiting frames are never acknowledged on their own.
+func shouldInstigateAck(fs []frames.Frame) bool {
+	for _, f := range fs {
+		switch f.(type) {
+		case *frames.AckFrame, *frames.StopWaitingFrame:
+		default:
+			return true
+		}
+	}
+	return false
+}
+
 type packetUnpacker struct {
 	version protocol.VersionNumber
 	aead    crypto.AEAD
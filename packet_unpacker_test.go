@@ -198,4 +198,26 @@ var _ = Describe("Packet unpacker", func() {
 			Expect(err.(*qerr.QuicError).ErrorCode).To(Equal(e))
 		}
 	})
+
+	Context("deciding if a packet instigates an ACK", func() {
+		It("doesn't instigate an ACK for packets only containing ACK and StopWaiting frames", func() {
+			fs := []frames.Frame{
+				&frames.AckFrame{LargestAcked: 1},
+				&frames.StopWaitingFrame{LeastUnacked: 1},
+			}
+			Expect(shouldInstigateAck(fs)).To(BeFalse())
+		})
+
+		It("doesn't instigate an ACK for empty packets", func() {
+			Expect(shouldInstigateAck(nil)).To(BeFalse())
+		})
+
+		It("instigates an ACK for packets containing other frames", func() {
+			fs := []frames.Frame{
+				&frames.AckFrame{LargestAcked: 1},
+				&frames.PingFrame{},
+			}
+			Expect(shouldInstigateAck(fs)).To(BeTrue())
+		})
+	})
 })
@@ -33,6 +33,22 @@ type StreamID uint32
 // A ByteCount in QUIC
 type ByteCount uint64
 
+// A StreamPriority is the scheduling priority of a stream. Lower values are scheduled first.
+type StreamPriority uint8
+
+const (
+	// HighestStreamPriority is the highest priority a stream can have
+	HighestStreamPriority StreamPriority = 0
+	// LowestStreamPriority is the lowest priority a stream can have
+	LowestStreamPriority StreamPriority = 7
+	// DefaultStreamPriority is the priority of newly opened streams
+	DefaultStreamPriority StreamPriority = 3
+)
+
+// RstStreamRefusedStream is the error code of a RST_STREAM frame for a stream that the server refused to open.
+// It is QUIC_REFUSED_STREAM in Chromium, and tells the client that it can safely retry the request on a new connection.
+const RstStreamRefusedStream uint32 = 8
+
 // MaxByteCount is the maximum value of a ByteCount
 const MaxByteCount = math.MaxUint64
 
@@ -62,5 +78,15 @@ const MinRetransmissionTime = 200 * time.Millisecond
 // MaxRetransmissionTime is the maximum RTO time
 const MaxRetransmissionTime = 60 * time.Second
 
+// MinHandshakeRetransmissionTime is the minimum time after which a handshake packet is retransmitted
+const MinHandshakeRetransmissionTime = 10 * time.Millisecond
+
+// CryptoStreamID is the ID of the stream that carries the crypto handshake
+const CryptoStreamID StreamID = 1
+
 // ClientHelloMinimumSize is the minimum size the server expects an inchoate CHLO to have.
 const ClientHelloMinimumSize = 1024
+
+// MinInitialPacketSize is the minimum size of a packet that creates a new session.
+// It carries the CHLO, which is padded to ClientHelloMinimumSize.
+const MinInitialPacketSize = ClientHelloMinimumSize
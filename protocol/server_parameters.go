@@ -5,16 +5,64 @@ import "time"
 // DefaultMaxCongestionWindow is the default for the max congestion window
 const DefaultMaxCongestionWindow = 1000
 
+// DefaultMinCongestionWindow is the default for the min congestion window in QUIC packets.
+// It is also the smallest min congestion window that can be configured.
+const DefaultMinCongestionWindow = 2
+
 // InitialCongestionWindow is the initial congestion window in QUIC packets
 const InitialCongestionWindow = 32
 
+// DefaultPacingBurstSize is the number of packets that the pacer allows to be sent back-to-back
+const DefaultPacingBurstSize = 10
+
+// MaxPacingBurstSize is the largest pacing burst size in QUIC packets that can be configured
+const MaxPacingBurstSize = 100
+
+// ServerBandwidthBurstSize is the number of bytes that all sessions of a server together may send back-to-back, if the MaxServerBandwidth budget allows it
+const ServerBandwidthBurstSize = MaxPacingBurstSize * MaxPacketSize
+
+// MinInitialCongestionWindow is the smallest initial congestion window in QUIC packets that can be configured
+const MinInitialCongestionWindow = 2
+
+// MaxInitialCongestionWindow is the largest initial congestion window in QUIC packets that can be configured
+const MaxInitialCongestionWindow = 200
+
 // MaxUndecryptablePackets limits the number of undecryptable packets that a
-// session queues for later until it sends a public reset.
+// session queues for later decryption. Once the queue is full, undecryptable packets are dropped.
 const MaxUndecryptablePackets = 10
 
+// MinRTTWindow is the time window over which the min RTT is tracked
+const MinRTTWindow = 10 * time.Second
+
+// DefaultAckElicitingThreshold is the number of received packets after which an ACK is sent immediately
+const DefaultAckElicitingThreshold = 2
+
+// MaxAckElicitingThreshold is the largest ACK-eliciting threshold that can be configured
+const MaxAckElicitingThreshold = 10
+
 // AckSendDelay is the maximal time delay applied to packets containing only ACKs
 const AckSendDelay = 5 * time.Millisecond
 
+// DefaultMinKeepAliveInterval is the shortest keep-alive interval tried when discovering the NAT binding timeout, if not configured otherwise
+const DefaultMinKeepAliveInterval = 10 * time.Second
+
+// KeepAliveDiscoveryResolution is the precision with which the NAT binding timeout is discovered
+const KeepAliveDiscoveryResolution = 5 * time.Second
+
+// KeepAliveProbeTimeoutRTTs is the number of RTTs after which a keep-alive PING that was not acknowledged is regarded as lost
+const KeepAliveProbeTimeoutRTTs = 4
+
+// KeepAliveProbeLossesBeforeUnsafe is the number of keep-alive PINGs sent after the same interval that have to be lost in a row, before the interval is regarded as too long to keep the NAT binding.
+// A single lost PING is more likely caused by random packet loss than by an expired binding.
+const KeepAliveProbeLossesBeforeUnsafe = 2
+
+// MinKeepAliveProbeTimeout is the minimum time after which a keep-alive PING that was not acknowledged is regarded as lost
+const MinKeepAliveProbeTimeout = time.Second
+
+// MaxAckDelay is the largest ack delay reported by the peer that is subtracted from an RTT sample.
+// A peer that delays ACKs for longer than that is not expected to be a well-behaved peer.
+const MaxAckDelay = 25 * time.Millisecond
+
 // ReceiveStreamFlowControlWindow is the stream-level flow control window for receiving data
 // This is the value that Google servers are using
 const ReceiveStreamFlowControlWindow ByteCount = (1 << 10) * 32 // 32 kB
@@ -53,6 +101,9 @@ const MaxSessionUnprocessedPackets = DefaultMaxCongestionWindow
 // RetransmissionThreshold + 1 is the number of times a packet has to be NACKed so that it gets retransmitted
 const RetransmissionThreshold = 3
 
+// PersistentCongestionThreshold is the number of RTO periods. If all packets sent during this time are lost, the connection is considered to be in persistent congestion.
+const PersistentCongestionThreshold = 3
+
 // SkipPacketAveragePeriodLength is the average period length in which one packet number is skipped to prevent an Optimistic ACK attack
 const SkipPacketAveragePeriodLength PacketNumber = 500
 
@@ -65,11 +116,44 @@ const STKExpiryTimeSec = 24 * 60 * 60
 // MaxTrackedSentPackets is maximum number of sent packets saved for either later retransmission or entropy calculation
 const MaxTrackedSentPackets = 2 * DefaultMaxCongestionWindow
 
-// MaxTrackedReceivedPackets is the maximum number of received packets saved for doing the entropy calculations
-const MaxTrackedReceivedPackets = 2 * DefaultMaxCongestionWindow
+// DefaultMaxTrackedReceivedPackets is the default maximum number of received packets saved for doing the entropy calculations
+const DefaultMaxTrackedReceivedPackets = 2 * DefaultMaxCongestionWindow
 
-// MaxTrackedReceivedAckRanges is the maximum number of ACK ranges tracked
-const MaxTrackedReceivedAckRanges = DefaultMaxCongestionWindow
+// MaxAckFrameSize is the maximum size of an ACK frame.
+// It is chosen such that the ACK frame fits into a packet together with a StopWaiting frame and other control frames.
+const MaxAckFrameSize ByteCount = 1000
+
+// MaxStreamControlFramesSizePerPacket is the number of bytes of a packet that stream-level control frames (WINDOW_UPDATE, BLOCKED and RST_STREAM for a stream) may use before StreamFrames are packed.
+const MaxStreamControlFramesSizePerPacket = MaxPacketSize / 2
+
+// MaxAckTimestamps is the maximum number of receive timestamps sent in an ACK frame, if sending timestamps is enabled
+const MaxAckTimestamps = 20
+
+// DefaultMaxAckRanges is the number of ACK ranges that are kept by default, even if the peer doesn't send StopWaiting frames.
+// An ACK frame can't contain more ranges anyway.
+const DefaultMaxAckRanges = 256
+
+// DefaultMaxAckRangeAge is the time after which an ACK range that didn't receive any packets is deleted
+const DefaultMaxAckRangeAge = 30 * time.Second
+
+// AcksBeforeReceivedPacketGC is the number of ACKs that must have reported a received packet before it is deleted, even if the peer doesn't send StopWaiting frames
+const AcksBeforeReceivedPacketGC = 3
+
+// RTTsBeforeReceivedPacketGC is the number of RTTs after which a received packet that was reported in AcksBeforeReceivedPacketGC ACKs is deleted
+const RTTsBeforeReceivedPacketGC = 3
+
+// DefaultMaxTrackedReceivedAckRanges is the default maximum number of ACK ranges tracked
+const DefaultMaxTrackedReceivedAckRanges = DefaultMaxCongestionWindow
+
+// MaxCryptoStreamOutOfOrderData is the maximum number of bytes of out-of-order data buffered on the crypto stream
+// prevents memory exhaustion during the handshake
+const MaxCryptoStreamOutOfOrderData ByteCount = 16 * 1024
+
+// DefaultMaxStreamSendBuffer is the default maximum number of bytes a stream buffers for sending, before Write blocks
+const DefaultMaxStreamSendBuffer ByteCount = 1 << 20
+
+// StreamReadFromBufferSize is the size of the buffers that a stream's ReadFrom reads into
+const StreamReadFromBufferSize ByteCount = 32 * 1024
 
 // MaxStreamFrameSorterGaps is the maximum number of gaps between received StreamFrames
 // prevents DoS attacks against the streamFrameSorter
@@ -94,8 +178,28 @@ const DefaultIdleTimeout = 30 * time.Second
 // MaxIdleTimeout is the maximum idle timeout that can be negotiated.
 const MaxIdleTimeout = 1 * time.Minute
 
+// ClosedSessionDeleteTimeout is the time after which a closed session is deleted from the server's session map.
+// By then, the client's idle timeout has fired, so it won't send any more packets for this connection.
+const ClosedSessionDeleteTimeout = MaxIdleTimeout
+
 // MaxTimeForCryptoHandshake is the default timeout for a connection until the crypto handshake succeeds.
 const MaxTimeForCryptoHandshake = 10 * time.Second
 
 // NumCachedCertificates is the number of cached compressed certificate chains, each taking ~1K space
 const NumCachedCertificates = 128
+
+// DefaultReplayWindow is the default time window in which client nonces are checked for replays, see handshake.NewMemoryReplayFilter.
+// CHLOs with client nonces whose timestamp lies further in the past or the future are never accepted for a 0-RTT handshake.
+const DefaultReplayWindow = 5 * time.Minute
+
+// MaxReplayFilterNonces is the maximum number of client nonces remembered by a handshake.NewMemoryReplayFilter, each taking ~100 bytes
+const MaxReplayFilterNonces = 100000
+
+// MaxNonRetransmittablePackets is the maximum number of consecutive packets containing only ACK and StopWaiting frames that are sent.
+// Since these packets are not acknowledged, a PING is added to the next one, so that the peer acknowledges it.
+// Value taken from Chrome.
+const MaxNonRetransmittablePackets = 19
+
+// DefaultWindowUpdateThreshold is the fraction of the receive window increment that may be left before a WINDOW_UPDATE is sent.
+// Chromium uses the same threshold.
+const DefaultWindowUpdateThreshold = 0.5
@@ -2,6 +2,7 @@ package protocol
 
 import (
 	"bytes"
+	"crypto/rand"
 	"encoding/binary"
 	"strconv"
 )
@@ -22,7 +23,7 @@ var SupportedVersions = []VersionNumber{
 	Version34, Version35, Version36,
 }
 
-// SupportedVersionsAsTags is needed for the SHLO crypto message
+// SupportedVersionsAsTags is needed for the SHLO crypto message and the version negotiation packet
 var SupportedVersionsAsTags []byte
 
 // SupportedVersionsAsString is needed for the Alt-Scv HTTP header
@@ -39,6 +40,29 @@ func VersionTagToNumber(v uint32) VersionNumber {
 	return VersionNumber(((v>>8)&0xff-'0')*100 + ((v>>16)&0xff-'0')*10 + ((v>>24)&0xff - '0'))
 }
 
+// IsReservedVersionTag returns true if the version tag is reserved for greasing.
+// Reserved version tags follow the pattern 0x?a?a?a?a, and are never used by an actual version.
+func IsReservedVersionTag(v uint32) bool {
+	return v&0x0f0f0f0f == 0x0a0a0a0a
+}
+
+// GetGreasedVersionTag returns a random reserved version tag
+func GetGreasedVersionTag() uint32 {
+	b := make([]byte, 4)
+	rand.Read(b) // ignore the error here. Any value will do.
+	return binary.LittleEndian.Uint32(b)&0xf0f0f0f0 | 0x0a0a0a0a
+}
+
+// GetGreasedVersionTags returns the SupportedVersionsAsTags, followed by a random reserved version tag.
+// Advertising a reserved version makes sure that peers can handle unknown versions.
+func GetGreasedVersionTags() []byte {
+	tags := make([]byte, len(SupportedVersionsAsTags), len(SupportedVersionsAsTags)+4)
+	copy(tags, SupportedVersionsAsTags)
+	grease := make([]byte, 4)
+	binary.LittleEndian.PutUint32(grease, GetGreasedVersionTag())
+	return append(tags, grease...)
+}
+
 // IsSupportedVersion returns true if the server supports this version
 func IsSupportedVersion(v VersionNumber) bool {
 	for _, t := range SupportedVersions {
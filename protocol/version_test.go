@@ -1,6 +1,8 @@
 package protocol
 
 import (
+	"encoding/binary"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
@@ -24,6 +26,35 @@ var _ = Describe("Version", func() {
 		Expect(SupportedVersionsAsString).To(Equal("36,35,34"))
 	})
 
+	Context("greasing", func() {
+		It("recognizes reserved version tags", func() {
+			Expect(IsReservedVersionTag(0x0a0a0a0a)).To(BeTrue())
+			Expect(IsReservedVersionTag(0x1a2a3a4a)).To(BeTrue())
+			Expect(IsReservedVersionTag(0x1a2a3a4b)).To(BeFalse())
+			for _, v := range SupportedVersions {
+				Expect(IsReservedVersionTag(VersionNumberToTag(v))).To(BeFalse())
+			}
+		})
+
+		It("gets random reserved version tags", func() {
+			tags := make(map[uint32]struct{})
+			for i := 0; i < 10; i++ {
+				tag := GetGreasedVersionTag()
+				Expect(IsReservedVersionTag(tag)).To(BeTrue())
+				tags[tag] = struct{}{}
+			}
+			Expect(len(tags)).To(BeNumerically(">", 1))
+		})
+
+		It("appends a reserved version tag to the supported versions", func() {
+			tags := GetGreasedVersionTags()
+			Expect(tags).To(HaveLen(len(SupportedVersionsAsTags) + 4))
+			Expect(tags[:len(SupportedVersionsAsTags)]).To(Equal(SupportedVersionsAsTags))
+			Expect(IsReservedVersionTag(binary.LittleEndian.Uint32(tags[len(SupportedVersionsAsTags):]))).To(BeTrue())
+			Expect(SupportedVersionsAsTags).To(Equal([]byte("Q034Q035Q036")))
+		})
+	})
+
 	It("recognizes supported versions", func() {
 		Expect(IsSupportedVersion(0)).To(BeFalse())
 		Expect(IsSupportedVersion(SupportedVersions[0])).To(BeTrue())
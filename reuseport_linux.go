@@ -0,0 +1,121 @@
+// +build linux
+
+package quic
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// not defined in the syscall package
+const (
+	soReusePort           = 0xf
+	soAttachReuseportCBPF = 0x33
+	bpfMod                = 0x90
+)
+
+// ListenUDPReusePort opens n UDP sockets bound to the same address, using SO_REUSEPORT.
+// It attaches a classic BPF program to the sockets that steers every packet by the first 4 bytes of its connection ID,
+// so that all packets of a connection arrive at the same socket, even if the client's address changes.
+// Packets without a connection ID are distributed by the kernel, using a hash of the 4-tuple.
+// Every socket should be served by a separate Server. The Servers should use the same Config.STKSecrets.
+func ListenUDPReusePort(addr string, n int) ([]*net.UDPConn, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("invalid number of sockets: %d", n)
+	}
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conns := make([]*net.UDPConn, 0, n)
+	closeAll := func() {
+		for _, c := range conns {
+			c.Close()
+		}
+	}
+	for i := 0; i < n; i++ {
+		fd, err := bindReusePort(udpAddr)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		if i == 0 {
+			if err = attachConnectionIDSteering(fd, n); err != nil {
+				syscall.Close(fd)
+				closeAll()
+				return nil, err
+			}
+		}
+		f := os.NewFile(uintptr(fd), "")
+		c, err := net.FilePacketConn(f)
+		f.Close()
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		conn := c.(*net.UDPConn)
+		conns = append(conns, conn)
+		// if the port was chosen by the kernel, all following sockets need to be bound to that port
+		udpAddr = conn.LocalAddr().(*net.UDPAddr)
+	}
+	return conns, nil
+}
+
+func bindReusePort(addr *net.UDPAddr) (int, error) {
+	var family int
+	var sa syscall.Sockaddr
+	if ip4 := addr.IP.To4(); ip4 != nil {
+		family = syscall.AF_INET
+		sa4 := &syscall.SockaddrInet4{Port: addr.Port}
+		copy(sa4.Addr[:], ip4)
+		sa = sa4
+	} else {
+		family = syscall.AF_INET6
+		sa6 := &syscall.SockaddrInet6{Port: addr.Port}
+		copy(sa6.Addr[:], addr.IP.To16())
+		sa = sa6
+	}
+	fd, err := syscall.Socket(family, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, syscall.IPPROTO_UDP)
+	if err != nil {
+		return -1, os.NewSyscallError("socket", err)
+	}
+	if err = syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, soReusePort, 1); err != nil {
+		syscall.Close(fd)
+		return -1, os.NewSyscallError("setsockopt", err)
+	}
+	if err = syscall.Bind(fd, sa); err != nil {
+		syscall.Close(fd)
+		return -1, os.NewSyscallError("bind", err)
+	}
+	return fd, nil
+}
+
+// attachConnectionIDSteering attaches the steering program to the SO_REUSEPORT group of the socket.
+// The program is run on the UDP payload, and returns the index of the socket that receives the packet.
+// If the index is out of range, the kernel falls back to the 4-tuple hash.
+func attachConnectionIDSteering(fd int, n int) error {
+	filter := []syscall.SockFilter{
+		// load the public flags
+		{Code: syscall.BPF_LD | syscall.BPF_B | syscall.BPF_ABS, K: 0},
+		// check if the connection ID is present
+		{Code: syscall.BPF_JMP | syscall.BPF_JSET | syscall.BPF_K, K: 0x08, Jt: 0, Jf: 3},
+		// load the first 4 bytes of the connection ID, and select the socket
+		{Code: syscall.BPF_LD | syscall.BPF_W | syscall.BPF_ABS, K: 1},
+		{Code: syscall.BPF_ALU | bpfMod | syscall.BPF_K, K: uint32(n)},
+		{Code: syscall.BPF_RET | syscall.BPF_A},
+		// no connection ID: let the kernel decide
+		{Code: syscall.BPF_RET | syscall.BPF_K, K: 0xffffffff},
+	}
+	prog := syscall.SockFprog{
+		Len:    uint16(len(filter)),
+		Filter: &filter[0],
+	}
+	_, _, errno := syscall.Syscall6(syscall.SYS_SETSOCKOPT, uintptr(fd), syscall.SOL_SOCKET, soAttachReuseportCBPF, uintptr(unsafe.Pointer(&prog)), unsafe.Sizeof(prog), 0)
+	if errno != 0 {
+		return os.NewSyscallError("setsockopt", errno)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+// +build linux
+
+package quic
+
+import (
+	"net"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SO_REUSEPORT sockets", func() {
+	var conns []*net.UDPConn
+
+	AfterEach(func() {
+		for _, c := range conns {
+			c.Close()
+		}
+	})
+
+	// receivingSocket sends a packet from a new socket, and returns the index of the socket that received it
+	receivingSocket := func(packet []byte) int {
+		client, err := net.DialUDP("udp", nil, conns[0].LocalAddr().(*net.UDPAddr))
+		Expect(err).ToNot(HaveOccurred())
+		defer client.Close()
+		_, err = client.Write(packet)
+		Expect(err).ToNot(HaveOccurred())
+		for attempt := 0; attempt < 100; attempt++ {
+			for i, c := range conns {
+				c.SetReadDeadline(time.Now().Add(5 * time.Millisecond))
+				b := make([]byte, 100)
+				n, _, err := c.ReadFromUDP(b)
+				if err == nil {
+					Expect(b[:n]).To(Equal(packet))
+					return i
+				}
+			}
+		}
+		Fail("packet not received")
+		return -1
+	}
+
+	It("opens sockets on the same port", func() {
+		var err error
+		conns, err = ListenUDPReusePort("127.0.0.1:0", 3)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(conns).To(HaveLen(3))
+		port := conns[0].LocalAddr().(*net.UDPAddr).Port
+		Expect(port).ToNot(BeZero())
+		for _, c := range conns {
+			Expect(c.LocalAddr().(*net.UDPAddr).Port).To(Equal(port))
+		}
+	})
+
+	It("steers packets by the connection ID", func() {
+		var err error
+		conns, err = ListenUDPReusePort("127.0.0.1:0", 4)
+		Expect(err).ToNot(HaveOccurred())
+		for i := byte(0); i < 8; i++ {
+			packet := []byte{0x08, 0, 0, 0, i, 0xde, 0xad, 0xbe, 0xef, 0x42}
+			// the packets are sent from different ports
+			Expect(receivingSocket(packet)).To(Equal(int(i % 4)))
+			Expect(receivingSocket(packet)).To(Equal(int(i % 4)))
+		}
+	})
+
+	It("rejects an invalid number of sockets", func() {
+		_, err := ListenUDPReusePort("127.0.0.1:0", 0)
+		Expect(err).To(MatchError("invalid number of sockets: 0"))
+	})
+})
@@ -0,0 +1,14 @@
+// +build !linux
+
+package quic
+
+import (
+	"errors"
+	"net"
+)
+
+// ListenUDPReusePort opens n UDP sockets bound to the same address, steering packets by their connection ID.
+// It is only supported on Linux.
+func ListenUDPReusePort(addr string, n int) ([]*net.UDPConn, error) {
+	return nil, errors.New("ListenUDPReusePort is only supported on Linux")
+}
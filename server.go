@@ -16,6 +16,8 @@ import (
 	"github.com/lucas-clemente/quic-go/utils"
 )
 
+var errInitialPacketTooSmall = errors.New("initial packet too small to contain a CHLO")
+
 // packetHandler handles packets
 type packetHandler interface {
 	handlePacket(*receivedPacket)
@@ -30,19 +32,25 @@ type Server struct {
 	conn      *net.UDPConn
 	connMutex sync.Mutex
 
-	signer crypto.Signer
 	scfg   *handshake.ServerConfig
+	config *Config
 
 	sessions      map[protocol.ConnectionID]packetHandler
 	sessionsMutex sync.RWMutex
 
+	// closedSessionDeleteTimeout is the time a closed session is kept in the sessions map
+	closedSessionDeleteTimeout time.Duration
+	// deleteTimers delete closed sessions from the sessions map. They are protected by the sessionsMutex, and stopped when the server is closed.
+	deleteTimers map[protocol.ConnectionID]*time.Timer
+
 	streamCallback StreamCallback
 
-	newSession func(conn connection, v protocol.VersionNumber, connectionID protocol.ConnectionID, sCfg *handshake.ServerConfig, streamCallback StreamCallback, closeCallback closeCallback) (packetHandler, error)
+	newSession func(conn connection, v protocol.VersionNumber, connectionID protocol.ConnectionID, sCfg *handshake.ServerConfig, config *Config, streamCallback StreamCallback, closeCallback closeCallback) (packetHandler, error)
 }
 
-// NewServer makes a new server
-func NewServer(addr string, tlsConfig *tls.Config, cb StreamCallback) (*Server, error) {
+// NewServer makes a new server.
+// If config is nil, the default configuration is used.
+func NewServer(addr string, tlsConfig *tls.Config, config *Config, cb StreamCallback) (*Server, error) {
 	signer, err := crypto.NewProofSource(tlsConfig)
 	if err != nil {
 		return nil, err
@@ -62,13 +70,29 @@ func NewServer(addr string, tlsConfig *tls.Config, cb StreamCallback) (*Server,
 		return nil, err
 	}
 
+	config, err = populateConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	scfg.ReplayFilter = config.ReplayFilter
+	scfg.AcceptEarlyData = config.AcceptEarlyData
+	scfg.AEADs = config.aeads()
+	if len(config.STKSecrets) > 0 {
+		if err = scfg.SetSTKSecrets(config.STKSecrets[0], config.STKSecrets[1:]...); err != nil {
+			return nil, err
+		}
+	}
+
 	return &Server{
 		addr:           udpAddr,
-		signer:         signer,
 		scfg:           scfg,
+		config:         config,
 		streamCallback: cb,
 		sessions:       map[protocol.ConnectionID]packetHandler{},
+		deleteTimers:   map[protocol.ConnectionID]*time.Timer{},
 		newSession:     newSession,
+
+		closedSessionDeleteTimeout: protocol.ClosedSessionDeleteTimeout,
 	}, nil
 }
 
@@ -114,6 +138,10 @@ func (s *Server) Close() error {
 			s.sessionsMutex.Lock()
 		}
 	}
+	for id, timer := range s.deleteTimers {
+		timer.Stop()
+		delete(s.deleteTimers, id)
+	}
 	s.sessionsMutex.Unlock()
 
 	s.connMutex.Lock()
@@ -127,6 +155,23 @@ func (s *Server) Close() error {
 	return conn.Close()
 }
 
+// RotateSTKSecret makes secret the secret used for new source address tokens.
+// Tokens created using the previous secret are still accepted, such that clients don't have to do a full handshake at once.
+func (s *Server) RotateSTKSecret(secret []byte) error {
+	return s.scfg.RotateSTKSecret(secret)
+}
+
+// SetTLSConfig replaces the certificates used for new handshakes, e.g. after they were renewed.
+// Existing sessions are not affected.
+func (s *Server) SetTLSConfig(tlsConfig *tls.Config) error {
+	signer, err := crypto.NewProofSource(tlsConfig)
+	if err != nil {
+		return err
+	}
+	s.scfg.SetSigner(signer)
+	return nil
+}
+
 func (s *Server) handlePacket(conn *net.UDPConn, remoteAddr *net.UDPAddr, packet []byte) error {
 	if protocol.ByteCount(len(packet)) > protocol.MaxPacketSize {
 		return qerr.PacketTooLarge
@@ -156,7 +201,7 @@ func (s *Server) handlePacket(conn *net.UDPConn, remoteAddr *net.UDPAddr, packet
 	// Send Version Negotiation Packet if the client is speaking a different protocol version
 	if hdr.VersionFlag && !protocol.IsSupportedVersion(hdr.VersionNumber) {
 		utils.Infof("Client offered version %d, sending VersionNegotiationPacket", hdr.VersionNumber)
-		_, err = conn.WriteToUDP(composeVersionNegotiation(hdr.ConnectionID), remoteAddr)
+		_, err = conn.WriteToUDP(composeVersionNegotiation(hdr.ConnectionID, s.scfg.VersionTags()), remoteAddr)
 		return err
 	}
 
@@ -170,12 +215,20 @@ func (s *Server) handlePacket(conn *net.UDPConn, remoteAddr *net.UDPAddr, packet
 			return errors.New("Server BUG: negotiated version not supported")
 		}
 
+		// Creating a session allocates all its state and starts goroutines.
+		// Packets that can't be the first packet of a handshake are dropped before, so that spoofed packets are cheap to handle.
+		if err = validateInitialPacket(hdr, packet[len(packet)-r.Len():]); err != nil {
+			utils.Debugf("Dropping initial packet for connection %x from %v: %s", hdr.ConnectionID, remoteAddr, err.Error())
+			return nil
+		}
+
 		utils.Infof("Serving new connection: %x, version %d from %v", hdr.ConnectionID, version, remoteAddr)
 		session, err = s.newSession(
 			&udpConn{conn: conn, currentAddr: remoteAddr},
 			version,
 			hdr.ConnectionID,
 			s.scfg,
+			s.config,
 			s.streamCallback,
 			s.closeCallback,
 		)
@@ -200,13 +253,40 @@ func (s *Server) handlePacket(conn *net.UDPConn, remoteAddr *net.UDPAddr, packet
 	return nil
 }
 
-func (s *Server) closeCallback(id protocol.ConnectionID) {
+// validateInitialPacket checks that a packet could be the first packet of a handshake, without allocating any state.
+// The first packet is not encrypted, but it is protected by a hash, and it carries the padded CHLO.
+func validateInitialPacket(hdr *PublicHeader, data []byte) error {
+	if len(hdr.Raw)+len(data) < protocol.MinInitialPacketSize {
+		return errInitialPacketTooSmall
+	}
+	_, err := (&crypto.NullAEAD{}).Open(nil, data, hdr.PacketNumber, hdr.Raw)
+	return err
+}
+
+func (s *Server) closeCallback(id protocol.ConnectionID, closed packetHandler) {
 	s.sessionsMutex.Lock()
-	s.sessions[id] = nil
-	s.sessionsMutex.Unlock()
+	defer s.sessionsMutex.Unlock()
+	s.sessions[id] = closed
+
+	if timer, ok := s.deleteTimers[id]; ok {
+		timer.Stop()
+	}
+	// Keep the entry around for a while, so that late packets are handled by the closed session (or dropped), instead of creating a new session.
+	var timer *time.Timer
+	timer = time.AfterFunc(s.closedSessionDeleteTimeout, func() {
+		s.sessionsMutex.Lock()
+		defer s.sessionsMutex.Unlock()
+		// the timer might have been replaced while this function was waiting for the mutex
+		if s.deleteTimers[id] != timer {
+			return
+		}
+		delete(s.sessions, id)
+		delete(s.deleteTimers, id)
+	})
+	s.deleteTimers[id] = timer
 }
 
-func composeVersionNegotiation(connectionID protocol.ConnectionID) []byte {
+func composeVersionNegotiation(connectionID protocol.ConnectionID, versionTags []byte) []byte {
 	fullReply := &bytes.Buffer{}
 	responsePublicHeader := PublicHeader{
 		ConnectionID: connectionID,
@@ -217,6 +297,6 @@ func composeVersionNegotiation(connectionID protocol.ConnectionID) []byte {
 	if err != nil {
 		utils.Errorf("error composing version negotiation packet: %s", err.Error())
 	}
-	fullReply.Write(protocol.SupportedVersionsAsTags)
+	fullReply.Write(versionTags)
 	return fullReply.Bytes()
 }
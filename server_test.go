@@ -2,7 +2,9 @@ package quic
 
 import (
 	"bytes"
+	"crypto/tls"
 	"net"
+	"time"
 
 	"github.com/lucas-clemente/quic-go/crypto"
 	"github.com/lucas-clemente/quic-go/handshake"
@@ -28,7 +30,7 @@ func (s *mockSession) handlePacket(*receivedPacket) {
 func (s *mockSession) run()              {}
 func (s *mockSession) Close(error) error { s.closed = true; return nil }
 
-func newMockSession(conn connection, v protocol.VersionNumber, connectionID protocol.ConnectionID, sCfg *handshake.ServerConfig, streamCallback StreamCallback, closeCallback closeCallback) (packetHandler, error) {
+func newMockSession(conn connection, v protocol.VersionNumber, connectionID protocol.ConnectionID, sCfg *handshake.ServerConfig, config *Config, streamCallback StreamCallback, closeCallback closeCallback) (packetHandler, error) {
 	return &mockSession{
 		connectionID: connectionID,
 	}, nil
@@ -43,13 +45,17 @@ var _ = Describe("Server", func() {
 
 		BeforeEach(func() {
 			server = &Server{
-				sessions:   map[protocol.ConnectionID]packetHandler{},
-				newSession: newMockSession,
+				sessions:     map[protocol.ConnectionID]packetHandler{},
+				deleteTimers: map[protocol.ConnectionID]*time.Timer{},
+				newSession:   newMockSession,
+
+				closedSessionDeleteTimeout: protocol.ClosedSessionDeleteTimeout,
 			}
 			b := &bytes.Buffer{}
 			utils.WriteUint32(b, protocol.VersionNumberToTag(protocol.SupportedVersions[0]))
-			firstPacket = []byte{0x09, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c}
-			firstPacket = append(append(firstPacket, b.Bytes()...), 0x01)
+			hdr := []byte{0x09, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c}
+			hdr = append(append(hdr, b.Bytes()...), 0x01)
+			firstPacket = append(hdr, (&crypto.NullAEAD{}).Seal(nil, make([]byte, protocol.MinInitialPacketSize), 1, hdr)...)
 		})
 
 		It("composes version negotiation packets", func() {
@@ -57,7 +63,7 @@ var _ = Describe("Server", func() {
 				[]byte{0x01 | 0x08, 0x1, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0},
 				protocol.SupportedVersionsAsTags...,
 			)
-			Expect(composeVersionNegotiation(1)).To(Equal(expected))
+			Expect(composeVersionNegotiation(1, protocol.SupportedVersionsAsTags)).To(Equal(expected))
 		})
 
 		It("creates new sessions", func() {
@@ -79,15 +85,56 @@ var _ = Describe("Server", func() {
 		})
 
 		It("closes and deletes sessions", func() {
-			err := server.handlePacket(nil, nil, append(firstPacket, (&crypto.NullAEAD{}).Seal(nil, nil, 0, firstPacket)...))
+			err := server.handlePacket(nil, nil, firstPacket)
 			Expect(err).ToNot(HaveOccurred())
 			Expect(server.sessions).To(HaveLen(1))
-			server.closeCallback(0x4cfa9f9b668619f6)
+			server.closeCallback(0x4cfa9f9b668619f6, nil)
 			// The server should now have closed the session, leaving a nil value in the sessions map
 			Expect(server.sessions).To(HaveLen(1))
 			Expect(server.sessions[0x4cfa9f9b668619f6]).To(BeNil())
 		})
 
+		It("deletes closed sessions after a timeout", func() {
+			server.closedSessionDeleteTimeout = 10 * time.Millisecond
+			err := server.handlePacket(nil, nil, firstPacket)
+			Expect(err).ToNot(HaveOccurred())
+			closed := &mockSession{}
+			server.closeCallback(0x4cfa9f9b668619f6, closed)
+			server.sessionsMutex.RLock()
+			Expect(server.sessions).To(HaveKey(protocol.ConnectionID(0x4cfa9f9b668619f6)))
+			server.sessionsMutex.RUnlock()
+			Eventually(func() int {
+				server.sessionsMutex.RLock()
+				defer server.sessionsMutex.RUnlock()
+				return len(server.sessions)
+			}).Should(BeZero())
+		})
+
+		It("stops the timers deleting closed sessions when Close is called", func() {
+			server.closedSessionDeleteTimeout = 10 * time.Millisecond
+			server.closeCallback(0x4cfa9f9b668619f6, &mockSession{})
+			Expect(server.deleteTimers).To(HaveLen(1))
+			err := server.Close()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(server.deleteTimers).To(BeEmpty())
+			Consistently(func() int {
+				server.sessionsMutex.RLock()
+				defer server.sessionsMutex.RUnlock()
+				return len(server.sessions)
+			}, 50*time.Millisecond).Should(Equal(1))
+		})
+
+		It("replaces closed sessions", func() {
+			err := server.handlePacket(nil, nil, firstPacket)
+			Expect(err).ToNot(HaveOccurred())
+			closed := &mockSession{}
+			server.closeCallback(0x4cfa9f9b668619f6, closed)
+			Expect(server.sessions[0x4cfa9f9b668619f6]).To(BeIdenticalTo(closed))
+			err = server.handlePacket(nil, nil, []byte{0x08, 0xf6, 0x19, 0x86, 0x66, 0x9b, 0x9f, 0xfa, 0x4c, 0x01})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(closed.packetCount).To(Equal(1))
+		})
+
 		It("closes sessions when Close is called", func() {
 			session := &mockSession{}
 			server.sessions[1] = session
@@ -121,6 +168,20 @@ var _ = Describe("Server", func() {
 			Expect(server.sessions[0x4cfa9f9b668619f6].(*mockSession).packetCount).To(Equal(1))
 		})
 
+		It("doesn't create sessions for small packets", func() {
+			hdr := firstPacket[:len(firstPacket)-protocol.MinInitialPacketSize-12]
+			err := server.handlePacket(nil, nil, append(hdr, (&crypto.NullAEAD{}).Seal(nil, []byte("foobar"), 1, hdr)...))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(server.sessions).To(BeEmpty())
+		})
+
+		It("doesn't create sessions for packets with an invalid hash", func() {
+			firstPacket[len(firstPacket)-1] ^= 0xff
+			err := server.handlePacket(nil, nil, firstPacket)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(server.sessions).To(BeEmpty())
+		})
+
 		It("errors on invalid public header", func() {
 			err := server.handlePacket(nil, nil, nil)
 			Expect(err.(*qerr.QuicError).ErrorCode).To(Equal(qerr.InvalidPacketHeader))
@@ -132,11 +193,80 @@ var _ = Describe("Server", func() {
 		})
 	})
 
+	It("uses the default config if none is given", func() {
+		server, err := NewServer("", testdata.GetTLSConfig(), nil, nil)
+		Expect(err).ToNot(HaveOccurred())
+		defaultConfig, err := populateConfig(nil)
+		Expect(err).ToNot(HaveOccurred())
+		// every config gets its own replay filter
+		Expect(server.config.ReplayFilter).ToNot(BeNil())
+		defaultConfig.ReplayFilter = server.config.ReplayFilter
+		Expect(server.config).To(Equal(defaultConfig))
+	})
+
+	It("uses the replay filter for the server config", func() {
+		filter := handshake.NewMemoryReplayFilter(time.Second)
+		server, err := NewServer("", testdata.GetTLSConfig(), &Config{ReplayFilter: filter}, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(server.scfg.ReplayFilter).To(BeIdenticalTo(filter))
+	})
+
+	It("uses the early data callback for the server config", func() {
+		var called bool
+		config := &Config{AcceptEarlyData: func(net.IP, string) bool { called = true; return true }}
+		server, err := NewServer("", testdata.GetTLSConfig(), config, nil)
+		Expect(err).ToNot(HaveOccurred())
+		server.scfg.AcceptEarlyData(nil, "")
+		Expect(called).To(BeTrue())
+	})
+
+	It("uses the AEAD preference for the server config", func() {
+		if !crypto.SupportsChacha20Poly1305 {
+			Skip("ChaCha20-Poly1305 is not supported")
+		}
+		server, err := NewServer("", testdata.GetTLSConfig(), &Config{AEADPreference: AEADPreferenceChaCha20}, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(server.scfg.AEADs).To(Equal([]handshake.Tag{handshake.TagCC20, handshake.TagAESG}))
+		server, err = NewServer("", testdata.GetTLSConfig(), nil, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(server.scfg.AEADs).To(BeNil())
+	})
+
+	It("replaces the certificates", func() {
+		server, err := NewServer("", testdata.GetTLSConfig(), nil, nil)
+		Expect(err).ToNot(HaveOccurred())
+		cert, err := server.scfg.GetLeafCert("")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cert).To(Equal(testdata.GetTLSConfig().Certificates[0].Certificate[0]))
+		tlsConfig := &tls.Config{
+			Certificates: []tls.Certificate{{Certificate: [][]byte{[]byte("new cert")}}},
+		}
+		err = server.SetTLSConfig(tlsConfig)
+		Expect(err).ToNot(HaveOccurred())
+		cert, err = server.scfg.GetLeafCert("")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cert).To(Equal([]byte("new cert")))
+	})
+
+	It("copies the config", func() {
+		config := &Config{CongestionControl: CongestionControlNewReno}
+		server, err := NewServer("", testdata.GetTLSConfig(), config, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(server.config.CongestionControl).To(Equal(CongestionControlNewReno))
+		Expect(server.config).ToNot(BeIdenticalTo(config))
+	})
+
+	It("errors when the config is invalid", func() {
+		config := &Config{InitialCongestionWindow: 1}
+		_, err := NewServer("", testdata.GetTLSConfig(), config, nil)
+		Expect(err).To(HaveOccurred())
+	})
+
 	It("setups and responds with version negotiation", func(done Done) {
 		addr, err := net.ResolveUDPAddr("udp", "127.0.0.1:0")
 		Expect(err).ToNot(HaveOccurred())
 
-		server, err := NewServer("", testdata.GetTLSConfig(), nil)
+		server, err := NewServer("", testdata.GetTLSConfig(), nil, nil)
 		Expect(err).ToNot(HaveOccurred())
 
 		serverConn, err := net.ListenUDP("udp", addr)
@@ -163,7 +293,7 @@ var _ = Describe("Server", func() {
 		data = data[:n]
 		expected := append(
 			[]byte{0x9, 0x1, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0},
-			protocol.SupportedVersionsAsTags...,
+			server.scfg.VersionTags()...,
 		)
 		Expect(data).To(Equal(expected))
 
@@ -171,11 +301,52 @@ var _ = Describe("Server", func() {
 		Expect(err).ToNot(HaveOccurred())
 	})
 
+	It("responds with version negotiation if the client offers a reserved version", func(done Done) {
+		addr, err := net.ResolveUDPAddr("udp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+
+		server, err := NewServer("", testdata.GetTLSConfig(), nil, nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		serverConn, err := net.ListenUDP("udp", addr)
+		Expect(err).NotTo(HaveOccurred())
+
+		addr = serverConn.LocalAddr().(*net.UDPAddr)
+
+		go func() {
+			defer GinkgoRecover()
+			err2 := server.Serve(serverConn)
+			Expect(err2).ToNot(HaveOccurred())
+			close(done)
+		}()
+
+		clientConn, err := net.DialUDP("udp", nil, addr)
+		Expect(err).ToNot(HaveOccurred())
+
+		b := &bytes.Buffer{}
+		utils.WriteUint32(b, protocol.GetGreasedVersionTag())
+		packet := append([]byte{0x09, 0x01, 0, 0, 0, 0, 0, 0, 0}, b.Bytes()...)
+		_, err = clientConn.Write(append(packet, 0x01))
+		Expect(err).NotTo(HaveOccurred())
+		data := make([]byte, 1000)
+		var n int
+		n, _, err = clientConn.ReadFromUDP(data)
+		Expect(err).NotTo(HaveOccurred())
+		data = data[:n]
+		Expect(data).To(Equal(append([]byte{0x9, 0x1, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0}, server.scfg.VersionTags()...)))
+		server.sessionsMutex.RLock()
+		Expect(server.sessions).To(BeEmpty())
+		server.sessionsMutex.RUnlock()
+
+		err = server.Close()
+		Expect(err).ToNot(HaveOccurred())
+	})
+
 	It("sends a public reset for new connections that don't have the VersionFlag set", func(done Done) {
 		addr, err := net.ResolveUDPAddr("udp", "127.0.0.1:0")
 		Expect(err).ToNot(HaveOccurred())
 
-		server, err := NewServer("", testdata.GetTLSConfig(), nil)
+		server, err := NewServer("", testdata.GetTLSConfig(), nil, nil)
 		Expect(err).ToNot(HaveOccurred())
 
 		serverConn, err := net.ListenUDP("udp", addr)
@@ -211,7 +382,7 @@ var _ = Describe("Server", func() {
 		addr, err := net.ResolveUDPAddr("udp", "127.0.0.1:0")
 		Expect(err).ToNot(HaveOccurred())
 
-		server, err := NewServer("", testdata.GetTLSConfig(), nil)
+		server, err := NewServer("", testdata.GetTLSConfig(), nil, nil)
 		Expect(err).ToNot(HaveOccurred())
 
 		serverConn, err := net.ListenUDP("udp", addr)
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net"
 	"runtime"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -16,12 +17,31 @@ import (
 	"github.com/lucas-clemente/quic-go/protocol"
 	"github.com/lucas-clemente/quic-go/qerr"
 	"github.com/lucas-clemente/quic-go/utils"
+	"golang.org/x/net/context"
 )
 
 type unpacker interface {
 	Unpack(publicHeaderBinary []byte, hdr *PublicHeader, data []byte) (*unpackedPacket, error)
 }
 
+// pendingPing is a call of Session.Ping that wasn't answered yet
+type pendingPing struct {
+	// sentPackets are the packets containing a PING frame that were sent after Ping was called.
+	// The PING frame queued by Ping is sent in the first of them, retransmissions in later ones.
+	sentPackets []sentPingPacket
+	result      chan pingResult
+}
+
+type sentPingPacket struct {
+	packetNumber protocol.PacketNumber
+	sentTime     time.Time
+}
+
+type pingResult struct {
+	rtt time.Duration
+	err error
+}
+
 type receivedPacket struct {
 	remoteAddr   interface{}
 	publicHeader *PublicHeader
@@ -33,13 +53,145 @@ var (
 	errRstStreamOnInvalidStream   = errors.New("RST_STREAM received for unknown stream")
 	errWindowUpdateOnClosedStream = errors.New("WINDOW_UPDATE received for an already closed stream")
 	errSessionAlreadyClosed       = errors.New("Cannot close Session. It was already closed before.")
+	errDebugSnapshotsDisabled     = errors.New("Debug snapshots are disabled. Set Config.EnableDebugSnapshots to enable them.")
+	errDebugSnapshotSessionClosed = errors.New("Cannot take a debug snapshot. The session is closed.")
 )
 
-// StreamCallback gets a stream frame and returns a reply frame
-type StreamCallback func(*Session, utils.Stream)
+// ConnectionState records basic details about the QUIC connection.
+// Since gQUIC doesn't support client certificates, there are no peer certificates.
+type ConnectionState struct {
+	// Version is the QUIC version of the connection
+	Version protocol.VersionNumber
+	// HandshakeComplete is true once the client sent the first forward secure packet
+	HandshakeComplete bool
+	// ServerName is the server name requested by the client (SNI)
+	ServerName string
+	// AEAD is the tag of the negotiated AEAD, e.g. AESG
+	AEAD string
+	// KeyExchange is the tag of the negotiated key exchange algorithm, e.g. C255
+	KeyExchange string
+}
+
+// SessionStats is a snapshot of the congestion controller and flow control state.
+// It is sampled every time an ACK is received.
+type SessionStats struct {
+	// CongestionWindow is the current congestion window
+	CongestionWindow protocol.ByteCount
+	// SlowStartThreshold is the slow start threshold of the congestion controller
+	SlowStartThreshold protocol.ByteCount
+	// BandwidthEstimate is the current estimate of the available bandwidth.
+	// It can be used by applications to adapt their sending rate, e.g. for adaptive bitrate streaming.
+	BandwidthEstimate congestion.Bandwidth
+	// PacingRate is the rate at which packets are sent out
+	PacingRate congestion.Bandwidth
+	// BytesInFlight is the number of bytes sent, but not yet acknowledged
+	BytesInFlight protocol.ByteCount
+	// CongestionLimited is true if sending is currently blocked by the congestion controller
+	CongestionLimited bool
+	// FlowControlLimited is true if sending is currently blocked by connection-level flow control
+	FlowControlLimited bool
+	// MinRTT is the minimum RTT observed during the last protocol.MinRTTWindow
+	MinRTT time.Duration
+	// PacketsLost is the number of packets that were declared lost
+	PacketsLost uint64
+	// PacketsRetransmitted is the number of lost packets whose frames were retransmitted
+	PacketsRetransmitted uint64
+	// SpuriousLosses is the number of packets that were declared lost, but acknowledged later
+	SpuriousLosses uint64
+	// RTOCount is the number of retransmission timeouts
+	RTOCount uint64
+	// ConnectionBlockedCount is the number of times sending was blocked by connection-level flow control
+	ConnectionBlockedCount uint64
+	// StreamBlockedCount is the number of times a stream was blocked by stream-level flow control
+	StreamBlockedCount uint64
+	// ConnectionBlockedTime is the total time the connection-level flow control window was used up
+	ConnectionBlockedTime time.Duration
+	// ForwardQueuingDelay is the queuing delay on the path to the client, estimated from the receive timestamps in the client's ACK frames.
+	// It is 0 if the client doesn't send timestamps.
+	ForwardQueuingDelay time.Duration
+	// ReverseQueuingDelay is the queuing delay on the path from the client, estimated from the latest RTT sample and the ForwardQueuingDelay.
+	// It is 0 if the client doesn't send timestamps.
+	ReverseQueuingDelay time.Duration
+	// KeepAliveInterval is the time the session may be idle before a keep-alive PING is sent, or 0 if keep-alives are disabled.
+	// It changes while the NAT binding timeout is discovered, see Config.DiscoverKeepAliveInterval.
+	KeepAliveInterval time.Duration
+}
+
+// DebugSnapshot is a snapshot of the internal state of a session, used for debugging stuck connections.
+// It is taken by the run loop when requested using Session.DebugSnapshot, if Config.EnableDebugSnapshots is set.
+type DebugSnapshot struct {
+	// Time is when the snapshot was taken
+	Time time.Time
+	// AckRanges are the ranges of received packets that are held to be acknowledged, starting with the highest range
+	AckRanges []frames.AckRange
+	// LargestReceivedPacketNumber is the largest packet number received from the client
+	LargestReceivedPacketNumber protocol.PacketNumber
+	// BytesInFlight is the number of bytes sent, but not yet acknowledged
+	BytesInFlight protocol.ByteCount
+	// LeastUnacked is the lowest packet number that was sent, but not yet acknowledged
+	LeastUnacked protocol.PacketNumber
+	// SendingAllowed is false if sending is currently blocked by the congestion controller
+	SendingAllowed bool
+	// ConnectionSendWindow is the remaining connection-level flow control window
+	ConnectionSendWindow protocol.ByteCount
+	// Streams contains the state of all open streams
+	Streams []StreamDebugSnapshot
+	// IdleTimeout is when the connection is closed if no packets are received
+	IdleTimeout time.Time
+	// RetransmissionTimeout is when the next retransmission timer fires. It is zero if no packets are outstanding.
+	RetransmissionTimeout time.Time
+	// PacingDeadline is when the pacer allows sending the next packet. It is zero if sending is allowed right away.
+	PacingDeadline time.Time
+	// DelayedAckDeadline is when a delayed ACK is sent. It is zero if no ACK is pending.
+	DelayedAckDeadline time.Time
+	// TimerDeadline is the deadline the run loop timer is currently set to
+	TimerDeadline time.Time
+	// UndecryptablePackets is the number of packets queued until the keys to decrypt them are available
+	UndecryptablePackets int
+}
 
-// closeCallback is called when a session is closed
-type closeCallback func(id protocol.ConnectionID)
+// StreamDebugSnapshot is a snapshot of the state of a single stream
+type StreamDebugSnapshot struct {
+	StreamID protocol.StreamID
+	// BytesWritten is the number of bytes handed to the packet packer
+	BytesWritten protocol.ByteCount
+	// BytesQueued is the number of bytes written by the application, but not yet handed to the packet packer
+	BytesQueued protocol.ByteCount
+	// SendWindow is the remaining stream-level flow control window
+	SendWindow protocol.ByteCount
+	// FinSent is true once the FIN bit was sent
+	FinSent bool
+}
+
+// StreamCallback gets a stream frame and returns a reply frame
+type StreamCallback func(SessionHandle, utils.Stream)
+
+// closeCallback is called when a session is closed.
+// If the session sent a CONNECTION_CLOSE, closed handles the packets that arrive afterwards, otherwise it is nil.
+type closeCallback func(id protocol.ConnectionID, closed packetHandler)
+
+// ConnectionEvents contains callbacks for state changes of a session. All of them are optional.
+// They are called synchronously from the session, so they must not block.
+type ConnectionEvents struct {
+	// VersionNegotiated is called when the session starts, with the QUIC version it uses
+	VersionNegotiated func(s SessionHandle, version protocol.VersionNumber)
+	// KeysChanged is called when the session starts using new packet protection keys.
+	// This happens twice: after the full CHLO was processed, and once the client sends the first forward-secure packet.
+	KeysChanged func(s SessionHandle, forwardSecure bool)
+	// HandshakeComplete is called once the client sent the first forward-secure packet
+	HandshakeComplete func(s SessionHandle)
+	// Migrated is called when the IP address of the client changed
+	Migrated func(s SessionHandle, oldAddr, newAddr *net.UDPAddr)
+	// GoingAway is called when a GOAWAY frame is sent to the client
+	GoingAway func(s SessionHandle)
+	// FlowControlBlocked is called when sending data on a stream is blocked by the client's flow control window, and a BLOCKED frame is sent.
+	// streamID is 0 if the connection-level window is used up. Writes to the stream don't progress until the client sends a WINDOW_UPDATE.
+	// Whether sending is blocked by the congestion controller instead can be checked using s.Stats().
+	FlowControlBlocked func(s SessionHandle, streamID protocol.StreamID)
+	// Closed is called exactly once, when the session is closed.
+	// err is the *qerr.QuicError the session was closed with, and remote is true if the client closed it.
+	Closed func(s SessionHandle, err error, remote bool)
+}
 
 // A Session is a QUIC session
 type Session struct {
@@ -53,6 +205,8 @@ type Session struct {
 
 	streamsMap *streamsMap
 
+	// clock is used for all time measurements, so that tests can use a virtual clock
+	clock    congestion.Clock
 	rttStats *congestion.RTTStats
 
 	sentPacketHandler     ackhandler.SentPacketHandler
@@ -60,6 +214,44 @@ type Session struct {
 	streamFramer          *streamFramer
 
 	flowControlManager flowcontrol.FlowControlManager
+	// maxStreamOutOfOrderData limits the out-of-order data buffered per stream
+	maxStreamOutOfOrderData protocol.ByteCount
+	// maxStreamSendBuffer limits the data buffered for sending per stream
+	maxStreamSendBuffer protocol.ByteCount
+	// disableActiveMigration makes the session ignore packets sent from a different IP address
+	disableActiveMigration bool
+
+	statsMutex sync.Mutex
+	stats      SessionStats
+	// connectionBlockedSince is the time when the connection-level flow control window was used up, or zero if it isn't
+	connectionBlockedSince time.Time
+	connectionBlockedTime  time.Duration
+
+	// debugSnapshots enables taking DebugSnapshots
+	debugSnapshots bool
+	// debugSnapshotRequests are sent to the run loop by DebugSnapshot, which then takes a snapshot and sends it back
+	debugSnapshotRequests chan chan<- debugSnapshotResult
+
+	// pingsMutex protects pings and pingsClosedErr, since Ping is called from outside the run loop
+	pingsMutex sync.Mutex
+	pings      []*pendingPing
+	// pingsClosedErr is set when the session is closed
+	pingsClosedErr error
+
+	// maxBandwidthMutex protects maxBandwidth and maxBandwidthChanged, since SetMaxBandwidth is called from outside the run loop
+	maxBandwidthMutex   sync.Mutex
+	maxBandwidth        congestion.Bandwidth
+	maxBandwidthChanged bool
+	// maxPacingRate is the Config.MaxPacingRate, which SetMaxBandwidth(0) goes back to
+	maxPacingRate congestion.Bandwidth
+
+	// keepAlive is nil if keep-alives are disabled
+	keepAlive *keepAliveProber
+	// keepAlivePing is the keep-alive PING that is waiting for an acknowledgement, if any
+	keepAlivePing     *pendingPing
+	keepAlivePingSent time.Time
+	// keepAliveLostTime is when the last keep-alive PING was regarded as lost. No keep-alives are sent until a packet is received after that, since the NAT binding expired.
+	keepAliveLostTime time.Time
 
 	unpacker unpacker
 	packer   *packetPacker
@@ -70,13 +262,22 @@ type Session struct {
 	sendingScheduled chan struct{}
 	// closeChan is used to notify the run loop that it should terminate.
 	// If the value is not nil, the error is sent as a CONNECTION_CLOSE.
-	closeChan chan *qerr.QuicError
-	runClosed chan struct{}
-	closed    uint32 // atomic bool
+	closeChan  chan *qerr.QuicError
+	runClosed  chan struct{}
+	runDone    chan struct{}
+	closed     uint32 // atomic bool
+	goawaySent uint32 // atomic bool
 
 	undecryptablePackets []*receivedPacket
 	aeadChanged          chan struct{}
 
+	// connectionClosePacket is the CONNECTION_CLOSE packet that was sent, if any
+	connectionClosePacket []byte
+
+	connectionEvents ConnectionEvents
+	// handshakeComplete is set in the run loop, once the ConnectionEvents were notified about the completed handshake
+	handshakeComplete bool
+
 	delayedAckOriginTime time.Time
 
 	connectionParameters handshake.ConnectionParametersManager
@@ -89,26 +290,43 @@ type Session struct {
 	sessionCreationTime     time.Time
 	lastNetworkActivityTime time.Time
 
-	timer           *time.Timer
+	timer           congestion.Timer
 	currentDeadline time.Time
 	timerRead       bool
 }
 
+func newCongestionController(clock congestion.Clock, config *Config, rttStats *congestion.RTTStats) congestion.SendAlgorithm {
+	if config.CongestionControl == CongestionControlNewReno {
+		return congestion.NewNewRenoSender(rttStats, config.initialCongestionWindowPackets(), config.minCongestionWindowPackets(), config.maxCongestionWindowPackets())
+	}
+	return congestion.NewCubicSender(
+		clock,
+		rttStats,
+		false, /* don't use reno since chromium doesn't (why?) */
+		config.initialCongestionWindowPackets(),
+		config.minCongestionWindowPackets(),
+		config.maxCongestionWindowPackets(),
+	)
+}
+
 // newSession makes a new session
-func newSession(conn connection, v protocol.VersionNumber, connectionID protocol.ConnectionID, sCfg *handshake.ServerConfig, streamCallback StreamCallback, closeCallback closeCallback) (packetHandler, error) {
+func newSession(conn connection, v protocol.VersionNumber, connectionID protocol.ConnectionID, sCfg *handshake.ServerConfig, config *Config, streamCallback StreamCallback, closeCallback closeCallback) (packetHandler, error) {
 	connectionParameters := handshake.NewConnectionParamatersManager(v)
 
 	var sentPacketHandler ackhandler.SentPacketHandler
 	var receivedPacketHandler ackhandler.ReceivedPacketHandler
 
-	rttStats := &congestion.RTTStats{}
+	clock := config.clock
+	rttStats := congestion.NewRTTStats()
+	rttStats.SetRecentMinRTTwindow(protocol.MinRTTWindow)
 
-	sentPacketHandler = ackhandler.NewSentPacketHandler(rttStats)
-	receivedPacketHandler = ackhandler.NewReceivedPacketHandler()
-	flowControlManager := flowcontrol.NewFlowControlManager(connectionParameters, rttStats)
+	sentPacketHandler = ackhandler.NewSentPacketHandler(clock, rttStats, newCongestionController(clock, config, rttStats), config.MaxPacingRate, config.PacingBurstSize, config.serverPacer)
+	receivedPacketHandler = ackhandler.NewReceivedPacketHandler(clock, rttStats, config.AckElicitingThreshold, config.MaxAckRanges, config.MaxAckRangeAge, config.MaxTrackedReceivedPackets, config.MaxTrackedReceivedAckRanges, config.SendAckTimestamps)
+	flowControlManager := flowcontrol.NewFlowControlManager(clock, connectionParameters, rttStats, config.WindowUpdateThreshold, config.WindowUpdateRTTs, config.MaxConnectionReceiveBuffer)
 
-	now := time.Now()
+	now := clock.Now()
 	session := &Session{
+		clock:        clock,
 		conn:         conn,
 		connectionID: connectionID,
 		version:      v,
@@ -120,6 +338,14 @@ func newSession(conn connection, v protocol.VersionNumber, connectionID protocol
 		sentPacketHandler:     sentPacketHandler,
 		receivedPacketHandler: receivedPacketHandler,
 		flowControlManager:    flowControlManager,
+		rttStats:              rttStats,
+
+		maxPacingRate:           config.MaxPacingRate,
+		maxStreamOutOfOrderData: config.MaxStreamOutOfOrderData,
+		maxStreamSendBuffer:     config.MaxStreamSendBuffer,
+		disableActiveMigration:  config.DisableActiveMigration,
+		debugSnapshots:          config.EnableDebugSnapshots,
+		connectionEvents:        config.ConnectionEvents,
 
 		receivedPackets:      make(chan *receivedPacket, protocol.MaxSessionUnprocessedPackets),
 		closeChan:            make(chan *qerr.QuicError, 1),
@@ -127,12 +353,19 @@ func newSession(conn connection, v protocol.VersionNumber, connectionID protocol
 		undecryptablePackets: make([]*receivedPacket, 0, protocol.MaxUndecryptablePackets),
 		aeadChanged:          make(chan struct{}, 1),
 		runClosed:            make(chan struct{}, 1), // this channel will receive once the run loop has been stopped
+		runDone:              make(chan struct{}),    // this channel will be closed once the run loop has been stopped
+
+		debugSnapshotRequests: make(chan chan<- debugSnapshotResult),
 
-		timer: time.NewTimer(0),
+		timer: clock.NewTimer(0),
 		lastNetworkActivityTime: now,
 		sessionCreationTime:     now,
 	}
 
+	if config.KeepAliveInterval > 0 {
+		session.keepAlive = newKeepAliveProber(config.MinKeepAliveInterval, config.KeepAliveInterval, config.DiscoverKeepAliveInterval)
+	}
+
 	session.streamsMap = newStreamsMap(session.newStream, session.connectionParameters)
 
 	cryptoStream, _ := session.GetOrOpenStream(1)
@@ -151,6 +384,10 @@ func newSession(conn connection, v protocol.VersionNumber, connectionID protocol
 
 // run the session main loop
 func (s *Session) run() {
+	if cb := s.connectionEvents.VersionNegotiated; cb != nil {
+		cb(s, s.version)
+	}
+
 	// Start the crypto stream handler
 	go func() {
 		if err := s.cryptoSetup.HandleCryptoStream(); err != nil {
@@ -179,7 +416,7 @@ runLoop:
 				s.sendConnectionClose(errForConnClose)
 			}
 			break runLoop
-		case <-s.timer.C:
+		case <-s.timer.Chan():
 			s.timerRead = true
 			// We do all the interesting stuff after the switch statement, so
 			// nothing to see here.
@@ -199,34 +436,71 @@ runLoop:
 				s.delayedAckOriginTime = p.rcvTime
 			}
 		case <-s.aeadChanged:
+			if cb := s.connectionEvents.KeysChanged; cb != nil {
+				cb(s, false)
+			}
 			s.tryDecryptingQueuedPackets()
+		case result := <-s.debugSnapshotRequests:
+			snapshot, err := s.takeDebugSnapshot()
+			result <- debugSnapshotResult{snapshot: snapshot, err: err}
+			// taking a snapshot doesn't change the state, so there's nothing else to do
+			continue
 		}
 
 		if err != nil {
 			s.close(err)
 		}
 
+		if !s.handshakeComplete && s.cryptoSetup.HandshakeComplete() {
+			s.handshakeComplete = true
+			if cb := s.connectionEvents.KeysChanged; cb != nil {
+				cb(s, true)
+			}
+			if cb := s.connectionEvents.HandshakeComplete; cb != nil {
+				cb(s)
+			}
+		}
+
+		s.applyMaxBandwidth()
+		s.maybeSendKeepAlive()
 		if err := s.sendPacket(); err != nil {
 			s.close(err)
 		}
-		if time.Now().Sub(s.lastNetworkActivityTime) >= s.idleTimeout() {
+		if s.connectionBlockedSince.IsZero() && s.flowControlManager.RemainingConnectionWindowSize() == 0 {
+			s.connectionBlockedSince = s.clock.Now()
+		}
+		if s.clock.Now().Sub(s.lastNetworkActivityTime) >= s.idleTimeout() {
 			s.close(qerr.Error(qerr.NetworkIdleTimeout, "No recent network activity."))
 		}
-		if !s.cryptoSetup.HandshakeComplete() && time.Now().Sub(s.sessionCreationTime) >= protocol.MaxTimeForCryptoHandshake {
+		if !s.cryptoSetup.HandshakeComplete() && s.clock.Now().Sub(s.sessionCreationTime) >= protocol.MaxTimeForCryptoHandshake {
 			s.close(qerr.Error(qerr.NetworkIdleTimeout, "Crypto handshake did not complete in time."))
 		}
 		s.garbageCollectStreams()
 	}
 
-	s.closeCallback(s.connectionID)
+	var closed packetHandler
+	if s.connectionClosePacket != nil {
+		closed = newClosedSession(s.connectionID, s.conn, s.connectionClosePacket)
+	}
+	s.closeCallback(s.connectionID, closed)
+	close(s.runDone)
 	s.runClosed <- struct{}{}
 }
 
 func (s *Session) maybeResetTimer() {
 	nextDeadline := s.lastNetworkActivityTime.Add(s.idleTimeout())
 
+	// the pacing deadline is only relevant if it lies in the future. Otherwise, sending is already allowed
+	pacingDeadline := s.sentPacketHandler.TimeUntilSend()
+	if !pacingDeadline.After(s.clock.Now()) {
+		pacingDeadline = time.Time{}
+	}
+	if !pacingDeadline.IsZero() {
+		nextDeadline = utils.MinTime(nextDeadline, pacingDeadline)
+	}
 	if !s.delayedAckOriginTime.IsZero() {
-		nextDeadline = utils.MinTime(nextDeadline, s.delayedAckOriginTime.Add(protocol.AckSendDelay))
+		// an ACK can't be sent before the pacer allows it
+		nextDeadline = utils.MinTime(nextDeadline, utils.MaxTime(s.delayedAckOriginTime.Add(protocol.AckSendDelay), pacingDeadline))
 	}
 	if rtoTime := s.sentPacketHandler.TimeOfFirstRTO(); !rtoTime.IsZero() {
 		nextDeadline = utils.MinTime(nextDeadline, rtoTime)
@@ -235,6 +509,9 @@ func (s *Session) maybeResetTimer() {
 		handshakeDeadline := s.sessionCreationTime.Add(protocol.MaxTimeForCryptoHandshake)
 		nextDeadline = utils.MinTime(nextDeadline, handshakeDeadline)
 	}
+	if keepAliveDeadline := s.keepAliveDeadline(); !keepAliveDeadline.IsZero() {
+		nextDeadline = utils.MinTime(nextDeadline, keepAliveDeadline)
+	}
 
 	if nextDeadline.Equal(s.currentDeadline) {
 		// No need to reset the timer
@@ -244,9 +521,9 @@ func (s *Session) maybeResetTimer() {
 	// We need to drain the timer if the value from its channel was not read yet.
 	// See https://groups.google.com/forum/#!topic/golang-dev/c9UUfASVPoU
 	if !s.timer.Stop() && !s.timerRead {
-		<-s.timer.C
+		<-s.timer.Chan()
 	}
-	s.timer.Reset(nextDeadline.Sub(time.Now()))
+	s.timer.Reset(nextDeadline.Sub(s.clock.Now()))
 
 	s.timerRead = false
 	s.currentDeadline = nextDeadline
@@ -262,9 +539,15 @@ func (s *Session) idleTimeout() time.Duration {
 func (s *Session) handlePacketImpl(p *receivedPacket) error {
 	if p.rcvTime.IsZero() {
 		// To simplify testing
-		p.rcvTime = time.Now()
+		p.rcvTime = s.clock.Now()
+	}
+
+	if s.disableActiveMigration && s.pathChanged(p.remoteAddr) {
+		utils.Debugf("Ignoring packet for connection %x from %s, since active migration is disabled", s.connectionID, p.remoteAddr)
+		return nil
 	}
 
+	idleTime := p.rcvTime.Sub(s.lastNetworkActivityTime)
 	s.lastNetworkActivityTime = p.rcvTime
 	hdr := p.publicHeader
 	data := p.data
@@ -279,9 +562,6 @@ func (s *Session) handlePacketImpl(p *receivedPacket) error {
 		utils.Debugf("<- Reading packet 0x%x (%d bytes) for connection %x @ %s", hdr.PacketNumber, len(data)+len(hdr.Raw), hdr.ConnectionID, time.Now().Format("15:04:05.000"))
 	}
 
-	// TODO: Only do this after authenticating
-	s.conn.setCurrentRemoteAddr(p.remoteAddr)
-
 	packet, err := s.unpacker.Unpack(hdr.Raw, hdr, data)
 	if err != nil {
 		return err
@@ -289,9 +569,16 @@ func (s *Session) handlePacketImpl(p *receivedPacket) error {
 
 	s.lastRcvdPacketNumber = hdr.PacketNumber
 	// Only do this after decrypting, so we are sure the packet is not attacker-controlled
+	// Reordered packets that were sent before a migration must not change the remote address back
+	if hdr.PacketNumber > s.largestRcvdPacketNumber {
+		if s.keepAlive != nil && s.portChanged(p.remoteAddr) {
+			s.keepAlive.OnRebinding(idleTime)
+		}
+		s.updateRemoteAddr(p.remoteAddr)
+	}
 	s.largestRcvdPacketNumber = utils.MaxPacketNumber(s.largestRcvdPacketNumber, hdr.PacketNumber)
 
-	err = s.receivedPacketHandler.ReceivedPacket(hdr.PacketNumber)
+	err = s.receivedPacketHandler.ReceivedPacket(hdr.PacketNumber, p.rcvTime, shouldInstigateAck(packet.frames))
 	// ignore duplicate packets
 	if err == ackhandler.ErrDuplicatePacket {
 		utils.Infof("Ignoring packet 0x%x due to ErrDuplicatePacket", hdr.PacketNumber)
@@ -310,6 +597,37 @@ func (s *Session) handlePacketImpl(p *receivedPacket) error {
 	return s.handleFrames(packet.frames)
 }
 
+// updateRemoteAddr sets the remote address, and resets the path-dependent state if the connection migrated.
+// A change of the port only is usually caused by a NAT rebinding, and doesn't change the path.
+func (s *Session) updateRemoteAddr(addr interface{}) {
+	oldAddr := s.conn.RemoteAddr()
+	migrated := s.pathChanged(addr)
+	s.conn.setCurrentRemoteAddr(addr)
+	if !migrated {
+		return
+	}
+	newAddr := addr.(*net.UDPAddr)
+	utils.Infof("Connection %x migrated from %s to %s. Resetting RTT and congestion state.", s.connectionID, oldAddr, newAddr)
+	s.sentPacketHandler.OnConnectionMigration()
+	if cb := s.connectionEvents.Migrated; cb != nil {
+		cb(s, oldAddr, newAddr)
+	}
+}
+
+// pathChanged determines if a packet received from addr was sent from a different IP address than the previous packets
+func (s *Session) pathChanged(addr interface{}) bool {
+	oldAddr := s.conn.RemoteAddr()
+	newAddr, ok := addr.(*net.UDPAddr)
+	return ok && oldAddr != nil && !oldAddr.IP.Equal(newAddr.IP)
+}
+
+// portChanged determines if a packet received from addr was sent from the same IP address as the previous packets, but from a different port
+func (s *Session) portChanged(addr interface{}) bool {
+	oldAddr := s.conn.RemoteAddr()
+	newAddr, ok := addr.(*net.UDPAddr)
+	return ok && oldAddr != nil && oldAddr.IP.Equal(newAddr.IP) && oldAddr.Port != newAddr.Port
+}
+
 func (s *Session) handleFrames(fs []frames.Frame) error {
 	for _, ff := range fs {
 		var err error
@@ -364,7 +682,7 @@ func (s *Session) handlePacket(p *receivedPacket) {
 }
 
 func (s *Session) handleStreamFrame(frame *frames.StreamFrame) error {
-	str, err := s.streamsMap.GetOrOpenStream(frame.StreamID)
+	str, err := s.getOrOpenStream(frame.StreamID)
 	if err != nil {
 		return err
 	}
@@ -381,7 +699,7 @@ func (s *Session) handleStreamFrame(frame *frames.StreamFrame) error {
 
 func (s *Session) handleWindowUpdateFrame(frame *frames.WindowUpdateFrame) error {
 	if frame.StreamID != 0 {
-		str, err := s.streamsMap.GetOrOpenStream(frame.StreamID)
+		str, err := s.getOrOpenStream(frame.StreamID)
 		if err != nil {
 			return err
 		}
@@ -389,13 +707,31 @@ func (s *Session) handleWindowUpdateFrame(frame *frames.WindowUpdateFrame) error
 			return errWindowUpdateOnClosedStream
 		}
 	}
-	_, err := s.flowControlManager.UpdateWindow(frame.StreamID, frame.ByteOffset)
-	return err
+	updated, err := s.flowControlManager.UpdateWindow(frame.StreamID, frame.ByteOffset)
+	if err != nil {
+		return err
+	}
+	if updated && frame.StreamID == 0 && !s.connectionBlockedSince.IsZero() {
+		s.connectionBlockedTime += s.clock.Now().Sub(s.connectionBlockedSince)
+		s.connectionBlockedSince = time.Time{}
+	}
+	return nil
+}
+
+// reportFlowControlBlocked calls the FlowControlBlocked callback for all streams that became blocked while packing, with stream ID 0 if the connection is blocked.
+// It must not be called while packing, since the callback may call methods of the session that take the locks held then.
+func (s *Session) reportFlowControlBlocked() {
+	ids := s.streamFramer.PopBlockedStreams()
+	if cb := s.connectionEvents.FlowControlBlocked; cb != nil {
+		for _, id := range ids {
+			cb(s, id)
+		}
+	}
 }
 
 // TODO: Handle frame.byteOffset
 func (s *Session) handleRstStreamFrame(frame *frames.RstStreamFrame) error {
-	str, err := s.streamsMap.GetOrOpenStream(frame.StreamID)
+	str, err := s.getOrOpenStream(frame.StreamID)
 	if err != nil {
 		return err
 	}
@@ -410,9 +746,94 @@ func (s *Session) handleAckFrame(frame *frames.AckFrame) error {
 	if err := s.sentPacketHandler.ReceivedAck(frame, s.lastRcvdPacketNumber, s.lastNetworkActivityTime); err != nil {
 		return err
 	}
+	s.updateStats()
+	s.completePings(frame)
 	return nil
 }
 
+// completePings reports the round-trip time to the callers of Ping whose PING frames were acknowledged by the ACK frame
+func (s *Session) completePings(frame *frames.AckFrame) {
+	s.pingsMutex.Lock()
+	defer s.pingsMutex.Unlock()
+	// like the RTT estimator, don't count the time the peer delayed the ACK, and don't trust it to report a reasonable ack delay
+	ackDelay := utils.MinDuration(frame.DelayTime, protocol.MaxAckDelay)
+	pending := s.pings[:0]
+	for _, ping := range s.pings {
+		acked := false
+		for _, p := range ping.sentPackets {
+			if frame.AcksPacket(p.packetNumber) {
+				rtt := s.lastNetworkActivityTime.Sub(p.sentTime)
+				if rtt > ackDelay {
+					rtt -= ackDelay
+				}
+				ping.result <- pingResult{rtt: rtt}
+				acked = true
+				break
+			}
+		}
+		if !acked {
+			pending = append(pending, ping)
+		}
+	}
+	s.pings = pending
+}
+
+// onPacketSent records the packets containing PING frames for the pending calls of Ping
+func (s *Session) onPacketSent(packet *packedPacket) {
+	s.pingsMutex.Lock()
+	defer s.pingsMutex.Unlock()
+	if len(s.pings) == 0 {
+		return
+	}
+	for _, frame := range packet.frames {
+		if _, ok := frame.(*frames.PingFrame); !ok {
+			continue
+		}
+		sent := sentPingPacket{packetNumber: packet.number, sentTime: s.clock.Now()}
+		for _, ping := range s.pings {
+			ping.sentPackets = append(ping.sentPackets, sent)
+		}
+		return
+	}
+}
+
+func (s *Session) updateStats() {
+	cs := s.sentPacketHandler.GetCongestionStats()
+	ls := s.sentPacketHandler.GetLossStats()
+	connectionBlockedCount, streamBlockedCount := s.streamFramer.BlockedCounts()
+	connectionBlockedTime := s.connectionBlockedTime
+	var keepAliveInterval time.Duration
+	if s.keepAlive != nil {
+		keepAliveInterval = s.keepAlive.Interval()
+	}
+	if !s.connectionBlockedSince.IsZero() {
+		connectionBlockedTime += s.clock.Now().Sub(s.connectionBlockedSince)
+	}
+	s.statsMutex.Lock()
+	s.stats = SessionStats{
+		CongestionWindow:     cs.CongestionWindow,
+		SlowStartThreshold:   cs.SlowStartThreshold,
+		BandwidthEstimate:    cs.BandwidthEstimate,
+		PacingRate:           cs.PacingRate,
+		BytesInFlight:        cs.BytesInFlight,
+		CongestionLimited:    cs.CongestionLimited,
+		FlowControlLimited:   s.flowControlManager.RemainingConnectionWindowSize() == 0,
+		MinRTT:               s.rttStats.RecentMinRTT(),
+		PacketsLost:          ls.PacketsLost,
+		PacketsRetransmitted: ls.PacketsRetransmitted,
+		SpuriousLosses:       ls.SpuriousLosses,
+		RTOCount:             ls.RTOCount,
+		ForwardQueuingDelay:  cs.ForwardQueuingDelay,
+		ReverseQueuingDelay:  cs.ReverseQueuingDelay,
+
+		ConnectionBlockedCount: connectionBlockedCount,
+		StreamBlockedCount:     streamBlockedCount,
+		ConnectionBlockedTime:  connectionBlockedTime,
+		KeepAliveInterval:      keepAliveInterval,
+	}
+	s.statsMutex.Unlock()
+}
+
 // Close the connection. If err is nil it will be set to qerr.PeerGoingAway.
 // It waits until the run loop has stopped before returning
 func (s *Session) Close(e error) error {
@@ -455,6 +876,11 @@ func (s *Session) closeImpl(e error, remoteClose bool) error {
 	}
 
 	s.closeStreamsWithError(quicErr)
+	s.closePingsWithError(quicErr)
+
+	if cb := s.connectionEvents.Closed; cb != nil {
+		cb(s, quicErr, remoteClose)
+	}
 
 	if remoteClose {
 		// If this is a remote close we don't need to send a CONNECTION_CLOSE
@@ -478,11 +904,23 @@ func (s *Session) closeStreamsWithError(err error) {
 	})
 }
 
+func (s *Session) closePingsWithError(err error) {
+	s.pingsMutex.Lock()
+	defer s.pingsMutex.Unlock()
+	s.pingsClosedErr = err
+	for _, ping := range s.pings {
+		ping.result <- pingResult{err: err}
+	}
+	s.pings = nil
+}
+
 func (s *Session) closeStreamWithError(str *stream, err error) {
 	str.RegisterError(err)
 }
 
 func (s *Session) sendPacket() error {
+	defer s.reportFlowControlBlocked()
+
 	// Repeatedly try sending until we don't have any more data, or run out of the congestion window
 	for {
 		err := s.sentPacketHandler.CheckForError()
@@ -496,6 +934,10 @@ func (s *Session) sendPacket() error {
 		if !s.sentPacketHandler.SendingAllowed() {
 			return nil
 		}
+		// the timer will wake up the run loop when the pacer allows sending again
+		if s.sentPacketHandler.TimeUntilSend().After(s.clock.Now()) {
+			return nil
+		}
 
 		var controlFrames []frames.Frame
 
@@ -532,17 +974,16 @@ func (s *Session) sendPacket() error {
 		}
 
 		// Check whether we are allowed to send a packet containing only an ACK
-		maySendOnlyAck := time.Now().Sub(s.delayedAckOriginTime) > protocol.AckSendDelay
+		maySendOnlyAck := s.clock.Now().Sub(s.delayedAckOriginTime) > protocol.AckSendDelay || s.receivedPacketHandler.ShouldAckImmediately()
 		if runtime.GOOS == "windows" {
 			maySendOnlyAck = true
 		}
 
+		// Every packet carries an updated StopWaiting, once packets were acknowledged, or retransmitted or abandoned, so that the peer can prune its received packet history.
+		// A packet that contains a retransmission always carries a StopWaiting.
+		// The StopWaiting is only regarded as sent if the packet is actually sent, otherwise it is bundled with the next packet.
 		hasRetransmission := s.streamFramer.HasFramesForRetransmission()
-
-		var stopWaitingFrame *frames.StopWaitingFrame
-		if ack != nil || hasRetransmission {
-			stopWaitingFrame = s.sentPacketHandler.GetStopWaitingFrame(hasRetransmission)
-		}
+		stopWaitingFrame := s.sentPacketHandler.GetStopWaitingFrame(hasRetransmission)
 		packet, err := s.packer.PackPacket(stopWaitingFrame, controlFrames, s.sentPacketHandler.GetLeastUnacked(), maySendOnlyAck)
 		if err != nil {
 			return err
@@ -571,6 +1012,7 @@ func (s *Session) sendPacket() error {
 		}
 
 		s.logPacket(packet)
+		s.onPacketSent(packet)
 		s.delayedAckOriginTime = time.Time{}
 
 		err = s.conn.write(packet.raw)
@@ -590,7 +1032,12 @@ func (s *Session) sendConnectionClose(quicErr *qerr.QuicError) error {
 		return errors.New("Session BUG: expected packet not to be nil")
 	}
 	s.logPacket(packet)
-	return s.conn.write(packet.raw)
+	// the packet buffer is returned to the pool, so keep a copy for the closedSession
+	s.connectionClosePacket = make([]byte, len(packet.raw))
+	copy(s.connectionClosePacket, packet.raw)
+	err = s.conn.write(packet.raw)
+	putPacketBuffer(packet.raw)
+	return err
 }
 
 func (s *Session) logPacket(packet *packedPacket) {
@@ -609,7 +1056,40 @@ func (s *Session) logPacket(packet *packedPacket) {
 // GetOrOpenStream either returns an existing stream, a newly opened stream, or nil if a stream with the provided ID is already closed.
 // Newly opened streams should only originate from the client. To open a stream from the server, OpenStream should be used.
 func (s *Session) GetOrOpenStream(id protocol.StreamID) (utils.Stream, error) {
-	return s.streamsMap.GetOrOpenStream(id)
+	str, err := s.getOrOpenStream(id)
+	if str == nil {
+		// make sure that we don't return a typed nil
+		return nil, err
+	}
+	return str, err
+}
+
+// getOrOpenStream gets or opens a stream. Streams that are refused after sending a GOAWAY are reset.
+func (s *Session) getOrOpenStream(id protocol.StreamID) (*stream, error) {
+	str, err := s.streamsMap.GetOrOpenStream(id)
+	if err == errStreamRefused {
+		s.queueResetStreamFrame(id, 0, protocol.RstStreamRefusedStream)
+		return nil, nil
+	}
+	return str, err
+}
+
+// GoAway sends a GOAWAY frame to the client. All streams that the client opens afterwards are refused.
+// Streams that are already open are not affected, and the session stays open until it is closed.
+func (s *Session) GoAway() {
+	if !atomic.CompareAndSwapUint32(&s.goawaySent, 0, 1) {
+		return
+	}
+	lastGoodStream := s.streamsMap.RefuseNewStreams()
+	s.packer.QueueControlFrameForNextPacket(&frames.GoawayFrame{
+		ErrorCode:      qerr.PeerGoingAway,
+		LastGoodStream: lastGoodStream,
+		ReasonPhrase:   "server shutting down",
+	})
+	s.scheduleSending()
+	if cb := s.connectionEvents.GoingAway; cb != nil {
+		cb(s)
+	}
 }
 
 // OpenStream opens a stream from the server's side
@@ -617,12 +1097,18 @@ func (s *Session) OpenStream(id protocol.StreamID) (utils.Stream, error) {
 	return s.streamsMap.OpenStream(id)
 }
 
+// SetStreamPriority sets the priority of an open stream.
+// Data of streams with a higher priority is sent before data of streams with a lower priority.
+func (s *Session) SetStreamPriority(id protocol.StreamID, priority protocol.StreamPriority) error {
+	return s.streamsMap.SetPriority(id, priority)
+}
+
 func (s *Session) newStreamImpl(id protocol.StreamID) (*stream, error) {
 	return s.streamsMap.GetOrOpenStream(id)
 }
 
 func (s *Session) newStream(id protocol.StreamID) (*stream, error) {
-	stream, err := newStream(id, s.scheduleSending, s.flowControlManager)
+	stream, err := newStream(id, s.scheduleSending, s.onStreamReset, s.flowControlManager, s.maxStreamOutOfOrderData, s.maxStreamSendBuffer)
 	if err != nil {
 		return nil, err
 	}
@@ -660,6 +1146,21 @@ func (s *Session) sendPublicReset(rejectedPacketNumber protocol.PacketNumber) er
 	return s.conn.write(writePublicReset(s.connectionID, rejectedPacketNumber, 0))
 }
 
+// onStreamReset is called when a stream is reset by the application
+func (s *Session) onStreamReset(id protocol.StreamID, offset protocol.ByteCount) {
+	s.queueResetStreamFrame(id, offset, uint32(qerr.InternalError))
+}
+
+// queueResetStreamFrame queues a RST_STREAM frame
+func (s *Session) queueResetStreamFrame(id protocol.StreamID, offset protocol.ByteCount, errorCode uint32) {
+	s.packer.QueueControlFrameForNextPacket(&frames.RstStreamFrame{
+		StreamID:   id,
+		ByteOffset: offset,
+		ErrorCode:  errorCode,
+	})
+	s.scheduleSending()
+}
+
 // scheduleSending signals that we have data for sending
 func (s *Session) scheduleSending() {
 	select {
@@ -672,10 +1173,11 @@ func (s *Session) tryQueueingUndecryptablePacket(p *receivedPacket) {
 	if s.cryptoSetup.HandshakeComplete() {
 		return
 	}
-	utils.Infof("Queueing packet 0x%x for later decryption", p.publicHeader.PacketNumber)
-	if len(s.undecryptablePackets)+1 >= protocol.MaxUndecryptablePackets {
-		s.close(qerr.Error(qerr.DecryptionFailure, "too many undecryptable packets received"))
+	if len(s.undecryptablePackets) >= protocol.MaxUndecryptablePackets {
+		utils.Infof("Dropping undecryptable packet 0x%x (undecryptable packet queue full)", p.publicHeader.PacketNumber)
+		return
 	}
+	utils.Infof("Queueing packet 0x%x for later decryption", p.publicHeader.PacketNumber)
 	s.undecryptablePackets = append(s.undecryptablePackets, p)
 }
 
@@ -699,3 +1201,211 @@ func (s *Session) getWindowUpdateFrames() ([]*frames.WindowUpdateFrame, error) {
 func (s *Session) RemoteAddr() *net.UDPAddr {
 	return s.conn.RemoteAddr()
 }
+
+// ConnectionState returns basic details about the QUIC connection.
+func (s *Session) ConnectionState() ConnectionState {
+	cs := s.cryptoSetup.ConnectionState()
+	return ConnectionState{
+		Version:           s.version,
+		HandshakeComplete: cs.HandshakeComplete,
+		ServerName:        cs.ServerName,
+		AEAD:              cs.AEAD,
+		KeyExchange:       cs.KeyExchange,
+	}
+}
+
+// Stats returns the stats sampled when the last ACK was received.
+func (s *Session) Stats() SessionStats {
+	s.statsMutex.Lock()
+	defer s.statsMutex.Unlock()
+	return s.stats
+}
+
+// Ping sends a PING frame and waits until the packet containing it is acknowledged.
+// It returns the time between sending that packet and receiving the acknowledgement, minus the time the client reports to have delayed the ACK.
+// If the packet is lost, the RTT is measured from the retransmission, or from any other packet containing a PING frame that is acknowledged first.
+// Ping returns early if the context is done or the session is closed.
+func (s *Session) Ping(ctx context.Context) (time.Duration, error) {
+	ping, err := s.queuePing()
+	if err != nil {
+		return 0, err
+	}
+	s.scheduleSending()
+
+	select {
+	case res := <-ping.result:
+		return res.rtt, res.err
+	case <-ctx.Done():
+		s.removePing(ping)
+		return 0, ctx.Err()
+	}
+}
+
+// SetMaxBandwidth limits the rate at which packets are sent, to less than the congestion controller allows.
+// This can be used to send background transfers without competing with other traffic.
+// It replaces the limit set by Config.MaxPacingRate. 0 goes back to the Config.MaxPacingRate.
+func (s *Session) SetMaxBandwidth(bandwidth congestion.Bandwidth) {
+	s.maxBandwidthMutex.Lock()
+	if bandwidth == 0 {
+		bandwidth = s.maxPacingRate
+	}
+	s.maxBandwidth = bandwidth
+	s.maxBandwidthChanged = true
+	s.maxBandwidthMutex.Unlock()
+	s.scheduleSending()
+}
+
+// applyMaxBandwidth passes the limit set by SetMaxBandwidth to the sentPacketHandler.
+// It must only be called from the run loop.
+func (s *Session) applyMaxBandwidth() {
+	s.maxBandwidthMutex.Lock()
+	defer s.maxBandwidthMutex.Unlock()
+	if !s.maxBandwidthChanged {
+		return
+	}
+	s.sentPacketHandler.SetMaxPacingRate(s.maxBandwidth)
+	s.maxBandwidthChanged = false
+}
+
+// queuePing queues a PING frame for the next packet, and registers a pendingPing that is notified when it is acknowledged
+func (s *Session) queuePing() (*pendingPing, error) {
+	ping := &pendingPing{result: make(chan pingResult, 1)}
+	s.pingsMutex.Lock()
+	if s.pingsClosedErr != nil {
+		s.pingsMutex.Unlock()
+		return nil, s.pingsClosedErr
+	}
+	s.pings = append(s.pings, ping)
+	s.pingsMutex.Unlock()
+
+	s.packer.QueueControlFrameForNextPacket(&frames.PingFrame{})
+	return ping, nil
+}
+
+func (s *Session) removePing(ping *pendingPing) {
+	s.pingsMutex.Lock()
+	defer s.pingsMutex.Unlock()
+	for i, p := range s.pings {
+		if p == ping {
+			s.pings = append(s.pings[:i], s.pings[i+1:]...)
+			return
+		}
+	}
+}
+
+// maybeSendKeepAlive sends a keep-alive PING if no packet was received for the keep-alive interval.
+// It also reports to the keepAliveProber if the previous keep-alive PING was acknowledged or lost.
+// It must only be called from the run loop.
+func (s *Session) maybeSendKeepAlive() {
+	if s.keepAlive == nil || !s.handshakeComplete {
+		return
+	}
+	now := s.clock.Now()
+	if s.keepAlivePing != nil {
+		select {
+		case res := <-s.keepAlivePing.result:
+			if res.err == nil {
+				s.keepAlive.OnProbeAcked()
+			}
+			s.keepAlivePing = nil
+		default:
+			if now.Sub(s.keepAlivePingSent) < s.keepAliveProbeTimeout() {
+				return
+			}
+			utils.Debugf("Keep-alive PING after %s was lost", s.keepAlive.Interval())
+			s.removePing(s.keepAlivePing)
+			s.keepAlivePing = nil
+			s.keepAliveLostTime = now
+			s.keepAlive.OnProbeLost()
+		}
+		return
+	}
+	if deadline := s.keepAliveDeadline(); deadline.IsZero() || now.Before(deadline) {
+		return
+	}
+	ping, err := s.queuePing()
+	if err != nil {
+		return
+	}
+	s.keepAlivePing = ping
+	s.keepAlivePingSent = now
+}
+
+// keepAliveDeadline returns when the next keep-alive PING is sent, or when the current one is regarded as lost.
+// It returns the zero time if no keep-alive is due.
+func (s *Session) keepAliveDeadline() time.Time {
+	if s.keepAlive == nil || !s.handshakeComplete {
+		return time.Time{}
+	}
+	if s.keepAlivePing != nil {
+		return s.keepAlivePingSent.Add(s.keepAliveProbeTimeout())
+	}
+	// after a keep-alive was lost, the client has to send a packet first
+	if !s.keepAliveLostTime.IsZero() && !s.lastNetworkActivityTime.After(s.keepAliveLostTime) {
+		return time.Time{}
+	}
+	return s.lastNetworkActivityTime.Add(s.keepAlive.Interval())
+}
+
+func (s *Session) keepAliveProbeTimeout() time.Duration {
+	return utils.MaxDuration(protocol.KeepAliveProbeTimeoutRTTs*s.rttStats.SmoothedRTT(), protocol.MinKeepAliveProbeTimeout)
+}
+
+type debugSnapshotResult struct {
+	snapshot *DebugSnapshot
+	err      error
+}
+
+// DebugSnapshot asks the run loop to take a snapshot of the internal state of the session, and waits for it.
+// It returns an error if Config.EnableDebugSnapshots is not set, or if the session is closed.
+// If the run loop is blocked, it doesn't answer, and the error of ctx is returned once ctx is done.
+func (s *Session) DebugSnapshot(ctx context.Context) (*DebugSnapshot, error) {
+	if !s.debugSnapshots {
+		return nil, errDebugSnapshotsDisabled
+	}
+	result := make(chan debugSnapshotResult, 1)
+	select {
+	case s.debugSnapshotRequests <- result:
+	case <-s.runDone:
+		return nil, errDebugSnapshotSessionClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	res := <-result
+	return res.snapshot, res.err
+}
+
+// takeDebugSnapshot must only be called from the run loop
+func (s *Session) takeDebugSnapshot() (*DebugSnapshot, error) {
+	snapshot := &DebugSnapshot{
+		Time:                        s.clock.Now(),
+		AckRanges:                   s.receivedPacketHandler.GetAckRanges(),
+		LargestReceivedPacketNumber: s.largestRcvdPacketNumber,
+		BytesInFlight:               s.sentPacketHandler.BytesInFlight(),
+		LeastUnacked:                s.sentPacketHandler.GetLeastUnacked(),
+		SendingAllowed:              s.sentPacketHandler.SendingAllowed(),
+		ConnectionSendWindow:        s.flowControlManager.RemainingConnectionWindowSize(),
+		IdleTimeout:                 s.lastNetworkActivityTime.Add(s.idleTimeout()),
+		RetransmissionTimeout:       s.sentPacketHandler.TimeOfFirstRTO(),
+		PacingDeadline:              s.sentPacketHandler.TimeUntilSend(),
+		TimerDeadline:               s.currentDeadline,
+		UndecryptablePackets:        len(s.undecryptablePackets),
+	}
+	if !s.delayedAckOriginTime.IsZero() {
+		snapshot.DelayedAckDeadline = s.delayedAckOriginTime.Add(protocol.AckSendDelay)
+	}
+	err := s.streamsMap.Iterate(func(str *stream) (bool, error) {
+		streamSnapshot := str.debugSnapshot()
+		sendWindow, err := s.flowControlManager.SendWindowSize(str.streamID)
+		if err != nil {
+			return false, err
+		}
+		streamSnapshot.SendWindow = sendWindow
+		snapshot.Streams = append(snapshot.Streams, streamSnapshot)
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
@@ -7,6 +7,7 @@ import (
 	"net"
 	"reflect"
 	"runtime"
+	"sync"
 	"sync/atomic"
 	"time"
 	"unsafe"
@@ -15,6 +16,7 @@ import (
 	. "github.com/onsi/gomega"
 
 	"github.com/lucas-clemente/quic-go/ackhandler"
+	"github.com/lucas-clemente/quic-go/congestion"
 	"github.com/lucas-clemente/quic-go/crypto"
 	"github.com/lucas-clemente/quic-go/frames"
 	"github.com/lucas-clemente/quic-go/handshake"
@@ -22,10 +24,12 @@ import (
 	"github.com/lucas-clemente/quic-go/qerr"
 	"github.com/lucas-clemente/quic-go/testdata"
 	"github.com/lucas-clemente/quic-go/utils"
+	"golang.org/x/net/context"
 )
 
 type mockConnection struct {
-	written [][]byte
+	written    [][]byte
+	remoteAddr *net.UDPAddr
 }
 
 func (m *mockConnection) write(p []byte) error {
@@ -35,8 +39,17 @@ func (m *mockConnection) write(p []byte) error {
 	return nil
 }
 
-func (*mockConnection) setCurrentRemoteAddr(addr interface{}) {}
-func (*mockConnection) RemoteAddr() *net.UDPAddr              { return &net.UDPAddr{} }
+func (m *mockConnection) setCurrentRemoteAddr(addr interface{}) {
+	if a, ok := addr.(*net.UDPAddr); ok {
+		m.remoteAddr = a
+	}
+}
+func (m *mockConnection) RemoteAddr() *net.UDPAddr {
+	if m.remoteAddr == nil {
+		return &net.UDPAddr{}
+	}
+	return m.remoteAddr
+}
 
 type mockUnpacker struct{}
 
@@ -52,6 +65,9 @@ type mockSentPacketHandler struct {
 	congestionLimited    bool
 	maybeQueueRTOsCalled bool
 	requestedStopWaiting bool
+	migrated             bool
+	nextSendTime         time.Time
+	maxPacingRate        congestion.Bandwidth
 }
 
 func (h *mockSentPacketHandler) SentPacket(packet *ackhandler.Packet) error {
@@ -69,7 +85,30 @@ func (h *mockSentPacketHandler) GetStopWaitingFrame(force bool) *frames.StopWait
 }
 func (h *mockSentPacketHandler) SendingAllowed() bool      { return !h.congestionLimited }
 func (h *mockSentPacketHandler) CheckForError() error      { return nil }
-func (h *mockSentPacketHandler) TimeOfFirstRTO() time.Time { panic("not implemented") }
+func (h *mockSentPacketHandler) TimeOfFirstRTO() time.Time { return time.Time{} }
+
+func (h *mockSentPacketHandler) GetCongestionStats() ackhandler.CongestionStats {
+	return ackhandler.CongestionStats{
+		CongestionWindow:  1000,
+		BandwidthEstimate: 100 * congestion.KBytesPerSecond,
+		BytesInFlight:     500,
+		CongestionLimited: h.congestionLimited,
+
+		ForwardQueuingDelay: 3 * time.Millisecond,
+		ReverseQueuingDelay: 4 * time.Millisecond,
+	}
+}
+
+func (h *mockSentPacketHandler) GetLossStats() ackhandler.LossStats {
+	return ackhandler.LossStats{PacketsLost: 3, PacketsRetransmitted: 2, SpuriousLosses: 1, RTOCount: 4}
+}
+
+func (h *mockSentPacketHandler) OnConnectionMigration()   { h.migrated = true }
+func (h *mockSentPacketHandler) TimeUntilSend() time.Time { return h.nextSendTime }
+
+func (h *mockSentPacketHandler) SetMaxPacingRate(rate congestion.Bandwidth) {
+	h.maxPacingRate = rate
+}
 
 func (h *mockSentPacketHandler) MaybeQueueRTOs() {
 	h.maybeQueueRTOsCalled = true
@@ -88,33 +127,75 @@ func newMockSentPacketHandler() ackhandler.SentPacketHandler {
 	return &mockSentPacketHandler{}
 }
 
+// mockClock is a clock that only advances when told to. Its timer only fires when told to.
+type mockClock struct {
+	mutex sync.Mutex
+	now   time.Time
+	timer *mockTimer
+}
+
+func (c *mockClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *mockClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func (c *mockClock) NewTimer(time.Duration) congestion.Timer {
+	c.timer = &mockTimer{c: make(chan time.Time, 1)}
+	return c.timer
+}
+
+type mockTimer struct {
+	c chan time.Time
+}
+
+func (t *mockTimer) Chan() <-chan time.Time   { return t.c }
+func (t *mockTimer) Reset(time.Duration) bool { return true }
+func (t *mockTimer) Stop() bool               { return true }
+func (t *mockTimer) Fire()                    { t.c <- time.Time{} }
+
 var _ = Describe("Session", func() {
 	var (
 		session              *Session
 		streamCallbackCalled bool
 		closeCallbackCalled  bool
+		closedHandler        packetHandler
 		conn                 *mockConnection
 		cpm                  *mockConnectionParametersManager
+		scfg                 *handshake.ServerConfig
 	)
 
 	BeforeEach(func() {
 		conn = &mockConnection{}
 		streamCallbackCalled = false
 		closeCallbackCalled = false
+		closedHandler = nil
 
 		signer, err := crypto.NewProofSource(testdata.GetTLSConfig())
 		Expect(err).ToNot(HaveOccurred())
 		kex, err := crypto.NewCurve25519KEX()
 		Expect(err).NotTo(HaveOccurred())
-		scfg, err := handshake.NewServerConfig(kex, signer)
+		scfg, err = handshake.NewServerConfig(kex, signer)
+		Expect(err).NotTo(HaveOccurred())
+		config, err := populateConfig(nil)
 		Expect(err).NotTo(HaveOccurred())
 		pSession, err := newSession(
 			conn,
 			protocol.Version35,
 			0,
 			scfg,
-			func(*Session, utils.Stream) { streamCallbackCalled = true },
-			func(protocol.ConnectionID) { closeCallbackCalled = true },
+			config,
+			func(SessionHandle, utils.Stream) { streamCallbackCalled = true },
+			func(_ protocol.ConnectionID, closed packetHandler) {
+				closeCallbackCalled = true
+				closedHandler = closed
+			},
 		)
 		Expect(err).NotTo(HaveOccurred())
 		session = pSession.(*Session)
@@ -431,6 +512,56 @@ var _ = Describe("Session", func() {
 			Expect(session.runClosed).ToNot(Receive()) // channel should be drained by Close()
 		})
 
+		It("notifies the ConnectionEvents once", func() {
+			var calls int
+			var closeErr error
+			var closedRemotely bool
+			session.connectionEvents.Closed = func(_ SessionHandle, err error, remote bool) {
+				calls++
+				closeErr = err
+				closedRemotely = remote
+			}
+			session.Close(errors.New("foobar"))
+			session.Close(nil)
+			Eventually(func() int { return runtime.NumGoroutine() }).Should(Equal(nGoRoutinesBefore))
+			Expect(calls).To(Equal(1))
+			Expect(closeErr).To(MatchError(qerr.Error(qerr.InternalError, "foobar")))
+			Expect(closedRemotely).To(BeFalse())
+		})
+
+		It("notifies the ConnectionEvents when the client closes the session", func() {
+			var closeErr error
+			var closedRemotely bool
+			session.connectionEvents.Closed = func(_ SessionHandle, err error, remote bool) {
+				closeErr = err
+				closedRemotely = remote
+			}
+			err := session.handleFrames([]frames.Frame{&frames.ConnectionCloseFrame{ErrorCode: qerr.ProofInvalid, ReasonPhrase: "foobar"}})
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(func() int { return runtime.NumGoroutine() }).Should(Equal(nGoRoutinesBefore))
+			Expect(closeErr).To(MatchError(qerr.Error(qerr.ProofInvalid, "foobar")))
+			Expect(closedRemotely).To(BeTrue())
+			// no CONNECTION_CLOSE was sent, so there's nothing to retransmit
+			Expect(closedHandler).To(BeNil())
+		})
+
+		It("retransmits the CONNECTION_CLOSE when receiving packets after closing", func() {
+			session.Close(nil)
+			Eventually(func() int { return runtime.NumGoroutine() }).Should(Equal(nGoRoutinesBefore))
+			Expect(conn.written).To(HaveLen(1))
+			Expect(closedHandler).To(BeAssignableToTypeOf(&closedSession{}))
+			closedHandler.handlePacket(&receivedPacket{publicHeader: &PublicHeader{Raw: getPacketBuffer()}})
+			Expect(conn.written).To(HaveLen(2))
+			Expect(conn.written[1]).To(Equal(conn.written[0]))
+		})
+
+		It("doesn't keep the packet buffer of the CONNECTION_CLOSE", func() {
+			session.Close(nil)
+			Eventually(func() int { return runtime.NumGoroutine() }).Should(Equal(nGoRoutinesBefore))
+			Expect(session.connectionClosePacket).To(Equal(conn.written[0]))
+			Expect(cap(session.connectionClosePacket)).To(Equal(len(conn.written[0])))
+		})
+
 		It("only closes once", func() {
 			session.Close(nil)
 			session.Close(nil)
@@ -500,18 +631,594 @@ var _ = Describe("Session", func() {
 			err = session.handlePacketImpl(&receivedPacket{publicHeader: hdr})
 			Expect(err).ToNot(HaveOccurred())
 		})
+
+		Context("migration", func() {
+			var sph *mockSentPacketHandler
+
+			BeforeEach(func() {
+				sph = &mockSentPacketHandler{}
+				session.sentPacketHandler = sph
+				conn.remoteAddr = &net.UDPAddr{IP: net.IPv4(192, 168, 0, 1), Port: 1337}
+			})
+
+			It("updates the remote address", func() {
+				addr := &net.UDPAddr{IP: net.IPv4(192, 168, 0, 2), Port: 1337}
+				hdr.PacketNumber = 5
+				err := session.handlePacketImpl(&receivedPacket{publicHeader: hdr, remoteAddr: addr})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(session.RemoteAddr()).To(Equal(addr))
+				Expect(sph.migrated).To(BeTrue())
+			})
+
+			It("tells the keep-alive prober about NAT rebindings", func() {
+				session.keepAlive = newKeepAliveProber(10*time.Second, 90*time.Second, true)
+				session.lastNetworkActivityTime = time.Now().Add(-20 * time.Second)
+				addr := &net.UDPAddr{IP: net.IPv4(192, 168, 0, 1), Port: 1338}
+				hdr.PacketNumber = 5
+				err := session.handlePacketImpl(&receivedPacket{publicHeader: hdr, remoteAddr: addr})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(session.keepAlive.Interval()).To(BeNumerically("~", 15*time.Second, time.Second))
+			})
+
+			It("doesn't regard a change of the IP address as a NAT rebinding", func() {
+				session.keepAlive = newKeepAliveProber(10*time.Second, 90*time.Second, true)
+				session.lastNetworkActivityTime = time.Now().Add(-20 * time.Second)
+				addr := &net.UDPAddr{IP: net.IPv4(192, 168, 0, 2), Port: 1338}
+				hdr.PacketNumber = 5
+				err := session.handlePacketImpl(&receivedPacket{publicHeader: hdr, remoteAddr: addr})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(session.keepAlive.Interval()).To(Equal(90 * time.Second))
+			})
+
+			It("notifies the ConnectionEvents about the migration", func() {
+				var oldAddr, newAddr *net.UDPAddr
+				session.connectionEvents.Migrated = func(_ SessionHandle, o, n *net.UDPAddr) {
+					oldAddr = o
+					newAddr = n
+				}
+				addr := &net.UDPAddr{IP: net.IPv4(192, 168, 0, 2), Port: 1337}
+				hdr.PacketNumber = 5
+				err := session.handlePacketImpl(&receivedPacket{publicHeader: hdr, remoteAddr: addr})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(oldAddr).To(Equal(&net.UDPAddr{IP: net.IPv4(192, 168, 0, 1), Port: 1337}))
+				Expect(newAddr).To(Equal(addr))
+			})
+
+			It("ignores packets from a different IP address if active migration is disabled", func() {
+				session.disableActiveMigration = true
+				addr := &net.UDPAddr{IP: net.IPv4(192, 168, 0, 2), Port: 1337}
+				hdr.PacketNumber = 5
+				err := session.handlePacketImpl(&receivedPacket{publicHeader: hdr, remoteAddr: addr})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(session.RemoteAddr()).To(Equal(&net.UDPAddr{IP: net.IPv4(192, 168, 0, 1), Port: 1337}))
+				Expect(session.largestRcvdPacketNumber).To(BeZero())
+				Expect(sph.migrated).To(BeFalse())
+			})
+
+			It("accepts NAT rebindings if active migration is disabled", func() {
+				session.disableActiveMigration = true
+				addr := &net.UDPAddr{IP: net.IPv4(192, 168, 0, 1), Port: 4242}
+				hdr.PacketNumber = 5
+				err := session.handlePacketImpl(&receivedPacket{publicHeader: hdr, remoteAddr: addr})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(session.RemoteAddr()).To(Equal(addr))
+			})
+
+			It("doesn't reset the path-dependent state if only the port changed", func() {
+				addr := &net.UDPAddr{IP: net.IPv4(192, 168, 0, 1), Port: 4242}
+				hdr.PacketNumber = 5
+				err := session.handlePacketImpl(&receivedPacket{publicHeader: hdr, remoteAddr: addr})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(session.RemoteAddr()).To(Equal(addr))
+				Expect(sph.migrated).To(BeFalse())
+			})
+
+			It("doesn't change the remote address for reordered packets", func() {
+				addr := &net.UDPAddr{IP: net.IPv4(192, 168, 0, 2), Port: 1337}
+				hdr.PacketNumber = 5
+				err := session.handlePacketImpl(&receivedPacket{publicHeader: hdr, remoteAddr: addr})
+				Expect(err).ToNot(HaveOccurred())
+				hdr.PacketNumber = 4
+				err = session.handlePacketImpl(&receivedPacket{publicHeader: hdr, remoteAddr: &net.UDPAddr{IP: net.IPv4(192, 168, 0, 1), Port: 1337}})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(session.RemoteAddr()).To(Equal(addr))
+			})
+		})
+	})
+
+	It("returns the connection state", func() {
+		Expect(session.ConnectionState()).To(Equal(ConnectionState{Version: protocol.Version35}))
+	})
+
+	Context("congestion control", func() {
+		var config *Config
+
+		BeforeEach(func() {
+			var err error
+			config, err = populateConfig(nil)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("uses cubic by default", func() {
+			cong := newCongestionController(congestion.DefaultClock{}, config, &congestion.RTTStats{})
+			Expect(cong).To(BeAssignableToTypeOf(congestion.NewCubicSender(congestion.DefaultClock{}, &congestion.RTTStats{}, false, 1, 1, 1)))
+			Expect(cong.GetCongestionWindow()).To(Equal(protocol.InitialCongestionWindow * protocol.DefaultTCPMSS))
+		})
+
+		It("uses NewReno, if configured", func() {
+			config.CongestionControl = CongestionControlNewReno
+			cong := newCongestionController(congestion.DefaultClock{}, config, &congestion.RTTStats{})
+			Expect(cong).To(BeAssignableToTypeOf(congestion.NewNewRenoSender(&congestion.RTTStats{}, 1, 1, 1)))
+		})
+
+		It("uses the configured initial congestion window", func() {
+			config.InitialCongestionWindow = 10 * protocol.DefaultTCPMSS
+			cong := newCongestionController(congestion.DefaultClock{}, config, &congestion.RTTStats{})
+			Expect(cong.GetCongestionWindow()).To(Equal(10 * protocol.DefaultTCPMSS))
+			config.CongestionControl = CongestionControlNewReno
+			cong = newCongestionController(congestion.DefaultClock{}, config, &congestion.RTTStats{})
+			Expect(cong.GetCongestionWindow()).To(Equal(10 * protocol.DefaultTCPMSS))
+		})
+
+		It("uses the configured min congestion window", func() {
+			config.MinCongestionWindow = 10 * protocol.DefaultTCPMSS
+			for _, cc := range []CongestionControlAlgorithm{CongestionControlCubic, CongestionControlNewReno} {
+				config.CongestionControl = cc
+				cong := newCongestionController(congestion.DefaultClock{}, config, &congestion.RTTStats{})
+				cong.OnRetransmissionTimeout(true)
+				Expect(cong.GetCongestionWindow()).To(Equal(10 * protocol.DefaultTCPMSS))
+			}
+		})
+
+		It("uses the configured max congestion window", func() {
+			config.MaxCongestionWindow = 50 * protocol.DefaultTCPMSS
+			for _, cc := range []CongestionControlAlgorithm{CongestionControlCubic, CongestionControlNewReno} {
+				config.CongestionControl = cc
+				cong := newCongestionController(congestion.DefaultClock{}, config, &congestion.RTTStats{})
+				var bytesInFlight protocol.ByteCount
+				for i := 1; i <= 100; i++ {
+					cong.OnPacketSent(time.Now(), bytesInFlight, protocol.PacketNumber(i), protocol.DefaultTCPMSS, true)
+					bytesInFlight += protocol.DefaultTCPMSS
+				}
+				for i := 1; i <= 100; i++ {
+					cong.OnCongestionEvent(false, bytesInFlight, congestion.PacketVector{{Number: protocol.PacketNumber(i), Length: protocol.DefaultTCPMSS}}, nil)
+				}
+				Expect(cong.GetCongestionWindow()).To(Equal(50 * protocol.DefaultTCPMSS))
+			}
+		})
+	})
+
+	Context("stats", func() {
+		It("has empty stats before receiving an ACK", func() {
+			Expect(session.Stats()).To(Equal(SessionStats{}))
+		})
+
+		It("samples the stats when receiving an ACK", func() {
+			session.sentPacketHandler = &mockSentPacketHandler{congestionLimited: true}
+			err := session.handleFrames([]frames.Frame{&frames.AckFrame{LargestAcked: 1}})
+			Expect(err).ToNot(HaveOccurred())
+			stats := session.Stats()
+			Expect(stats.CongestionWindow).To(Equal(protocol.ByteCount(1000)))
+			Expect(stats.BandwidthEstimate).To(Equal(100 * congestion.KBytesPerSecond))
+			Expect(stats.BytesInFlight).To(Equal(protocol.ByteCount(500)))
+			Expect(stats.CongestionLimited).To(BeTrue())
+			Expect(stats.FlowControlLimited).To(BeFalse())
+			Expect(stats.PacketsLost).To(Equal(uint64(3)))
+			Expect(stats.PacketsRetransmitted).To(Equal(uint64(2)))
+			Expect(stats.SpuriousLosses).To(Equal(uint64(1)))
+			Expect(stats.RTOCount).To(Equal(uint64(4)))
+			Expect(stats.ForwardQueuingDelay).To(Equal(3 * time.Millisecond))
+			Expect(stats.ReverseQueuingDelay).To(Equal(4 * time.Millisecond))
+		})
+
+		It("reports the min RTT", func() {
+			session.rttStats.UpdateRTT(30*time.Millisecond, 0, time.Now())
+			session.rttStats.UpdateRTT(10*time.Millisecond, 0, time.Now())
+			session.rttStats.UpdateRTT(20*time.Millisecond, 0, time.Now())
+			session.updateStats()
+			Expect(session.Stats().MinRTT).To(Equal(10 * time.Millisecond))
+		})
+
+		It("reports the time blocked by connection-level flow control", func() {
+			session.connectionBlockedSince = time.Now().Add(-time.Second)
+			session.updateStats()
+			Expect(session.Stats().ConnectionBlockedTime).To(BeNumerically("~", time.Second, 10*time.Millisecond))
+		})
+
+		It("stops counting the blocked time when the connection-level window is increased", func() {
+			session.connectionBlockedSince = time.Now().Add(-time.Second)
+			err := session.handleWindowUpdateFrame(&frames.WindowUpdateFrame{StreamID: 0, ByteOffset: 1 << 20})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(session.connectionBlockedSince.IsZero()).To(BeTrue())
+			Expect(session.connectionBlockedTime).To(BeNumerically("~", time.Second, 10*time.Millisecond))
+			time.Sleep(10 * time.Millisecond)
+			session.updateStats()
+			Expect(session.Stats().ConnectionBlockedTime).To(Equal(session.connectionBlockedTime))
+		})
+	})
+
+	Context("debug snapshots", func() {
+		It("returns an error if debug snapshots are disabled", func() {
+			_, err := session.DebugSnapshot(context.Background())
+			Expect(err).To(MatchError(errDebugSnapshotsDisabled))
+		})
+
+		It("returns an error if the session is closed", func() {
+			session.debugSnapshots = true
+			go session.run()
+			session.Close(nil)
+			_, err := session.DebugSnapshot(context.Background())
+			Expect(err).To(MatchError(errDebugSnapshotSessionClosed))
+		})
+
+		It("returns when the context is done if the run loop doesn't answer", func() {
+			session.debugSnapshots = true
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+			_, err := session.DebugSnapshot(ctx)
+			Expect(err).To(MatchError(context.DeadlineExceeded))
+		})
+
+		It("takes a snapshot", func() {
+			session.debugSnapshots = true
+			session.sentPacketHandler = &mockSentPacketHandler{congestionLimited: true}
+			err := session.receivedPacketHandler.ReceivedPacket(1, time.Now(), true)
+			Expect(err).ToNot(HaveOccurred())
+			err = session.receivedPacketHandler.ReceivedPacket(3, time.Now(), true)
+			Expect(err).ToNot(HaveOccurred())
+			session.largestRcvdPacketNumber = 3
+			session.delayedAckOriginTime = time.Now()
+			str, err := session.GetOrOpenStream(5)
+			Expect(err).ToNot(HaveOccurred())
+			go str.Write([]byte("foobar"))
+			Eventually(func() protocol.ByteCount { return str.(*stream).lenOfDataForWriting() }).Should(Equal(protocol.ByteCount(6)))
+			snapshot, err := session.takeDebugSnapshot()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(snapshot.Time).To(BeTemporally("~", time.Now(), 10*time.Millisecond))
+			Expect(snapshot.AckRanges).To(Equal([]frames.AckRange{
+				{FirstPacketNumber: 3, LastPacketNumber: 3},
+				{FirstPacketNumber: 1, LastPacketNumber: 1},
+			}))
+			Expect(snapshot.LargestReceivedPacketNumber).To(Equal(protocol.PacketNumber(3)))
+			Expect(snapshot.LeastUnacked).To(Equal(protocol.PacketNumber(1)))
+			Expect(snapshot.SendingAllowed).To(BeFalse())
+			Expect(snapshot.ConnectionSendWindow).To(Equal(protocol.InitialConnectionFlowControlWindow))
+			Expect(snapshot.IdleTimeout).To(BeTemporally("~", session.lastNetworkActivityTime.Add(protocol.InitialIdleTimeout)))
+			Expect(snapshot.DelayedAckDeadline).To(Equal(session.delayedAckOriginTime.Add(protocol.AckSendDelay)))
+			Expect(snapshot.Streams).To(HaveLen(2))
+			Expect(snapshot.Streams).To(ContainElement(StreamDebugSnapshot{
+				StreamID:    5,
+				BytesQueued: 6,
+				SendWindow:  protocol.InitialStreamFlowControlWindow,
+			}))
+		})
+
+		It("takes snapshots in the run loop", func() {
+			session.debugSnapshots = true
+			go session.run()
+			snapshot, err := session.DebugSnapshot(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(snapshot.Time).To(BeTemporally("~", time.Now(), 10*time.Millisecond))
+			session.Close(nil)
+		})
+	})
+
+	Context("pinging", func() {
+		var sph *mockSentPacketHandler
+
+		numPendingPings := func() int {
+			session.pingsMutex.Lock()
+			defer session.pingsMutex.Unlock()
+			return len(session.pings)
+		}
+
+		BeforeEach(func() {
+			// a StopWaitingFrame is added, so make sure the packet number of the new package is higher than its LeastUnacked
+			session.packer.packetNumberGenerator.next = 0x1337 + 9
+			sph = &mockSentPacketHandler{}
+			session.sentPacketHandler = sph
+		})
+
+		It("returns the RTT when the packet containing the PING is acknowledged", func() {
+			rttChan := make(chan time.Duration)
+			go func() {
+				defer GinkgoRecover()
+				rtt, err := session.Ping(context.Background())
+				Expect(err).ToNot(HaveOccurred())
+				rttChan <- rtt
+			}()
+			Eventually(numPendingPings).Should(Equal(1))
+			err := session.sendPacket()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sph.sentPackets).To(HaveLen(1))
+			Expect(sph.sentPackets[0].Frames).To(ContainElement(&frames.PingFrame{}))
+			session.lastNetworkActivityTime = time.Now().Add(50 * time.Millisecond)
+			err = session.handleFrames([]frames.Frame{&frames.AckFrame{LargestAcked: sph.sentPackets[0].PacketNumber}})
+			Expect(err).ToNot(HaveOccurred())
+			var rtt time.Duration
+			Eventually(rttChan).Should(Receive(&rtt))
+			Expect(rtt).To(BeNumerically("~", 50*time.Millisecond, 10*time.Millisecond))
+			Expect(numPendingPings()).To(BeZero())
+		})
+
+		It("doesn't count the ack delay in the RTT", func() {
+			rttChan := make(chan time.Duration)
+			go func() {
+				defer GinkgoRecover()
+				rtt, err := session.Ping(context.Background())
+				Expect(err).ToNot(HaveOccurred())
+				rttChan <- rtt
+			}()
+			Eventually(numPendingPings).Should(Equal(1))
+			err := session.sendPacket()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sph.sentPackets).To(HaveLen(1))
+			session.lastNetworkActivityTime = time.Now().Add(50 * time.Millisecond)
+			err = session.handleFrames([]frames.Frame{&frames.AckFrame{
+				LargestAcked: sph.sentPackets[0].PacketNumber,
+				DelayTime:    20 * time.Millisecond,
+			}})
+			Expect(err).ToNot(HaveOccurred())
+			var rtt time.Duration
+			Eventually(rttChan).Should(Receive(&rtt))
+			Expect(rtt).To(BeNumerically("~", 30*time.Millisecond, 10*time.Millisecond))
+		})
+
+		It("limits the ack delay subtracted from the RTT to the MaxAckDelay", func() {
+			rttChan := make(chan time.Duration)
+			go func() {
+				defer GinkgoRecover()
+				rtt, err := session.Ping(context.Background())
+				Expect(err).ToNot(HaveOccurred())
+				rttChan <- rtt
+			}()
+			Eventually(numPendingPings).Should(Equal(1))
+			err := session.sendPacket()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sph.sentPackets).To(HaveLen(1))
+			session.lastNetworkActivityTime = time.Now().Add(protocol.MaxAckDelay + 50*time.Millisecond)
+			err = session.handleFrames([]frames.Frame{&frames.AckFrame{
+				LargestAcked: sph.sentPackets[0].PacketNumber,
+				DelayTime:    time.Hour,
+			}})
+			Expect(err).ToNot(HaveOccurred())
+			var rtt time.Duration
+			Eventually(rttChan).Should(Receive(&rtt))
+			Expect(rtt).To(BeNumerically("~", 50*time.Millisecond, 10*time.Millisecond))
+		})
+
+		It("doesn't return before the packet containing the PING is acknowledged", func() {
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				session.Ping(context.Background())
+				close(done)
+			}()
+			Eventually(numPendingPings).Should(Equal(1))
+			err := session.sendPacket()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sph.sentPackets).To(HaveLen(1))
+			pn := sph.sentPackets[0].PacketNumber
+			err = session.handleFrames([]frames.Frame{&frames.AckFrame{LargestAcked: pn + 1, LowestAcked: pn + 1}})
+			Expect(err).ToNot(HaveOccurred())
+			Consistently(done).ShouldNot(BeClosed())
+			err = session.handleFrames([]frames.Frame{&frames.AckFrame{LargestAcked: pn + 1}})
+			Expect(err).ToNot(HaveOccurred())
+			Eventually(done).Should(BeClosed())
+		})
+
+		It("returns when the context is canceled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			errChan := make(chan error)
+			go func() {
+				defer GinkgoRecover()
+				_, err := session.Ping(ctx)
+				errChan <- err
+			}()
+			Eventually(numPendingPings).Should(Equal(1))
+			cancel()
+			Eventually(errChan).Should(Receive(Equal(context.Canceled)))
+			Expect(numPendingPings()).To(BeZero())
+		})
+
+		It("returns when the session is closed", func() {
+			errChan := make(chan error)
+			go func() {
+				defer GinkgoRecover()
+				_, err := session.Ping(context.Background())
+				errChan <- err
+			}()
+			Eventually(numPendingPings).Should(Equal(1))
+			session.close(qerr.Error(qerr.ProofInvalid, "foobar"))
+			Eventually(errChan).Should(Receive(MatchError(qerr.Error(qerr.ProofInvalid, "foobar"))))
+			_, err := session.Ping(context.Background())
+			Expect(err).To(MatchError(qerr.Error(qerr.ProofInvalid, "foobar")))
+		})
+	})
+
+	Context("limiting the bandwidth", func() {
+		It("passes the max bandwidth to the sent packet handler", func() {
+			sph := &mockSentPacketHandler{}
+			session.sentPacketHandler = sph
+			session.SetMaxBandwidth(100 * congestion.KBytesPerSecond)
+			Expect(session.sendingScheduled).To(Receive())
+			Expect(sph.maxPacingRate).To(BeZero())
+			session.applyMaxBandwidth()
+			Expect(sph.maxPacingRate).To(Equal(100 * congestion.KBytesPerSecond))
+		})
+
+		It("goes back to the max pacing rate from the config", func() {
+			session.maxPacingRate = 10 * congestion.KBytesPerSecond
+			sph := &mockSentPacketHandler{maxPacingRate: congestion.KBytesPerSecond}
+			session.sentPacketHandler = sph
+			session.SetMaxBandwidth(0)
+			session.applyMaxBandwidth()
+			Expect(sph.maxPacingRate).To(Equal(10 * congestion.KBytesPerSecond))
+		})
+
+		It("removes the limit if the config doesn't set a max pacing rate", func() {
+			sph := &mockSentPacketHandler{maxPacingRate: congestion.KBytesPerSecond}
+			session.sentPacketHandler = sph
+			session.SetMaxBandwidth(0)
+			session.applyMaxBandwidth()
+			Expect(sph.maxPacingRate).To(BeZero())
+		})
+
+		It("doesn't change the limit if SetMaxBandwidth wasn't called", func() {
+			sph := &mockSentPacketHandler{maxPacingRate: congestion.KBytesPerSecond}
+			session.sentPacketHandler = sph
+			session.applyMaxBandwidth()
+			Expect(sph.maxPacingRate).To(Equal(congestion.KBytesPerSecond))
+		})
+	})
+
+	Context("keep-alives", func() {
+		var sph *mockSentPacketHandler
+
+		BeforeEach(func() {
+			session.packer.packetNumberGenerator.next = 0x1337 + 9
+			sph = &mockSentPacketHandler{}
+			session.sentPacketHandler = sph
+			session.keepAlive = newKeepAliveProber(10*time.Second, 90*time.Second, true)
+			session.handshakeComplete = true
+		})
+
+		sendKeepAlive := func() {
+			session.lastNetworkActivityTime = time.Now().Add(-session.keepAlive.Interval())
+			session.maybeSendKeepAlive()
+			Expect(session.keepAlivePing).ToNot(BeNil())
+			err := session.sendPacket()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sph.sentPackets).ToNot(BeEmpty())
+			Expect(sph.sentPackets[len(sph.sentPackets)-1].Frames).To(ContainElement(&frames.PingFrame{}))
+		}
+
+		It("doesn't send keep-alives if they are disabled", func() {
+			session.keepAlive = nil
+			session.lastNetworkActivityTime = time.Now().Add(-time.Hour)
+			session.maybeSendKeepAlive()
+			Expect(session.keepAlivePing).To(BeNil())
+			Expect(session.keepAliveDeadline()).To(BeZero())
+		})
+
+		It("doesn't send keep-alives before the handshake is complete", func() {
+			session.handshakeComplete = false
+			session.lastNetworkActivityTime = time.Now().Add(-time.Hour)
+			session.maybeSendKeepAlive()
+			Expect(session.keepAlivePing).To(BeNil())
+		})
+
+		It("doesn't send a keep-alive before the interval has passed", func() {
+			session.lastNetworkActivityTime = time.Now().Add(-80 * time.Second)
+			session.maybeSendKeepAlive()
+			Expect(session.keepAlivePing).To(BeNil())
+			Expect(session.keepAliveDeadline()).To(Equal(session.lastNetworkActivityTime.Add(90 * time.Second)))
+		})
+
+		It("tells the prober when a keep-alive is acknowledged", func() {
+			for i := 0; i < protocol.KeepAliveProbeLossesBeforeUnsafe; i++ {
+				session.keepAlive.OnProbeLost()
+			}
+			Expect(session.keepAlive.Interval()).To(Equal(50 * time.Second))
+			sendKeepAlive()
+			err := session.handleFrames([]frames.Frame{&frames.AckFrame{LargestAcked: sph.sentPackets[0].PacketNumber}})
+			Expect(err).ToNot(HaveOccurred())
+			session.maybeSendKeepAlive()
+			Expect(session.keepAlivePing).To(BeNil())
+			Expect(session.keepAlive.Interval()).To(Equal(70 * time.Second))
+		})
+
+		It("tells the prober when a keep-alive is lost, and waits for the client before sending the next one", func() {
+			for i := 0; i < protocol.KeepAliveProbeLossesBeforeUnsafe-1; i++ {
+				session.keepAlive.OnProbeLost()
+			}
+			sendKeepAlive()
+			Expect(session.keepAliveDeadline()).To(Equal(session.keepAlivePingSent.Add(protocol.MinKeepAliveProbeTimeout)))
+			session.keepAlivePingSent = time.Now().Add(-protocol.MinKeepAliveProbeTimeout)
+			session.maybeSendKeepAlive()
+			Expect(session.keepAlivePing).To(BeNil())
+			Expect(session.pings).To(BeEmpty())
+			Expect(session.keepAlive.Interval()).To(Equal(50 * time.Second))
+			Expect(session.keepAliveDeadline()).To(BeZero())
+			// receive a packet from the client
+			session.lastNetworkActivityTime = time.Now().Add(time.Millisecond)
+			Expect(session.keepAliveDeadline()).To(Equal(session.lastNetworkActivityTime.Add(50 * time.Second)))
+		})
+
+		It("reports the keep-alive interval in the stats", func() {
+			for i := 0; i < protocol.KeepAliveProbeLossesBeforeUnsafe; i++ {
+				session.keepAlive.OnProbeLost()
+			}
+			session.updateStats()
+			Expect(session.Stats().KeepAliveInterval).To(Equal(50 * time.Second))
+		})
 	})
 
 	Context("sending packets", func() {
+		It("notifies the ConnectionEvents when sending is blocked by flow control", func() {
+			var blocked []protocol.StreamID
+			session.connectionEvents.FlowControlBlocked = func(_ SessionHandle, id protocol.StreamID) {
+				blocked = append(blocked, id)
+			}
+			str, err := session.GetOrOpenStream(5)
+			Expect(err).ToNot(HaveOccurred())
+			go str.Write(make([]byte, 2*protocol.InitialConnectionFlowControlWindow))
+			Eventually(func() protocol.ByteCount { return str.(*stream).lenOfDataForWriting() }).ShouldNot(BeZero())
+			err = session.sendPacket()
+			Expect(err).ToNot(HaveOccurred())
+			// stream 5 contributes to connection-level flow control, and both windows are used up at the same time
+			Expect(blocked).To(Equal([]protocol.StreamID{0}))
+		})
+
+		It("calls the FlowControlBlocked callback without holding any locks", func(done Done) {
+			session.connectionEvents.FlowControlBlocked = func(sess SessionHandle, id protocol.StreamID) {
+				sess.GoAway()
+			}
+			str, err := session.GetOrOpenStream(5)
+			Expect(err).ToNot(HaveOccurred())
+			go str.Write(make([]byte, 2*protocol.InitialConnectionFlowControlWindow))
+			Eventually(func() protocol.ByteCount { return str.(*stream).lenOfDataForWriting() }).ShouldNot(BeZero())
+			err = session.sendPacket()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(atomic.LoadUint32(&session.goawaySent)).To(Equal(uint32(1)))
+			close(done)
+		})
+
 		It("sends ack frames", func() {
 			packetNumber := protocol.PacketNumber(0x035E)
-			session.receivedPacketHandler.ReceivedPacket(packetNumber)
+			session.receivedPacketHandler.ReceivedPacket(packetNumber, time.Now(), true)
 			err := session.sendPacket()
 			Expect(err).NotTo(HaveOccurred())
 			Expect(conn.written).To(HaveLen(1))
 			Expect(conn.written[0]).To(ContainSubstring(string([]byte{0x5E, 0x03})))
 		})
 
+		It("delays ACKs until the ACK-eliciting threshold is reached", func() {
+			session.delayedAckOriginTime = time.Now()
+			session.receivedPacketHandler.ReceivedPacket(1, time.Now(), true)
+			err := session.sendPacket()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(conn.written).To(BeEmpty())
+			session.receivedPacketHandler.ReceivedPacket(2, time.Now(), true)
+			err = session.sendPacket()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(conn.written).To(HaveLen(1))
+		})
+
+		It("bundles an ACK that is not yet due with a StreamFrame", func() {
+			session.delayedAckOriginTime = time.Now()
+			session.receivedPacketHandler.ReceivedPacket(0x1337, time.Now(), true)
+			session.streamFramer.AddFrameForRetransmission(&frames.StreamFrame{StreamID: 5, Data: []byte("foobar")})
+			err := session.sendPacket()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(conn.written).To(HaveLen(1))
+			Expect(conn.written[0]).To(ContainSubstring("foobar"))
+			Expect(conn.written[0]).To(ContainSubstring(string([]byte{0x37, 0x13})))
+			ack, err := session.receivedPacketHandler.GetAckFrame(false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ack).To(BeNil())
+		})
+
 		It("sends two WindowUpdate frames", func() {
 			_, err := session.GetOrOpenStream(5)
 			Expect(err).ToNot(HaveOccurred())
@@ -527,6 +1234,34 @@ var _ = Describe("Session", func() {
 			Expect(conn.written[1]).To(ContainSubstring(string([]byte{0x04, 0x05, 0, 0, 0})))
 		})
 
+		It("sends a RST_STREAM when a stream is reset", func() {
+			str, err := session.GetOrOpenStream(5)
+			Expect(err).ToNot(HaveOccurred())
+			str.Reset(errors.New("foobar"))
+			err = session.sendPacket()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(conn.written).To(HaveLen(1))
+			Expect(conn.written[0]).To(ContainSubstring(string([]byte{0x01, 0x05, 0, 0, 0})))
+			session.garbageCollectStreams()
+			Expect(session.streamsMap.streams).ToNot(HaveKey(protocol.StreamID(5)))
+			Expect(session.streamsMap.isClosed(5)).To(BeTrue())
+		})
+
+		It("doesn't send a packet before the pacer allows it", func() {
+			session.sentPacketHandler = &mockSentPacketHandler{nextSendTime: time.Now().Add(time.Hour)}
+			session.receivedPacketHandler.ReceivedPacket(1, time.Now(), true)
+			err := session.sendPacket()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(conn.written).To(BeEmpty())
+		})
+
+		It("sets the timer to the pacing deadline", func() {
+			pacingDeadline := time.Now().Add(time.Millisecond)
+			session.sentPacketHandler = &mockSentPacketHandler{nextSendTime: pacingDeadline}
+			session.maybeResetTimer()
+			Expect(session.currentDeadline).To(Equal(pacingDeadline))
+		})
+
 		It("sends public reset", func() {
 			err := session.sendPublicReset(1)
 			Expect(err).NotTo(HaveOccurred())
@@ -614,6 +1349,21 @@ var _ = Describe("Session", func() {
 			Expect(ok).To(BeTrue())
 		})
 
+		It("bundles an updated StopWaiting with packets that don't contain an ACK", func() {
+			// make sure the packet number of the new package is higher than the LeastUnacked of the StopWaiting
+			session.packer.packetNumberGenerator.next = 0x1337 + 9
+			sph := newMockSentPacketHandler()
+			session.sentPacketHandler = sph
+			session.packer.QueueControlFrameForNextPacket(&frames.WindowUpdateFrame{StreamID: 5})
+			err := session.sendPacket()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sph.(*mockSentPacketHandler).requestedStopWaiting).To(BeTrue())
+			sentPackets := sph.(*mockSentPacketHandler).sentPackets
+			Expect(sentPackets).To(HaveLen(1))
+			Expect(sentPackets[0].Frames[0]).To(BeAssignableToTypeOf(&frames.StopWaitingFrame{}))
+			Expect(sentPackets[0].Frames[0].(*frames.StopWaitingFrame).LeastUnacked).To(Equal(protocol.PacketNumber(0x1337)))
+		})
+
 		It("calls MaybeQueueRTOs even if congestion blocked, so that bytesInFlight is updated", func() {
 			sph := newMockSentPacketHandler()
 			sph.(*mockSentPacketHandler).congestionLimited = true
@@ -686,7 +1436,7 @@ var _ = Describe("Session", func() {
 
 			It("sends a queued ACK frame only once", func() {
 				packetNumber := protocol.PacketNumber(0x1337)
-				session.receivedPacketHandler.ReceivedPacket(packetNumber)
+				session.receivedPacketHandler.ReceivedPacket(packetNumber, time.Now(), true)
 
 				s, err := session.GetOrOpenStream(5)
 				Expect(err).NotTo(HaveOccurred())
@@ -718,19 +1468,16 @@ var _ = Describe("Session", func() {
 		Expect(err.(*qerr.QuicError).ErrorCode).To(Equal(qerr.InvalidCryptoMessageType))
 	})
 
-	It("sends public reset after too many undecryptable packets", func() {
-		// Write protocol.MaxUndecryptablePackets and expect a public reset to happen
-		for i := 0; i < protocol.MaxUndecryptablePackets; i++ {
+	It("drops undecryptable packets when the queue is full", func() {
+		for i := 0; i < protocol.MaxUndecryptablePackets+5; i++ {
 			hdr := &PublicHeader{
 				PacketNumber: protocol.PacketNumber(i + 1),
 			}
-			session.handlePacket(&receivedPacket{publicHeader: hdr, data: []byte("foobar")})
+			session.tryQueueingUndecryptablePacket(&receivedPacket{publicHeader: hdr, data: []byte("foobar")})
 		}
-		session.run()
-
-		Expect(conn.written).To(HaveLen(1))
-		Expect(conn.written[0]).To(ContainSubstring(string([]byte("PRST"))))
-		Expect(session.runClosed).To(Receive())
+		Expect(session.undecryptablePackets).To(HaveLen(protocol.MaxUndecryptablePackets))
+		Expect(session.undecryptablePackets[0].publicHeader.PacketNumber).To(Equal(protocol.PacketNumber(1)))
+		Expect(session.closed).To(BeZero())
 	})
 
 	It("ignores undecryptable packets after the handshake is complete", func() {
@@ -747,6 +1494,41 @@ var _ = Describe("Session", func() {
 		Eventually(session.runClosed).Should(Receive())
 	})
 
+	Context("connection events", func() {
+		It("notifies about the version and the secure keys", func() {
+			var version protocol.VersionNumber
+			keysChanged := make(chan bool, 1)
+			session.connectionEvents = ConnectionEvents{
+				VersionNegotiated: func(_ SessionHandle, v protocol.VersionNumber) { version = v },
+				KeysChanged:       func(_ SessionHandle, forwardSecure bool) { keysChanged <- forwardSecure },
+			}
+			go session.run()
+			session.aeadChanged <- struct{}{}
+			Eventually(keysChanged).Should(Receive(BeFalse()))
+			Expect(version).To(Equal(protocol.Version35))
+			session.closeImpl(nil, true)
+			Eventually(session.runClosed).Should(Receive())
+		})
+
+		It("notifies once when the handshake completes", func() {
+			keysChanged := make(chan bool, 2)
+			handshakeComplete := make(chan struct{}, 2)
+			session.connectionEvents = ConnectionEvents{
+				KeysChanged:       func(_ SessionHandle, forwardSecure bool) { keysChanged <- forwardSecure },
+				HandshakeComplete: func(SessionHandle) { handshakeComplete <- struct{}{} },
+			}
+			*(*bool)(unsafe.Pointer(reflect.ValueOf(session.cryptoSetup).Elem().FieldByName("receivedForwardSecurePacket").UnsafeAddr())) = true
+			go session.run()
+			session.scheduleSending()
+			Eventually(handshakeComplete).Should(Receive())
+			Expect(keysChanged).To(Receive(BeTrue()))
+			session.scheduleSending()
+			Consistently(handshakeComplete).ShouldNot(Receive())
+			session.closeImpl(nil, true)
+			Eventually(session.runClosed).Should(Receive())
+		})
+	})
+
 	It("unqueues undecryptable packets for later decryption", func() {
 		session.undecryptablePackets = []*receivedPacket{{
 			publicHeader: &PublicHeader{PacketNumber: protocol.PacketNumber(42)},
@@ -758,6 +1540,32 @@ var _ = Describe("Session", func() {
 	})
 
 	Context("timeouts", func() {
+		It("uses the clock from the config for the run loop timer", func(done Done) {
+			clock := &mockClock{now: time.Now().Add(time.Hour)}
+			config, err := populateConfig(&Config{clock: clock})
+			Expect(err).ToNot(HaveOccurred())
+			closed := make(chan struct{})
+			pSession, err := newSession(
+				&mockConnection{},
+				protocol.Version35,
+				0,
+				scfg,
+				config,
+				func(SessionHandle, utils.Stream) {},
+				func(protocol.ConnectionID, packetHandler) { close(closed) },
+			)
+			Expect(err).ToNot(HaveOccurred())
+			sess := pSession.(*Session)
+			Expect(sess.lastNetworkActivityTime).To(Equal(clock.Now()))
+			go sess.run()
+			// the wall clock doesn't matter, and the timer doesn't fire on its own
+			Consistently(closed).ShouldNot(BeClosed())
+			clock.Advance(time.Hour)
+			clock.timer.Fire()
+			Eventually(closed).Should(BeClosed())
+			close(done)
+		})
+
 		It("times out due to no network activity", func(done Done) {
 			session.lastNetworkActivityTime = time.Now().Add(-time.Hour)
 			session.run() // Would normally not return
@@ -849,6 +1657,63 @@ var _ = Describe("Session", func() {
 		Expect(conn.written[0]).To(ContainSubstring("foobar"))
 	})
 
+	Context("sending GOAWAY", func() {
+		It("sends a GOAWAY frame with the last good stream", func() {
+			_, err := session.GetOrOpenStream(5)
+			Expect(err).ToNot(HaveOccurred())
+			session.GoAway()
+			err = session.sendPacket()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(conn.written).To(HaveLen(1))
+			Expect(conn.written[0]).To(ContainSubstring(string([]byte{0x03, 0x10, 0, 0, 0, 0x05, 0, 0, 0})))
+		})
+
+		It("only sends one GOAWAY frame", func() {
+			session.GoAway()
+			session.GoAway()
+			Expect(session.packer.controlFrames).To(HaveLen(1))
+		})
+
+		It("notifies the ConnectionEvents once", func() {
+			var calls int
+			session.connectionEvents.GoingAway = func(SessionHandle) { calls++ }
+			session.GoAway()
+			session.GoAway()
+			Expect(calls).To(Equal(1))
+		})
+
+		It("resets streams opened by the client after sending GOAWAY", func() {
+			session.GoAway()
+			str, err := session.GetOrOpenStream(5)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(str).To(BeNil())
+			err = session.sendPacket()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(conn.written).To(HaveLen(1))
+			rst := &frames.RstStreamFrame{StreamID: 5, ErrorCode: protocol.RstStreamRefusedStream}
+			b := &bytes.Buffer{}
+			rst.Write(b, protocol.VersionWhatever)
+			Expect(conn.written[0]).To(ContainSubstring(b.String()))
+		})
+
+		It("resets streams reset by the application with an internal error", func() {
+			session.onStreamReset(5, 42)
+			Expect(session.packer.controlFrames).To(ContainElement(&frames.RstStreamFrame{
+				StreamID:   5,
+				ByteOffset: 42,
+				ErrorCode:  uint32(qerr.InternalError),
+			}))
+		})
+
+		It("ignores STREAM frames for refused streams", func() {
+			session.GoAway()
+			err := session.handleStreamFrame(&frames.StreamFrame{StreamID: 5, Data: []byte("foobar")})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(session.streamsMap.streams).ToNot(HaveKey(protocol.StreamID(5)))
+			Expect(session.streamsMap.isClosed(5)).To(BeTrue())
+		})
+	})
+
 	Context("counting streams", func() {
 		It("errors when too many streams are opened", func() {
 			for i := 2; i <= 110; i++ {
@@ -19,6 +19,8 @@ import (
 type stream struct {
 	streamID protocol.StreamID
 	onData   func()
+	// onReset is a callback that should send a RST_STREAM
+	onReset func(protocol.StreamID, protocol.ByteCount)
 
 	readPosInFrame int
 	writeOffset    protocol.ByteCount
@@ -32,24 +34,37 @@ type stream struct {
 	eof int32 // really a bool
 	// closed is set when we are finished writing
 	closed int32 // really a bool
+	// resetLocally is set if Reset() is called
+	resetLocally int32 // really a bool
 
 	frameQueue        *streamFrameSorter
 	newFrameOrErrCond sync.Cond
 
 	dataForWriting       []byte
+	maxSendBuffer        protocol.ByteCount
 	finSent              bool
 	doneWritingOrErrCond sync.Cond
 
 	flowControlManager flowcontrol.FlowControlManager
+
+	// priority is protected by the mutex of the streamsMap
+	priority protocol.StreamPriority
 }
 
 // newStream creates a new Stream
-func newStream(StreamID protocol.StreamID, onData func(), flowControlManager flowcontrol.FlowControlManager) (*stream, error) {
+// It buffers at most maxOutOfOrderData bytes that arrived out of order, and at most maxSendBuffer bytes for sending. 0 means no limit.
+func newStream(StreamID protocol.StreamID, onData func(), onReset func(protocol.StreamID, protocol.ByteCount), flowControlManager flowcontrol.FlowControlManager, maxOutOfOrderData protocol.ByteCount, maxSendBuffer protocol.ByteCount) (*stream, error) {
 	s := &stream{
 		onData:             onData,
+		onReset:            onReset,
 		streamID:           StreamID,
 		flowControlManager: flowControlManager,
-		frameQueue:         newStreamFrameSorter(),
+		frameQueue:         newStreamFrameSorter(maxOutOfOrderData),
+		maxSendBuffer:      maxSendBuffer,
+		priority:           protocol.DefaultStreamPriority,
+	}
+	if StreamID == protocol.CryptoStreamID {
+		s.frameQueue = newStreamFrameSorter(protocol.MaxCryptoStreamOutOfOrderData)
 	}
 
 	s.newFrameOrErrCond.L = &s.mutex
@@ -129,6 +144,10 @@ func (s *stream) Read(p []byte) (int, error) {
 	return bytesRead, nil
 }
 
+// Write implements io.Writer.
+// The data is appended to the data that wasn't sent yet, so that the session can send the data of multiple small writes in a single StreamFrame.
+// It only blocks while maxSendBuffer bytes are buffered. Use Flush to wait until the data was handed to the session.
+// If an error occurs, it returns the number of bytes that were buffered.
 func (s *stream) Write(p []byte) (int, error) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
@@ -137,24 +156,93 @@ func (s *stream) Write(p []byte) (int, error) {
 		return 0, s.err
 	}
 
-	if len(p) == 0 {
-		return 0, nil
-	}
+	var bytesWritten int
+	for bytesWritten < len(p) {
+		for s.maxSendBuffer != 0 && protocol.ByteCount(len(s.dataForWriting)) >= s.maxSendBuffer && s.err == nil {
+			s.doneWritingOrErrCond.Wait()
+		}
 
-	s.dataForWriting = make([]byte, len(p))
-	copy(s.dataForWriting, p)
+		if s.err != nil {
+			return bytesWritten, s.err
+		}
 
-	s.onData()
+		n := len(p) - bytesWritten
+		if s.maxSendBuffer != 0 {
+			n = utils.Min(n, int(s.maxSendBuffer)-len(s.dataForWriting))
+		}
+		s.dataForWriting = append(s.dataForWriting, p[bytesWritten:bytesWritten+n]...)
+		bytesWritten += n
 
-	for s.dataForWriting != nil && s.err == nil {
-		s.doneWritingOrErrCond.Wait()
+		s.onData()
 	}
 
-	if s.err != nil {
-		return 0, s.err
+	return bytesWritten, nil
+}
+
+// ReadFrom implements io.ReaderFrom, so that io.Copy to a stream doesn't need an intermediate buffer.
+// It reads into a buffer that is handed to the session without copying. Consecutive reads fill the same buffer, until it is used up.
+// Like Write, it blocks while maxSendBuffer bytes are buffered.
+func (s *stream) ReadFrom(r io.Reader) (int64, error) {
+	var bytesWritten int64
+	// buf is the part of the buffer that was not read into yet
+	var buf []byte
+	for {
+		s.mutex.Lock()
+		for s.maxSendBuffer != 0 && protocol.ByteCount(len(s.dataForWriting)) >= s.maxSendBuffer && s.err == nil {
+			s.doneWritingOrErrCond.Wait()
+		}
+		if s.err != nil {
+			s.mutex.Unlock()
+			return bytesWritten, s.err
+		}
+		size := protocol.StreamReadFromBufferSize
+		if s.maxSendBuffer != 0 {
+			size = utils.MinByteCount(size, s.maxSendBuffer-protocol.ByteCount(len(s.dataForWriting)))
+		}
+		// don't hold the mutex while reading, since r might block
+		s.mutex.Unlock()
+
+		if len(buf) == 0 {
+			buf = make([]byte, protocol.StreamReadFromBufferSize)
+		}
+		n, err := r.Read(buf[:utils.MinByteCount(protocol.ByteCount(len(buf)), size)])
+		if n > 0 {
+			s.mutex.Lock()
+			if s.err != nil {
+				s.mutex.Unlock()
+				return bytesWritten, s.err
+			}
+			if len(s.dataForWriting) == 0 {
+				s.dataForWriting = buf[:n]
+			} else if cap(s.dataForWriting)-len(s.dataForWriting) == cap(buf) {
+				// the data read before ends where buf starts, since the session only takes data from the front
+				s.dataForWriting = s.dataForWriting[:len(s.dataForWriting)+n]
+			} else {
+				s.dataForWriting = append(s.dataForWriting, buf[:n]...)
+			}
+			buf = buf[n:]
+			bytesWritten += int64(n)
+			s.onData()
+			s.mutex.Unlock()
+		}
+		if err == io.EOF {
+			return bytesWritten, nil
+		}
+		if err != nil {
+			return bytesWritten, err
+		}
 	}
+}
 
-	return len(p), nil
+// Flush blocks until all data written to the stream was handed to the session for sending
+func (s *stream) Flush() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for s.dataForWriting != nil && s.err == nil {
+		s.doneWritingOrErrCond.Wait()
+	}
+	return s.err
 }
 
 func (s *stream) lenOfDataForWriting() protocol.ByteCount {
@@ -177,13 +265,26 @@ func (s *stream) getDataForWriting(maxBytes protocol.ByteCount) []byte {
 	} else {
 		ret = s.dataForWriting
 		s.dataForWriting = nil
-		s.doneWritingOrErrCond.Signal()
 	}
+	// unblock Write if the send buffer was full, and Flush if all data was handed to the session
+	s.doneWritingOrErrCond.Broadcast()
 	s.writeOffset += protocol.ByteCount(len(ret))
 	s.mutex.Unlock()
 	return ret
 }
 
+// debugSnapshot returns the state of the stream, except for the flow control window
+func (s *stream) debugSnapshot() StreamDebugSnapshot {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return StreamDebugSnapshot{
+		StreamID:     s.streamID,
+		BytesWritten: s.writeOffset,
+		BytesQueued:  protocol.ByteCount(len(s.dataForWriting)),
+		FinSent:      s.finSent,
+	}
+}
+
 // Close implements io.Closer
 func (s *stream) Close() error {
 	atomic.StoreInt32(&s.closed, 1)
@@ -244,10 +345,26 @@ func (s *stream) RegisterError(err error) {
 		return
 	}
 	s.err = err
-	s.doneWritingOrErrCond.Signal()
+	s.doneWritingOrErrCond.Broadcast()
 	s.newFrameOrErrCond.Signal()
 }
 
+// Reset aborts receiving and sending data on the stream.
+// Data that was not yet sent is discarded, and a RST_STREAM frame is sent to the peer.
+// Subsequent calls to Read() and Write() return err.
+func (s *stream) Reset(err error) {
+	if !atomic.CompareAndSwapInt32(&s.resetLocally, 0, 1) {
+		return
+	}
+	// register the error first, so that a blocked Write doesn't buffer more data once the data is discarded
+	s.RegisterError(err)
+	s.mutex.Lock()
+	s.dataForWriting = nil
+	writeOffset := s.writeOffset
+	s.mutex.Unlock()
+	s.onReset(s.streamID, writeOffset)
+}
+
 func (s *stream) finishedReading() bool {
 	return atomic.LoadInt32(&s.eof) != 0
 }
@@ -259,7 +376,7 @@ func (s *stream) finishedWriting() bool {
 }
 
 func (s *stream) finished() bool {
-	return s.finishedReading() && s.finishedWriting()
+	return atomic.LoadInt32(&s.resetLocally) != 0 || (s.finishedReading() && s.finishedWriting())
 }
 
 func (s *stream) StreamID() protocol.StreamID {
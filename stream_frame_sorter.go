@@ -2,6 +2,7 @@ package quic
 
 import (
 	"errors"
+	"sort"
 
 	"github.com/lucas-clemente/quic-go/frames"
 	"github.com/lucas-clemente/quic-go/protocol"
@@ -10,110 +11,157 @@ import (
 )
 
 type streamFrameSorter struct {
-	queuedFrames map[protocol.ByteCount]*frames.StreamFrame
+	// queuedFrames are the frames that weren't popped yet, sorted by their offset.
+	// Their data doesn't overlap, so the next frame to read is always the first one.
+	queuedFrames []*frames.StreamFrame
 	readPosition protocol.ByteCount
-	gaps         *utils.ByteIntervalList
+	// gaps are the byte ranges that weren't received yet, sorted by their offset
+	gaps []utils.ByteInterval
+
+	// queuedBytes is the number of bytes in all queued frames
+	queuedBytes protocol.ByteCount
+	// maxOutOfOrderData limits the number of bytes queued after the first gap. 0 means no limit.
+	maxOutOfOrderData protocol.ByteCount
 }
 
 var (
 	errTooManyGapsInReceivedStreamData = errors.New("Too many gaps in received StreamFrame data")
 	errDuplicateStreamData             = errors.New("Overlapping Stream Data")
 	errEmptyStreamData                 = errors.New("Stream Data empty")
+	errTooMuchOutOfOrderStreamData     = qerr.Error(qerr.InvalidStreamData, "Too much out-of-order stream data")
 )
 
-func newStreamFrameSorter() *streamFrameSorter {
-	s := streamFrameSorter{
-		gaps:         utils.NewByteIntervalList(),
-		queuedFrames: make(map[protocol.ByteCount]*frames.StreamFrame),
+// newStreamFrameSorter creates a new streamFrameSorter.
+// If maxOutOfOrderData is not 0, it buffers at most maxOutOfOrderData bytes that can't be read yet because of a gap.
+func newStreamFrameSorter(maxOutOfOrderData protocol.ByteCount) *streamFrameSorter {
+	return &streamFrameSorter{
+		gaps:              []utils.ByteInterval{{Start: 0, End: protocol.MaxByteCount}},
+		maxOutOfOrderData: maxOutOfOrderData,
 	}
-	s.gaps.PushFront(utils.ByteInterval{Start: 0, End: protocol.MaxByteCount})
-	return &s
 }
 
+// Push queues the data of a frame that wasn't received yet.
+// If the frame overlaps with data that was already received, only the new parts are queued, without copying the data.
+// If it doesn't contain any new data, errDuplicateStreamData is returned.
 func (s *streamFrameSorter) Push(frame *frames.StreamFrame) error {
-	_, ok := s.queuedFrames[frame.Offset]
-	if ok {
-		return errDuplicateStreamData
-	}
-
 	start := frame.Offset
 	end := frame.Offset + frame.DataLen()
 
 	if start == end {
-		if frame.FinBit {
-			s.queuedFrames[frame.Offset] = frame
-			return nil
-		}
-		return errEmptyStreamData
-	}
-
-	var foundInGap bool
-
-	for gap := s.gaps.Front(); gap != nil; gap = gap.Next() {
-		// the complete frame lies before or after the gap
-		if end <= gap.Value.Start || start > gap.Value.End {
-			continue
+		if !frame.FinBit {
+			return errEmptyStreamData
 		}
-
-		if start < gap.Value.Start {
-			return qerr.Error(qerr.OverlappingStreamData, "start of gap in stream chunk")
-		}
-
-		if start < gap.Value.End && end > gap.Value.End {
-			return qerr.Error(qerr.OverlappingStreamData, "end of gap in stream chunk")
-		}
-
-		foundInGap = true
-
-		if start == gap.Value.Start {
-			if end == gap.Value.End {
-				s.gaps.Remove(gap)
-				break
-			}
-			if end < gap.Value.End {
-				gap.Value.Start = end
-				break
-			}
-		}
-
-		if end == gap.Value.End {
-			gap.Value.End = start
-			break
+		i := s.searchQueuedFrame(frame.Offset)
+		if i < len(s.queuedFrames) && s.queuedFrames[i].Offset == frame.Offset {
+			return errDuplicateStreamData
 		}
+		s.insertQueuedFrame(i, frame)
+		return nil
+	}
 
-		if end < gap.Value.End {
-			intv := utils.ByteInterval{Start: end, End: gap.Value.End}
-			s.gaps.InsertAfter(intv, gap)
-			gap.Value.End = start
-			break
+	// the gaps[first:last] overlap with the frame
+	first := sort.Search(len(s.gaps), func(i int) bool { return s.gaps[i].End > start })
+	last := first
+	var outOfOrderBytes protocol.ByteCount
+	for ; last < len(s.gaps) && s.gaps[last].Start < end; last++ {
+		gap := s.gaps[last]
+		pieceStart := utils.MaxByteCount(start, gap.Start)
+		// the piece doesn't start at the read position, so it is buffered out of order
+		if last != 0 || pieceStart > gap.Start {
+			outOfOrderBytes += utils.MinByteCount(end, gap.End) - pieceStart
 		}
 	}
-
-	if !foundInGap {
+	if first == last {
 		return errDuplicateStreamData
 	}
+	if outOfOrderBytes > 0 && s.maxOutOfOrderData != 0 && s.outOfOrderData()+outOfOrderBytes > s.maxOutOfOrderData {
+		return errTooMuchOutOfOrderStreamData
+	}
 
-	if s.gaps.Len() > protocol.MaxStreamFrameSorterGaps {
+	// the parts of the first and the last gap that are not filled by the frame
+	remaining := make([]utils.ByteInterval, 0, 2)
+	if start > s.gaps[first].Start {
+		remaining = append(remaining, utils.ByteInterval{Start: s.gaps[first].Start, End: start})
+	}
+	if end < s.gaps[last-1].End {
+		remaining = append(remaining, utils.ByteInterval{Start: end, End: s.gaps[last-1].End})
+	}
+	if len(s.gaps)-(last-first)+len(remaining) > protocol.MaxStreamFrameSorterGaps {
 		return errTooManyGapsInReceivedStreamData
 	}
 
-	s.queuedFrames[frame.Offset] = frame
+	if last-first == 1 && s.gaps[first].Start <= start && end <= s.gaps[first].End {
+		// the frame lies within a single gap
+		s.insertQueuedFrame(s.searchQueuedFrame(frame.Offset), frame)
+		s.queuedBytes += frame.DataLen()
+	} else {
+		// the pieces are inserted in ascending order, so the search can start after the previous piece
+		i := s.searchQueuedFrame(start)
+		for _, gap := range s.gaps[first:last] {
+			pieceStart := utils.MaxByteCount(start, gap.Start)
+			pieceEnd := utils.MinByteCount(end, gap.End)
+			for i < len(s.queuedFrames) && s.queuedFrames[i].Offset < pieceStart {
+				i++
+			}
+			s.insertQueuedFrame(i, &frames.StreamFrame{
+				StreamID: frame.StreamID,
+				Offset:   pieceStart,
+				Data:     frame.Data[pieceStart-start : pieceEnd-start],
+				FinBit:   frame.FinBit && pieceEnd == end,
+			})
+			i++
+			s.queuedBytes += pieceEnd - pieceStart
+		}
+	}
+	s.replaceGaps(first, last, remaining)
 	return nil
 }
 
+// searchQueuedFrame returns the index of the first queued frame with an offset not smaller than offset
+func (s *streamFrameSorter) searchQueuedFrame(offset protocol.ByteCount) int {
+	return sort.Search(len(s.queuedFrames), func(i int) bool { return s.queuedFrames[i].Offset >= offset })
+}
+
+func (s *streamFrameSorter) insertQueuedFrame(i int, frame *frames.StreamFrame) {
+	s.queuedFrames = append(s.queuedFrames, nil)
+	copy(s.queuedFrames[i+1:], s.queuedFrames[i:])
+	s.queuedFrames[i] = frame
+}
+
+// replaceGaps replaces the gaps[first:last] by the intervals
+func (s *streamFrameSorter) replaceGaps(first, last int, intervals []utils.ByteInterval) {
+	if diff := len(intervals) - (last - first); diff > 0 {
+		s.gaps = append(s.gaps, make([]utils.ByteInterval, diff)...)
+		copy(s.gaps[last+diff:], s.gaps[last:])
+	} else if diff < 0 {
+		s.gaps = append(s.gaps[:last+diff], s.gaps[last:]...)
+	}
+	copy(s.gaps[first:], intervals)
+}
+
 func (s *streamFrameSorter) Pop() *frames.StreamFrame {
 	frame := s.Head()
 	if frame != nil {
 		s.readPosition += frame.DataLen()
-		delete(s.queuedFrames, frame.Offset)
+		s.queuedBytes -= frame.DataLen()
+		s.queuedFrames[0] = nil
+		s.queuedFrames = s.queuedFrames[1:]
 	}
 	return frame
 }
 
+// outOfOrderData returns the number of queued bytes that lie after the first gap
+func (s *streamFrameSorter) outOfOrderData() protocol.ByteCount {
+	if len(s.gaps) == 0 {
+		return 0
+	}
+	// all data between the read position and the first gap is queued
+	return s.queuedBytes - (s.gaps[0].Start - s.readPosition)
+}
+
 func (s *streamFrameSorter) Head() *frames.StreamFrame {
-	frame, ok := s.queuedFrames[s.readPosition]
-	if ok {
-		return frame
+	if len(s.queuedFrames) == 0 || s.queuedFrames[0].Offset != s.readPosition {
+		return nil
 	}
-	return nil
+	return s.queuedFrames[0]
 }
@@ -8,20 +8,21 @@ import (
 	. "github.com/onsi/gomega"
 )
 
-func compareGapValues(gapList *utils.ByteIntervalList, expectedGaps []utils.ByteInterval) {
-	Expect(gapList.Len()).To(Equal(len(expectedGaps)))
-	var i int
-	for gap := gapList.Front(); gap != nil; gap = gap.Next() {
-		Expect(gap.Value).To(Equal(expectedGaps[i]))
-		i++
-	}
-}
-
 var _ = Describe("StreamFrame sorter", func() {
 	var s *streamFrameSorter
 
+	// queuedFrame returns the queued frame starting at offset, or nil
+	queuedFrame := func(offset protocol.ByteCount) *frames.StreamFrame {
+		for _, f := range s.queuedFrames {
+			if f.Offset == offset {
+				return f
+			}
+		}
+		return nil
+	}
+
 	BeforeEach(func() {
-		s = newStreamFrameSorter()
+		s = newStreamFrameSorter(0)
 	})
 
 	It("head returns nil when empty", func() {
@@ -102,8 +103,8 @@ var _ = Describe("StreamFrame sorter", func() {
 				}
 				err := s.Push(f)
 				Expect(err).ToNot(HaveOccurred())
-				Expect(s.gaps.Len()).To(Equal(2))
-				Expect(s.gaps.Front().Value).To(Equal(utils.ByteInterval{Start: 0, End: 10}))
+				Expect(len(s.gaps)).To(Equal(2))
+				Expect(s.gaps[0]).To(Equal(utils.ByteInterval{Start: 0, End: 10}))
 			})
 
 			It("correctly sets the first gap for a frame with offset 0", func() {
@@ -113,8 +114,8 @@ var _ = Describe("StreamFrame sorter", func() {
 				}
 				err := s.Push(f)
 				Expect(err).ToNot(HaveOccurred())
-				Expect(s.gaps.Len()).To(Equal(1))
-				Expect(s.gaps.Front().Value).To(Equal(utils.ByteInterval{Start: 6, End: protocol.MaxByteCount}))
+				Expect(len(s.gaps)).To(Equal(1))
+				Expect(s.gaps[0]).To(Equal(utils.ByteInterval{Start: 6, End: protocol.MaxByteCount}))
 			})
 
 			It("finds the two gaps", func() {
@@ -130,12 +131,10 @@ var _ = Describe("StreamFrame sorter", func() {
 				}
 				err = s.Push(f2)
 				Expect(err).ToNot(HaveOccurred())
-				Expect(s.gaps.Len()).To(Equal(3))
-				el := s.gaps.Front() // first gap
-				Expect(el.Value).To(Equal(utils.ByteInterval{Start: 0, End: 10}))
-				el = el.Next() // second gap
-				Expect(el.Value).To(Equal(utils.ByteInterval{Start: 16, End: 20}))
-				Expect(s.gaps.Back().Value.Start).To(Equal(protocol.ByteCount(26)))
+				Expect(len(s.gaps)).To(Equal(3))
+				Expect(s.gaps[0]).To(Equal(utils.ByteInterval{Start: 0, End: 10}))
+				Expect(s.gaps[1]).To(Equal(utils.ByteInterval{Start: 16, End: 20}))
+				Expect(s.gaps[len(s.gaps)-1].Start).To(Equal(protocol.ByteCount(26)))
 			})
 
 			It("finds the two gaps in reverse order", func() {
@@ -151,12 +150,10 @@ var _ = Describe("StreamFrame sorter", func() {
 				}
 				err = s.Push(f2)
 				Expect(err).ToNot(HaveOccurred())
-				Expect(s.gaps.Len()).To(Equal(3))
-				el := s.gaps.Front() // first gap
-				Expect(el.Value).To(Equal(utils.ByteInterval{Start: 0, End: 10}))
-				el = el.Next() // second gap
-				Expect(el.Value).To(Equal(utils.ByteInterval{Start: 16, End: 20}))
-				Expect(s.gaps.Back().Value).To(Equal(utils.ByteInterval{Start: 26, End: protocol.MaxByteCount}))
+				Expect(len(s.gaps)).To(Equal(3))
+				Expect(s.gaps[0]).To(Equal(utils.ByteInterval{Start: 0, End: 10}))
+				Expect(s.gaps[1]).To(Equal(utils.ByteInterval{Start: 16, End: 20}))
+				Expect(s.gaps[len(s.gaps)-1]).To(Equal(utils.ByteInterval{Start: 26, End: protocol.MaxByteCount}))
 			})
 
 			It("shrinks a gap when it is partially filled", func() {
@@ -172,9 +169,9 @@ var _ = Describe("StreamFrame sorter", func() {
 				}
 				err = s.Push(f2)
 				Expect(err).ToNot(HaveOccurred())
-				Expect(s.gaps.Len()).To(Equal(2))
-				Expect(s.gaps.Front().Value).To(Equal(utils.ByteInterval{Start: 0, End: 4}))
-				Expect(s.gaps.Back().Value).To(Equal(utils.ByteInterval{Start: 14, End: protocol.MaxByteCount}))
+				Expect(len(s.gaps)).To(Equal(2))
+				Expect(s.gaps[0]).To(Equal(utils.ByteInterval{Start: 0, End: 4}))
+				Expect(s.gaps[len(s.gaps)-1]).To(Equal(utils.ByteInterval{Start: 14, End: protocol.MaxByteCount}))
 			})
 
 			It("deletes a gap at the beginning, when it is filled", func() {
@@ -190,8 +187,8 @@ var _ = Describe("StreamFrame sorter", func() {
 				}
 				err = s.Push(f2)
 				Expect(err).ToNot(HaveOccurred())
-				Expect(s.gaps.Len()).To(Equal(1))
-				Expect(s.gaps.Front().Value).To(Equal(utils.ByteInterval{Start: 10, End: protocol.MaxByteCount}))
+				Expect(len(s.gaps)).To(Equal(1))
+				Expect(s.gaps[0]).To(Equal(utils.ByteInterval{Start: 10, End: protocol.MaxByteCount}))
 			})
 
 			It("deletes a gap in the middle, when it is filled", func() {
@@ -213,8 +210,8 @@ var _ = Describe("StreamFrame sorter", func() {
 				}
 				err = s.Push(f3)
 				Expect(err).ToNot(HaveOccurred())
-				Expect(s.gaps.Len()).To(Equal(1))
-				Expect(s.gaps.Front().Value).To(Equal(utils.ByteInterval{Start: 15, End: protocol.MaxByteCount}))
+				Expect(len(s.gaps)).To(Equal(1))
+				Expect(s.gaps[0]).To(Equal(utils.ByteInterval{Start: 15, End: protocol.MaxByteCount}))
 				Expect(s.queuedFrames).To(HaveLen(3))
 			})
 
@@ -231,113 +228,160 @@ var _ = Describe("StreamFrame sorter", func() {
 				}
 				err = s.Push(f2)
 				Expect(err).ToNot(HaveOccurred())
-				Expect(s.gaps.Len()).To(Equal(3))
-				el := s.gaps.Front() // first gap
-				Expect(el.Value).To(Equal(utils.ByteInterval{Start: 0, End: 50}))
-				el = el.Next() // second gap
-				Expect(el.Value).To(Equal(utils.ByteInterval{Start: 56, End: 100}))
-				Expect(s.gaps.Back().Value).To(Equal(utils.ByteInterval{Start: 104, End: protocol.MaxByteCount}))
+				Expect(len(s.gaps)).To(Equal(3))
+				Expect(s.gaps[0]).To(Equal(utils.ByteInterval{Start: 0, End: 50}))
+				Expect(s.gaps[1]).To(Equal(utils.ByteInterval{Start: 56, End: 100}))
+				Expect(s.gaps[len(s.gaps)-1]).To(Equal(utils.ByteInterval{Start: 104, End: protocol.MaxByteCount}))
 				Expect(s.queuedFrames).To(HaveLen(2))
 			})
 
-			Context("Overlapping Stream Data detection", func() {
-				var expectedGaps []utils.ByteInterval
+			It("keeps the queued frames sorted by offset", func() {
+				err := s.Push(&frames.StreamFrame{Offset: 20, Data: []byte("fooba")})
+				Expect(err).ToNot(HaveOccurred())
+				err = s.Push(&frames.StreamFrame{Offset: 10, Data: []byte("fooba")})
+				Expect(err).ToNot(HaveOccurred())
+				err = s.Push(&frames.StreamFrame{Offset: 0, Data: []byte("0123456789abcdefghijklmnopqrst")})
+				Expect(err).ToNot(HaveOccurred())
+				var offsets []protocol.ByteCount
+				for _, f := range s.queuedFrames {
+					offsets = append(offsets, f.Offset)
+				}
+				Expect(offsets).To(Equal([]protocol.ByteCount{0, 10, 15, 20, 25}))
+				var data []byte
+				for f := s.Pop(); f != nil; f = s.Pop() {
+					data = append(data, f.Data...)
+				}
+				Expect(data).To(Equal([]byte("0123456789foobafghijfoobapqrst")))
+				Expect(s.queuedFrames).To(BeEmpty())
+			})
+
+			Context("Overlapping Stream Data", func() {
 				BeforeEach(func() {
-					// create gaps: 0-5, 10-15, 15-20, 30-inf
-					expectedGaps = expectedGaps[:0]
-					expectedGaps = append(expectedGaps, utils.ByteInterval{Start: 0, End: 5})
+					// create gaps: 0-5, 10-15, 20-25, 30-inf
 					err := s.Push(&frames.StreamFrame{Offset: 5, Data: []byte("12345")})
 					Expect(err).ToNot(HaveOccurred())
-					expectedGaps = append(expectedGaps, utils.ByteInterval{Start: 10, End: 15})
 					err = s.Push(&frames.StreamFrame{Offset: 15, Data: []byte("12345")})
 					Expect(err).ToNot(HaveOccurred())
-					expectedGaps = append(expectedGaps, utils.ByteInterval{Start: 20, End: 25})
 					err = s.Push(&frames.StreamFrame{Offset: 25, Data: []byte("12345")})
 					Expect(err).ToNot(HaveOccurred())
-					expectedGaps = append(expectedGaps, utils.ByteInterval{Start: 30, End: protocol.MaxByteCount})
 				})
 
-				It("rejects a frame with offset 0 that overlaps at the end", func() {
-					f := &frames.StreamFrame{
-						Offset: 0,
-						Data:   []byte("foobar"),
-					}
-					err := s.Push(f)
-					Expect(err).To(MatchError("OverlappingStreamData: end of gap in stream chunk"))
-					Expect(s.queuedFrames).ToNot(HaveKey(protocol.ByteCount(0)))
-					compareGapValues(s.gaps, expectedGaps)
+				It("queues the new data of a frame with offset 0 that overlaps at the end", func() {
+					// 0 to 6
+					err := s.Push(&frames.StreamFrame{Offset: 0, Data: []byte("foobar")})
+					Expect(err).ToNot(HaveOccurred())
+					Expect(queuedFrame(0).Data).To(Equal([]byte("fooba")))
+					Expect(queuedFrame(5).Data).To(Equal([]byte("12345")))
+					Expect(s.gaps).To(Equal([]utils.ByteInterval{
+						{Start: 10, End: 15},
+						{Start: 20, End: 25},
+						{Start: 30, End: protocol.MaxByteCount},
+					}))
 				})
 
-				It("rejects a frame that overlaps at the end", func() {
+				It("queues the new data of a frame that overlaps at the end", func() {
 					// 4 to 6
-					f := &frames.StreamFrame{
-						Offset: 4,
-						Data:   []byte("12"),
-					}
-					err := s.Push(f)
-					Expect(err).To(MatchError("OverlappingStreamData: end of gap in stream chunk"))
-					Expect(s.queuedFrames).ToNot(HaveKey(protocol.ByteCount(4)))
-					compareGapValues(s.gaps, expectedGaps)
+					err := s.Push(&frames.StreamFrame{Offset: 4, Data: []byte("12")})
+					Expect(err).ToNot(HaveOccurred())
+					Expect(queuedFrame(4).Data).To(Equal([]byte("1")))
+					Expect(s.gaps).To(Equal([]utils.ByteInterval{
+						{Start: 0, End: 4},
+						{Start: 10, End: 15},
+						{Start: 20, End: 25},
+						{Start: 30, End: protocol.MaxByteCount},
+					}))
 				})
 
-				It("rejects a frame that completely fills a gap, but overlaps at the end", func() {
+				It("queues the new data of a frame that completely fills a gap, but overlaps at the end", func() {
 					// 10 to 16
-					f := &frames.StreamFrame{
-						Offset: 10,
-						Data:   []byte("foobar"),
-					}
-					err := s.Push(f)
-					Expect(err).To(MatchError("OverlappingStreamData: end of gap in stream chunk"))
-					Expect(s.queuedFrames).ToNot(HaveKey(protocol.ByteCount(10)))
-					compareGapValues(s.gaps, expectedGaps)
+					err := s.Push(&frames.StreamFrame{Offset: 10, Data: []byte("foobar")})
+					Expect(err).ToNot(HaveOccurred())
+					Expect(queuedFrame(10).Data).To(Equal([]byte("fooba")))
+					Expect(s.gaps).To(Equal([]utils.ByteInterval{
+						{Start: 0, End: 5},
+						{Start: 20, End: 25},
+						{Start: 30, End: protocol.MaxByteCount},
+					}))
 				})
 
-				It("rejects a frame that overlaps at the beginning", func() {
+				It("queues the new data of a frame that overlaps at the beginning", func() {
 					// 8 to 14
-					f := &frames.StreamFrame{
-						Offset: 8,
-						Data:   []byte("foobar"),
-					}
-					err := s.Push(f)
-					Expect(err).To(MatchError("OverlappingStreamData: start of gap in stream chunk"))
-					Expect(s.queuedFrames).ToNot(HaveKey(protocol.ByteCount(8)))
-					compareGapValues(s.gaps, expectedGaps)
+					err := s.Push(&frames.StreamFrame{Offset: 8, Data: []byte("foobar")})
+					Expect(err).ToNot(HaveOccurred())
+					Expect(queuedFrame(8)).To(BeNil())
+					Expect(queuedFrame(10).Data).To(Equal([]byte("obar")))
+					Expect(s.gaps).To(Equal([]utils.ByteInterval{
+						{Start: 0, End: 5},
+						{Start: 14, End: 15},
+						{Start: 20, End: 25},
+						{Start: 30, End: protocol.MaxByteCount},
+					}))
 				})
 
-				It("rejects a frame that overlaps at the beginning and at the end, starting in a gap", func() {
+				It("queues the new data of a frame that overlaps at the beginning and at the end, starting in a gap", func() {
 					// 2 to 11
-					f := &frames.StreamFrame{
-						Offset: 2,
-						Data:   []byte("123456789"),
-					}
-					err := s.Push(f)
-					Expect(err).To(MatchError("OverlappingStreamData: end of gap in stream chunk"))
-					Expect(s.queuedFrames).ToNot(HaveKey(protocol.ByteCount(2)))
-					compareGapValues(s.gaps, expectedGaps)
+					err := s.Push(&frames.StreamFrame{Offset: 2, Data: []byte("123456789")})
+					Expect(err).ToNot(HaveOccurred())
+					Expect(queuedFrame(2).Data).To(Equal([]byte("123")))
+					Expect(queuedFrame(10).Data).To(Equal([]byte("9")))
+					Expect(s.gaps).To(Equal([]utils.ByteInterval{
+						{Start: 0, End: 2},
+						{Start: 11, End: 15},
+						{Start: 20, End: 25},
+						{Start: 30, End: protocol.MaxByteCount},
+					}))
 				})
 
-				It("rejects a frame that overlaps at the beginning and at the end, starting in data already received", func() {
+				It("queues the new data of a frame that overlaps at the beginning and at the end, starting in data already received", func() {
 					// 8 to 17
-					f := &frames.StreamFrame{
-						Offset: 8,
-						Data:   []byte("123456789"),
-					}
+					err := s.Push(&frames.StreamFrame{Offset: 8, Data: []byte("123456789")})
+					Expect(err).ToNot(HaveOccurred())
+					Expect(queuedFrame(10).Data).To(Equal([]byte("34567")))
+					Expect(s.gaps).To(Equal([]utils.ByteInterval{
+						{Start: 0, End: 5},
+						{Start: 20, End: 25},
+						{Start: 30, End: protocol.MaxByteCount},
+					}))
+				})
+
+				It("queues the new data of a frame that covers two gaps", func() {
+					// 8 to 22
+					err := s.Push(&frames.StreamFrame{Offset: 8, Data: []byte("12345678901234")})
+					Expect(err).ToNot(HaveOccurred())
+					Expect(queuedFrame(10).Data).To(Equal([]byte("34567")))
+					Expect(queuedFrame(20).Data).To(Equal([]byte("34")))
+					Expect(s.queuedBytes).To(Equal(protocol.ByteCount(15 + 7)))
+					Expect(s.gaps).To(Equal([]utils.ByteInterval{
+						{Start: 0, End: 5},
+						{Start: 22, End: 25},
+						{Start: 30, End: protocol.MaxByteCount},
+					}))
+				})
+
+				It("doesn't copy the data", func() {
+					f := &frames.StreamFrame{Offset: 8, Data: []byte("foobar")}
 					err := s.Push(f)
-					Expect(err).To(MatchError("OverlappingStreamData: start of gap in stream chunk"))
-					Expect(s.queuedFrames).ToNot(HaveKey(protocol.ByteCount(8)))
-					compareGapValues(s.gaps, expectedGaps)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(&queuedFrame(10).Data[0]).To(BeIdenticalTo(&f.Data[2]))
 				})
 
-				It("rejects a frame that completely covers two gaps", func() {
-					// 10 to 20
-					f := &frames.StreamFrame{
-						Offset: 10,
-						Data:   []byte("1234567890"),
+				It("keeps the FinBit", func() {
+					// 28 to 32
+					err := s.Push(&frames.StreamFrame{Offset: 28, Data: []byte("foob"), FinBit: true})
+					Expect(err).ToNot(HaveOccurred())
+					Expect(queuedFrame(25).FinBit).To(BeFalse())
+					Expect(queuedFrame(30).Data).To(Equal([]byte("ob")))
+					Expect(queuedFrame(30).FinBit).To(BeTrue())
+				})
+
+				It("reads all data in order", func() {
+					err := s.Push(&frames.StreamFrame{Offset: 0, Data: []byte("abcdefghijklmnopqrstuvwxyz1234")})
+					Expect(err).ToNot(HaveOccurred())
+					var data []byte
+					for frame := s.Pop(); frame != nil; frame = s.Pop() {
+						data = append(data, frame.Data...)
 					}
-					err := s.Push(f)
-					Expect(err).To(MatchError("OverlappingStreamData: end of gap in stream chunk"))
-					Expect(s.queuedFrames).ToNot(HaveKey(protocol.ByteCount(10)))
-					compareGapValues(s.gaps, expectedGaps)
+					Expect(data).To(Equal([]byte("abcde12345klmno12345uvwxy12345")))
 				})
 			})
 
@@ -358,40 +402,40 @@ var _ = Describe("StreamFrame sorter", func() {
 				It("detects a complete duplicate frame", func() {
 					err := s.Push(&frames.StreamFrame{Offset: 0, Data: []byte("12345")})
 					Expect(err).To(MatchError(errDuplicateStreamData))
-					compareGapValues(s.gaps, expectedGaps)
+					Expect(s.gaps).To(Equal(expectedGaps))
 				})
 
 				It("does not modify data when receiving a duplicate", func() {
 					err := s.Push(&frames.StreamFrame{Offset: 0, Data: []byte("67890")})
 					Expect(err).To(MatchError(errDuplicateStreamData))
-					Expect(s.queuedFrames[0].Data).To(Equal([]byte("12345")))
-					compareGapValues(s.gaps, expectedGaps)
+					Expect(queuedFrame(0).Data).To(Equal([]byte("12345")))
+					Expect(s.gaps).To(Equal(expectedGaps))
 				})
 
 				It("detects a duplicate frame that is smaller than the original, starting at the beginning", func() {
 					// 10 to 12
 					err := s.Push(&frames.StreamFrame{Offset: 10, Data: []byte("12")})
 					Expect(err).To(MatchError(errDuplicateStreamData))
-					Expect(s.queuedFrames[10].DataLen()).To(Equal(protocol.ByteCount(5)))
-					compareGapValues(s.gaps, expectedGaps)
+					Expect(queuedFrame(10).DataLen()).To(Equal(protocol.ByteCount(5)))
+					Expect(s.gaps).To(Equal(expectedGaps))
 				})
 
 				It("detects a duplicate frame that is smaller than the original, somewhere in the middle", func() {
 					// 1 to 4
 					err := s.Push(&frames.StreamFrame{Offset: 1, Data: []byte("123")})
 					Expect(err).To(MatchError(errDuplicateStreamData))
-					Expect(s.queuedFrames[0].DataLen()).To(Equal(protocol.ByteCount(5)))
-					Expect(s.queuedFrames).ToNot(HaveKey(protocol.ByteCount(1)))
-					compareGapValues(s.gaps, expectedGaps)
+					Expect(queuedFrame(0).DataLen()).To(Equal(protocol.ByteCount(5)))
+					Expect(queuedFrame(1)).To(BeNil())
+					Expect(s.gaps).To(Equal(expectedGaps))
 				})
 
 				It("detects a duplicate frame that is smaller than the original, with aligned end", func() {
 					// 3 to 5
 					err := s.Push(&frames.StreamFrame{Offset: 3, Data: []byte("12")})
 					Expect(err).To(MatchError(errDuplicateStreamData))
-					Expect(s.queuedFrames[0].DataLen()).To(Equal(protocol.ByteCount(5)))
-					Expect(s.queuedFrames).ToNot(HaveKey(protocol.ByteCount(8)))
-					compareGapValues(s.gaps, expectedGaps)
+					Expect(queuedFrame(0).DataLen()).To(Equal(protocol.ByteCount(5)))
+					Expect(queuedFrame(8)).To(BeNil())
+					Expect(s.gaps).To(Equal(expectedGaps))
 				})
 			})
 
@@ -405,7 +449,7 @@ var _ = Describe("StreamFrame sorter", func() {
 						err := s.Push(f)
 						Expect(err).ToNot(HaveOccurred())
 					}
-					Expect(s.gaps.Len()).To(Equal(protocol.MaxStreamFrameSorterGaps))
+					Expect(len(s.gaps)).To(Equal(protocol.MaxStreamFrameSorterGaps))
 					f := &frames.StreamFrame{
 						Data:   []byte("foobar"),
 						Offset: protocol.ByteCount(protocol.MaxStreamFrameSorterGaps*7) + 100,
@@ -413,6 +457,62 @@ var _ = Describe("StreamFrame sorter", func() {
 					err := s.Push(f)
 					Expect(err).To(MatchError(errTooManyGapsInReceivedStreamData))
 				})
+
+				Context("limiting out-of-order data", func() {
+					BeforeEach(func() {
+						s = newStreamFrameSorter(10)
+					})
+
+					It("accepts out-of-order data up to the limit", func() {
+						err := s.Push(&frames.StreamFrame{Offset: 10, Data: []byte("foobar")})
+						Expect(err).ToNot(HaveOccurred())
+						err = s.Push(&frames.StreamFrame{Offset: 20, Data: []byte("foob")})
+						Expect(err).ToNot(HaveOccurred())
+						Expect(s.outOfOrderData()).To(Equal(protocol.ByteCount(10)))
+					})
+
+					It("errors when too much out-of-order data is received", func() {
+						err := s.Push(&frames.StreamFrame{Offset: 10, Data: []byte("foobar")})
+						Expect(err).ToNot(HaveOccurred())
+						err = s.Push(&frames.StreamFrame{Offset: 20, Data: []byte("foobar")})
+						Expect(err).To(MatchError(errTooMuchOutOfOrderStreamData))
+					})
+
+					It("doesn't count data that can be read", func() {
+						err := s.Push(&frames.StreamFrame{Offset: 0, Data: []byte("foobarfoobar")})
+						Expect(err).ToNot(HaveOccurred())
+						err = s.Push(&frames.StreamFrame{Offset: 20, Data: []byte("foobar")})
+						Expect(err).ToNot(HaveOccurred())
+						Expect(s.outOfOrderData()).To(Equal(protocol.ByteCount(6)))
+					})
+
+					It("accepts data that fills the first gap", func() {
+						err := s.Push(&frames.StreamFrame{Offset: 6, Data: []byte("foobarfoob")})
+						Expect(err).ToNot(HaveOccurred())
+						err = s.Push(&frames.StreamFrame{Offset: 0, Data: []byte("foobar")})
+						Expect(err).ToNot(HaveOccurred())
+						Expect(s.outOfOrderData()).To(BeZero())
+						err = s.Push(&frames.StreamFrame{Offset: 20, Data: []byte("foobar")})
+						Expect(err).ToNot(HaveOccurred())
+					})
+
+					It("updates the out-of-order data when frames are popped", func() {
+						err := s.Push(&frames.StreamFrame{Offset: 0, Data: []byte("foobar")})
+						Expect(err).ToNot(HaveOccurred())
+						err = s.Push(&frames.StreamFrame{Offset: 10, Data: []byte("foobar")})
+						Expect(err).ToNot(HaveOccurred())
+						s.Pop()
+						Expect(s.queuedBytes).To(Equal(protocol.ByteCount(6)))
+						Expect(s.outOfOrderData()).To(Equal(protocol.ByteCount(6)))
+					})
+
+					It("doesn't error on duplicate data", func() {
+						err := s.Push(&frames.StreamFrame{Offset: 10, Data: []byte("foobarfoob")})
+						Expect(err).ToNot(HaveOccurred())
+						err = s.Push(&frames.StreamFrame{Offset: 10, Data: []byte("foobarfoob")})
+						Expect(err).To(MatchError(errDuplicateStreamData))
+					})
+				})
 			})
 		})
 	})
@@ -14,6 +14,13 @@ type streamFramer struct {
 
 	retransmissionQueue []*frames.StreamFrame
 	blockedFrameQueue   []*frames.BlockedFrame
+	// blockedStreams are the streams a BLOCKED frame was queued for, with stream ID 0 for the connection.
+	// They are collected while packing, and the session reports them once no locks are held.
+	blockedStreams []protocol.StreamID
+
+	// number of BLOCKED frames queued, for the stats
+	connectionBlockedCount uint64
+	streamBlockedCount     uint64
 }
 
 func newStreamFramer(streamsMap *streamsMap, flowControlManager flowcontrol.FlowControlManager) *streamFramer {
@@ -24,7 +31,18 @@ func newStreamFramer(streamsMap *streamsMap, flowControlManager flowcontrol.Flow
 }
 
 func (f *streamFramer) AddFrameForRetransmission(frame *frames.StreamFrame) {
-	f.retransmissionQueue = append(f.retransmissionQueue, frame)
+	if frame.StreamID != protocol.CryptoStreamID {
+		f.retransmissionQueue = append(f.retransmissionQueue, frame)
+		return
+	}
+	// retransmissions of handshake data are sent before all other retransmissions
+	i := 0
+	for i < len(f.retransmissionQueue) && f.retransmissionQueue[i].StreamID == protocol.CryptoStreamID {
+		i++
+	}
+	f.retransmissionQueue = append(f.retransmissionQueue, nil)
+	copy(f.retransmissionQueue[i+1:], f.retransmissionQueue[i:])
+	f.retransmissionQueue[i] = frame
 }
 
 func (f *streamFramer) PopStreamFrames(maxLen protocol.ByteCount) []*frames.StreamFrame {
@@ -41,6 +59,18 @@ func (f *streamFramer) PopBlockedFrame() *frames.BlockedFrame {
 	return frame
 }
 
+// PopBlockedStreams returns the streams that became blocked by flow control since the last call, with stream ID 0 for the connection
+func (f *streamFramer) PopBlockedStreams() []protocol.StreamID {
+	ids := f.blockedStreams
+	f.blockedStreams = nil
+	return ids
+}
+
+// BlockedCounts returns how often sending was blocked by connection-level and by stream-level flow control
+func (f *streamFramer) BlockedCounts() (connection, stream uint64) {
+	return f.connectionBlockedCount, f.streamBlockedCount
+}
+
 func (f *streamFramer) HasFramesForRetransmission() bool {
 	return len(f.retransmissionQueue) > 0
 }
@@ -119,9 +149,13 @@ func (f *streamFramer) maybePopNormalFrames(maxBytes protocol.ByteCount) (res []
 		if f.flowControlManager.RemainingConnectionWindowSize() == 0 {
 			// We are now connection-level FC blocked
 			f.blockedFrameQueue = append(f.blockedFrameQueue, &frames.BlockedFrame{StreamID: 0})
+			f.connectionBlockedCount++
+			f.blockedStreams = append(f.blockedStreams, 0)
 		} else if !frame.FinBit && sendWindowSize-frame.DataLen() == 0 {
 			// We are now stream-level FC blocked
 			f.blockedFrameQueue = append(f.blockedFrameQueue, &frames.BlockedFrame{StreamID: s.StreamID()})
+			f.streamBlockedCount++
+			f.blockedStreams = append(f.blockedStreams, s.streamID)
 		}
 
 		res = append(res, frame)
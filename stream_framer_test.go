@@ -113,6 +113,17 @@ var _ = Describe("Stream Framer", func() {
 			Expect(framer.PopStreamFrames(1000)).To(BeEmpty())
 		})
 
+		It("returns retransmissions of handshake data before other retransmissions", func() {
+			cryptoFrame1 := &frames.StreamFrame{StreamID: 1, Data: []byte("foo")}
+			cryptoFrame2 := &frames.StreamFrame{StreamID: 1, Offset: 3, Data: []byte("bar")}
+			framer.AddFrameForRetransmission(retransmittedFrame1)
+			framer.AddFrameForRetransmission(cryptoFrame1)
+			framer.AddFrameForRetransmission(retransmittedFrame2)
+			framer.AddFrameForRetransmission(cryptoFrame2)
+			fs := framer.PopStreamFrames(1000)
+			Expect(fs).To(Equal([]*frames.StreamFrame{cryptoFrame1, cryptoFrame2, retransmittedFrame1, retransmittedFrame2}))
+		})
+
 		It("does not pop empty frames", func() {
 			stream1.dataForWriting = []byte("foobar")
 			fs := framer.PopStreamFrames(4)
@@ -393,6 +404,10 @@ var _ = Describe("Stream Framer", func() {
 			Expect(blockedFrame).ToNot(BeNil())
 			Expect(blockedFrame.StreamID).To(Equal(stream1.StreamID()))
 			Expect(framer.PopBlockedFrame()).To(BeNil())
+			connectionBlocked, streamBlocked := framer.BlockedCounts()
+			Expect(connectionBlocked).To(BeZero())
+			Expect(streamBlocked).To(Equal(uint64(1)))
+			Expect(framer.PopBlockedStreams()).To(Equal([]protocol.StreamID{stream1.StreamID()}))
 		})
 
 		It("does not queue a stream-level BLOCKED frame after sending the FinBit frame", func() {
@@ -419,6 +434,10 @@ var _ = Describe("Stream Framer", func() {
 			Expect(blockedFrame).ToNot(BeNil())
 			Expect(blockedFrame.StreamID).To(BeZero())
 			Expect(framer.PopBlockedFrame()).To(BeNil())
+			connectionBlocked, streamBlocked := framer.BlockedCounts()
+			Expect(connectionBlocked).To(Equal(uint64(1)))
+			Expect(streamBlocked).To(BeZero())
+			Expect(framer.PopBlockedStreams()).To(Equal([]protocol.StreamID{0}))
 		})
 
 		It("does not queue BLOCKED frames for non-contributing streams", func() {
@@ -426,6 +445,7 @@ var _ = Describe("Stream Framer", func() {
 			stream1.dataForWriting = []byte("foo")
 			framer.PopStreamFrames(1000)
 			Expect(framer.PopBlockedFrame()).To(BeNil())
+			Expect(framer.PopBlockedStreams()).To(BeEmpty())
 		})
 
 		It("does not queue BLOCKED frames twice", func() {
@@ -1,8 +1,13 @@
 package quic
 
 import (
+	"bytes"
 	"errors"
 	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"testing/iotest"
 	"time"
 
 	"github.com/lucas-clemente/quic-go/congestion"
@@ -14,6 +19,21 @@ import (
 	. "github.com/onsi/gomega"
 )
 
+type errorReader struct{ err error }
+
+func (r *errorReader) Read([]byte) (int, error) { return 0, r.err }
+
+// callbackReader calls onRead before every Read
+type callbackReader struct {
+	r      io.Reader
+	onRead func()
+}
+
+func (r *callbackReader) Read(p []byte) (int, error) {
+	r.onRead()
+	return r.r.Read(p)
+}
+
 type mockFlowControlHandler struct {
 	streamsContributing []protocol.StreamID
 
@@ -98,27 +118,48 @@ func (m *mockFlowControlHandler) UpdateWindow(streamID protocol.StreamID, offset
 
 var _ = Describe("Stream", func() {
 	var (
-		str          *stream
-		onDataCalled bool
+		str            *stream
+		onDataCalled   bool
+		resetCalled    bool
+		resetCalledFor protocol.StreamID
+		resetCalledAt  protocol.ByteCount
 	)
 
 	onData := func() {
 		onDataCalled = true
 	}
 
+	onReset := func(id protocol.StreamID, offset protocol.ByteCount) {
+		resetCalled = true
+		resetCalledFor = id
+		resetCalledAt = offset
+	}
+
 	BeforeEach(func() {
 		onDataCalled = false
+		resetCalled = false
 		var streamID protocol.StreamID = 1337
 		cpm := &mockConnectionParametersManager{}
-		flowControlManager := flowcontrol.NewFlowControlManager(cpm, &congestion.RTTStats{})
+		flowControlManager := flowcontrol.NewFlowControlManager(congestion.DefaultClock{}, cpm, &congestion.RTTStats{}, protocol.DefaultWindowUpdateThreshold, 0, protocol.MaxReceiveConnectionFlowControlWindow)
 		flowControlManager.NewStream(streamID, true)
-		str, _ = newStream(streamID, onData, flowControlManager)
+		str, _ = newStream(streamID, onData, onReset, flowControlManager, 0, 0)
 	})
 
 	It("gets stream id", func() {
 		Expect(str.StreamID()).To(Equal(protocol.StreamID(1337)))
 	})
 
+	It("limits the out-of-order data on the crypto stream", func() {
+		Expect(str.frameQueue.maxOutOfOrderData).To(BeZero())
+		cryptoStream, _ := newStream(1, onData, onReset, nil, protocol.MaxReceiveStreamFlowControlWindow, 0)
+		Expect(cryptoStream.frameQueue.maxOutOfOrderData).To(Equal(protocol.MaxCryptoStreamOutOfOrderData))
+	})
+
+	It("limits the out-of-order data", func() {
+		s, _ := newStream(5, onData, onReset, nil, 1000, 0)
+		Expect(s.frameQueue.maxOutOfOrderData).To(Equal(protocol.ByteCount(1000)))
+	})
+
 	Context("reading", func() {
 		It("reads a single StreamFrame", func() {
 			frame := frames.StreamFrame{
@@ -254,7 +295,7 @@ var _ = Describe("Stream", func() {
 			Expect(b).To(Equal([]byte{0xDE, 0xAD, 0xBE, 0xEF}))
 		})
 
-		It("rejects a StreamFrames with an overlapping data range", func() {
+		It("accepts StreamFrames with an overlapping data range", func() {
 			frame1 := frames.StreamFrame{
 				Offset: 0,
 				Data:   []byte("ab"),
@@ -266,7 +307,12 @@ var _ = Describe("Stream", func() {
 			err := str.AddStreamFrame(&frame1)
 			Expect(err).ToNot(HaveOccurred())
 			err = str.AddStreamFrame(&frame2)
-			Expect(err).To(MatchError("OverlappingStreamData: start of gap in stream chunk"))
+			Expect(err).ToNot(HaveOccurred())
+			b := make([]byte, 3)
+			n, err := str.Read(b)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(n).To(Equal(3))
+			Expect(b).To(Equal([]byte("aby")))
 		})
 
 		It("calls onData", func() {
@@ -283,18 +329,11 @@ var _ = Describe("Stream", func() {
 	})
 
 	Context("writing", func() {
-		It("writes and gets all data at once", func(done Done) {
-			go func() {
-				n, err := str.Write([]byte("foobar"))
-				Expect(err).ToNot(HaveOccurred())
-				Expect(n).To(Equal(6))
-				close(done)
-			}()
-			Eventually(func() []byte {
-				str.mutex.Lock()
-				defer str.mutex.Unlock()
-				return str.dataForWriting
-			}).Should(Equal([]byte("foobar")))
+		It("writes and gets all data at once", func() {
+			n, err := str.Write([]byte("foobar"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(n).To(Equal(6))
+			Expect(str.dataForWriting).To(Equal([]byte("foobar")))
 			Expect(onDataCalled).To(BeTrue())
 			Expect(str.lenOfDataForWriting()).To(Equal(protocol.ByteCount(6)))
 			data := str.getDataForWriting(1000)
@@ -303,18 +342,11 @@ var _ = Describe("Stream", func() {
 			Expect(str.dataForWriting).To(BeNil())
 		})
 
-		It("writes and gets data in two turns", func(done Done) {
-			go func() {
-				n, err := str.Write([]byte("foobar"))
-				Expect(err).ToNot(HaveOccurred())
-				Expect(n).To(Equal(6))
-				close(done)
-			}()
-			Eventually(func() []byte {
-				str.mutex.Lock()
-				defer str.mutex.Unlock()
-				return str.dataForWriting
-			}).Should(Equal([]byte("foobar")))
+		It("writes and gets data in two turns", func() {
+			n, err := str.Write([]byte("foobar"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(n).To(Equal(6))
+			Expect(str.dataForWriting).To(Equal([]byte("foobar")))
 			Expect(str.lenOfDataForWriting()).To(Equal(protocol.ByteCount(6)))
 			data := str.getDataForWriting(3)
 			Expect(data).To(Equal([]byte("foo")))
@@ -343,14 +375,205 @@ var _ = Describe("Stream", func() {
 
 		It("copies the slice while writing", func() {
 			s := []byte("foo")
+			n, err := str.Write(s)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(n).To(Equal(3))
+			s[0] = 'v'
+			Expect(str.getDataForWriting(3)).To(Equal([]byte("foo")))
+		})
+
+		It("coalesces small writes", func() {
+			n, err := str.Write([]byte("foo"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(n).To(Equal(3))
+			n, err = str.Write([]byte("bar"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(n).To(Equal(3))
+			Expect(str.getDataForWriting(1000)).To(Equal([]byte("foobar")))
+		})
+
+		It("limits the data buffered for sending", func(done Done) {
+			str.maxSendBuffer = 4
+			var writeReturned int32
 			go func() {
-				n, err := str.Write(s)
+				defer GinkgoRecover()
+				n, err := str.Write([]byte("foobar"))
 				Expect(err).ToNot(HaveOccurred())
-				Expect(n).To(Equal(3))
+				Expect(n).To(Equal(6))
+				atomic.StoreInt32(&writeReturned, 1)
 			}()
-			Eventually(func() protocol.ByteCount { return str.lenOfDataForWriting() }).ShouldNot(BeZero())
-			s[0] = 'v'
+			Eventually(func() protocol.ByteCount { return str.lenOfDataForWriting() }).Should(Equal(protocol.ByteCount(4)))
+			Consistently(func() int32 { return atomic.LoadInt32(&writeReturned) }).Should(BeZero())
 			Expect(str.getDataForWriting(3)).To(Equal([]byte("foo")))
+			Eventually(func() int32 { return atomic.LoadInt32(&writeReturned) }).Should(Equal(int32(1)))
+			Expect(str.getDataForWriting(3)).To(Equal([]byte("bar")))
+			Expect(str.writeOffset).To(Equal(protocol.ByteCount(6)))
+			close(done)
+		})
+
+		It("returns the number of bytes buffered before an error occurred", func(done Done) {
+			str.maxSendBuffer = 4
+			testErr := errors.New("test")
+			go func() {
+				defer GinkgoRecover()
+				n, err := str.Write([]byte("foobar"))
+				Expect(err).To(MatchError(testErr))
+				Expect(n).To(Equal(4))
+				close(done)
+			}()
+			Eventually(func() protocol.ByteCount { return str.lenOfDataForWriting() }).Should(Equal(protocol.ByteCount(4)))
+			str.RegisterError(testErr)
+		})
+
+		Context("reading from a reader", func() {
+			It("reads all data", func() {
+				n, err := str.ReadFrom(strings.NewReader("foobar"))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(n).To(Equal(int64(6)))
+				Expect(onDataCalled).To(BeTrue())
+				Expect(str.getDataForWriting(1000)).To(Equal([]byte("foobar")))
+			})
+
+			It("appends to data that was written before", func() {
+				_, err := str.Write([]byte("foo"))
+				Expect(err).ToNot(HaveOccurred())
+				n, err := str.ReadFrom(strings.NewReader("bar"))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(n).To(Equal(int64(3)))
+				Expect(str.getDataForWriting(1000)).To(Equal([]byte("foobar")))
+			})
+
+			It("uses the buffer it read into without copying", func() {
+				n, err := str.ReadFrom(strings.NewReader("foobar"))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(n).To(Equal(int64(6)))
+				Expect(cap(str.dataForWriting)).To(Equal(int(protocol.StreamReadFromBufferSize)))
+			})
+
+			It("reads into the spare capacity of the send buffer", func() {
+				_, err := str.ReadFrom(strings.NewReader("foo"))
+				Expect(err).ToNot(HaveOccurred())
+				data := str.dataForWriting
+				Expect(str.getDataForWriting(2)).To(Equal([]byte("fo")))
+				_, err = str.ReadFrom(strings.NewReader("bar"))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(str.dataForWriting).To(Equal([]byte("obar")))
+				Expect(&str.dataForWriting[0]).To(Equal(&data[2]))
+			})
+
+			It("reads into the same buffer until it is used up", func() {
+				n, err := str.ReadFrom(iotest.OneByteReader(strings.NewReader("foobar")))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(n).To(Equal(int64(6)))
+				Expect(str.dataForWriting).To(Equal([]byte("foobar")))
+				Expect(cap(str.dataForWriting)).To(Equal(int(protocol.StreamReadFromBufferSize)))
+			})
+
+			It("keeps reading when the session takes all data while reading", func() {
+				var sent []byte
+				r := &callbackReader{
+					r:      iotest.OneByteReader(strings.NewReader("bar")),
+					onRead: func() { sent = append(sent, str.getDataForWriting(1000)...) },
+				}
+				_, err := str.Write([]byte("foo"))
+				Expect(err).ToNot(HaveOccurred())
+				n, err := str.ReadFrom(r)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(n).To(Equal(int64(3)))
+				sent = append(sent, str.getDataForWriting(1000)...)
+				Expect(sent).To(Equal([]byte("foobar")))
+			})
+
+			It("limits the data buffered for sending", func(done Done) {
+				str.maxSendBuffer = 4
+				var readReturned int32
+				go func() {
+					defer GinkgoRecover()
+					n, err := str.ReadFrom(strings.NewReader("foobar"))
+					Expect(err).ToNot(HaveOccurred())
+					Expect(n).To(Equal(int64(6)))
+					atomic.StoreInt32(&readReturned, 1)
+				}()
+				Eventually(func() protocol.ByteCount { return str.lenOfDataForWriting() }).Should(Equal(protocol.ByteCount(4)))
+				Consistently(func() int32 { return atomic.LoadInt32(&readReturned) }).Should(BeZero())
+				Expect(str.getDataForWriting(3)).To(Equal([]byte("foo")))
+				Eventually(func() int32 { return atomic.LoadInt32(&readReturned) }).Should(Equal(int32(1)))
+				Expect(str.getDataForWriting(3)).To(Equal([]byte("bar")))
+				close(done)
+			})
+
+			It("returns errors of the reader", func() {
+				testErr := errors.New("test")
+				n, err := str.ReadFrom(io.MultiReader(strings.NewReader("foo"), &errorReader{err: testErr}))
+				Expect(err).To(MatchError(testErr))
+				Expect(n).To(Equal(int64(3)))
+				Expect(str.getDataForWriting(1000)).To(Equal([]byte("foo")))
+			})
+
+			It("returns stream errors", func() {
+				testErr := errors.New("test")
+				str.RegisterError(testErr)
+				n, err := str.ReadFrom(strings.NewReader("foobar"))
+				Expect(err).To(MatchError(testErr))
+				Expect(n).To(BeZero())
+			})
+		})
+
+		Context("flushing", func() {
+			It("returns immediately if no data is buffered", func() {
+				Expect(str.Flush()).To(Succeed())
+			})
+
+			It("blocks until all data was handed to the session", func(done Done) {
+				var flushed int32
+				_, err := str.Write([]byte("foobar"))
+				Expect(err).ToNot(HaveOccurred())
+				go func() {
+					defer GinkgoRecover()
+					Expect(str.Flush()).To(Succeed())
+					atomic.StoreInt32(&flushed, 1)
+				}()
+				Expect(str.getDataForWriting(3)).To(Equal([]byte("foo")))
+				Consistently(func() int32 { return atomic.LoadInt32(&flushed) }).Should(BeZero())
+				Expect(str.getDataForWriting(3)).To(Equal([]byte("bar")))
+				Eventually(func() int32 { return atomic.LoadInt32(&flushed) }).Should(Equal(int32(1)))
+				close(done)
+			})
+
+			It("returns errors", func(done Done) {
+				testErr := errors.New("test")
+				_, err := str.Write([]byte("foobar"))
+				Expect(err).ToNot(HaveOccurred())
+				go func() {
+					defer GinkgoRecover()
+					Expect(str.Flush()).To(MatchError(testErr))
+					close(done)
+				}()
+				str.RegisterError(testErr)
+			})
+
+			It("unblocks both Flush and a blocked Write when an error occurs", func(done Done) {
+				testErr := errors.New("test")
+				var flushed, written int32
+				str.maxSendBuffer = 4
+				go func() {
+					defer GinkgoRecover()
+					_, err := str.Write([]byte("foobar"))
+					Expect(err).To(MatchError(testErr))
+					atomic.StoreInt32(&written, 1)
+				}()
+				Eventually(func() protocol.ByteCount { return str.lenOfDataForWriting() }).Should(Equal(protocol.ByteCount(4)))
+				go func() {
+					defer GinkgoRecover()
+					Expect(str.Flush()).To(MatchError(testErr))
+					atomic.StoreInt32(&flushed, 1)
+				}()
+				Consistently(func() int32 { return atomic.LoadInt32(&flushed) + atomic.LoadInt32(&written) }).Should(BeZero())
+				str.RegisterError(testErr)
+				Eventually(func() int32 { return atomic.LoadInt32(&written) }).Should(Equal(int32(1)))
+				Eventually(func() int32 { return atomic.LoadInt32(&flushed) }).Should(Equal(int32(1)))
+				close(done)
+			})
 		})
 
 		It("returns when given a nil input", func() {
@@ -542,4 +765,93 @@ var _ = Describe("Stream", func() {
 			})
 		})
 	})
+
+	Context("resetting", func() {
+		testErr := errors.New("test error")
+
+		It("calls the onReset callback with the current write offset", func() {
+			str.writeOffset = 0x1000
+			str.Reset(testErr)
+			Expect(resetCalled).To(BeTrue())
+			Expect(resetCalledFor).To(Equal(str.streamID))
+			Expect(resetCalledAt).To(Equal(protocol.ByteCount(0x1000)))
+		})
+
+		It("only calls the onReset callback once", func() {
+			str.Reset(testErr)
+			resetCalled = false
+			str.Reset(testErr)
+			Expect(resetCalled).To(BeFalse())
+		})
+
+		It("discards data that was not yet sent and unblocks Write", func() {
+			var writeReturned bool
+			var n int
+			var err error
+			str.maxSendBuffer = 3
+			go func() {
+				n, err = str.Write([]byte("foobar"))
+				writeReturned = true
+			}()
+			Eventually(func() protocol.ByteCount { return str.lenOfDataForWriting() }).ShouldNot(BeZero())
+			str.Reset(testErr)
+			Expect(str.getDataForWriting(6)).To(BeNil())
+			Eventually(func() bool { return writeReturned }).Should(BeTrue())
+			Expect(n).To(Equal(3))
+			Expect(err).To(MatchError(testErr))
+		})
+
+		It("returns the error on Read", func() {
+			str.Reset(testErr)
+			n, err := str.Read(make([]byte, 4))
+			Expect(n).To(BeZero())
+			Expect(err).To(MatchError(testErr))
+		})
+
+		It("doesn't send a FIN after being reset", func() {
+			str.Close()
+			str.Reset(testErr)
+			Expect(str.shouldSendFin()).To(BeFalse())
+		})
+
+		It("is finished after being reset", func() {
+			Expect(str.finished()).To(BeFalse())
+			str.Reset(testErr)
+			Expect(str.finished()).To(BeTrue())
+		})
+	})
 })
+
+func benchmarkStreamCopy(b *testing.B, copyToStream func(*stream, io.Reader) (int64, error)) {
+	data := make([]byte, 1<<20)
+	str, _ := newStream(5, func() {}, func(protocol.StreamID, protocol.ByteCount) {}, nil, 0, protocol.DefaultMaxStreamSendBuffer)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// the session takes the data in packet-sized chunks, before every read
+		r := &callbackReader{
+			r: bytes.NewReader(data),
+			onRead: func() {
+				for str.getDataForWriting(protocol.MaxPacketSize) != nil {
+				}
+			},
+		}
+		if _, err := copyToStream(str, r); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkStreamReadFrom(b *testing.B) {
+	benchmarkStreamCopy(b, func(str *stream, r io.Reader) (int64, error) {
+		return str.ReadFrom(r)
+	})
+}
+
+func BenchmarkStreamIOCopy(b *testing.B) {
+	benchmarkStreamCopy(b, func(str *stream, r io.Reader) (int64, error) {
+		// hide ReadFrom, so that io.Copy uses an intermediate buffer and Write
+		return io.Copy(struct{ io.Writer }{str}, r)
+	})
+}
@@ -3,6 +3,7 @@ package quic
 import (
 	"errors"
 	"fmt"
+	"sort"
 	"sync"
 
 	"github.com/lucas-clemente/quic-go/handshake"
@@ -15,11 +16,16 @@ type streamsMap struct {
 
 	connectionParameters handshake.ConnectionParametersManager
 
+	// streams contains the open streams only
 	streams     map[protocol.StreamID]*stream
 	openStreams []protocol.StreamID
+	// closedClientStreams are the sorted IDs of the client-side streams that were closed, and that the client could still open because they are not too much smaller than highestStreamOpenedByClient.
+	// It prevents a client from reopening closed streams. It is bounded by protocol.MaxNewStreamIDDelta, see garbageCollectClosedStreams.
+	// Server-side streams are closed if they are not open and not larger than highestStreamOpenedByServer, so they don't need to be tracked.
+	closedClientStreams []protocol.StreamID
 
-	highestStreamOpenedByClient          protocol.StreamID
-	streamsOpenedAfterLastGarbageCollect int
+	highestStreamOpenedByClient protocol.StreamID
+	highestStreamOpenedByServer protocol.StreamID
 
 	newStream newStreamLambda
 
@@ -29,13 +35,18 @@ type streamsMap struct {
 	numIncomingStreams uint32
 
 	roundRobinIndex uint32
+	// refuseNewStreams is set once the session sent a GOAWAY
+	refuseNewStreams bool
+	// prioritized is set as soon as the priority of a stream was changed
+	prioritized bool
 }
 
 type streamLambda func(*stream) (bool, error)
 type newStreamLambda func(protocol.StreamID) (*stream, error)
 
 var (
-	errMapAccess = errors.New("streamsMap: Error accessing the streams map")
+	errMapAccess     = errors.New("streamsMap: Error accessing the streams map")
+	errStreamRefused = errors.New("streamsMap: refusing to open a new stream after sending a GOAWAY")
 )
 
 func newStreamsMap(newStream newStreamLambda, connectionParameters handshake.ConnectionParametersManager) *streamsMap {
@@ -52,17 +63,18 @@ func newStreamsMap(newStream newStreamLambda, connectionParameters handshake.Con
 func (m *streamsMap) GetOrOpenStream(id protocol.StreamID) (*stream, error) {
 	m.mutex.RLock()
 	s, ok := m.streams[id]
+	closed := !ok && m.isClosed(id)
 	m.mutex.RUnlock()
-	if ok {
-		return s, nil // s may be nil
+	if ok || closed {
+		return s, nil // s is nil if the stream is closed
 	}
 
 	// ... we don't have an existing stream, try opening a new one
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
-	// We need to check whether another invocation has already created a stream (between RUnlock() and Lock()).
+	// We need to check whether another invocation has already created or closed a stream (between RUnlock() and Lock()).
 	s, ok = m.streams[id]
-	if ok {
+	if ok || m.isClosed(id) {
 		return s, nil
 	}
 	if m.numIncomingStreams >= m.connectionParameters.GetMaxIncomingStreams() {
@@ -74,25 +86,61 @@ func (m *streamsMap) GetOrOpenStream(id protocol.StreamID) (*stream, error) {
 	if id+protocol.MaxNewStreamIDDelta < m.highestStreamOpenedByClient {
 		return nil, qerr.Error(qerr.InvalidStreamID, fmt.Sprintf("attempted to open stream %d, which is a lot smaller than the highest opened stream, %d", id, m.highestStreamOpenedByClient))
 	}
+	if m.refuseNewStreams {
+		// treat the stream as closed from now on, so that it is only refused once
+		m.updateHighestStreamOpenedByClient(id)
+		m.addClosedClientStream(id)
+		return nil, errStreamRefused
+	}
 
 	s, err := m.newStream(id)
 	if err != nil {
 		return nil, err
 	}
 	m.numIncomingStreams++
+	m.updateHighestStreamOpenedByClient(id)
+
+	m.putStream(s)
+	return s, nil
+}
 
+// Attention: this function must only be called if a mutex has been acquired previously
+func (m *streamsMap) updateHighestStreamOpenedByClient(id protocol.StreamID) {
 	if id > m.highestStreamOpenedByClient {
 		m.highestStreamOpenedByClient = id
+		m.garbageCollectClosedStreams()
 	}
+}
 
-	// maybe trigger garbage collection of streams map
-	m.streamsOpenedAfterLastGarbageCollect++
-	if m.streamsOpenedAfterLastGarbageCollect%protocol.MaxNewStreamIDDelta == 0 {
-		m.garbageCollectClosedStreams()
+// isClosed says if a stream that is not open was closed already.
+// Attention: this function must only be called if a mutex has been acquired previously
+func (m *streamsMap) isClosed(id protocol.StreamID) bool {
+	if id%2 == 0 {
+		return id != 0 && id <= m.highestStreamOpenedByServer
 	}
+	i := sort.Search(len(m.closedClientStreams), func(i int) bool { return m.closedClientStreams[i] >= id })
+	return i < len(m.closedClientStreams) && m.closedClientStreams[i] == id
+}
 
-	m.putStream(s)
-	return s, nil
+// Attention: this function must only be called if a mutex has been acquired previously
+func (m *streamsMap) addClosedClientStream(id protocol.StreamID) {
+	// streams this small can't be opened anymore anyway
+	if id+protocol.MaxNewStreamIDDelta < m.highestStreamOpenedByClient {
+		return
+	}
+	i := sort.Search(len(m.closedClientStreams), func(i int) bool { return m.closedClientStreams[i] >= id })
+	m.closedClientStreams = append(m.closedClientStreams, 0)
+	copy(m.closedClientStreams[i+1:], m.closedClientStreams[i:])
+	m.closedClientStreams[i] = id
+}
+
+// RefuseNewStreams makes GetOrOpenStream refuse all streams that are not open yet.
+// It returns the highest stream opened by the client so far.
+func (m *streamsMap) RefuseNewStreams() protocol.StreamID {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.refuseNewStreams = true
+	return m.highestStreamOpenedByClient
 }
 
 // OpenStream opens a stream from the server's side
@@ -107,6 +155,9 @@ func (m *streamsMap) OpenStream(id protocol.StreamID) (*stream, error) {
 	if ok {
 		return nil, qerr.Error(qerr.InvalidStreamID, fmt.Sprintf("attempted to open stream %d, which is already open", id))
 	}
+	if id <= m.highestStreamOpenedByServer {
+		return nil, qerr.Error(qerr.InvalidStreamID, fmt.Sprintf("attempted to open stream %d, which is not larger than the highest opened stream, %d", id, m.highestStreamOpenedByServer))
+	}
 	if m.numOutgoingStreams >= m.connectionParameters.GetMaxOutgoingStreams() {
 		return nil, qerr.TooManyOpenStreams
 	}
@@ -116,6 +167,7 @@ func (m *streamsMap) OpenStream(id protocol.StreamID) (*stream, error) {
 		return nil, err
 	}
 	m.numOutgoingStreams++
+	m.highestStreamOpenedByServer = id
 
 	m.putStream(s)
 	return s, nil
@@ -157,8 +209,17 @@ func (m *streamsMap) RoundRobinIterate(fn streamLambda) error {
 		}
 	}
 
+	var order []uint32
+	if m.prioritized {
+		order = m.streamsByPriority(startIndex)
+	}
+
 	for i := uint32(0); i < numStreams; i++ {
-		streamID := m.openStreams[(i+startIndex)%numStreams]
+		index := (i + startIndex) % numStreams
+		if order != nil {
+			index = order[i]
+		}
+		streamID := m.openStreams[index]
 
 		if streamID == 1 || streamID == 3 {
 			continue
@@ -168,7 +229,8 @@ func (m *streamsMap) RoundRobinIterate(fn streamLambda) error {
 		if err != nil {
 			return err
 		}
-		m.roundRobinIndex = (m.roundRobinIndex + 1) % numStreams
+		// the next iteration starts with the stream after this one, even if the crypto- or header stream were skipped
+		m.roundRobinIndex = (index + 1) % numStreams
 		if !cont {
 			break
 		}
@@ -176,14 +238,41 @@ func (m *streamsMap) RoundRobinIterate(fn streamLambda) error {
 	return nil
 }
 
+// streamsByPriority returns the indices of the open streams in m.openStreams, starting at the round-robin position and ordered by priority.
+// Streams with the same priority keep their round-robin order.
+// Attention: this function must only be called if a mutex has been acquired previously
+func (m *streamsMap) streamsByPriority(startIndex uint32) []uint32 {
+	numStreams := uint32(len(m.openStreams))
+	indices := make([]uint32, numStreams)
+	for i := uint32(0); i < numStreams; i++ {
+		indices[i] = (i + startIndex) % numStreams
+	}
+	sort.Stable(&streamPrioritySorter{indices: indices, ids: m.openStreams, streams: m.streams})
+	return indices
+}
+
+// SetPriority sets the priority of an open stream
+func (m *streamsMap) SetPriority(id protocol.StreamID, priority protocol.StreamPriority) error {
+	if priority > protocol.LowestStreamPriority {
+		return fmt.Errorf("invalid priority %d for stream %d", priority, id)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	s, ok := m.streams[id]
+	if !ok {
+		return fmt.Errorf("attempted to set the priority of non-existing stream: %d", id)
+	}
+	s.priority = priority
+	m.prioritized = true
+	return nil
+}
+
 func (m *streamsMap) iterateFunc(streamID protocol.StreamID, fn streamLambda) (bool, error) {
 	str, ok := m.streams[streamID]
 	if !ok {
 		return true, errMapAccess
 	}
-	if str == nil {
-		return false, fmt.Errorf("BUG: Stream %d is closed, but still in openStreams map", streamID)
-	}
 	return fn(str)
 }
 
@@ -201,16 +290,16 @@ func (m *streamsMap) putStream(s *stream) error {
 
 // Attention: this function must only be called if a mutex has been acquired previously
 func (m *streamsMap) RemoveStream(id protocol.StreamID) error {
-	s, ok := m.streams[id]
-	if !ok || s == nil {
+	if _, ok := m.streams[id]; !ok {
 		return fmt.Errorf("attempted to remove non-existing stream: %d", id)
 	}
 
-	m.streams[id] = nil
+	delete(m.streams, id)
 	if id%2 == 0 {
 		m.numOutgoingStreams--
 	} else {
 		m.numIncomingStreams--
+		m.addClosedClientStream(id)
 	}
 
 	for i, s := range m.openStreams {
@@ -228,19 +317,26 @@ func (m *streamsMap) RemoveStream(id protocol.StreamID) error {
 	return nil
 }
 
-// garbageCollectClosedStreams deletes nil values in the streams if they are smaller than protocol.MaxNewStreamIDDelta than the highest stream opened by the client
-// note that this garbage collection is relatively expensive, since it iterates over the whole streams map. It should not be called every time a stream is openend or closed
+// garbageCollectClosedStreams forgets the closed client-side streams that are more than protocol.MaxNewStreamIDDelta smaller than the highest stream opened by the client.
+// GetOrOpenStream rejects them anyway.
+// Attention: this function must only be called if a mutex has been acquired previously
 func (m *streamsMap) garbageCollectClosedStreams() {
-	for id, str := range m.streams {
-		if str != nil {
-			continue
-		}
+	i := sort.Search(len(m.closedClientStreams), func(i int) bool {
+		return m.closedClientStreams[i]+protocol.MaxNewStreamIDDelta >= m.highestStreamOpenedByClient
+	})
+	m.closedClientStreams = m.closedClientStreams[i:]
+}
 
-		// server-side streams can be gargage collected immediately
-		// client-side streams need to be kept as nils in the streams map for a bit longer, in order to prevent a client from reopening closed streams
-		if id%2 == 0 || id+protocol.MaxNewStreamIDDelta <= m.highestStreamOpenedByClient {
-			delete(m.streams, id)
-		}
-	}
-	m.streamsOpenedAfterLastGarbageCollect = 0
+type streamPrioritySorter struct {
+	indices []uint32
+	ids     []protocol.StreamID
+	streams map[protocol.StreamID]*stream
+}
+
+func (s *streamPrioritySorter) Len() int { return len(s.indices) }
+func (s *streamPrioritySorter) Swap(i, j int) {
+	s.indices[i], s.indices[j] = s.indices[j], s.indices[i]
+}
+func (s *streamPrioritySorter) Less(i, j int) bool {
+	return s.streams[s.ids[s.indices[i]]].priority < s.streams[s.ids[s.indices[j]]].priority
 }
@@ -100,6 +100,35 @@ var _ = Describe("Streams Map", func() {
 				Expect(m.numIncomingStreams).To(BeZero())
 			})
 
+			Context("refusing new streams", func() {
+				It("returns the highest stream opened by the client", func() {
+					_, err := m.GetOrOpenStream(5)
+					Expect(err).NotTo(HaveOccurred())
+					_, err = m.GetOrOpenStream(3)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(m.RefuseNewStreams()).To(Equal(protocol.StreamID(5)))
+				})
+
+				It("refuses new streams only once", func() {
+					m.RefuseNewStreams()
+					_, err := m.GetOrOpenStream(5)
+					Expect(err).To(MatchError(errStreamRefused))
+					s, err := m.GetOrOpenStream(5)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(s).To(BeNil())
+					Expect(m.numIncomingStreams).To(BeZero())
+				})
+
+				It("still returns existing streams", func() {
+					_, err := m.GetOrOpenStream(5)
+					Expect(err).NotTo(HaveOccurred())
+					m.RefuseNewStreams()
+					s, err := m.GetOrOpenStream(5)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(s.StreamID()).To(Equal(protocol.StreamID(5)))
+				})
+			})
+
 			Context("counting streams", func() {
 				var maxNumStreams int
 
@@ -141,6 +170,24 @@ var _ = Describe("Streams Map", func() {
 				Expect(m.numOutgoingStreams).To(Equal(uint32(1)))
 			})
 
+			It("returns nil for closed streams", func() {
+				_, err := m.OpenStream(4)
+				Expect(err).ToNot(HaveOccurred())
+				err = m.RemoveStream(4)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(m.streams).To(BeEmpty())
+				s, err := m.GetOrOpenStream(4)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(s).To(BeNil())
+			})
+
+			It("errors when a stream is not opened in order", func() {
+				_, err := m.OpenStream(6)
+				Expect(err).ToNot(HaveOccurred())
+				_, err = m.OpenStream(4)
+				Expect(err).To(MatchError("InvalidStreamID: attempted to open stream 4, which is not larger than the highest opened stream, 6"))
+			})
+
 			It("returns an error for already openend streams", func() {
 				_, err := m.OpenStream(4)
 				Expect(err).ToNot(HaveOccurred())
@@ -215,7 +262,7 @@ var _ = Describe("Streams Map", func() {
 				Expect(err).ToNot(HaveOccurred())
 			})
 
-			It("garbage-collects closed streams", func() {
+			It("forgets closed streams that can't be opened anymore", func() {
 				for i := 1; i < 4*protocol.MaxNewStreamIDDelta; i += 2 {
 					streamID := protocol.StreamID(i)
 					_, err := m.GetOrOpenStream(streamID)
@@ -224,16 +271,31 @@ var _ = Describe("Streams Map", func() {
 					err = m.RemoveStream(streamID)
 					Expect(err).NotTo(HaveOccurred())
 				}
-				m.garbageCollectClosedStreams()
-				for i := 1; i < 3*protocol.MaxNewStreamIDDelta; i += 2 {
-					Expect(m.streams).ToNot(HaveKey(protocol.StreamID(i)))
+				Expect(m.streams).To(BeEmpty())
+				Expect(m.closedClientStreams).To(HaveLen(protocol.MaxNewStreamIDDelta/2 + 1))
+				Expect(m.closedClientStreams[0]).To(Equal(protocol.StreamID(4*protocol.MaxNewStreamIDDelta - 1 - protocol.MaxNewStreamIDDelta)))
+				for i := 3*protocol.MaxNewStreamIDDelta - 1; i < 4*protocol.MaxNewStreamIDDelta; i += 2 {
+					s, err := m.GetOrOpenStream(protocol.StreamID(i))
+					Expect(err).ToNot(HaveOccurred())
+					Expect(s).To(BeNil())
+				}
+			})
+
+			It("keeps the closed streams sorted when they are closed out of order", func() {
+				for _, id := range []protocol.StreamID{3, 5, 7, 9} {
+					_, err := m.GetOrOpenStream(id)
+					Expect(err).NotTo(HaveOccurred())
 				}
-				for i := 3*protocol.MaxNewStreamIDDelta + 1; i < 4*protocol.MaxNewStreamIDDelta; i += 2 {
-					Expect(m.streams).To(HaveKey(protocol.StreamID(i)))
+				for _, id := range []protocol.StreamID{7, 3, 9, 5} {
+					err := m.RemoveStream(id)
+					Expect(err).NotTo(HaveOccurred())
 				}
+				Expect(m.closedClientStreams).To(Equal([]protocol.StreamID{3, 5, 7, 9}))
+				Expect(m.isClosed(5)).To(BeTrue())
+				Expect(m.isClosed(11)).To(BeFalse())
 			})
 
-			It("does not garbage-collects open streams", func() {
+			It("does not forget open streams", func() {
 				for i := 1; i < 1002; i += 2 {
 					streamID := protocol.StreamID(i)
 					_, err := m.GetOrOpenStream(streamID)
@@ -244,31 +306,21 @@ var _ = Describe("Streams Map", func() {
 						Expect(err).NotTo(HaveOccurred())
 					}
 				}
-				lengthBefore := len(m.streams)
-				m.garbageCollectClosedStreams()
-				Expect(len(m.streams)).To(BeNumerically("<", lengthBefore))
+				Expect(m.streams).To(HaveLen(1))
 				Expect(m.streams).To(HaveKey(protocol.StreamID(23)))
-				Expect(m.streams[23]).ToNot(BeNil())
+				Expect(m.isClosed(23)).To(BeFalse())
+				s, err := m.GetOrOpenStream(23)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(s).ToNot(BeNil())
 			})
 
-			It("runs garbage-collection after a bunch of streams have been opened", func() {
-				numGarbageCollections := 0
-				numSavedStreams := 0
+			It("limits the number of refused streams it remembers", func() {
+				m.RefuseNewStreams()
 				for i := 1; i < 4*protocol.MaxNewStreamIDDelta; i += 2 {
-					streamID := protocol.StreamID(i)
-					_, err := m.GetOrOpenStream(streamID)
-					Expect(m.highestStreamOpenedByClient).To(Equal(streamID))
-					Expect(err).NotTo(HaveOccurred())
-					err = m.RemoveStream(streamID)
-					Expect(err).NotTo(HaveOccurred())
-					if len(m.streams) != numSavedStreams+1 {
-						numGarbageCollections++
-					}
-					numSavedStreams = len(m.streams)
+					_, err := m.GetOrOpenStream(protocol.StreamID(i))
+					Expect(err).To(MatchError(errStreamRefused))
 				}
-				Expect(numGarbageCollections).ToNot(BeZero())
-				Expect(numGarbageCollections).To(BeNumerically("<", 4))
-				Expect(len(m.streams)).To(BeNumerically("<", 2*protocol.MaxNewStreamIDDelta))
+				Expect(len(m.closedClientStreams)).To(BeNumerically("<=", protocol.MaxNewStreamIDDelta/2+1))
 			})
 		})
 	})
@@ -457,6 +509,49 @@ var _ = Describe("Streams Map", func() {
 			Expect(m.roundRobinIndex).To(Equal(uint32(3)))
 		})
 
+		Context("stream priorities", func() {
+			It("iterates over streams with a higher priority first", func() {
+				err := m.SetPriority(7, protocol.HighestStreamPriority)
+				Expect(err).ToNot(HaveOccurred())
+				err = m.SetPriority(5, protocol.LowestStreamPriority)
+				Expect(err).ToNot(HaveOccurred())
+				for _, id := range []protocol.StreamID{4, 6, 8} {
+					err = m.SetPriority(id, protocol.DefaultStreamPriority)
+					Expect(err).ToNot(HaveOccurred())
+				}
+				fn := func(str *stream) (bool, error) {
+					lambdaCalledForStream = append(lambdaCalledForStream, str.StreamID())
+					return true, nil
+				}
+				err = m.RoundRobinIterate(fn)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(lambdaCalledForStream).To(Equal([]protocol.StreamID{7, 4, 6, 8, 5}))
+			})
+
+			It("uses round-robin for streams with the same priority", func() {
+				err := m.SetPriority(7, protocol.LowestStreamPriority)
+				Expect(err).ToNot(HaveOccurred())
+				m.roundRobinIndex = 2 // stream 6
+				fn := func(str *stream) (bool, error) {
+					lambdaCalledForStream = append(lambdaCalledForStream, str.StreamID())
+					return true, nil
+				}
+				err = m.RoundRobinIterate(fn)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(lambdaCalledForStream).To(Equal([]protocol.StreamID{6, 8, 4, 5, 7}))
+			})
+
+			It("errors when setting the priority of a non-existing stream", func() {
+				err := m.SetPriority(11, protocol.DefaultStreamPriority)
+				Expect(err).To(MatchError("attempted to set the priority of non-existing stream: 11"))
+			})
+
+			It("errors for invalid priorities", func() {
+				err := m.SetPriority(5, protocol.LowestStreamPriority+1)
+				Expect(err).To(MatchError("invalid priority 8 for stream 5"))
+			})
+		})
+
 		Context("Prioritizing crypto- and header streams", func() {
 			BeforeEach(func() {
 				err := m.putStream(&stream{streamID: 1})
@@ -480,6 +575,38 @@ var _ = Describe("Streams Map", func() {
 				Expect(numIterations).To(Equal(3))
 				Expect(lambdaCalledForStream).To(Equal([]protocol.StreamID{1, 3, 7}))
 			})
+
+			It("rotates through the streams if the lambda stops at every stream", func() {
+				fn := func(str *stream) (bool, error) {
+					if str.StreamID() == 1 || str.StreamID() == 3 {
+						return true, nil
+					}
+					lambdaCalledForStream = append(lambdaCalledForStream, str.StreamID())
+					return false, nil
+				}
+				for i := 0; i < 7; i++ {
+					err := m.RoundRobinIterate(fn)
+					Expect(err).ToNot(HaveOccurred())
+				}
+				Expect(lambdaCalledForStream).To(Equal([]protocol.StreamID{4, 5, 6, 7, 8, 4, 5}))
+			})
+
+			It("rotates through streams with the same priority if the lambda stops at every stream", func() {
+				err := m.SetPriority(8, protocol.LowestStreamPriority)
+				Expect(err).ToNot(HaveOccurred())
+				fn := func(str *stream) (bool, error) {
+					if str.StreamID() == 1 || str.StreamID() == 3 {
+						return true, nil
+					}
+					lambdaCalledForStream = append(lambdaCalledForStream, str.StreamID())
+					return false, nil
+				}
+				for i := 0; i < 6; i++ {
+					err = m.RoundRobinIterate(fn)
+					Expect(err).ToNot(HaveOccurred())
+				}
+				Expect(lambdaCalledForStream).To(Equal([]protocol.StreamID{4, 5, 6, 7, 4, 5}))
+			})
 		})
 	})
 })
@@ -30,6 +30,11 @@ var _ = Describe("Min / Max", func() {
 			Expect(MaxInt64(7, 5)).To(Equal(int64(7)))
 		})
 
+		It("returns the maximum ByteCount", func() {
+			Expect(MaxByteCount(7, 5)).To(Equal(protocol.ByteCount(7)))
+			Expect(MaxByteCount(5, 7)).To(Equal(protocol.ByteCount(7)))
+		})
+
 		It("returns the maximum duration", func() {
 			Expect(MaxDuration(time.Microsecond, time.Nanosecond)).To(Equal(time.Microsecond))
 			Expect(MaxDuration(time.Nanosecond, time.Microsecond)).To(Equal(time.Microsecond))
@@ -40,6 +45,13 @@ var _ = Describe("Min / Max", func() {
 			Expect(MinDuration(time.Nanosecond, time.Microsecond)).To(Equal(time.Nanosecond))
 		})
 
+		It("returns the later time", func() {
+			a := time.Now()
+			b := a.Add(time.Second)
+			Expect(MaxTime(a, b)).To(Equal(b))
+			Expect(MaxTime(b, a)).To(Equal(b))
+		})
+
 		It("returns packet number max", func() {
 			Expect(MaxPacketNumber(1, 2)).To(Equal(protocol.PacketNumber(2)))
 			Expect(MaxPacketNumber(2, 1)).To(Equal(protocol.PacketNumber(2)))
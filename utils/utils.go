@@ -12,8 +12,11 @@ type Stream interface {
 	io.Reader
 	io.Writer
 	io.Closer
+	// Flush blocks until all data written to the stream was handed to the session for sending
+	Flush() error
 	StreamID() protocol.StreamID
 	CloseRemote(offset protocol.ByteCount)
+	Reset(error)
 }
 
 // ReadUintN reads N bytes